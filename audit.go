@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/server"
+)
+
+// auditAdmin records every admin action, that is every request changing
+// something, and every request refused for lack of a role, in the log and
+// in a span of its own. Reads, such as scrapes, pass through. It must run
+// after server.RequireAuth.
+func auditAdmin(svcName string) func(http.Handler) http.Handler {
+	tracer := otel.Tracer(svcName)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, _ := server.PrincipalFromContext(r.Context())
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(ww, r)
+				if ww.Status() == http.StatusForbidden {
+					audit(r, p, r.URL.Path, ww.Status())
+				}
+				return
+			}
+
+			ctx, span := tracer.Start(r.Context(), "admin "+r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("admin.principal", p.Name),
+					attribute.String("admin.role", p.Role.String()),
+					attribute.String("http.request.method", r.Method),
+					attribute.String("url.path", r.URL.Path),
+				),
+			)
+			defer span.End()
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			route := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetName("admin " + r.Method + " " + route)
+			span.SetAttributes(
+				attribute.String("http.route", route),
+				attribute.Int("http.response.status_code", status),
+			)
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			audit(r.WithContext(ctx), p, route, status)
+		})
+	}
+}
+
+// audit logs an admin request. Warn so the audit trail survives raising the
+// log level through /control.
+func audit(r *http.Request, p server.Principal, route string, status int) {
+	ev := log.Warn().
+		Bool("audit", true).
+		Str("principal", p.Name).
+		Str("role", p.Role.String()).
+		Str("method", r.Method).
+		Str("route", route).
+		Int("status", status).
+		Str("remote_addr", r.RemoteAddr)
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		ev = ev.Str("trace_id", sc.TraceID().String())
+	}
+	ev.Msg("admin action")
+}
@@ -0,0 +1,124 @@
+// Package collector generates a minimal OpenTelemetry Collector config
+// receiving what the service sends, so new users have a collector to point
+// it at without learning the collector first.
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options describes the signals of the service the config collects.
+type Options struct {
+	ServiceName string
+	// OTLPEndpoint is the host:port the service exports OTLP to; the
+	// collector receives OTLP/gRPC on its port. Empty means 4317.
+	OTLPEndpoint string
+	// Traces, Metrics and Logs are the signals the service sends over
+	// OTLP. Logs are those its relay forwards.
+	Traces, Metrics, Logs bool
+	// ScrapeTarget is the host:port of the /metrics endpoint the
+	// collector scrapes. Empty scrapes nothing.
+	ScrapeTarget   string
+	ScrapeInterval time.Duration
+	// ScrapeAuth notes that scraping needs credentials, which the config
+	// leaves for the user to fill in.
+	ScrapeAuth bool
+	// Backend is the host:port of the OTLP/gRPC backend the collector
+	// exports to. Empty uses the debug exporter, which logs what it gets.
+	Backend string
+	// BackendInsecure exports to Backend without TLS.
+	BackendInsecure bool
+}
+
+// Write writes the collector config of o as YAML.
+func Write(w io.Writer, o Options) error {
+	if !o.Traces && !o.Metrics && !o.Logs && o.ScrapeTarget == "" {
+		return errors.New("nothing to collect: no OTLP exporter, relay or prometheus exporter is configured")
+	}
+	port := "4317"
+	if o.OTLPEndpoint != "" {
+		_, p, err := net.SplitHostPort(o.OTLPEndpoint)
+		if err != nil {
+			return fmt.Errorf("otlp endpoint: %w", err)
+		}
+		port = p
+	}
+	otlp := o.Traces || o.Metrics || o.Logs
+	exporter := "debug"
+	if o.Backend != "" {
+		exporter = "otlp"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# OpenTelemetry Collector config for %s, generated by `%s collector-config`.\n", o.ServiceName, o.ServiceName)
+	b.WriteString("receivers:\n")
+	if otlp {
+		b.WriteString("  otlp:\n    protocols:\n      grpc:\n")
+		fmt.Fprintf(&b, "        endpoint: %s\n", quote("0.0.0.0:"+port))
+	}
+	if o.ScrapeTarget != "" {
+		interval := o.ScrapeInterval
+		if interval <= 0 {
+			interval = 15 * time.Second
+		}
+		b.WriteString("  prometheus:\n    config:\n      scrape_configs:\n")
+		fmt.Fprintf(&b, "        - job_name: %s\n", quote(o.ServiceName))
+		fmt.Fprintf(&b, "          scrape_interval: %s\n", interval)
+		if o.ScrapeAuth {
+			b.WriteString("          # /metrics requires credentials: set authorization or basic_auth here.\n")
+		}
+		fmt.Fprintf(&b, "          static_configs:\n            - targets: [%s]\n", quote(o.ScrapeTarget))
+	}
+
+	b.WriteString("processors:\n")
+	b.WriteString("  memory_limiter:\n    check_interval: 1s\n    limit_percentage: 80\n    spike_limit_percentage: 25\n")
+	b.WriteString("  batch: {}\n")
+
+	b.WriteString("exporters:\n")
+	if o.Backend != "" {
+		fmt.Fprintf(&b, "  otlp:\n    endpoint: %s\n", quote(o.Backend))
+		if o.BackendInsecure {
+			b.WriteString("    tls:\n      insecure: true\n")
+		}
+	} else {
+		b.WriteString("  debug:\n    verbosity: basic\n")
+	}
+
+	b.WriteString("service:\n  pipelines:\n")
+	pipeline := func(signal string, receivers ...string) {
+		fmt.Fprintf(&b, "    %s:\n", signal)
+		fmt.Fprintf(&b, "      receivers: [%s]\n", strings.Join(receivers, ", "))
+		b.WriteString("      processors: [memory_limiter, batch]\n")
+		fmt.Fprintf(&b, "      exporters: [%s]\n", exporter)
+	}
+	if o.Traces {
+		pipeline("traces", "otlp")
+	}
+	var metricReceivers []string
+	if o.Metrics {
+		metricReceivers = append(metricReceivers, "otlp")
+	}
+	if o.ScrapeTarget != "" {
+		metricReceivers = append(metricReceivers, "prometheus")
+	}
+	if len(metricReceivers) > 0 {
+		pipeline("metrics", metricReceivers...)
+	}
+	if o.Logs {
+		pipeline("logs", "otlp")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// quote returns s as a YAML double quoted scalar.
+func quote(s string) string {
+	return strconv.Quote(s)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"go-otel/collector"
+	"go-otel/telemetry"
+)
+
+// runCollectorConfig implements the collector-config subcommand, printing
+// an OpenTelemetry Collector config receiving the signals the service is
+// configured to send. It returns the exit code.
+func runCollectorConfig(svcName string, dev bool, args []string) int {
+	fs := flag.NewFlagSet("collector-config", flag.ExitOnError)
+	backend := fs.String("backend", "", "host:port of the OTLP backend the collector exports to; empty logs what it gets")
+	insecure := fs.Bool("insecure", false, "export to -backend without TLS")
+	host := fs.String("host", "localhost", "host the collector reaches the service on, to scrape it")
+	_ = fs.Parse(args)
+
+	cfg, err := loadConfig(svcName, dev)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+	opts := collector.Options{
+		ServiceName:     svcName,
+		ScrapeInterval:  cfg.telemetry.ScrapeInterval,
+		Backend:         *backend,
+		BackendInsecure: *insecure,
+	}
+	for _, eo := range cfg.telemetry.TraceExporters {
+		if eo.Kind == telemetry.ExporterOTLP {
+			opts.Traces, opts.OTLPEndpoint = true, eo.Endpoint
+		}
+	}
+	for _, eo := range cfg.telemetry.MetricExporters {
+		switch eo.Kind {
+		case telemetry.MetricExporterOTLP:
+			opts.Metrics = true
+			if opts.OTLPEndpoint == "" {
+				opts.OTLPEndpoint = eo.Endpoint
+			}
+		case telemetry.MetricExporterPrometheus:
+			opts.ScrapeTarget, opts.ScrapeAuth = scrapeTarget(cfg, *host), cfg.adminAuth.Enabled()
+		}
+	}
+	// The relay forwards every signal of the local processes.
+	if cfg.telemetry.Relay.Listen != "" {
+		opts.Traces, opts.Metrics, opts.Logs = true, true, true
+		if cfg.telemetry.Relay.Upstream != "" {
+			opts.OTLPEndpoint = cfg.telemetry.Relay.Upstream
+		}
+	}
+
+	if err := collector.Write(os.Stdout, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "collector-config: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// scrapeTarget returns the host:port /metrics is served on: the metrics
+// server, the API server or the admin server.
+func scrapeTarget(cfg config, host string) string {
+	addr := cfg.admin.Addr
+	switch {
+	case cfg.metrics.Addr != "":
+		addr = cfg.metrics.Addr
+	case cfg.metricsOnAPI:
+		addr = cfg.api.Addr
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		return net.JoinHostPort(host, port)
+	}
+	return addr
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"go-otel/dependency"
+	"go-otel/health"
+	"go-otel/internal/envconfig"
+	"go-otel/probe"
+	"go-otel/profiling"
+	"go-otel/retry"
+	"go-otel/server"
+	"go-otel/shutdown"
+	"go-otel/taskstore"
+	"go-otel/telemetry"
+)
+
+// config holds everything the service reads at startup.
+type config struct {
+	telemetry telemetry.Options
+
+	api   server.Options
+	admin server.Options
+	// metrics serves /metrics on a listener of its own when its Addr is
+	// set; otherwise the metrics endpoints stay on the admin server.
+	metrics server.Options
+	// adminAuth protects the admin endpoints, wherever they are mounted.
+	adminAuth server.AuthOptions
+	// metricsOnAPI mounts /metrics on the API router instead of the admin
+	// server.
+	metricsOnAPI bool
+	// debug mounts pprof, expvar and the runtime config dump on the admin
+	// server. It defaults to on for the dev preset.
+	debug bool
+	probe probe.Options
+	// health answers /healthz and /readyz, keeping their history.
+	health health.Options
+	// rum accepts browser telemetry on POST /rum/events.
+	rum bool
+	// deps bounds the calls to each dependency.
+	deps dependency.Config
+	// retry retries the calls to the dependencies opting in.
+	retry    retry.Options
+	shutdown shutdown.Options
+	tasks    taskstore.Options
+	// profiling pushes continuous profiles when its URL is set.
+	profiling profiling.Options
+	// gops starts the gops diagnostics agent, listening on gopsAddr, or
+	// on a free loopback port when empty.
+	gops     bool
+	gopsAddr string
+}
+
+// loadConfig builds the config for the preset, applying environment
+// overrides on top.
+func loadConfig(svcName string, dev bool) (config, error) {
+	preset := telemetry.Preset(os.Getenv(telemetry.EnvPreset))
+	if preset == "" {
+		preset = buildPreset
+	}
+	if dev {
+		preset = telemetry.PresetDev
+	}
+	opts, err := telemetry.PresetOptions(preset, svcName)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg := config{
+		telemetry: opts,
+		api:       server.DefaultOptions("api", fmt.Sprintf("0.0.0.0:%d", 8080)),
+		admin:     server.DefaultOptions("admin", "127.0.0.1:2222"),
+		metrics:   server.DefaultOptions("metrics", ""),
+		debug:     opts.Preset == telemetry.PresetDev || opts.Preset == telemetry.PresetDebug,
+		probe:     probe.DefaultOptions("/foo"),
+		health:    health.DefaultOptions(),
+		deps:      dependency.Config{},
+		retry:     retry.DefaultOptions(),
+		shutdown:  shutdown.DefaultOptions(),
+		tasks:     taskstore.DefaultOptions(),
+		profiling: profiling.DefaultOptions(),
+	}
+
+	if opts.Preset == telemetry.PresetDebug {
+		// A request paused on a breakpoint must not be cut off by the
+		// server, nor the process killed while paused in shutdown.
+		cfg.api.ReadTimeout, cfg.api.WriteTimeout = 0, 0
+		cfg.shutdown.Timeout = time.Hour
+	}
+
+	if err := cfg.telemetry.LoadEnv(); err != nil {
+		return config{}, err
+	}
+	if err := cfg.api.LoadEnv("GO_OTEL_API_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.admin.LoadEnv("GO_OTEL_ADMIN_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.metrics.LoadEnv("GO_OTEL_METRICS_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.adminAuth.LoadEnv("GO_OTEL_ADMIN_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.probe.LoadEnv("GO_OTEL_PROBE_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.health.LoadEnv("GO_OTEL_HEALTH_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.retry.LoadEnv("GO_OTEL_RETRY_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.deps.LoadEnv("GO_OTEL_DEPENDENCY_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.shutdown.LoadEnv("GO_OTEL_SHUTDOWN_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.tasks.LoadEnv("GO_OTEL_TASKS_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.profiling.LoadEnv("GO_OTEL_PROFILING_"); err != nil {
+		return config{}, err
+	}
+	if err := cfg.profiling.Validate(); err != nil {
+		return config{}, err
+	}
+	if err := cfg.telemetry.Validate(); err != nil {
+		return config{}, err
+	}
+	err = envconfig.Load([]envconfig.Var{
+		{Name: "GO_OTEL_METRICS_ON_API", Set: envconfig.Bool(&cfg.metricsOnAPI)},
+		{Name: "GO_OTEL_ADMIN_DEBUG", Set: envconfig.Bool(&cfg.debug)},
+		{Name: "GO_OTEL_RUM_ENABLED", Set: envconfig.Bool(&cfg.rum)},
+		{Name: "GO_OTEL_GOPS_ENABLED", Set: envconfig.Bool(&cfg.gops)},
+		{Name: "GO_OTEL_GOPS_ADDR", Set: envconfig.String(&cfg.gopsAddr)},
+	})
+	if err == nil && cfg.metricsOnAPI && cfg.metrics.Addr != "" {
+		err = fmt.Errorf("GO_OTEL_METRICS_ON_API and GO_OTEL_METRICS_ADDR are mutually exclusive")
+	}
+	// With the dev and debug presets, the debug endpoints and runtime
+	// control work out of the box, as only local callers can reach a
+	// loopback listener.
+	devPreset := opts.Preset == telemetry.PresetDev || opts.Preset == telemetry.PresetDebug
+	if devPreset && !cfg.adminAuth.Enabled() && loopback(cfg.admin.Addr) {
+		cfg.adminAuth.AnonymousRole = server.RoleOperator
+	}
+	return cfg, err
+}
+
+// loopback reports whether addr only accepts connections from this host.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
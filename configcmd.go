@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"go-otel/telemetry"
+)
+
+// runConfig implements the config subcommand: "config schema" prints the
+// JSON Schema of the reload file, and "config validate FILE..." checks
+// reload files, "-" reading stdin, as the service would, so pipelines can
+// reject a config before it is rolled out. It returns the exit code.
+func runConfig(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: config schema | config validate FILE...")
+		return 2
+	}
+	switch args[0] {
+	case "schema":
+		b, err := telemetry.ConfigSchema()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config schema: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%s\n", b)
+		return 0
+	case "validate":
+		if len(args) == 1 {
+			fmt.Fprintln(os.Stderr, "usage: config validate FILE...")
+			return 2
+		}
+		code := 0
+		for _, path := range args[1:] {
+			var b []byte
+			var err error
+			if path == "-" {
+				b, err = io.ReadAll(os.Stdin)
+			} else {
+				b, err = os.ReadFile(path)
+			}
+			if err == nil {
+				err = telemetry.ValidateConfig(b)
+			}
+			if err != nil {
+				fmt.Printf("FAIL %s: %v\n", path, err)
+				code = 1
+				continue
+			}
+			fmt.Printf("ok   %s\n", path)
+		}
+		return code
+	default:
+		fmt.Fprintf(os.Stderr, "config: unknown command %q\n", args[0])
+		return 2
+	}
+}
@@ -0,0 +1,464 @@
+// Package dependency isolates calls to the service's dependencies, such as
+// its database, cache or payment provider. Each has its own timeout and
+// concurrency limit, a bulkhead, so one slow dependency cannot tie up every
+// request while the others are healthy, and a circuit breaker failing calls
+// fast while it is down.
+package dependency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/circuit"
+	"go-otel/internal/envconfig"
+	"go-otel/retry"
+)
+
+const instrumentationName = "go-otel/dependency"
+
+// ErrSaturated is returned for calls rejected because the dependency's
+// bulkhead is full.
+var ErrSaturated = errors.New("dependency saturated")
+
+// ErrCircuitOpen is returned for calls failed fast because the circuit
+// breaker of the dependency is open.
+var ErrCircuitOpen = errors.New("dependency circuit open")
+
+// Options configures the calls to one dependency.
+type Options struct {
+	// Timeout bounds each call. Zero means none beyond the caller's.
+	Timeout time.Duration
+	// MaxConcurrent bounds the calls in flight. Zero means unbounded.
+	MaxConcurrent int
+	// MaxWait is how long a call may wait for a free slot before it is
+	// rejected with ErrSaturated. Zero rejects at once.
+	MaxWait time.Duration
+	// FailureThreshold is the number of consecutive failed calls opening
+	// the circuit. Zero disables the breaker.
+	FailureThreshold int
+	// OpenDuration is how long the circuit stays open before a trial call
+	// is let through. Zero means 30s.
+	OpenDuration time.Duration
+	// Retry retries failed calls with the retry policy of the set. Only
+	// idempotent calls may be retried.
+	Retry bool
+	// Address is the host:port of the dependency, dialed by
+	// Client.DialContext.
+	Address string
+	// Warm is the number of connections to Address kept dialed ahead of
+	// the calls needing them, so the first ones do not pay for a cold
+	// connection. Zero dials on demand.
+	Warm int
+	// WarmInterval is how often warm connections are topped up, and how
+	// long they may stay idle before they are replaced. Zero means 30s.
+	WarmInterval time.Duration
+}
+
+// DefaultOptions gives each call 5s and allows 32 of them at once, opening
+// the circuit for 30s after 5 consecutive failures.
+func DefaultOptions() Options {
+	return Options{
+		Timeout:          5 * time.Second,
+		MaxConcurrent:    32,
+		FailureThreshold: 5,
+		OpenDuration:     30 * time.Second,
+	}
+}
+
+// Config names the dependencies and their options.
+type Config map[string]Options
+
+// LoadEnv adds the dependencies listed in prefix + "NAMES", e.g.
+// GO_OTEL_DEPENDENCY_NAMES=db,cache, with DefaultOptions overridden by the
+// variables named prefix + NAME + "_" + suffix, e.g.
+// GO_OTEL_DEPENDENCY_DB_TIMEOUT.
+func (c Config) LoadEnv(prefix string) error {
+	var names []string
+	if err := envconfig.Load([]envconfig.Var{
+		{Name: prefix + "NAMES", Set: envconfig.List(&names)},
+	}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		o, ok := c[name]
+		if !ok {
+			o = DefaultOptions()
+		}
+		p := prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		if err := envconfig.Load([]envconfig.Var{
+			{Name: p + "TIMEOUT", Set: envconfig.Duration(&o.Timeout)},
+			{Name: p + "MAX_CONCURRENT", Set: envconfig.Int(&o.MaxConcurrent)},
+			{Name: p + "MAX_WAIT", Set: envconfig.Duration(&o.MaxWait)},
+			{Name: p + "FAILURE_THRESHOLD", Set: envconfig.Int(&o.FailureThreshold)},
+			{Name: p + "OPEN_DURATION", Set: envconfig.Duration(&o.OpenDuration)},
+			{Name: p + "RETRY", Set: envconfig.Bool(&o.Retry)},
+			{Name: p + "ADDRESS", Set: envconfig.String(&o.Address)},
+			{Name: p + "WARM", Set: envconfig.Int(&o.Warm)},
+			{Name: p + "WARM_INTERVAL", Set: envconfig.Duration(&o.WarmInterval)},
+		}); err != nil {
+			return err
+		}
+		c[name] = o
+	}
+	return nil
+}
+
+// Set holds a Client per configured dependency.
+type Set struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+	retries *retry.Policy
+}
+
+// NewSet creates a client per dependency of cfg, exporting their
+// saturation from the start. The dependencies opting in retry with
+// retries; nil disables retries.
+func NewSet(cfg Config, retries *retry.Policy) *Set {
+	s := &Set{clients: make(map[string]*Client, len(cfg)), retries: retries}
+	for name, opts := range cfg {
+		s.clients[name] = s.newClient(name, opts)
+	}
+	return s
+}
+
+func (s *Set) newClient(name string, opts Options) *Client {
+	c := New(name, opts)
+	if opts.Retry {
+		c.retries = s.retries
+	}
+	return c
+}
+
+// Get returns the client of the named dependency. Dependencies missing
+// from the config get a client with DefaultOptions, so a forgotten entry
+// does not leave calls unbounded.
+func (s *Set) Get(name string) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[name]; ok {
+		return c
+	}
+	c := s.newClient(name, DefaultOptions())
+	s.clients[name] = c
+	return c
+}
+
+// Close closes the warm connections of every dependency.
+func (s *Set) Close(ctx context.Context) error {
+	s.mu.Lock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	var errs []error
+	for _, c := range clients {
+		if err := c.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Names returns the configured dependencies, sorted.
+func (s *Set) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.clients))
+	for name := range s.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Client calls one dependency within its timeout, bulkhead and circuit
+// breaker.
+type Client struct {
+	name     string
+	opts     Options
+	slots    chan struct{}
+	inFlight atomic.Int64
+	breaker  *circuit.Breaker
+	retries  *retry.Policy
+	warm     *warmPool
+	tracer   trace.Tracer
+	attrs    attribute.Set
+
+	calls    metric.Int64Counter
+	duration metric.Float64Histogram
+	wait     metric.Float64Histogram
+	opened   metric.Int64Counter
+}
+
+// New returns the client of the dependency name.
+func New(name string, opts Options) *Client {
+	c := &Client{
+		name:   name,
+		opts:   opts,
+		tracer: otel.Tracer(instrumentationName),
+		attrs:  attribute.NewSet(attribute.String("dependency.name", name)),
+	}
+	if opts.MaxConcurrent > 0 {
+		c.slots = make(chan struct{}, opts.MaxConcurrent)
+	}
+	if opts.FailureThreshold > 0 {
+		if opts.OpenDuration <= 0 {
+			opts.OpenDuration = DefaultOptions().OpenDuration
+		}
+		c.breaker = &circuit.Breaker{Threshold: opts.FailureThreshold, Cooldown: opts.OpenDuration}
+	}
+
+	meter := otel.Meter(instrumentationName)
+	if opts.Warm > 0 && opts.Address != "" {
+		c.warm = newWarmPool(name, opts, meter, c.attrs)
+	}
+	c.calls, _ = meter.Int64Counter(
+		"dependency.calls",
+		metric.WithDescription("Calls to dependencies, by dependency and result."),
+	)
+	c.duration, _ = meter.Float64Histogram(
+		"dependency.call.duration",
+		metric.WithDescription("Duration of calls to dependencies, excluding the wait for a slot."),
+		metric.WithUnit("s"),
+	)
+	c.wait, _ = meter.Float64Histogram(
+		"dependency.wait.duration",
+		metric.WithDescription("Time calls waited for a slot in the bulkhead of their dependency."),
+		metric.WithUnit("s"),
+	)
+	c.opened, _ = meter.Int64Counter(
+		"circuit.open",
+		metric.WithDescription("Times the circuit breaker of a dependency opened."),
+	)
+	inFlight, err := meter.Int64ObservableGauge(
+		"dependency.in_flight",
+		metric.WithDescription("Calls in flight, by dependency."),
+	)
+	if err != nil {
+		return c
+	}
+	saturation, err := meter.Float64ObservableGauge(
+		"dependency.saturation",
+		metric.WithDescription("Share of the bulkhead of a dependency in use."),
+	)
+	if err != nil {
+		return c
+	}
+	state, err := meter.Int64ObservableGauge(
+		"circuit.state",
+		metric.WithDescription("State of the circuit breaker of a dependency: 0 closed, 1 half-open, 2 open."),
+	)
+	if err != nil {
+		return c
+	}
+	_, _ = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		n := c.inFlight.Load()
+		o.ObserveInt64(inFlight, n, metric.WithAttributeSet(c.attrs))
+		if opts.MaxConcurrent > 0 {
+			o.ObserveFloat64(saturation, float64(n)/float64(opts.MaxConcurrent), metric.WithAttributeSet(c.attrs))
+		}
+		if c.breaker != nil {
+			o.ObserveInt64(state, int64(c.breaker.State()), metric.WithAttributeSet(c.attrs))
+		}
+		return nil
+	}, inFlight, saturation, state)
+	return c
+}
+
+// Name returns the dependency name.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// DialContext dials the dependency at Options.Address, handing out a warm
+// connection when one is left, so database drivers and HTTP transports
+// can use it as their dialer. Other addresses are dialed as usual.
+func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.warm != nil && addr == c.opts.Address && strings.HasPrefix(network, "tcp") {
+		return c.warm.dial(ctx)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// Close closes the warm connections of the dependency and stops dialing
+// new ones.
+func (c *Client) Close(ctx context.Context) error {
+	if c.warm == nil {
+		return nil
+	}
+	return c.warm.close(ctx)
+}
+
+// Check returns ErrCircuitOpen while the circuit of the dependency is
+// open, for readiness checks.
+func (c *Client) Check(context.Context) error {
+	if c.breaker != nil && c.breaker.State() == circuit.Open {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Do calls fn in a span, once a slot is free, with ctx bounded by the
+// timeout. It returns ErrCircuitOpen without calling fn while the circuit
+// is open, and ErrSaturated when no slot frees up within MaxWait. If the
+// dependency retries, each attempt is such a call, but for those failed
+// fast by the circuit, which are not retried. The attempts are then
+// grouped under a span standing for the whole call; see retry.DoSpan.
+func (c *Client) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if c.retries == nil {
+		ctx, span := c.tracer.Start(ctx, "dependency "+c.name, c.spanOptions()...)
+		defer span.End()
+		err := c.do(ctx, span, fn)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+	return c.retries.DoSpan(ctx, "dependency "+c.name, func(ctx context.Context) error {
+		err := c.do(ctx, trace.SpanFromContext(ctx), fn)
+		if errors.Is(err, ErrCircuitOpen) {
+			return retry.Permanent(err)
+		}
+		return err
+	}, c.spanOptions()...)
+}
+
+func (c *Client) spanOptions() []trace.SpanStartOption {
+	return []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("peer.service", c.name)),
+	}
+}
+
+// do makes one call to the dependency, recording its circuit events in
+// span. The error is left for the caller to record.
+func (c *Client) do(ctx context.Context, span trace.Span, fn func(ctx context.Context) error) error {
+	if c.breaker != nil {
+		ok, t := c.breaker.Allow(time.Now())
+		c.transitioned(ctx, span, t)
+		if !ok {
+			err := fmt.Errorf("%s: %w", c.name, ErrCircuitOpen)
+			c.record(ctx, "circuit_open", 0)
+			span.AddEvent("circuit open", trace.WithAttributes(attribute.String("circuit.state", t.To.String())))
+			return err
+		}
+	}
+
+	if err := c.acquire(ctx); err != nil {
+		if c.breaker != nil {
+			c.breaker.Done(time.Now(), false, false)
+		}
+		c.record(ctx, "rejected", 0)
+		return err
+	}
+	defer c.release()
+
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := fn(ctx)
+	elapsed := time.Since(start)
+
+	result := "ok"
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result = "timeout"
+	default:
+		result = "error"
+	}
+	c.record(ctx, result, elapsed)
+	if c.breaker != nil {
+		// Calls cancelled by their caller say nothing of the dependency.
+		counted := !errors.Is(err, context.Canceled)
+		c.transitioned(ctx, span, c.breaker.Done(time.Now(), counted, err != nil))
+	}
+	return err
+}
+
+// transitioned records a change of circuit state on span, in circuit.open
+// when the circuit opened, and in the log.
+func (c *Client) transitioned(ctx context.Context, span trace.Span, t circuit.Transition) {
+	if !t.Changed() {
+		return
+	}
+	span.AddEvent("circuit state change", trace.WithAttributes(
+		attribute.String("circuit.state.from", t.From.String()),
+		attribute.String("circuit.state.to", t.To.String()),
+	))
+	ev := log.Info()
+	if t.To == circuit.Open {
+		c.opened.Add(ctx, 1, metric.WithAttributeSet(c.attrs))
+		ev = log.Warn()
+	}
+	ev.Str("dependency", c.name).Str("from", t.From.String()).Str("to", t.To.String()).Msg("circuit state changed")
+}
+
+func (c *Client) acquire(ctx context.Context) error {
+	if c.slots == nil {
+		c.inFlight.Add(1)
+		return nil
+	}
+	start := time.Now()
+	defer func() {
+		c.wait.Record(ctx, time.Since(start).Seconds(), metric.WithAttributeSet(c.attrs))
+	}()
+	select {
+	case c.slots <- struct{}{}:
+		c.inFlight.Add(1)
+		return nil
+	default:
+	}
+	if c.opts.MaxWait <= 0 {
+		return fmt.Errorf("%s: %w", c.name, ErrSaturated)
+	}
+	timer := time.NewTimer(c.opts.MaxWait)
+	defer timer.Stop()
+	select {
+	case c.slots <- struct{}{}:
+		c.inFlight.Add(1)
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%s: %w", c.name, ErrSaturated)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) release() {
+	c.inFlight.Add(-1)
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+func (c *Client) record(ctx context.Context, result string, elapsed time.Duration) {
+	attrs := metric.WithAttributes(
+		attribute.String("dependency.name", c.name),
+		attribute.String("result", result),
+	)
+	c.calls.Add(ctx, 1, attrs)
+	if result != "rejected" {
+		c.duration.Record(ctx, elapsed.Seconds(), attrs)
+	}
+}
@@ -0,0 +1,119 @@
+package dependency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("down")
+
+func TestBulkheadRejectsWhenFull(t *testing.T) {
+	c := New("db", Options{MaxConcurrent: 1})
+	release := hold(t, c)
+	defer release()
+
+	err := c.Do(context.Background(), func(context.Context) error {
+		t.Error("call ran while the bulkhead was full")
+		return nil
+	})
+	if !errors.Is(err, ErrSaturated) {
+		t.Fatalf("Do = %v, want ErrSaturated", err)
+	}
+}
+
+func TestBulkheadWaitsForSlot(t *testing.T) {
+	c := New("db", Options{MaxConcurrent: 1, MaxWait: time.Second})
+	release := hold(t, c)
+	time.AfterFunc(10*time.Millisecond, release)
+
+	called := false
+	if err := c.Do(context.Background(), func(context.Context) error {
+		called = true
+		return nil
+	}); err != nil || !called {
+		t.Fatalf("Do = %v, called %t, want the call made once a slot freed up", err, called)
+	}
+}
+
+func TestBreakerFailsFast(t *testing.T) {
+	c := New("db", Options{FailureThreshold: 2, OpenDuration: time.Minute})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := c.Do(ctx, fail); !errors.Is(err, errDown) {
+			t.Fatalf("call %d = %v, want the error of the call", i+1, err)
+		}
+	}
+	if err := c.Check(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Check = %v, want ErrCircuitOpen", err)
+	}
+	err := c.Do(ctx, func(context.Context) error {
+		t.Error("call ran while the circuit was open")
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Do = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerIgnoresCancelledCalls(t *testing.T) {
+	c := New("db", Options{FailureThreshold: 1, OpenDuration: time.Minute})
+	err := c.Do(context.Background(), func(context.Context) error { return context.Canceled })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do = %v, want context.Canceled", err)
+	}
+	if err := c.Check(context.Background()); err != nil {
+		t.Fatalf("Check = %v after a cancelled call, want the circuit closed", err)
+	}
+}
+
+func TestRejectedCallsDoNotOpenCircuit(t *testing.T) {
+	c := New("db", Options{MaxConcurrent: 1, FailureThreshold: 1, OpenDuration: time.Minute})
+	release := hold(t, c)
+	defer release()
+	for i := 0; i < 3; i++ {
+		if err := c.Do(context.Background(), fail); !errors.Is(err, ErrSaturated) {
+			t.Fatalf("Do = %v, want ErrSaturated", err)
+		}
+	}
+	if err := c.Check(context.Background()); err != nil {
+		t.Fatalf("Check = %v after rejected calls, want the circuit closed", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	c := New("db", Options{Timeout: 10 * time.Millisecond})
+	err := c.Do(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Do = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func fail(context.Context) error {
+	return errDown
+}
+
+// hold makes a call to c that stays in flight until release is called.
+func hold(t *testing.T, c *Client) (release func()) {
+	t.Helper()
+	started, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		_ = c.Do(context.Background(), func(context.Context) error {
+			close(started)
+			<-done
+			return nil
+		})
+	}()
+	<-started
+	var closed bool
+	return func() {
+		if !closed {
+			closed = true
+			close(done)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package dependency
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// defaultWarmInterval is how often warm connections are topped up and
+// replaced when Options.WarmInterval is zero.
+const defaultWarmInterval = 30 * time.Second
+
+// warmConn is a connection dialed ahead of the call needing it.
+type warmConn struct {
+	net.Conn
+	dialed time.Time
+}
+
+// warmPool keeps connections to a dependency dialed, so the first calls
+// after startup, or after a quiet period, do not pay for DNS, TCP and the
+// slow start of a cold connection. Connections idle for longer than the
+// interval are replaced, before load balancers and NATs drop them
+// silently.
+type warmPool struct {
+	name     string
+	addr     string
+	size     int
+	interval time.Duration
+	dialer   net.Dialer
+
+	mu      sync.Mutex
+	idle    []warmConn
+	filling bool
+	failing bool
+
+	stop context.CancelFunc
+	done chan struct{}
+
+	predials metric.Int64Counter
+	dials    metric.Int64Counter
+	reg      metric.Registration
+}
+
+func newWarmPool(name string, opts Options, meter metric.Meter, attrs attribute.Set) *warmPool {
+	p := &warmPool{
+		name:     name,
+		addr:     opts.Address,
+		size:     opts.Warm,
+		interval: opts.WarmInterval,
+		dialer:   net.Dialer{Timeout: opts.Timeout},
+		done:     make(chan struct{}),
+	}
+	if p.interval <= 0 {
+		p.interval = defaultWarmInterval
+	}
+	p.predials, _ = meter.Int64Counter(
+		"dependency.predials",
+		metric.WithDescription("Connections dialed ahead of calls to keep dependencies warm, by dependency and result."),
+	)
+	p.dials, _ = meter.Int64Counter(
+		"dependency.dials",
+		metric.WithDescription("Connections handed to calls, by dependency and whether they were warm."),
+	)
+	warm, err := meter.Int64ObservableGauge(
+		"dependency.warm.connections",
+		metric.WithDescription("Idle warm connections, by dependency."),
+	)
+	if err == nil {
+		p.reg, _ = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+			p.mu.Lock()
+			n := len(p.idle)
+			p.mu.Unlock()
+			o.ObserveInt64(warm, int64(n), metric.WithAttributeSet(attrs))
+			return nil
+		}, warm)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stop = cancel
+	go p.run(ctx)
+	return p
+}
+
+func (p *warmPool) run(ctx context.Context) {
+	defer close(p.done)
+	p.fill(ctx)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.expire(time.Now())
+			p.fill(ctx)
+		}
+	}
+}
+
+// fill dials until size connections are idle, stopping at the first
+// failure until the next tick. Only one fill runs at a time.
+func (p *warmPool) fill(ctx context.Context) {
+	p.mu.Lock()
+	if p.filling {
+		p.mu.Unlock()
+		return
+	}
+	p.filling = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.filling = false
+		p.mu.Unlock()
+	}()
+	for {
+		p.mu.Lock()
+		missing := p.size - len(p.idle)
+		p.mu.Unlock()
+		if missing <= 0 || ctx.Err() != nil {
+			return
+		}
+		conn, err := p.dialer.DialContext(ctx, "tcp", p.addr)
+		if ctx.Err() != nil {
+			// The pool closed while dialing.
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		p.predialed(ctx, err)
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		full := len(p.idle) >= p.size
+		if !full {
+			p.idle = append(p.idle, warmConn{Conn: conn, dialed: time.Now()})
+		}
+		p.mu.Unlock()
+		if full {
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// predialed counts a pre-dial, logging when pre-dials start or stop
+// failing.
+func (p *warmPool) predialed(ctx context.Context, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	p.predials.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("dependency.name", p.name),
+		attribute.String("result", result),
+	))
+	p.mu.Lock()
+	changed := p.failing != (err != nil)
+	p.failing = err != nil
+	p.mu.Unlock()
+	switch {
+	case !changed:
+	case err != nil:
+		log.Warn().Err(err).Str("dependency", p.name).Str("address", p.addr).Msg("failed to pre-dial dependency")
+	default:
+		log.Info().Str("dependency", p.name).Str("address", p.addr).Msg("pre-dialing dependency again")
+	}
+}
+
+// expire closes the connections idle for longer than the interval.
+func (p *warmPool) expire(now time.Time) {
+	p.mu.Lock()
+	var stale []warmConn
+	kept := p.idle[:0]
+	for _, c := range p.idle {
+		if now.Sub(c.dialed) >= p.interval {
+			stale = append(stale, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	p.idle = kept
+	p.mu.Unlock()
+	for _, c := range stale {
+		_ = c.Close()
+	}
+}
+
+// take returns a warm connection, or false if there is none young enough
+// to trust.
+func (p *warmPool) take(now time.Time) (net.Conn, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.idle) > 0 {
+		c := p.idle[0]
+		p.idle = p.idle[1:]
+		if now.Sub(c.dialed) < p.interval {
+			return c.Conn, true
+		}
+		_ = c.Close()
+	}
+	return nil, false
+}
+
+// dial hands a warm connection to a call, or dials one if there is none
+// left, waking the pool up to replace it.
+func (p *warmPool) dial(ctx context.Context) (net.Conn, error) {
+	conn, ok := p.take(time.Now())
+	p.dials.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("dependency.name", p.name),
+		attribute.Bool("dial.warm", ok),
+	))
+	if ok {
+		go p.fill(context.WithoutCancel(ctx))
+		return conn, nil
+	}
+	return p.dialer.DialContext(ctx, "tcp", p.addr)
+}
+
+// close stops the pool and closes its idle connections.
+func (p *warmPool) close(ctx context.Context) error {
+	p.stop()
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if p.reg != nil {
+		_ = p.reg.Unregister()
+	}
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.size = 0
+	p.mu.Unlock()
+	for _, c := range idle {
+		_ = c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go-otel/doctor"
+	"go-otel/telemetry"
+)
+
+// runDoctor implements the doctor subcommand, checking the configured OTLP
+// exporters, or -endpoint, can reach their collector. It returns the exit
+// code.
+func runDoctor(svcName string, dev bool, args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	endpoint := fs.String("endpoint", "", "check this host:port instead of the configured OTLP exporters")
+	insecure := fs.Bool("insecure", false, "connect to -endpoint without TLS")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of each check")
+	_ = fs.Parse(args)
+
+	d := &doctor.Doctor{ServiceName: svcName, Timeout: *timeout, Out: os.Stdout}
+	var targets []doctor.Target
+	if *endpoint != "" {
+		targets = append(targets, doctor.Target{Endpoint: *endpoint, Insecure: *insecure})
+	} else {
+		cfg, err := loadConfig(svcName, dev)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "invalid configuration:\n%v\n", err)
+			return 1
+		}
+		seen := make(map[doctor.Target]bool)
+		for _, eo := range cfg.telemetry.TraceExporters {
+			target := doctor.Target{Endpoint: eo.Endpoint, Insecure: eo.Insecure}
+			if eo.Kind == telemetry.ExporterOTLP && !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	if !d.Run(context.Background(), targets...) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,349 @@
+// Package doctor checks the service can reach its OTLP collector, explaining
+// what to fix when it cannot.
+package doctor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	collmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	colltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Target is an OTLP gRPC receiver to check.
+type Target struct {
+	// Endpoint is the host:port of the receiver.
+	Endpoint string
+	// Insecure connects without TLS.
+	Insecure bool
+}
+
+// Doctor runs the checks.
+type Doctor struct {
+	// ServiceName is reported in the test span and metric.
+	ServiceName string
+	// Timeout bounds each check. Zero means 5s.
+	Timeout time.Duration
+	// Out receives the report.
+	Out io.Writer
+}
+
+// check is the outcome of one step.
+type check struct {
+	name   string
+	detail string
+	err    error
+	hint   string
+}
+
+// Run checks every target in turn, from name resolution to exporting a test
+// span and metric, stopping at the first failing step of a target. It
+// reports whether every target passed.
+func (d *Doctor) Run(ctx context.Context, targets ...Target) bool {
+	if len(targets) == 0 {
+		d.report(check{
+			name: "config",
+			err:  errors.New("no OTLP exporter is configured"),
+			hint: "the dev preset prints to stdout; pass -endpoint to check a collector anyway",
+		})
+		return false
+	}
+	ok := true
+	for _, target := range targets {
+		fmt.Fprintf(d.Out, "%s (insecure: %t)\n", target.Endpoint, target.Insecure)
+		ok = d.runTarget(ctx, target) && ok
+	}
+	return ok
+}
+
+func (d *Doctor) runTarget(ctx context.Context, target Target) bool {
+	steps := []func(context.Context, Target) check{
+		d.checkEndpoint,
+		d.checkDNS,
+		d.checkDial,
+		d.checkTLS,
+		d.checkTraces,
+		d.checkMetrics,
+	}
+	for _, step := range steps {
+		ctx, cancel := context.WithTimeout(ctx, d.timeout())
+		c := step(ctx, target)
+		cancel()
+		if c.name == "" {
+			continue
+		}
+		d.report(c)
+		if c.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Doctor) timeout() time.Duration {
+	if d.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return d.Timeout
+}
+
+func (d *Doctor) report(c check) {
+	if c.err != nil {
+		fmt.Fprintf(d.Out, "  FAIL %-8s %v\n", c.name, c.err)
+		if c.hint != "" {
+			fmt.Fprintf(d.Out, "       %-8s %s\n", "", c.hint)
+		}
+		return
+	}
+	fmt.Fprintf(d.Out, "  ok   %-8s %s\n", c.name, c.detail)
+}
+
+func (d *Doctor) checkEndpoint(_ context.Context, target Target) check {
+	c := check{name: "endpoint", detail: target.Endpoint}
+	if strings.Contains(target.Endpoint, "://") {
+		c.err = fmt.Errorf("%q is a URL", target.Endpoint)
+		c.hint = "the gRPC exporter takes host:port, drop the scheme and any path"
+		return c
+	}
+	_, port, err := net.SplitHostPort(target.Endpoint)
+	if err != nil {
+		c.err = err
+		c.hint = "use host:port, e.g. otel-collector:4317"
+		return c
+	}
+	if port == "4318" {
+		// Only a warning, as a receiver may be configured on any port.
+		c.detail += " (4318 is usually the OTLP/HTTP port; the exporter speaks OTLP/gRPC, usually on 4317)"
+	}
+	return c
+}
+
+func (d *Doctor) checkDNS(ctx context.Context, target Target) check {
+	host, _, _ := net.SplitHostPort(target.Endpoint)
+	if net.ParseIP(host) != nil {
+		return check{}
+	}
+	c := check{name: "dns"}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		c.err = err
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			c.hint = fmt.Sprintf("no such host %q; check the spelling, and in Kubernetes use <service>.<namespace>.svc from other namespaces", host)
+		default:
+			c.hint = "the resolver did not answer; check /etc/resolv.conf and network policies allowing DNS"
+		}
+		return c
+	}
+	c.detail = fmt.Sprintf("%s -> %s", host, strings.Join(addrs, ", "))
+	return c
+}
+
+func (d *Doctor) checkDial(ctx context.Context, target Target) check {
+	c := check{name: "tcp"}
+	start := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", target.Endpoint)
+	if err != nil {
+		c.err = err
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			c.hint = "nothing listens there; is the collector running with the otlp receiver's grpc protocol enabled?"
+		case errors.Is(err, context.DeadlineExceeded), isTimeout(err):
+			c.hint = "the connection timed out; check firewalls, security groups and network policies"
+		default:
+			c.hint = "check the collector is reachable from this host"
+		}
+		return c
+	}
+	conn.Close()
+	c.detail = fmt.Sprintf("connected to %s in %s", conn.RemoteAddr(), time.Since(start).Round(time.Millisecond))
+	return c
+}
+
+func (d *Doctor) checkTLS(ctx context.Context, target Target) check {
+	if target.Insecure {
+		return check{}
+	}
+	c := check{name: "tls"}
+	host, _, _ := net.SplitHostPort(target.Endpoint)
+	dialer := &tls.Dialer{Config: &tls.Config{MinVersion: tls.VersionTLS12, ServerName: host}}
+	conn, err := dialer.DialContext(ctx, "tcp", target.Endpoint)
+	if err != nil {
+		c.err = err
+		c.hint = tlsHint(err, host)
+		return c
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	leaf := state.PeerCertificates[0]
+	c.detail = fmt.Sprintf("%s, certificate for %q expires %s", tls.VersionName(state.Version), leaf.Subject.CommonName, leaf.NotAfter.Format(time.DateOnly))
+	if time.Until(leaf.NotAfter) < 14*24*time.Hour {
+		c.detail += " (soon)"
+	}
+	return c
+}
+
+// tlsHint explains a failed TLS handshake with host.
+func tlsHint(err error, host string) string {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return "the certificate is signed by an unknown authority; add the collector's CA to the system trust store"
+	case errors.As(err, &hostname):
+		return fmt.Sprintf("the certificate is not valid for %q; connect with a name it was issued for", host)
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "the certificate has expired or is not valid yet; renew it, or check the clocks"
+	case errors.As(err, &invalid):
+		return "the certificate is invalid; check how it was issued"
+	case errors.As(err, &recordHeader):
+		return "the receiver does not speak TLS; configure the exporter as insecure, or enable TLS on the receiver"
+	default:
+		return "the TLS handshake failed; check the receiver's tls settings"
+	}
+}
+
+func (d *Doctor) dial(target Target) (*grpc.ClientConn, error) {
+	creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if target.Insecure {
+		creds = insecure.NewCredentials()
+	}
+	return grpc.Dial(target.Endpoint, grpc.WithTransportCredentials(creds))
+}
+
+func (d *Doctor) checkTraces(ctx context.Context, target Target) check {
+	c := check{name: "traces"}
+	conn, err := d.dial(target)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	defer conn.Close()
+
+	now := uint64(time.Now().UnixNano())
+	span := &tracepb.Span{
+		TraceId:           randomBytes(16),
+		SpanId:            randomBytes(8),
+		Name:              "doctor",
+		Kind:              tracepb.Span_SPAN_KIND_INTERNAL,
+		StartTimeUnixNano: now,
+		EndTimeUnixNano:   now,
+	}
+	resp, err := colltracepb.NewTraceServiceClient(conn).Export(ctx, &colltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource:   d.resource(),
+			ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{span}}},
+		}},
+	})
+	if err != nil {
+		c.err = err
+		c.hint = exportHint(err, target, "traces")
+		return c
+	}
+	if ps := resp.GetPartialSuccess(); ps.GetRejectedSpans() > 0 {
+		c.err = fmt.Errorf("the receiver rejected the span: %s", ps.GetErrorMessage())
+		return c
+	}
+	c.detail = fmt.Sprintf("sent span \"doctor\" in trace %x", span.TraceId)
+	return c
+}
+
+func (d *Doctor) checkMetrics(ctx context.Context, target Target) check {
+	c := check{name: "metrics"}
+	conn, err := d.dial(target)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	defer conn.Close()
+
+	m := &metricpb.Metric{
+		Name:        "doctor.check",
+		Description: "Sent by the doctor command.",
+		Data: &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{
+			DataPoints: []*metricpb.NumberDataPoint{{
+				TimeUnixNano: uint64(time.Now().UnixNano()),
+				Value:        &metricpb.NumberDataPoint_AsInt{AsInt: 1},
+			}},
+		}},
+	}
+	resp, err := collmetricpb.NewMetricsServiceClient(conn).Export(ctx, &collmetricpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricpb.ResourceMetrics{{
+			Resource:     d.resource(),
+			ScopeMetrics: []*metricpb.ScopeMetrics{{Metrics: []*metricpb.Metric{m}}},
+		}},
+	})
+	if err != nil {
+		c.err = err
+		c.hint = exportHint(err, target, "metrics")
+		return c
+	}
+	if ps := resp.GetPartialSuccess(); ps.GetRejectedDataPoints() > 0 {
+		c.err = fmt.Errorf("the receiver rejected the data point: %s", ps.GetErrorMessage())
+		return c
+	}
+	c.detail = `sent gauge "doctor.check"`
+	return c
+}
+
+// exportHint explains a failed export of signal to target.
+func exportHint(err error, target Target, signal string) string {
+	switch status.Code(err) {
+	case codes.Unimplemented:
+		return fmt.Sprintf("the receiver does not accept %s; add the otlp receiver to a %s pipeline of the collector", signal, signal)
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return "the receiver requires credentials the exporter does not send"
+	case codes.DeadlineExceeded:
+		return "the receiver did not answer in time; the collector may be overloaded"
+	case codes.ResourceExhausted:
+		return "the collector refuses data; check its memory_limiter and queue settings"
+	case codes.Unavailable:
+		if target.Insecure {
+			return "the connection failed; if the receiver serves TLS, configure the exporter as secure"
+		}
+		return "the connection failed; check the receiver is an OTLP gRPC endpoint"
+	default:
+		return ""
+	}
+}
+
+func (d *Doctor) resource() *resourcepb.Resource {
+	return &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+		Key:   "service.name",
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: d.ServiceName}},
+	}}}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
@@ -0,0 +1,226 @@
+// Package health answers the liveness and readiness probes of the
+// orchestrator from named checks, and keeps the last evaluations, so
+// flapping probes can be diagnosed after the fact.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"go-otel/internal/envconfig"
+)
+
+const instrumentationName = "go-otel/health"
+
+// Probe is the kind of probe a check answers.
+type Probe string
+
+const (
+	// Liveness fails when the process must be restarted.
+	Liveness Probe = "liveness"
+	// Readiness fails while the process must not receive traffic.
+	Readiness Probe = "readiness"
+)
+
+// Options configures the checker.
+type Options struct {
+	// History is how many evaluations are kept, of both probes. Zero
+	// means 100.
+	History int
+	// Timeout bounds each check. Zero means 2s.
+	Timeout time.Duration
+}
+
+// DefaultOptions keeps the last 100 evaluations and gives checks 2s.
+func DefaultOptions() Options {
+	return Options{History: 100, Timeout: 2 * time.Second}
+}
+
+// LoadEnv overrides opts with the variables named prefix + suffix, e.g.
+// GO_OTEL_HEALTH_HISTORY for the prefix "GO_OTEL_HEALTH_".
+func (o *Options) LoadEnv(prefix string) error {
+	return envconfig.Load([]envconfig.Var{
+		{Name: prefix + "HISTORY", Set: envconfig.Int(&o.History)},
+		{Name: prefix + "TIMEOUT", Set: envconfig.Duration(&o.Timeout)},
+	})
+}
+
+// CheckFunc reports why its check fails, or nil.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Failure is a check failing in an evaluation.
+type Failure struct {
+	Check string `json:"check"`
+	Error string `json:"error"`
+}
+
+// Evaluation is the outcome of a probe.
+type Evaluation struct {
+	Time       time.Time `json:"time"`
+	Probe      Probe     `json:"probe"`
+	Healthy    bool      `json:"healthy"`
+	Failing    []Failure `json:"failing,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// Checker evaluates the checks of each probe when it is called.
+type Checker struct {
+	opts Options
+
+	mu      sync.Mutex
+	checks  map[Probe][]check
+	healthy map[Probe]bool
+	// history is a ring of the last evaluations; next is where the next
+	// one goes.
+	history []Evaluation
+	next    int
+
+	failures metric.Int64Counter
+}
+
+// New returns a checker without checks, whose probes succeed.
+func New(opts Options) *Checker {
+	if opts.History <= 0 {
+		opts.History = DefaultOptions().History
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions().Timeout
+	}
+	c := &Checker{
+		opts:    opts,
+		checks:  make(map[Probe][]check),
+		healthy: make(map[Probe]bool),
+		history: make([]Evaluation, 0, opts.History),
+	}
+	c.failures, _ = otel.Meter(instrumentationName).Int64Counter(
+		"health.check.failures",
+		metric.WithDescription("Failed health checks, by probe and check."),
+	)
+	return c
+}
+
+// Add registers fn as the check name of probe.
+func (c *Checker) Add(probe Probe, name string, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[probe] = append(c.checks[probe], check{name: name, fn: fn})
+}
+
+// Evaluate runs the checks of probe concurrently, each within the
+// timeout, and records the outcome in the history.
+func (c *Checker) Evaluate(ctx context.Context, probe Probe) Evaluation {
+	c.mu.Lock()
+	checks := c.checks[probe]
+	c.mu.Unlock()
+
+	start := time.Now()
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, ch := range checks {
+		wg.Add(1)
+		go func(i int, ch check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+			defer cancel()
+			errs[i] = ch.fn(ctx)
+		}(i, ch)
+	}
+	wg.Wait()
+
+	e := Evaluation{Time: start, Probe: probe, Healthy: true}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		e.Healthy = false
+		e.Failing = append(e.Failing, Failure{Check: checks[i].name, Error: err.Error()})
+		c.failures.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("probe", string(probe)),
+			attribute.String("check", checks[i].name),
+		))
+	}
+	sort.Slice(e.Failing, func(i, j int) bool { return e.Failing[i].Check < e.Failing[j].Check })
+	e.DurationMS = float64(time.Since(start)) / float64(time.Millisecond)
+	c.record(e)
+	return e
+}
+
+// record adds e to the history, logging the probe changing state.
+func (c *Checker) record(e Evaluation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.history) < c.opts.History {
+		c.history = append(c.history, e)
+	} else {
+		c.history[c.next] = e
+	}
+	c.next = (c.next + 1) % c.opts.History
+
+	was, seen := c.healthy[e.Probe]
+	c.healthy[e.Probe] = e.Healthy
+	switch {
+	case !e.Healthy && (was || !seen):
+		failing := make([]string, len(e.Failing))
+		for i, f := range e.Failing {
+			failing[i] = f.Check
+		}
+		log.Warn().Str("probe", string(e.Probe)).Strs("failing", failing).Msg("health probe failing")
+	case e.Healthy && seen && !was:
+		log.Info().Str("probe", string(e.Probe)).Msg("health probe recovered")
+	}
+}
+
+// History returns the evaluations kept, oldest first.
+func (c *Checker) History() []Evaluation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := make([]Evaluation, 0, len(c.history))
+	if len(c.history) == c.opts.History {
+		h = append(h, c.history[c.next:]...)
+		return append(h, c.history[:c.next]...)
+	}
+	return append(h, c.history...)
+}
+
+// Handler answers probe: 200 when every check passes, 503 otherwise, with
+// the evaluation as JSON.
+func (c *Checker) Handler(probe Probe) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := c.Evaluate(r.Context(), probe)
+		if !e.Healthy {
+			render.Status(r, http.StatusServiceUnavailable)
+		}
+		render.JSON(w, r, e)
+	})
+}
+
+// HistoryHandler serves the evaluations kept, oldest first, as JSON, only
+// those of the probe named by the probe query parameter if set, and only
+// failed ones if failing=true.
+func (c *Checker) HistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probe := Probe(r.URL.Query().Get("probe"))
+		failing := r.URL.Query().Get("failing") == "true"
+		evaluations := []Evaluation{}
+		for _, e := range c.History() {
+			if (probe == "" || e.Probe == probe) && (!failing || !e.Healthy) {
+				evaluations = append(evaluations, e)
+			}
+		}
+		render.JSON(w, r, map[string]any{"evaluations": evaluations})
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go-otel/server"
+	"go-otel/telemetry"
+)
+
+// runHealthcheck implements the healthcheck subcommand, probing -path on
+// the API server through the loopback interface, for Docker HEALTHCHECK
+// and Kubernetes exec probes in images without curl. It returns 0 when the
+// endpoint answers 2xx within -timeout, and 1 otherwise.
+func runHealthcheck(svcName string, dev bool, args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	path := fs.String("path", "/readyz", "endpoint to probe")
+	timeout := fs.Duration("timeout", 3*time.Second, "timeout of the probe")
+	_ = fs.Parse(args)
+
+	cfg, err := loadConfig(svcName, dev)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: invalid configuration: %v\n", err)
+		return 1
+	}
+	srv, err := server.New(cfg.api, http.NotFoundHandler())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		return 1
+	}
+	base, tr := srv.Loopback()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+*path, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		return 1
+	}
+	req.Header.Set("User-Agent", svcName+"-healthcheck")
+	req.Header.Set(telemetry.SyntheticHeader, "healthcheck")
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		fmt.Fprintf(os.Stderr, "unhealthy: %s answered %s\n", *path, resp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+// Package circuit implements the circuit breaker shared by dependency
+// clients and telemetry exporters.
+package circuit
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker, exported as the value of the
+// circuit state gauges.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// HalfOpen lets a single trial call through, whose outcome closes or
+	// reopens the circuit.
+	HalfOpen
+	// Open fails every call fast until the cooldown has passed.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case HalfOpen:
+		return "half_open"
+	case Open:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// Transition is a change of circuit state; From == To means none.
+type Transition struct {
+	From, To State
+}
+
+// Changed reports whether the state changed.
+func (t Transition) Changed() bool {
+	return t.From != t.To
+}
+
+// Breaker opens after Threshold consecutive failures, and half-opens once
+// Cooldown has passed. The zero value never opens.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// Allow reports whether a call may proceed. A call allowed while half-open
+// is the trial, and must be followed by Done.
+func (b *Breaker) Allow(now time.Time) (bool, Transition) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := Transition{From: b.state, To: b.state}
+	if b.state == Open && now.Sub(b.openedAt) >= b.Cooldown {
+		b.state, t.To = HalfOpen, HalfOpen
+	}
+	switch b.state {
+	case Open:
+		return false, t
+	case HalfOpen:
+		if b.trial {
+			return false, t
+		}
+		b.trial = true
+	}
+	return true, t
+}
+
+// Done records the outcome of an allowed call. Calls that did not reach
+// the other end, such as those rejected by a bulkhead, pass counted false
+// and only give up the trial.
+func (b *Breaker) Done(now time.Time, counted, failed bool) Transition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := Transition{From: b.state, To: b.state}
+	wasTrial := b.state == HalfOpen && b.trial
+	if wasTrial {
+		b.trial = false
+	}
+	if !counted {
+		return t
+	}
+	switch {
+	case !failed:
+		b.failures = 0
+		if wasTrial {
+			b.state = Closed
+		}
+	case wasTrial:
+		b.state, b.openedAt = Open, now
+	default:
+		b.failures++
+		if b.state == Closed && b.Threshold > 0 && b.failures >= b.Threshold {
+			b.state, b.openedAt = Open, now
+		}
+	}
+	if b.state != Closed {
+		b.failures = 0
+	}
+	t.To = b.state
+	return t
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,112 @@
+package circuit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := &Breaker{Threshold: 3, Cooldown: time.Minute}
+	now := time.Now()
+	for i := 1; i <= 2; i++ {
+		mustAllow(t, b, now)
+		if tr := b.Done(now, true, true); tr.Changed() {
+			t.Fatalf("failure %d changed the state to %s, want closed", i, tr.To)
+		}
+	}
+	mustAllow(t, b, now)
+	if tr := b.Done(now, true, true); tr != (Transition{From: Closed, To: Open}) {
+		t.Fatalf("third failure: got %+v, want closed to open", tr)
+	}
+	if ok, _ := b.Allow(now.Add(time.Second)); ok {
+		t.Fatal("open circuit allowed a call before the cooldown")
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := &Breaker{Threshold: 2, Cooldown: time.Minute}
+	now := time.Now()
+	for _, failed := range []bool{true, false, true} {
+		mustAllow(t, b, now)
+		b.Done(now, true, failed)
+	}
+	if s := b.State(); s != Closed {
+		t.Fatalf("state %s after failures separated by a success, want closed", s)
+	}
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		failed bool
+		want   State
+	}{
+		{"success closes", false, Closed},
+		{"failure reopens", true, Open},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, opened := openBreaker(t)
+			later := opened.Add(time.Minute)
+			ok, tr := b.Allow(later)
+			if !ok || tr != (Transition{From: Open, To: HalfOpen}) {
+				t.Fatalf("Allow after the cooldown = %t, %+v, want the trial, open to half-open", ok, tr)
+			}
+			if ok, _ := b.Allow(later); ok {
+				t.Fatal("half-open circuit allowed a second call during the trial")
+			}
+			if tr := b.Done(later, true, tc.failed); tr.To != tc.want {
+				t.Fatalf("trial done: state %s, want %s", tr.To, tc.want)
+			}
+			if tc.want == Open {
+				if ok, _ := b.Allow(later.Add(time.Second)); ok {
+					t.Fatal("reopened circuit allowed a call before a new cooldown")
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerUncountedTrial(t *testing.T) {
+	b, opened := openBreaker(t)
+	later := opened.Add(time.Minute)
+	mustAllow(t, b, later)
+	if tr := b.Done(later, false, false); tr.Changed() {
+		t.Fatalf("uncounted trial changed the state to %s", tr.To)
+	}
+	if s := b.State(); s != HalfOpen {
+		t.Fatalf("state %s after an uncounted trial, want half-open", s)
+	}
+	// The trial was given up, so another call may try.
+	mustAllow(t, b, later)
+}
+
+func TestBreakerZeroValueNeverOpens(t *testing.T) {
+	var b Breaker
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		mustAllow(t, &b, now)
+		b.Done(now, true, true)
+	}
+	if s := b.State(); s != Closed {
+		t.Fatalf("zero value breaker is %s, want closed", s)
+	}
+}
+
+// openBreaker returns a breaker opened by a failure, and when it opened.
+func openBreaker(t *testing.T) (*Breaker, time.Time) {
+	t.Helper()
+	b := &Breaker{Threshold: 1, Cooldown: time.Minute}
+	now := time.Now()
+	mustAllow(t, b, now)
+	if tr := b.Done(now, true, true); tr.To != Open {
+		t.Fatalf("failure: state %s, want open", tr.To)
+	}
+	return b, now
+}
+
+func mustAllow(t *testing.T, b *Breaker, now time.Time) {
+	t.Helper()
+	if ok, _ := b.Allow(now); !ok {
+		t.Fatalf("%s circuit refused a call", b.State())
+	}
+}
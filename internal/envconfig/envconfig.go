@@ -0,0 +1,186 @@
+// Package envconfig parses configuration from environment variables.
+package envconfig
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Var binds an environment variable to a setter.
+type Var struct {
+	Name string
+	Set  func(string) error
+}
+
+// Load runs the setter of every variable that is set and non-empty. Errors
+// name the offending variable.
+func Load(vars []Var) error {
+	for _, v := range vars {
+		s, ok := os.LookupEnv(v.Name)
+		if !ok || s == "" {
+			continue
+		}
+		if err := v.Set(s); err != nil {
+			return fmt.Errorf("%s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// String stores the raw value.
+func String(p *string) func(string) error {
+	return func(s string) error {
+		*p = s
+		return nil
+	}
+}
+
+// Int parses a positive integer.
+func Int(p *int) func(string) error {
+	return func(s string) error {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		if v <= 0 {
+			return fmt.Errorf("must be positive, got %d", v)
+		}
+		*p = v
+		return nil
+	}
+}
+
+// Int64 parses a positive 64-bit integer.
+func Int64(p *int64) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		if v <= 0 {
+			return fmt.Errorf("must be positive, got %d", v)
+		}
+		*p = v
+		return nil
+	}
+}
+
+// Float parses a positive floating point number.
+func Float(p *float64) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		if v <= 0 {
+			return fmt.Errorf("must be positive, got %g", v)
+		}
+		*p = v
+		return nil
+	}
+}
+
+// Bool parses a boolean as strconv.ParseBool does.
+func Bool(p *bool) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*p = v
+		return nil
+	}
+}
+
+// Millis parses an integer number of milliseconds, as the OTEL_ variables use.
+func Millis(p *time.Duration) func(string) error {
+	return func(s string) error {
+		var ms int
+		if err := Int(&ms)(s); err != nil {
+			return err
+		}
+		*p = time.Duration(ms) * time.Millisecond
+		return nil
+	}
+}
+
+// Duration parses a positive Go duration such as "1m30s".
+func Duration(p *time.Duration) func(string) error {
+	return func(s string) error {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		if v <= 0 {
+			return fmt.Errorf("must be positive, got %s", v)
+		}
+		*p = v
+		return nil
+	}
+}
+
+// List parses comma separated values, dropping empty ones.
+func List(p *[]string) func(string) error {
+	return func(s string) error {
+		var list []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		*p = list
+		return nil
+	}
+}
+
+// DurationMap parses comma separated key=duration pairs, e.g.
+// "/foo=200ms,/bar=1s", into the map at p.
+func DurationMap(p *map[string]time.Duration) func(string) error {
+	return func(s string) error {
+		m := make(map[string]time.Duration)
+		for _, pair := range strings.Split(s, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return fmt.Errorf("%q is not key=duration", pair)
+			}
+			var d time.Duration
+			if err := Duration(&d)(v); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			m[k] = d
+		}
+		*p = m
+		return nil
+	}
+}
+
+// Map parses comma separated key=value pairs, e.g. "a=1,b=2", into the
+// map at p.
+func Map(p *map[string]string) func(string) error {
+	return func(s string) error {
+		m := make(map[string]string)
+		for _, pair := range strings.Split(s, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return fmt.Errorf("%q is not key=value", pair)
+			}
+			m[k] = v
+		}
+		*p = m
+		return nil
+	}
+}
+
+// Flagged records in set that the wrapped setter ran successfully.
+func Flagged(set *bool, fn func(string) error) func(string) error {
+	return func(s string) error {
+		if err := fn(s); err != nil {
+			return err
+		}
+		*set = true
+		return nil
+	}
+}
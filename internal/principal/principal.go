@@ -0,0 +1,49 @@
+// Package principal carries the caller authenticated by the admin server
+// in request contexts, for the packages recording who changed what.
+package principal
+
+import "context"
+
+// Role is what an authenticated caller may do. Each role includes the ones
+// before it.
+type Role int
+
+const (
+	// Viewer may read, e.g. scrape metrics or get the runtime settings.
+	Viewer Role = iota + 1
+	// Operator may also change the running service, e.g. its log level,
+	// sampling, or flush its telemetry.
+	Operator
+)
+
+func (r Role) String() string {
+	switch r {
+	case Viewer:
+		return "viewer"
+	case Operator:
+		return "operator"
+	default:
+		return "none"
+	}
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Name identifies the credential, e.g. "basic:alice" or
+	// "viewer-token". It is never the secret.
+	Name string
+	Role Role
+}
+
+type key struct{}
+
+// NewContext returns ctx carrying p.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, key{}, p)
+}
+
+// FromContext returns the principal ctx carries.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(key{}).(Principal)
+	return p, ok
+}
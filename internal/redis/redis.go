@@ -0,0 +1,221 @@
+// Package redis is a minimal Redis client speaking RESP2, enough for the
+// few commands the service sends without pulling in a full client.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNil is returned for nil replies, such as GET of a missing key.
+var ErrNil = errors.New("redis: nil")
+
+// Error is an error reply.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// maxIdle bounds the idle connections kept for reuse.
+const maxIdle = 8
+
+// Client sends commands over a pool of connections.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	dialer   net.Dialer
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+// New returns a client for a redis://[:password@]host:port[/db] URL. It
+// does not connect until the first command.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("redis url %q must be redis://host:port", rawURL)
+	}
+	c := &Client{addr: u.Host, dialer: net.Dialer{Timeout: 5 * time.Second}}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis url %q: invalid database %q", rawURL, db)
+		}
+	}
+	return c, nil
+}
+
+// Addr returns the host:port of the server.
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Do sends a command and returns its reply: a string, an int64, a []any or
+// nil. Error replies are returned as Error, nil replies as ErrNil.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(ctx, args...)
+	var rerr Error
+	if err != nil && !errors.Is(err, ErrNil) && !errors.As(err, &rerr) {
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Close closes the idle connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for _, cn := range c.idle {
+		errs = append(errs, cn.Close())
+	}
+	c.idle = nil
+	return errors.Join(errs...)
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	nc, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.password != "" {
+		if _, err := cn.do(ctx, "AUTH", c.password); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.do(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (cn *conn) do(ctx context.Context, args ...string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(cn, b.String()); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+func (cn *conn) read() (any, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		// Nested nil and error replies become items, so the rest of the
+		// array is still read off the connection.
+		items := make([]any, n)
+		for i := range items {
+			item, err := cn.read()
+			var rerr Error
+			switch {
+			case errors.Is(err, ErrNil):
+			case errors.As(err, &rerr):
+				items[i] = rerr
+			case err != nil:
+				return nil, err
+			default:
+				items[i] = item
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+
+	"github.com/rs/zerolog/log"
+
+	"go-otel/loadgen"
+	"go-otel/telemetry"
+)
+
+// runLoadgen implements the loadgen subcommand, sending synthetic traces and
+// metrics to the configured exporters. It returns the exit code.
+func runLoadgen(svcName string, dev bool, args []string) int {
+	opts := loadgen.DefaultOptions()
+	fs := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	fs.Float64Var(&opts.Rate, "rate", opts.Rate, "traces started per second")
+	fs.DurationVar(&opts.Duration, "duration", opts.Duration, "how long to generate load; 0 runs until interrupted")
+	fs.IntVar(&opts.Spans, "spans", opts.Spans, "spans per trace")
+	fs.IntVar(&opts.Depth, "depth", opts.Depth, "maximum depth of each trace")
+	fs.IntVar(&opts.Attributes, "attributes", opts.Attributes, "attributes per span")
+	fs.IntVar(&opts.Cardinality, "cardinality", opts.Cardinality, "distinct values of each attribute")
+	fs.Float64Var(&opts.ErrorRate, "error-rate", opts.ErrorRate, "fraction of spans ending with an error")
+	_ = fs.Parse(args)
+
+	cfg, err := loadConfig(svcName, dev)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid configuration")
+		return 1
+	}
+	// Every generated trace is meant to reach the exporters.
+	cfg.telemetry.SampleRatio = 1
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	tel, err := telemetry.Setup(ctx, cfg.telemetry)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to set up telemetry")
+		return 1
+	}
+	defer func() {
+		if err := tel.Shutdown(context.Background()); err != nil {
+			log.Error().Err(err).Msg("failed to shut down telemetry")
+		}
+	}()
+
+	g, err := loadgen.New(opts)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid load")
+		return 1
+	}
+	g.Run(ctx)
+	return 0
+}
@@ -0,0 +1,224 @@
+// Package loadgen generates synthetic traces and metrics through the
+// telemetry stack, so collectors and backends can be load tested with the
+// exact client the service uses.
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "go-otel/loadgen"
+
+// Options shapes the generated load.
+type Options struct {
+	// Rate is the number of traces started per second.
+	Rate float64
+	// Duration bounds the run. Zero runs until the context is done.
+	Duration time.Duration
+	// Spans is the number of spans per trace, and Depth the maximum depth
+	// of its tree, the root being at depth 1.
+	Spans int
+	Depth int
+	// Attributes is the number of attributes per span, each taking one of
+	// Cardinality values. Metric data points get one attribute of the same
+	// cardinality.
+	Attributes  int
+	Cardinality int
+	// ErrorRate is the fraction of spans ending with an error.
+	ErrorRate float64
+}
+
+// DefaultOptions generates 10 traces of 10 spans per second.
+func DefaultOptions() Options {
+	return Options{
+		Rate:        10,
+		Spans:       10,
+		Depth:       3,
+		Attributes:  5,
+		Cardinality: 100,
+		ErrorRate:   0.01,
+	}
+}
+
+// Validate reports options that cannot generate load.
+func (o Options) Validate() error {
+	var errs []error
+	if o.Rate <= 0 {
+		errs = append(errs, fmt.Errorf("rate must be positive, got %g", o.Rate))
+	}
+	if o.Spans < 1 || o.Depth < 1 {
+		errs = append(errs, fmt.Errorf("spans and depth must be at least 1, got %d and %d", o.Spans, o.Depth))
+	}
+	if o.Attributes < 0 || o.Cardinality < 1 {
+		errs = append(errs, fmt.Errorf("attributes must not be negative and cardinality must be at least 1, got %d and %d", o.Attributes, o.Cardinality))
+	}
+	if o.ErrorRate < 0 || o.ErrorRate > 1 {
+		errs = append(errs, fmt.Errorf("error rate must be between 0 and 1, got %g", o.ErrorRate))
+	}
+	return errors.Join(errs...)
+}
+
+// Generator emits traces and metrics through the global providers.
+type Generator struct {
+	opts   Options
+	tracer trace.Tracer
+	rng    *rand.Rand
+
+	operations metric.Int64Counter
+	duration   metric.Float64Histogram
+
+	traces, spans, errors int64
+}
+
+// New returns a generator. Setup must have registered the providers.
+func New(opts Options) (*Generator, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	g := &Generator{
+		opts:   opts,
+		tracer: otel.Tracer(instrumentationName),
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	meter := otel.Meter(instrumentationName)
+	g.operations, _ = meter.Int64Counter(
+		"loadgen.operations",
+		metric.WithDescription("Synthetic operations, one per generated trace."),
+	)
+	g.duration, _ = meter.Float64Histogram(
+		"loadgen.operation.duration",
+		metric.WithDescription("Synthetic duration of the generated traces."),
+		metric.WithUnit("s"),
+	)
+	return g, nil
+}
+
+// Run generates traces at the configured rate until Duration has passed or
+// ctx is done, logging progress every 10s.
+func (g *Generator) Run(ctx context.Context) {
+	if g.opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opts.Duration)
+		defer cancel()
+	}
+	log.Info().
+		Float64("rate", g.opts.Rate).
+		Int("spans", g.opts.Spans).
+		Int("depth", g.opts.Depth).
+		Int("cardinality", g.opts.Cardinality).
+		Float64("error_rate", g.opts.ErrorRate).
+		Msg("generating load")
+
+	// Ticks are coarse enough to keep up with high rates; each emits the
+	// traces due since the previous one.
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	progress := time.NewTicker(10 * time.Second)
+	defer progress.Stop()
+
+	start, last, due := time.Now(), time.Now(), 0.0
+	for {
+		select {
+		case <-ctx.Done():
+			g.logProgress("load generation done", time.Since(start))
+			return
+		case <-progress.C:
+			g.logProgress("generating load", time.Since(start))
+		case now := <-ticker.C:
+			due += g.opts.Rate * now.Sub(last).Seconds()
+			last = now
+			for ; due >= 1; due-- {
+				g.trace(ctx)
+			}
+		}
+	}
+}
+
+func (g *Generator) logProgress(msg string, elapsed time.Duration) {
+	log.Info().
+		Int64("traces", g.traces).
+		Int64("spans", g.spans).
+		Int64("errors", g.errors).
+		Dur("elapsed", elapsed).
+		Msg(msg)
+}
+
+// trace emits one trace of random shape, with timestamps laid out as if its
+// spans had run one after the other.
+func (g *Generator) trace(ctx context.Context) {
+	type node struct {
+		ctx   context.Context
+		span  trace.Span
+		depth int
+	}
+
+	now := time.Now()
+	ctx, root := g.tracer.Start(ctx, "loadgen operation",
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(now),
+		trace.WithAttributes(g.attributes()...),
+	)
+	nodes := []node{{ctx: ctx, span: root, depth: 1}}
+	// Parents are picked among the spans that can still have children.
+	parents := []int{0}
+	if g.opts.Depth == 1 {
+		parents = nil
+	}
+	at := now
+	for i := 1; i < g.opts.Spans && len(parents) > 0; i++ {
+		parent := nodes[parents[g.rng.Intn(len(parents))]]
+		at = at.Add(time.Duration(g.rng.Intn(5000)) * time.Microsecond)
+		cctx, span := g.tracer.Start(parent.ctx, fmt.Sprintf("loadgen step %d", parent.depth),
+			trace.WithSpanKind(trace.SpanKindInternal),
+			trace.WithTimestamp(at),
+			trace.WithAttributes(g.attributes()...),
+		)
+		nodes = append(nodes, node{ctx: cctx, span: span, depth: parent.depth + 1})
+		if parent.depth+1 < g.opts.Depth {
+			parents = append(parents, len(nodes)-1)
+		}
+	}
+
+	// End children before their parents, each a little after it started.
+	failed := false
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if g.rng.Float64() < g.opts.ErrorRate {
+			failed = true
+			g.errors++
+			err := errors.New("synthetic failure")
+			nodes[i].span.RecordError(err)
+			nodes[i].span.SetStatus(codes.Error, err.Error())
+		}
+		at = at.Add(time.Duration(g.rng.Intn(1000)) * time.Microsecond)
+		nodes[i].span.End(trace.WithTimestamp(at))
+	}
+	g.traces++
+	g.spans += int64(len(nodes))
+
+	attrs := metric.WithAttributes(
+		attribute.Int("loadgen.series", g.rng.Intn(g.opts.Cardinality)),
+		attribute.Bool("error", failed),
+	)
+	g.operations.Add(ctx, 1, attrs)
+	g.duration.Record(ctx, at.Sub(now).Seconds(), attrs)
+}
+
+func (g *Generator) attributes() []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, g.opts.Attributes)
+	for i := range attrs {
+		attrs[i] = attribute.String(fmt.Sprintf("loadgen.attr%d", i), fmt.Sprintf("value-%d", g.rng.Intn(g.opts.Cardinality)))
+	}
+	return attrs
+}
@@ -3,106 +3,297 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"flag"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
-	prom "github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/riandyrn/otelchi"
+	"github.com/google/gops/agent"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/exporters/prometheus"
-	"go.opentelemetry.io/otel/sdk/metric"
-	"go.opentelemetry.io/otel/sdk/resource"
-	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/metric"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+
+	"go-otel/dependency"
+	"go-otel/health"
+	"go-otel/probe"
+	"go-otel/profiling"
+	"go-otel/retry"
+	"go-otel/server"
+	"go-otel/shutdown"
+	"go-otel/taskstore"
+	"go-otel/telemetry"
 )
 
-var fooCounter = promauto.NewCounter(prom.CounterOpts{
-	Name: "api_foo_requests_total",
-	Help: "Total number of requests to the /foo endpoint.",
-})
+// errShuttingDown fails readiness once shutdown has started.
+var errShuttingDown = errors.New("shutting down")
 
 func main() {
-	// Create a context with a cancelletion
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	dev := flag.Bool("dev", false, "print telemetry to stdout instead of exporting it to a collector")
+	flag.Parse()
 
-	// initialize trace provider
 	svcName := "go-otel"
-	initTracer(ctx, svcName)
+	switch flag.Arg(0) {
+	case "doctor":
+		os.Exit(runDoctor(svcName, *dev, flag.Args()[1:]))
+	case "loadgen":
+		os.Exit(runLoadgen(svcName, *dev, flag.Args()[1:]))
+	case "collector-config":
+		os.Exit(runCollectorConfig(svcName, *dev, flag.Args()[1:]))
+	case "healthcheck":
+		os.Exit(runHealthcheck(svcName, *dev, flag.Args()[1:]))
+	case "replay":
+		os.Exit(runReplay(flag.Args()[1:]))
+	case "config":
+		os.Exit(runConfig(flag.Args()[1:]))
+	}
 
-	// The exporter embeds a default OpenTelemetry Reader and
-	// implements prometheus.Collector, allowing it to be used as
-	exporter, err := prometheus.New()
+	// Done on SIGINT or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := loadConfig(svcName, *dev)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to create prometheus exporter")
+		log.Fatal().Err(err).Msg("invalid configuration")
 	}
-	provider := metric.NewMeterProvider(metric.WithReader(exporter))
-	otel.SetMeterProvider(provider)
 
-	// Start the prometheus HTTP server and pass the exporter Collector to it
-	go serveMetrics()
+	// initialize trace and meter providers
+	tel, err := telemetry.Setup(ctx, cfg.telemetry)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to set up telemetry")
+	}
+	seq := shutdown.New(cfg.shutdown)
+	seq.Add(shutdown.PhaseTelemetry, "telemetry", tel.Shutdown)
+
+	fooCounter, err := otel.Meter(svcName).Int64Counter(
+		"api_foo_requests",
+		metric.WithDescription("Total number of requests to the /foo endpoint."),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create foo counter")
+	}
+
+	// Handlers call their dependencies through deps.Get(name).Do.
+	retries, err := retry.New(cfg.retry)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid retry configuration")
+	}
+	deps := dependency.NewSet(cfg.deps, retries)
+	seq.Add(shutdown.PhaseConsumers, "dependency connections", deps.Close)
+	if names := deps.Names(); len(names) > 0 {
+		log.Info().Strs("dependencies", names).Msg("bulkheads configured")
+	}
+
+	// Readiness fails while the circuit of a dependency is open, and once
+	// the service is shutting down.
+	checks := health.New(cfg.health)
+	for _, name := range deps.Names() {
+		checks.Add(health.Readiness, "dependency "+name, deps.Get(name).Check)
+	}
+	checks.Add(health.Readiness, "shutdown", func(context.Context) error {
+		if ctx.Err() != nil {
+			return errShuttingDown
+		}
+		return nil
+	})
+
+	// Asynchronous tasks store their results in tasks for clients to poll.
+	tasks, err := taskstore.New(cfg.tasks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create task store")
+	}
+	seq.Add(shutdown.PhaseConsumers, "task store", tasks.Close)
 
 	router := chi.NewRouter()
 
 	// router.Use(httplog.RequestLogger(l))
 	router.Use(middleware.Heartbeat("/ping"))
-	router.Use(middleware.Recoverer)
 	router.Use(render.SetContentType(render.ContentTypeJSON))
 	router.Use(middleware.RequestID)
-	router.Use(otelchi.Middleware(svcName))
+	router.Use(tel.HTTPMiddleware(telemetry.MiddlewareOptions{ServerName: svcName, Routes: router}))
+	if cfg.profiling.URL != "" {
+		router.Use(profiling.Middleware)
+	}
 
 	router.Get("/foo", func(w http.ResponseWriter, r *http.Request) {
 		// Increment the counter for each request to /foo
-		fooCounter.Inc()
+		fooCounter.Add(r.Context(), 1)
 
 		w.Write([]byte("bar"))
-		log.Info().Caller().Str("foo", "bar").Msg("get")
+		log.Ctx(r.Context()).Info().Str("foo", "bar").Msg("get")
 	})
 
-	addr := fmt.Sprintf("0.0.0.0:%d", 8080)
-	log.Info().Caller().Msgf("listening: %s", addr)
-	http.ListenAndServe(addr, router)
-}
+	router.Get("/tasks/{id}", tasks.Handler())
+	router.Get("/healthz", checks.Handler(health.Liveness).ServeHTTP)
+	router.Get("/readyz", checks.Handler(health.Readiness).ServeHTTP)
 
-func initTracer(ctx context.Context, svcName string) {
-	client := otlptracegrpc.NewClient(
-		otlptracegrpc.WithEndpoint("localhost:4317"),
-		otlptracegrpc.WithInsecure(), // Use WithInsecure for non-TLS, or configure TLS with appropriate options.
-	)
-	// Configure the OTLP exporter to send traces to your Otel Collector.
-	exporter, err := otlptrace.New(ctx, client)
+	if cfg.rum {
+		router.Handle("/rum/events", tel.RUMHandler())
+	}
+
+	if cfg.metricsOnAPI {
+		router.Group(func(r chi.Router) {
+			r.Use(server.RequireAuth(cfg.adminAuth))
+			mountMetrics(r, tel)
+		})
+	}
+	srv, err := server.New(cfg.api, router)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create api server")
+	}
+	log.Info().Bool("tls", srv.TLS()).Msgf("listening: %s", srv.Endpoint())
+	admin, err := newAdminServer(tel, checks, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create admin server")
+	}
+	metrics, err := newMetricsServer(tel, cfg)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to create exporter")
+		log.Fatal().Err(err).Msg("failed to create metrics server")
 	}
 
-	// Create a new trace provider with a batch span processor and the otlp exporter.
-	tp := trace.NewTracerProvider(
-		trace.WithBatcher(exporter),
-		trace.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(svcName),
-		)),
-	)
+	// The admin and metrics servers stop after the API server, so metrics
+	// can be scraped while requests drain.
+	servers := server.NewGroup(srv, admin, metrics)
+	if err := servers.Start(); err != nil {
+		log.Fatal().Err(err).Msg("failed to start servers")
+	}
+	for _, s := range servers.Servers() {
+		seq.Add(shutdown.PhaseTraffic, s.Name()+" server", s.Shutdown)
+	}
+
+	if cfg.probe.Enabled {
+		baseURL, rt := srv.Loopback()
+		probeCtx, stopProbe := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			probe.New(cfg.probe, baseURL, rt).Run(probeCtx)
+		}()
+		seq.Add(shutdown.PhaseSchedulers, "probe", func(ctx context.Context) error {
+			stopProbe()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}
+
+	if cfg.gops {
+		// The agent has no authentication: it listens on loopback unless
+		// told otherwise. It stops last, so a stuck shutdown can still be
+		// inspected.
+		addr := cfg.gopsAddr
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+		if err := agent.Listen(agent.Options{Addr: addr}); err != nil {
+			log.Fatal().Err(err).Msg("failed to start gops agent")
+		}
+		seq.Add(shutdown.PhaseTelemetry, "gops agent", func(context.Context) error {
+			agent.Close()
+			return nil
+		})
+		log.Info().Str("addr", addr).Msg("gops agent started")
+	}
+
+	if cfg.profiling.URL != "" {
+		profiler := profiling.New(cfg.profiling, tel.Resource())
+		go profiler.Run()
+		seq.Add(shutdown.PhaseSchedulers, "profiler", profiler.Stop)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-servers.Done():
+		log.Error().Err(servers.Err()).Msg("server failed, shutting down")
+	}
+	stop()
+	if err := seq.Run(context.Background()); err != nil {
+		log.Error().Err(err).Msg("shutdown incomplete")
+	}
+	if servers.Err() != nil {
+		os.Exit(1)
+	}
+}
 
-	// Register the trace provider globally.
-	otel.SetTracerProvider(tp)
+// mountMetrics adds the prometheus scrape endpoints to r.
+func mountMetrics(r chi.Router, tel *telemetry.Telemetry) {
+	h := tel.Handler()
+	r.Handle("/metrics", h)
+	r.Handle("/metrics/metadata", h)
 }
 
-func serveMetrics() {
-	log.Info().Caller().Msgf("metrics: %s", "localhost:2222/metrics")
-	http.Handle("/metrics", promhttp.Handler())
-	err := http.ListenAndServe(":2222", nil) //nolint:gosec // Ignoring G114: Use of net/http serve function that has no support for setting timeouts.
+// mountDebug adds pprof under /debug/pprof, expvar at /debug/vars and the
+// effective telemetry configuration at /debug/config to r.
+func mountDebug(r chi.Router, tel *telemetry.Telemetry) {
+	r.Mount("/debug", middleware.Profiler())
+	r.Handle("/debug/config", tel.ConfigHandler())
+}
+
+// newAdminServer builds the admin server, which hosts the runtime control
+// and flush endpoints, the health probe history, the metrics endpoints
+// unless they are mounted on the API router or served on their own, and the
+// debug endpoints when enabled. Viewers may read; changes and the debug
+// endpoints need an operator.
+func newAdminServer(tel *telemetry.Telemetry, checks *health.Checker, cfg config) (*server.Server, error) {
+	router := chi.NewRouter()
+	router.Use(server.RequireAuth(cfg.adminAuth))
+	router.Use(auditAdmin(cfg.telemetry.ServiceName))
+
+	operator := server.RequireRole(server.RoleOperator)
+	control := tel.ControlHandler()
+	router.Method(http.MethodGet, "/control", control)
+	router.Method(http.MethodHead, "/control", control)
+	router.Method(http.MethodGet, "/version", telemetry.VersionHandler())
+	router.Method(http.MethodGet, "/health/history", checks.HistoryHandler())
+	router.With(operator).Method(http.MethodPut, "/control", control)
+	router.With(operator).Method(http.MethodPatch, "/control", control)
+	router.With(operator).Method(http.MethodPost, "/flush", tel.FlushHandler())
+	router.With(operator).Method(http.MethodPost, "/credentials/rotate", tel.CredentialsHandler())
+	verbose := tel.VerboseHandler()
+	router.Method(http.MethodGet, "/verbose", verbose)
+	router.With(operator).Method(http.MethodPost, "/verbose", verbose)
+	router.With(operator).Method(http.MethodDelete, "/verbose", verbose)
+	if !cfg.metricsOnAPI && cfg.metrics.Addr == "" {
+		mountMetrics(router, tel)
+	}
+	if cfg.debug {
+		router.Group(func(r chi.Router) {
+			r.Use(operator)
+			mountDebug(r, tel)
+		})
+	}
+
+	srv, err := server.New(cfg.admin, router)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Bool("tls", srv.TLS()).Bool("auth", cfg.adminAuth.Enabled()).Bool("debug", cfg.debug).Msgf("admin: %s", srv.Endpoint())
+	return srv, nil
+}
+
+// newMetricsServer builds the server dedicated to the metrics endpoints, or
+// returns nil when they are not served on their own. It is protected like
+// the admin endpoints.
+func newMetricsServer(tel *telemetry.Telemetry, cfg config) (*server.Server, error) {
+	if cfg.metrics.Addr == "" {
+		return nil, nil
+	}
+	router := chi.NewRouter()
+	router.Use(server.RequireAuth(cfg.adminAuth))
+	mountMetrics(router, tel)
+
+	srv, err := server.New(cfg.metrics, router)
 	if err != nil {
-		fmt.Printf("error serving http: %v", err)
-		return
+		return nil, err
 	}
+	log.Info().Bool("tls", srv.TLS()).Bool("auth", cfg.adminAuth.Enabled()).Msgf("metrics: %s", srv.Endpoint())
+	return srv, nil
 }
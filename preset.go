@@ -0,0 +1,8 @@
+//go:build !debug
+
+package main
+
+import "go-otel/telemetry"
+
+// buildPreset is the preset used when GO_OTEL_ENV is not set.
+const buildPreset = telemetry.PresetProd
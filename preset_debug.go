@@ -0,0 +1,9 @@
+//go:build debug
+
+package main
+
+import "go-otel/telemetry"
+
+// buildPreset is the preset used when GO_OTEL_ENV is not set. Debug builds,
+// such as those of make debug, default to the debug preset.
+const buildPreset = telemetry.PresetDebug
@@ -0,0 +1,202 @@
+// Package probe periodically calls the service's own endpoints, so traces
+// and availability metrics exist even without real traffic.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/envconfig"
+	"go-otel/telemetry"
+)
+
+const instrumentationName = "go-otel/probe"
+
+// Options configures the prober.
+type Options struct {
+	Enabled bool
+	// Paths are probed in turn every Interval. A probe succeeds on a 2xx
+	// response within Timeout.
+	Paths    []string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// DefaultOptions probes paths every 30s, disabled until enabled.
+func DefaultOptions(paths ...string) Options {
+	return Options{
+		Paths:    paths,
+		Interval: 30 * time.Second,
+		Timeout:  5 * time.Second,
+	}
+}
+
+// LoadEnv overrides opts with the variables named prefix + suffix, e.g.
+// GO_OTEL_PROBE_INTERVAL for the prefix "GO_OTEL_PROBE_".
+func (o *Options) LoadEnv(prefix string) error {
+	return envconfig.Load([]envconfig.Var{
+		{Name: prefix + "ENABLED", Set: envconfig.Bool(&o.Enabled)},
+		{Name: prefix + "PATHS", Set: envconfig.List(&o.Paths)},
+		{Name: prefix + "INTERVAL", Set: envconfig.Duration(&o.Interval)},
+		{Name: prefix + "TIMEOUT", Set: envconfig.Duration(&o.Timeout)},
+	})
+}
+
+// Prober calls a server's endpoints through a loopback transport. Its
+// requests carry telemetry.SyntheticHeader, so their server spans are
+// tagged as synthetic.
+type Prober struct {
+	opts    Options
+	baseURL string
+	client  *http.Client
+	tracer  trace.Tracer
+
+	checks   metric.Int64Counter
+	duration metric.Float64Histogram
+
+	mu sync.Mutex
+	up map[string]bool
+}
+
+// New returns a prober for the server at baseURL reached through rt, as
+// returned by server.Server.Loopback.
+func New(opts Options, baseURL string, rt http.RoundTripper) *Prober {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultOptions().Interval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions().Timeout
+	}
+
+	p := &Prober{
+		opts:    opts,
+		baseURL: baseURL,
+		client:  &http.Client{Transport: rt, Timeout: opts.Timeout},
+		tracer:  otel.Tracer(instrumentationName),
+		up:      make(map[string]bool),
+	}
+
+	meter := otel.Meter(instrumentationName)
+	p.checks, _ = meter.Int64Counter(
+		"probe.checks",
+		metric.WithDescription("Synthetic probes by path and result."),
+	)
+	p.duration, _ = meter.Float64Histogram(
+		"probe.duration",
+		metric.WithDescription("Duration of synthetic probes."),
+		metric.WithUnit("s"),
+	)
+	if gauge, err := meter.Int64ObservableGauge(
+		"probe.up",
+		metric.WithDescription("Whether the last synthetic probe of a path succeeded."),
+	); err == nil {
+		_, _ = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			for path, up := range p.up {
+				v := int64(0)
+				if up {
+					v = 1
+				}
+				o.ObserveInt64(gauge, v, metric.WithAttributes(attribute.String("probe.path", path)))
+			}
+			return nil
+		}, gauge)
+	}
+	return p
+}
+
+// Run probes every path each interval until ctx is done.
+func (p *Prober) Run(ctx context.Context) {
+	if len(p.opts.Paths) == 0 {
+		return
+	}
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		for _, path := range p.opts.Paths {
+			p.probe(ctx, path)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Prober) probe(ctx context.Context, path string) {
+	ctx, span := p.tracer.Start(ctx, "probe GET "+path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", http.MethodGet),
+			attribute.String("url.full", p.baseURL+path),
+			attribute.String("user_agent.synthetic.type", "test"),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	err := p.get(ctx, span, path)
+	elapsed := time.Since(start)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("probe.path", path),
+		attribute.String("probe.result", result),
+	)
+	p.checks.Add(ctx, 1, attrs)
+	p.duration.Record(ctx, elapsed.Seconds(), attrs)
+
+	p.mu.Lock()
+	wasUp, seen := p.up[path]
+	p.up[path] = err == nil
+	p.mu.Unlock()
+
+	switch {
+	case err != nil && (wasUp || !seen):
+		log.Warn().Err(err).Str("probe.path", path).Msg("synthetic probe failing")
+	case err == nil && seen && !wasUp:
+		log.Info().Str("probe.path", path).Msg("synthetic probe recovered")
+	}
+}
+
+func (p *Prober) get(ctx context.Context, span trace.Span, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(telemetry.SyntheticHeader, "probe")
+	req.Header.Set("User-Agent", "go-otel-probe")
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+// Package profiling continuously profiles the service and pushes the
+// profiles to Pyroscope or Parca. Profiles are tagged with the service name
+// and version of the resource, and CPU samples taken while serving a
+// request with its trace and span IDs, so flamegraphs can be opened from
+// traces.
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/envconfig"
+)
+
+const instrumentationName = "go-otel/profiling"
+
+// Kind selects the profiling backend.
+type Kind string
+
+const (
+	// KindPyroscope pushes to the /ingest endpoint of Pyroscope.
+	KindPyroscope Kind = "pyroscope"
+	// KindParca pushes to the WriteRaw endpoint of the Parca profile store.
+	KindParca Kind = "parca"
+)
+
+// Options configures the profiler.
+type Options struct {
+	Kind Kind
+	// URL is the base URL of the backend. Empty disables profiling.
+	URL string
+	// Interval is the duration of each CPU profile, and how often the
+	// other profiles are taken.
+	Interval time.Duration
+	// Profiles are the profiles taken: "cpu" and the names known to
+	// runtime/pprof, such as "heap", "goroutine", "mutex" and "block".
+	Profiles []string
+	// Timeout bounds each upload.
+	Timeout time.Duration
+}
+
+// DefaultOptions profiles CPU and heap every 15s into Pyroscope, disabled
+// until a URL is set.
+func DefaultOptions() Options {
+	return Options{
+		Kind:     KindPyroscope,
+		Interval: 15 * time.Second,
+		Profiles: []string{"cpu", "heap"},
+		Timeout:  10 * time.Second,
+	}
+}
+
+// LoadEnv overrides opts with the variables named prefix + suffix, e.g.
+// GO_OTEL_PROFILING_URL for the prefix "GO_OTEL_PROFILING_".
+func (o *Options) LoadEnv(prefix string) error {
+	return envconfig.Load([]envconfig.Var{
+		{Name: prefix + "KIND", Set: func(v string) error { o.Kind = Kind(v); return nil }},
+		{Name: prefix + "URL", Set: envconfig.String(&o.URL)},
+		{Name: prefix + "INTERVAL", Set: envconfig.Duration(&o.Interval)},
+		{Name: prefix + "PROFILES", Set: envconfig.List(&o.Profiles)},
+		{Name: prefix + "TIMEOUT", Set: envconfig.Duration(&o.Timeout)},
+	})
+}
+
+// Validate reports options the profiler cannot run with.
+func (o Options) Validate() error {
+	if o.URL == "" {
+		return nil
+	}
+	var errs []error
+	switch o.Kind {
+	case KindPyroscope, KindParca:
+	default:
+		errs = append(errs, fmt.Errorf("unknown profiling backend %q", o.Kind))
+	}
+	if u, err := url.Parse(o.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("profiling url %q must be an absolute http(s) URL", o.URL))
+	}
+	for _, p := range o.Profiles {
+		if p != "cpu" && pprof.Lookup(p) == nil {
+			errs = append(errs, fmt.Errorf("unknown profile %q", p))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Middleware labels the goroutine serving each traced request with its
+// trace_id and span_id, so its CPU samples carry them, and records the
+// span ID as pyroscope.profile.id on the span, for backends linking spans
+// to profiles. It must run after the tracing middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		sc := span.SpanContext()
+		if !sc.IsValid() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		spanID := sc.SpanID().String()
+		span.SetAttributes(attribute.String("pyroscope.profile.id", spanID))
+		labels := pprof.Labels("trace_id", sc.TraceID().String(), "span_id", spanID)
+		pprof.Do(r.Context(), labels, func(ctx context.Context) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+}
+
+// Profiler takes profiles every interval and pushes them.
+type Profiler struct {
+	opts   Options
+	name   string
+	labels map[string]string
+	client *http.Client
+
+	uploads metric.Int64Counter
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a profiler tagging profiles with the service.name,
+// service.version and deployment.environment of res.
+func New(opts Options, res *resource.Resource) *Profiler {
+	def := DefaultOptions()
+	if opts.Interval <= 0 {
+		opts.Interval = def.Interval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = def.Timeout
+	}
+	p := &Profiler{
+		opts:   opts,
+		name:   "go-otel",
+		labels: make(map[string]string),
+		client: &http.Client{Timeout: opts.Timeout},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, kv := range res.Attributes() {
+		switch kv.Key {
+		case "service.name":
+			p.name = kv.Value.Emit()
+		case "service.version", "deployment.environment":
+		default:
+			continue
+		}
+		p.labels[strings.ReplaceAll(string(kv.Key), ".", "_")] = kv.Value.Emit()
+	}
+	p.uploads, _ = otel.Meter(instrumentationName).Int64Counter(
+		"profiling.uploads",
+		metric.WithDescription("Profiles pushed, by profile type and result."),
+	)
+	return p
+}
+
+// Run profiles until Stop is called.
+func (p *Profiler) Run() {
+	defer close(p.done)
+	for {
+		start := time.Now()
+		cpu, cpuErr := p.cpuProfile()
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		end := time.Now()
+		if cpuErr != nil {
+			log.Warn().Err(cpuErr).Msg("cpu profile skipped")
+		} else if cpu != nil {
+			p.upload("cpu", cpu, start, end)
+		}
+		for _, name := range p.opts.Profiles {
+			if name == "cpu" {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := pprof.Lookup(name).WriteTo(&buf, 0); err != nil {
+				log.Warn().Err(err).Str("profile", name).Msg("profile skipped")
+				continue
+			}
+			p.upload(name, buf.Bytes(), start, end)
+		}
+	}
+}
+
+// cpuProfile profiles the CPU for an interval, or waits for one when CPU
+// profiles are not taken. It returns early when the profiler stops. CPU
+// profiles cannot overlap, so it fails while one is taken elsewhere, e.g.
+// through /debug/pprof/profile.
+func (p *Profiler) cpuProfile() ([]byte, error) {
+	timer := time.NewTimer(p.opts.Interval)
+	defer timer.Stop()
+	wait := func() {
+		select {
+		case <-timer.C:
+		case <-p.stop:
+		}
+	}
+	if !slices.Contains(p.opts.Profiles, "cpu") {
+		wait()
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		wait()
+		return nil, err
+	}
+	wait()
+	pprof.StopCPUProfile()
+	return buf.Bytes(), nil
+}
+
+// Stop stops profiling. The profile being taken is dropped.
+func (p *Profiler) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Profiler) upload(profile string, data []byte, start, end time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.Timeout)
+	defer cancel()
+	var err error
+	switch p.opts.Kind {
+	case KindParca:
+		err = p.pushParca(ctx, profile, data)
+	default:
+		err = p.pushPyroscope(ctx, profile, data, start, end)
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+		log.Warn().Err(err).Str("profile", profile).Msg("profile upload failed")
+	}
+	p.uploads.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("profile", profile),
+		attribute.String("result", result),
+	))
+}
+
+// pushPyroscope sends a pprof profile to the Pyroscope ingest API, which
+// names the application and its tags as name{k=v,...}.
+func (p *Profiler) pushPyroscope(ctx context.Context, profile string, data []byte, start, end time.Time) error {
+	keys := make([]string, 0, len(p.labels))
+	for k := range p.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := make([]string, len(keys))
+	for i, k := range keys {
+		tags[i] = k + "=" + p.labels[k]
+	}
+	q := url.Values{
+		"name":       {p.name + "." + profile + "{" + strings.Join(tags, ",") + "}"},
+		"from":       {strconv.FormatInt(start.Unix(), 10)},
+		"until":      {strconv.FormatInt(end.Unix(), 10)},
+		"format":     {"pprof"},
+		"spyName":    {"gospy"},
+		"sampleRate": {"100"},
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return p.post(ctx, strings.TrimSuffix(p.opts.URL, "/")+"/ingest?"+q.Encode(), mw.FormDataContentType(), &body)
+}
+
+// parcaNames are the names Parca gives the profiles its agents collect.
+var parcaNames = map[string]string{"cpu": "process_cpu", "heap": "memory"}
+
+type parcaLabel struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// pushParca sends a pprof profile to the Parca profile store, through the
+// JSON mapping of its WriteRaw RPC.
+func (p *Profiler) pushParca(ctx context.Context, profile string, data []byte) error {
+	name, ok := parcaNames[profile]
+	if !ok {
+		name = profile
+	}
+	labels := []parcaLabel{{"__name__", name}}
+	for k, v := range p.labels {
+		labels = append(labels, parcaLabel{k, v})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	req := map[string]any{
+		"series": []any{map[string]any{
+			"labels":  map[string]any{"labels": labels},
+			"samples": []any{map[string]any{"rawProfile": data}},
+		}},
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(p.opts.URL, "/") + "/parca.profilestore.v1alpha1.ProfileStoreService/WriteRaw"
+	return p.post(ctx, u, "application/json", bytes.NewReader(b))
+}
+
+func (p *Profiler) post(ctx context.Context, u, contentType string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
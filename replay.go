@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"go-otel/replay"
+)
+
+// runReplay implements the replay subcommand, sending the requests
+// captured with GO_OTEL_CAPTURE_DIR, or those of the trace IDs given, to
+// -target again and comparing the statuses. It returns the exit code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	dir := fs.String("dir", os.Getenv("GO_OTEL_CAPTURE_DIR"), "directory of the captured requests")
+	target := fs.String("target", "http://localhost:8080", "base URL of the service to replay against")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	_ = fs.Parse(args)
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "replay: -dir or GO_OTEL_CAPTURE_DIR is required")
+		return 2
+	}
+
+	payloads, err := replay.Load(*dir, fs.Args()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := &http.Client{Timeout: *timeout}
+	code := 0
+	for _, p := range payloads {
+		if ctx.Err() != nil {
+			return 1
+		}
+		status, err := replay.Send(ctx, client, *target, p)
+		switch {
+		case err != nil:
+			fmt.Printf("FAIL %s %s %s: %v\n", p.TraceID, p.Method, p.URL, err)
+			code = 1
+		case status != p.Status:
+			fmt.Printf("DIFF %s %s %s: %d, captured %d\n", p.TraceID, p.Method, p.URL, status, p.Status)
+			code = 1
+		default:
+			fmt.Printf("ok   %s %s %s: %d\n", p.TraceID, p.Method, p.URL, status)
+		}
+	}
+	fmt.Printf("%d requests replayed\n", len(payloads))
+	return code
+}
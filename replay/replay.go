@@ -0,0 +1,98 @@
+// Package replay sends requests captured by the telemetry middleware in dev
+// mode, or archived in production, to a service again, so a bug seen once
+// can be reproduced against a local build.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go-otel/telemetry"
+)
+
+// skippedHeaders are not replayed: they describe the captured connection
+// or trace rather than the request, so each replay starts a trace of its
+// own.
+var skippedHeaders = []string{
+	"Connection", "Content-Length", "Host", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Traceparent", "Tracestate",
+}
+
+// Load reads the payloads captured under dir, oldest first. With traceIDs,
+// only the payloads of those traces are read.
+func Load(dir string, traceIDs ...string) ([]telemetry.ArchivedPayload, error) {
+	roots := []string{dir}
+	if len(traceIDs) > 0 {
+		roots = roots[:0]
+		for _, id := range traceIDs {
+			roots = append(roots, filepath.Join(dir, id))
+		}
+	}
+	var payloads []telemetry.ArchivedPayload
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+				return err
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var p telemetry.ArchivedPayload
+			if err := json.Unmarshal(b, &p); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			payloads = append(payloads, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(payloads, func(i, j int) bool { return payloads[i].Time.Before(payloads[j].Time) })
+	return payloads, nil
+}
+
+// Send sends the request of p to target, the base URL of the service, and
+// returns the status it answered with. Credentials were left out of the
+// capture, so routes requiring them answer 401.
+func Send(ctx context.Context, client *http.Client, target string, p telemetry.ArchivedPayload) (int, error) {
+	base, err := url.Parse(target)
+	if err != nil {
+		return 0, err
+	}
+	captured, err := url.Parse(p.URL)
+	if err != nil {
+		return 0, err
+	}
+	u := base.JoinPath(captured.Path)
+	u.RawQuery = captured.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, p.Method, u.String(), strings.NewReader(p.RequestBody))
+	if err != nil {
+		return 0, err
+	}
+	req.Header = p.RequestHeaders.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	for _, name := range skippedHeaders {
+		req.Header.Del(name)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
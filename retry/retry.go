@@ -0,0 +1,441 @@
+// Package retry retries failed outbound calls with pluggable backoff
+// strategies. Calls can be split between several strategies, the arms of
+// an experiment, whose outcomes are exported per strategy, so retry
+// policies can be compared on real traffic.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/envconfig"
+)
+
+const instrumentationName = "go-otel/retry"
+
+// Names of the built-in strategies.
+const (
+	Exponential        = "exponential"
+	DecorrelatedJitter = "decorrelated_jitter"
+	Adaptive           = "adaptive"
+)
+
+// Strategy decides whether and when a failed call is retried.
+type Strategy interface {
+	// Name labels the outcomes of the strategy.
+	Name() string
+	// Backoff returns the delay before retry n, from 1, which follows a
+	// delay of prev, or false to give up.
+	Backoff(n int, prev time.Duration) (time.Duration, bool)
+	// Observe is told the outcome of every attempt, for strategies
+	// adapting to them.
+	Observe(err error)
+}
+
+// Options configures the retries of outbound calls.
+type Options struct {
+	// Strategies maps the names of the strategies calls are split
+	// between, exponential, decorrelated_jitter or adaptive, to their
+	// weight, e.g. {"exponential": 9, "adaptive": 1} to try adaptive
+	// retries on a tenth of the calls.
+	Strategies map[string]float64
+	// MaxAttempts bounds the attempts of a call, the first included.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay before any retry.
+	MaxDelay time.Duration
+	// Retryable reports whether a failed attempt may be retried. Nil
+	// retries every error but the cancellation of the call.
+	Retryable func(error) bool
+}
+
+// DefaultOptions retries every call up to twice with exponential backoff,
+// from 100ms up to 5s.
+func DefaultOptions() Options {
+	return Options{
+		Strategies:  map[string]float64{Exponential: 1},
+		MaxAttempts: 3,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	}
+}
+
+// LoadEnv overrides opts with the variables named prefix + suffix, e.g.
+// GO_OTEL_RETRY_STRATEGIES=exponential=9,adaptive=1 for the prefix
+// "GO_OTEL_RETRY_".
+func (o *Options) LoadEnv(prefix string) error {
+	return envconfig.Load([]envconfig.Var{
+		{Name: prefix + "STRATEGIES", Set: func(s string) error {
+			var m map[string]string
+			if err := envconfig.Map(&m)(s); err != nil {
+				return err
+			}
+			weights := make(map[string]float64, len(m))
+			for name, w := range m {
+				f, err := strconv.ParseFloat(w, 64)
+				if err != nil {
+					return fmt.Errorf("weight of %s: %w", name, err)
+				}
+				weights[name] = f
+			}
+			o.Strategies = weights
+			return nil
+		}},
+		{Name: prefix + "MAX_ATTEMPTS", Set: envconfig.Int(&o.MaxAttempts)},
+		{Name: prefix + "BASE_DELAY", Set: envconfig.Duration(&o.BaseDelay)},
+		{Name: prefix + "MAX_DELAY", Set: envconfig.Duration(&o.MaxDelay)},
+	})
+}
+
+func (o Options) validate() error {
+	var errs []error
+	if len(o.Strategies) == 0 {
+		errs = append(errs, errors.New("at least one strategy is required"))
+	}
+	total := 0.0
+	for name, w := range o.Strategies {
+		switch name {
+		case Exponential, DecorrelatedJitter, Adaptive:
+		default:
+			errs = append(errs, fmt.Errorf("unknown retry strategy %q", name))
+		}
+		if w < 0 {
+			errs = append(errs, fmt.Errorf("weight of retry strategy %s must not be negative, got %g", name, w))
+		}
+		total += w
+	}
+	if len(o.Strategies) > 0 && total <= 0 {
+		errs = append(errs, errors.New("the weights of the retry strategies must not all be zero"))
+	}
+	if o.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("max attempts must be at least 1, got %d", o.MaxAttempts))
+	}
+	if o.BaseDelay <= 0 {
+		errs = append(errs, fmt.Errorf("base delay must be positive, got %s", o.BaseDelay))
+	}
+	if o.MaxDelay < o.BaseDelay {
+		errs = append(errs, fmt.Errorf("max delay %s must not be below the base delay %s", o.MaxDelay, o.BaseDelay))
+	}
+	return errors.Join(errs...)
+}
+
+// newStrategy returns the built-in strategy name.
+func newStrategy(name string, base, ceiling time.Duration) Strategy {
+	switch name {
+	case DecorrelatedJitter:
+		return decorrelatedJitter{base: base, ceiling: ceiling}
+	case Adaptive:
+		return newAdaptive(base, ceiling)
+	default:
+		return exponential{base: base, ceiling: ceiling}
+	}
+}
+
+// Arm is a strategy and its share of calls.
+type Arm struct {
+	Strategy Strategy
+	Weight   float64
+}
+
+// Policy retries calls, each with a strategy drawn by weight.
+type Policy struct {
+	opts  Options
+	arms  []Arm
+	total float64
+
+	tracer trace.Tracer
+
+	calls    metric.Int64Counter
+	attempts metric.Int64Histogram
+	duration metric.Float64Histogram
+}
+
+// New returns the policy of opts, with the built-in strategies it names.
+func New(opts Options) (*Policy, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(opts.Strategies))
+	for name := range opts.Strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	arms := make([]Arm, 0, len(names))
+	for _, name := range names {
+		arms = append(arms, Arm{Strategy: newStrategy(name, opts.BaseDelay, opts.MaxDelay), Weight: opts.Strategies[name]})
+	}
+	return NewWithArms(opts, arms...), nil
+}
+
+// NewWithArms returns a policy splitting calls between the strategies of
+// arms, by weight, for strategies other than the built-in ones. The
+// strategies of opts are ignored.
+func NewWithArms(opts Options, arms ...Arm) *Policy {
+	p := &Policy{opts: opts, tracer: otel.Tracer(instrumentationName)}
+	for _, a := range arms {
+		if a.Weight > 0 {
+			p.arms = append(p.arms, a)
+			p.total += a.Weight
+		}
+	}
+	if p.opts.MaxAttempts < 1 {
+		p.opts.MaxAttempts = 1
+	}
+
+	meter := otel.Meter(instrumentationName)
+	p.calls, _ = meter.Int64Counter(
+		"retry.calls",
+		metric.WithDescription("Calls made with retries, by strategy and result."),
+	)
+	p.attempts, _ = meter.Int64Histogram(
+		"retry.attempts",
+		metric.WithDescription("Attempts per call, by strategy and result."),
+		metric.WithExplicitBucketBoundaries(1, 2, 3, 4, 5, 6, 8, 10),
+	)
+	p.duration, _ = meter.Float64Histogram(
+		"retry.call.duration",
+		metric.WithDescription("Duration of calls made with retries, backoff included, by strategy and result."),
+		metric.WithUnit("s"),
+	)
+	return p
+}
+
+// pick draws the strategy of a call, by weight.
+func (p *Policy) pick() Strategy {
+	x := rand.Float64() * p.total
+	for _, a := range p.arms {
+		if x < a.Weight {
+			return a.Strategy
+		}
+		x -= a.Weight
+	}
+	return p.arms[len(p.arms)-1].Strategy
+}
+
+// permanentError is a failure retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, whatever Retryable says. Do
+// returns err itself. A nil err stays nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func (p *Policy) retryable(ctx context.Context, err error) bool {
+	var pe *permanentError
+	if ctx.Err() != nil || errors.As(err, &pe) {
+		return false
+	}
+	if p.opts.Retryable != nil {
+		return p.opts.Retryable(err)
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// outcome sums up a call made with retries.
+type outcome struct {
+	strategy string
+	attempts int
+	result   string
+	// delay is the total backoff between the attempts.
+	delay time.Duration
+}
+
+// Do calls fn until it succeeds, its error is not retryable, the strategy
+// drawn for the call gives up, or MaxAttempts are made, and returns its
+// last error. Each retry is an event of the span of ctx. The outcome is
+// recorded as ok, failed when the call was not retried further, or
+// exhausted when it ran out of attempts.
+func (p *Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	_, err := p.do(ctx, fn)
+	return err
+}
+
+// DoSpan is Do within a span, name, standing for the logical operation, so
+// backends show one operation rather than a sibling span per attempt. Each
+// attempt is a child span, name + " attempt", started with opts and
+// retry.attempt, in which fn is called. The logical span is summed up in
+// retry.strategy, retry.attempts, retry.result and retry.delay_ms, the
+// total backoff, and carries the retry events.
+func (p *Policy) DoSpan(ctx context.Context, name string, fn func(ctx context.Context) error, opts ...trace.SpanStartOption) error {
+	ctx, span := p.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+
+	n := 0
+	out, err := p.do(ctx, func(ctx context.Context) error {
+		n++
+		ctx, attempt := p.tracer.Start(ctx, name+" attempt",
+			append(opts, trace.WithAttributes(attribute.Int("retry.attempt", n)))...)
+		defer attempt.End()
+		err := fn(ctx)
+		if err != nil {
+			attempt.RecordError(err)
+			attempt.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	})
+	span.SetAttributes(
+		attribute.String("retry.strategy", out.strategy),
+		attribute.Int("retry.attempts", out.attempts),
+		attribute.String("retry.result", out.result),
+		attribute.Int64("retry.delay_ms", out.delay.Milliseconds()),
+	)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (p *Policy) do(ctx context.Context, fn func(ctx context.Context) error) (outcome, error) {
+	s := p.pick()
+	span := trace.SpanFromContext(ctx)
+	start := time.Now()
+	var prev, total time.Duration
+	var err error
+	n := 0
+	result := "exhausted"
+attempts:
+	for n < p.opts.MaxAttempts {
+		n++
+		err = fn(ctx)
+		s.Observe(err)
+		if err == nil {
+			result = "ok"
+			break
+		}
+		if n == p.opts.MaxAttempts {
+			break
+		}
+		delay, ok := s.Backoff(n, prev)
+		if !ok || !p.retryable(ctx, err) {
+			result = "failed"
+			break attempts
+		}
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.String("retry.strategy", s.Name()),
+			attribute.Int("retry.attempt", n+1),
+			attribute.Int64("retry.delay_ms", delay.Milliseconds()),
+			attribute.String("exception.message", err.Error()),
+		))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			result = "failed"
+			break attempts
+		}
+		prev = delay
+		total += delay
+	}
+
+	attrs := metric.WithAttributes(
+		attribute.String("retry.strategy", s.Name()),
+		attribute.String("result", result),
+	)
+	p.calls.Add(ctx, 1, attrs)
+	p.attempts.Record(ctx, int64(n), attrs)
+	p.duration.Record(ctx, time.Since(start).Seconds(), attrs)
+	out := outcome{strategy: s.Name(), attempts: n, result: result, delay: total}
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return out, pe.err
+	}
+	return out, err
+}
+
+// exponential doubles the delay at every retry, without jitter: the
+// baseline the other strategies are compared to.
+type exponential struct {
+	base, ceiling time.Duration
+}
+
+func (exponential) Name() string  { return Exponential }
+func (exponential) Observe(error) {}
+
+func (e exponential) Backoff(n int, _ time.Duration) (time.Duration, bool) {
+	d := e.base
+	for i := 1; i < n && d < e.ceiling; i++ {
+		d *= 2
+	}
+	return min(d, e.ceiling), true
+}
+
+// decorrelatedJitter draws each delay between the base delay and three
+// times the previous one, which spreads the retries of clients that failed
+// together.
+type decorrelatedJitter struct {
+	base, ceiling time.Duration
+}
+
+func (decorrelatedJitter) Name() string  { return DecorrelatedJitter }
+func (decorrelatedJitter) Observe(error) {}
+
+func (j decorrelatedJitter) Backoff(_ int, prev time.Duration) (time.Duration, bool) {
+	prev = max(prev, j.base)
+	d := j.base + time.Duration(rand.Int63n(int64(3*prev-j.base)+1))
+	return min(d, j.ceiling), true
+}
+
+// adaptiveTokens is the retry budget of the adaptive strategy: a failed
+// attempt spends a token, a successful one earns a tenth of one back, and
+// retries stop while half the budget or less is left.
+const adaptiveTokens = 10
+
+// adaptive retries with full jitter exponential backoff while its retry
+// budget lasts, so retries stop adding load to a dependency failing most
+// calls, like gRPC retry throttling.
+type adaptive struct {
+	base, ceiling time.Duration
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func newAdaptive(base, ceiling time.Duration) *adaptive {
+	return &adaptive{base: base, ceiling: ceiling, tokens: adaptiveTokens}
+}
+
+func (*adaptive) Name() string { return Adaptive }
+
+func (a *adaptive) Observe(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		a.tokens = max(a.tokens-1, 0)
+	} else {
+		a.tokens = min(a.tokens+0.1, adaptiveTokens)
+	}
+}
+
+func (a *adaptive) Backoff(n int, _ time.Duration) (time.Duration, bool) {
+	a.mu.Lock()
+	tokens := a.tokens
+	a.mu.Unlock()
+	if tokens <= adaptiveTokens/2 {
+		return 0, false
+	}
+	d, _ := exponential{base: a.base, ceiling: a.ceiling}.Backoff(n, 0)
+	return time.Duration(rand.Int63n(int64(d) + 1)), true
+}
@@ -0,0 +1,157 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var errFlaky = errors.New("flaky")
+
+func newPolicy(t *testing.T, maxAttempts int, retryable func(error) bool) *Policy {
+	t.Helper()
+	p, err := New(Options{
+		Strategies:  map[string]float64{Exponential: 1},
+		MaxAttempts: maxAttempts,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		Retryable:   retryable,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// failing returns fn failing its first n calls with err, and the number of
+// calls made.
+func failing(n int, err error) (fn func(context.Context) error, calls *int) {
+	calls = new(int)
+	return func(context.Context) error {
+		*calls++
+		if *calls <= n {
+			return err
+		}
+		return nil
+	}, calls
+}
+
+func TestDo(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		failures  int
+		err       error
+		retryable func(error) bool
+		wantCalls int
+		wantErr   error
+	}{
+		{"first attempt succeeds", 0, errFlaky, nil, 1, nil},
+		{"retried until success", 2, errFlaky, nil, 3, nil},
+		{"exhausted", 5, errFlaky, nil, 3, errFlaky},
+		{"permanent", 5, Permanent(errFlaky), nil, 1, errFlaky},
+		{"not retryable", 5, errFlaky, func(error) bool { return false }, 1, errFlaky},
+		{"cancelled", 5, context.Canceled, nil, 1, context.Canceled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fn, calls := failing(tc.failures, tc.err)
+			err := newPolicy(t, 3, tc.retryable).Do(context.Background(), fn)
+			if err != tc.wantErr {
+				t.Errorf("Do = %v, want %v", err, tc.wantErr)
+			}
+			if *calls != tc.wantCalls {
+				t.Errorf("%d calls, want %d", *calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenContextDone(t *testing.T) {
+	p, err := New(Options{
+		Strategies:  map[string]float64{Exponential: 1},
+		MaxAttempts: 5,
+		BaseDelay:   time.Hour,
+		MaxDelay:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fn, calls := failing(5, errFlaky)
+	if err := p.Do(ctx, fn); !errors.Is(err, errFlaky) {
+		t.Errorf("Do = %v, want the last error of the call", err)
+	}
+	if *calls != 1 {
+		t.Errorf("%d calls, want 1 as the backoff outlived the context", *calls)
+	}
+}
+
+func TestDoSpan(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	p := newPolicy(t, 3, nil)
+	p.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")
+
+	fn, _ := failing(1, errFlaky)
+	if err := p.DoSpan(context.Background(), "call", fn); err != nil {
+		t.Fatalf("DoSpan = %v, want nil", err)
+	}
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("%d spans, want 2 attempts and the call", len(ended))
+	}
+	call := ended[2]
+	if call.Name() != "call" {
+		t.Fatalf("last span ended is %q, want the call", call.Name())
+	}
+	for i, attempt := range ended[:2] {
+		if attempt.Name() != "call attempt" || attempt.Parent().SpanID() != call.SpanContext().SpanID() {
+			t.Errorf("span %d is %q under %s, want an attempt under the call", i, attempt.Name(), attempt.Parent().SpanID())
+		}
+	}
+	attrs := attribute.NewSet(call.Attributes()...)
+	if v, _ := attrs.Value("retry.attempts"); v.AsInt64() != 2 {
+		t.Errorf("retry.attempts = %d, want 2", v.AsInt64())
+	}
+	if v, _ := attrs.Value("retry.result"); v.AsString() != "ok" {
+		t.Errorf("retry.result = %q, want ok", v.AsString())
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	e := exponential{base: 100 * time.Millisecond, ceiling: time.Second}
+	for n, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if got, ok := e.Backoff(n+1, 0); !ok || got != want*time.Millisecond {
+			t.Errorf("Backoff(%d) = %s, %t, want %s", n+1, got, ok, want*time.Millisecond)
+		}
+	}
+}
+
+func TestAdaptiveGivesUp(t *testing.T) {
+	a := newAdaptive(time.Millisecond, time.Second)
+	if _, ok := a.Backoff(1, 0); !ok {
+		t.Fatal("adaptive gave up with its whole budget")
+	}
+	for i := 0; i < adaptiveTokens/2; i++ {
+		a.Observe(errFlaky)
+	}
+	if _, ok := a.Backoff(1, 0); ok {
+		t.Fatal("adaptive retried with half its budget spent")
+	}
+}
+
+func TestDecorrelatedJitterBounds(t *testing.T) {
+	j := decorrelatedJitter{base: 10 * time.Millisecond, ceiling: 100 * time.Millisecond}
+	prev := time.Duration(0)
+	for i := 0; i < 100; i++ {
+		d, ok := j.Backoff(i+1, prev)
+		if !ok || d < j.base || d > j.ceiling || d > 3*max(prev, j.base) {
+			t.Fatalf("Backoff after %s = %s, want within [%s, min(%s, 3*%s)]", prev, d, j.base, j.ceiling, max(prev, j.base))
+		}
+		prev = d
+	}
+}
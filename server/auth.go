@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"go-otel/internal/envconfig"
+	"go-otel/internal/principal"
+)
+
+// Role is what an authenticated caller may do. Each role includes the ones
+// before it.
+type Role = principal.Role
+
+const (
+	// RoleViewer may read, e.g. scrape metrics or get the runtime settings.
+	RoleViewer = principal.Viewer
+	// RoleOperator may also change the running service, e.g. its log level,
+	// sampling, or flush its telemetry.
+	RoleOperator = principal.Operator
+)
+
+// Principal is the caller authenticated by RequireAuth.
+type Principal = principal.Principal
+
+// PrincipalFromContext returns the caller RequireAuth authenticated.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	return principal.FromContext(ctx)
+}
+
+// AuthOptions protects a handler with basic auth, bearer tokens, or both,
+// in which case any credential is accepted. The basic auth user and the
+// bearer token are operators; the viewer token may only read. The zero
+// value allows all requests, as a viewer: changing the running service
+// requires a credential.
+type AuthOptions struct {
+	BasicUser     string
+	BasicPassword string
+	BearerToken   string
+	ViewerToken   string
+	// AnonymousRole is the role of callers while no credential is
+	// configured. Zero means RoleViewer.
+	AnonymousRole Role
+}
+
+// LoadEnv overrides opts with the variables named prefix + suffix, e.g.
+// GO_OTEL_ADMIN_BEARER_TOKEN for the prefix "GO_OTEL_ADMIN_".
+func (o *AuthOptions) LoadEnv(prefix string) error {
+	return envconfig.Load([]envconfig.Var{
+		{Name: prefix + "BASIC_AUTH_USER", Set: envconfig.String(&o.BasicUser)},
+		{Name: prefix + "BASIC_AUTH_PASSWORD", Set: envconfig.String(&o.BasicPassword)},
+		{Name: prefix + "BEARER_TOKEN", Set: envconfig.String(&o.BearerToken)},
+		{Name: prefix + "VIEWER_TOKEN", Set: envconfig.String(&o.ViewerToken)},
+	})
+}
+
+// Enabled reports whether any credential is configured.
+func (o AuthOptions) Enabled() bool {
+	return o.BasicUser != "" || o.BearerToken != "" || o.ViewerToken != ""
+}
+
+// RequireAuth rejects requests without valid credentials with 401, and
+// records the authenticated Principal in the request context. Without any
+// credential configured, every caller is anonymous, with
+// AnonymousRole.
+func RequireAuth(opts AuthOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p, ok := opts.authenticate(r); ok {
+				next.ServeHTTP(w, r.WithContext(principal.NewContext(r.Context(), p)))
+				return
+			}
+			if opts.BasicUser != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		})
+	}
+}
+
+// RequireRole rejects callers without role with 403. It must run after
+// RequireAuth.
+func RequireRole(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p, ok := PrincipalFromContext(r.Context()); !ok || p.Role < role {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (o AuthOptions) authenticate(r *http.Request) (Principal, bool) {
+	if !o.Enabled() {
+		role := o.AnonymousRole
+		if role == 0 {
+			role = RoleViewer
+		}
+		return Principal{Name: "anonymous", Role: role}, true
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if o.BearerToken != "" && equal(token, o.BearerToken) {
+			return Principal{Name: "operator-token", Role: RoleOperator}, true
+		}
+		if o.ViewerToken != "" && equal(token, o.ViewerToken) {
+			return Principal{Name: "viewer-token", Role: RoleViewer}, true
+		}
+	}
+	if o.BasicUser != "" {
+		if user, pass, ok := r.BasicAuth(); ok && equal(user, o.BasicUser) && equal(pass, o.BasicPassword) {
+			return Principal{Name: "basic:" + user, Role: RoleOperator}, true
+		}
+	}
+	return Principal{}, false
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
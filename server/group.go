@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net"
+	"sync"
+)
+
+// Group runs several servers with a shared lifecycle, like an errgroup:
+// every listener is opened before any server serves, so a port in use fails
+// the startup as a whole, and the first server to fail later is reported
+// through Done and Err, so the process stops rather than running with some
+// of its listeners gone.
+type Group struct {
+	servers []*Server
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// NewGroup returns a group of servers. Nil servers are left out.
+func NewGroup(servers ...*Server) *Group {
+	g := &Group{done: make(chan struct{})}
+	for _, s := range servers {
+		if s != nil {
+			g.servers = append(g.servers, s)
+		}
+	}
+	return g
+}
+
+// Servers returns the servers of the group in the order they were given.
+func (g *Group) Servers() []*Server {
+	return g.servers
+}
+
+// Start opens the listener of every server, then serves each in the
+// background. If a listener cannot be opened, those already opened are
+// closed, nothing is served and the error is returned.
+func (g *Group) Start() error {
+	listeners := make([]net.Listener, 0, len(g.servers))
+	for _, s := range g.servers {
+		ln, err := s.Listen()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+	for i, s := range g.servers {
+		go func(s *Server, ln net.Listener) {
+			if err := s.Serve(ln); err != nil {
+				g.fail(err)
+			}
+		}(s, listeners[i])
+	}
+	return nil
+}
+
+func (g *Group) fail(err error) {
+	g.once.Do(func() {
+		g.err = err
+		close(g.done)
+	})
+}
+
+// Done is closed when a server of the group fails. Servers stopped by
+// Shutdown do not close it.
+func (g *Group) Done() <-chan struct{} {
+	return g.done
+}
+
+// Err returns the error of the first server that failed, once Done is
+// closed, and nil before.
+func (g *Group) Err() error {
+	select {
+	case <-g.done:
+		return g.err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,236 @@
+// Package server builds the HTTP servers the service listens on.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"go-otel/internal/envconfig"
+)
+
+// Options configures one HTTP server.
+type Options struct {
+	// Name identifies the server in logs.
+	Name string
+	// Network is "tcp" or "unix". For unix sockets Addr is the socket path.
+	Network string
+	Addr    string
+	// LocalhostOnly binds TCP listeners to the loopback interface whatever
+	// host Addr names.
+	LocalhostOnly bool
+
+	// ReadHeaderTimeout bounds reading the request headers.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading the whole request, body included.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing the response.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long keep-alive connections wait for the next request.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes caps the size of the request headers.
+	MaxHeaderBytes int
+
+	// TLSCertFile and TLSKeyFile enable TLS when both are set. HTTP/2 is
+	// then negotiated through ALPN.
+	TLSCertFile string
+	TLSKeyFile  string
+	// H2C serves cleartext HTTP/2 when TLS is off.
+	H2C bool
+}
+
+// DefaultOptions returns conservative timeouts for a server on addr.
+func DefaultOptions(name, addr string) Options {
+	return Options{
+		Name:              name,
+		Network:           "tcp",
+		Addr:              addr,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+}
+
+// LoadEnv overrides opts with the variables named prefix + suffix, e.g.
+// GO_OTEL_API_ADDR for the prefix "GO_OTEL_API_".
+func (o *Options) LoadEnv(prefix string) error {
+	return envconfig.Load([]envconfig.Var{
+		{Name: prefix + "NETWORK", Set: envconfig.String(&o.Network)},
+		{Name: prefix + "ADDR", Set: envconfig.String(&o.Addr)},
+		{Name: prefix + "LOCALHOST_ONLY", Set: envconfig.Bool(&o.LocalhostOnly)},
+		{Name: prefix + "READ_HEADER_TIMEOUT", Set: envconfig.Duration(&o.ReadHeaderTimeout)},
+		{Name: prefix + "READ_TIMEOUT", Set: envconfig.Duration(&o.ReadTimeout)},
+		{Name: prefix + "WRITE_TIMEOUT", Set: envconfig.Duration(&o.WriteTimeout)},
+		{Name: prefix + "IDLE_TIMEOUT", Set: envconfig.Duration(&o.IdleTimeout)},
+		{Name: prefix + "MAX_HEADER_BYTES", Set: envconfig.Int(&o.MaxHeaderBytes)},
+		{Name: prefix + "TLS_CERT_FILE", Set: envconfig.String(&o.TLSCertFile)},
+		{Name: prefix + "TLS_KEY_FILE", Set: envconfig.String(&o.TLSKeyFile)},
+		{Name: prefix + "H2C", Set: envconfig.Bool(&o.H2C)},
+	})
+}
+
+// Server is an http.Server configured from Options.
+type Server struct {
+	*http.Server
+	opts   Options
+	cancel context.CancelCauseFunc
+}
+
+// ErrShutdown is the cause of the cancellation of requests still running
+// when the deadline of Shutdown passes.
+var ErrShutdown = errors.New("server shut down")
+
+// New builds a server for h. TLS material is loaded up front so a bad
+// certificate fails at startup rather than on the first connection.
+func New(opts Options, h http.Handler) (*Server, error) {
+	if (opts.TLSCertFile == "") != (opts.TLSKeyFile == "") {
+		return nil, fmt.Errorf("%s server: both a TLS certificate and key are required", opts.Name)
+	}
+
+	switch opts.Network {
+	case "", "tcp":
+		opts.Network = "tcp"
+		if opts.LocalhostOnly {
+			_, port, err := net.SplitHostPort(opts.Addr)
+			if err != nil {
+				return nil, fmt.Errorf("%s server: %w", opts.Name, err)
+			}
+			opts.Addr = net.JoinHostPort("127.0.0.1", port)
+		}
+	case "unix":
+	default:
+		return nil, fmt.Errorf("%s server: unsupported network %q", opts.Name, opts.Network)
+	}
+
+	srv := &http.Server{
+		Addr:              opts.Addr,
+		Handler:           h,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		ReadTimeout:       opts.ReadTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+		MaxHeaderBytes:    opts.MaxHeaderBytes,
+	}
+
+	switch {
+	case opts.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s server: %w", opts.Name, err)
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}
+	case opts.H2C:
+		srv.Handler = h2c.NewHandler(h, &http2.Server{IdleTimeout: opts.IdleTimeout})
+	}
+
+	// Requests derive their context from base, so Shutdown can cancel them
+	// with ErrShutdown as the cause.
+	base, cancel := context.WithCancelCause(context.Background())
+	srv.BaseContext = func(net.Listener) context.Context { return base }
+	return &Server{Server: srv, opts: opts, cancel: cancel}, nil
+}
+
+// Shutdown stops the server gracefully, waiting for running requests until
+// ctx is done; those still running then are cancelled with ErrShutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	s.cancel(ErrShutdown)
+	return err
+}
+
+// Name returns the name of the server in logs.
+func (s *Server) Name() string {
+	return s.opts.Name
+}
+
+// TLS reports whether the server serves TLS.
+func (s *Server) TLS() bool {
+	return s.TLSConfig != nil
+}
+
+// Listen opens the configured listener. A stale unix socket left behind by
+// a previous process is removed first.
+func (s *Server) Listen() (net.Listener, error) {
+	if s.opts.Network == "unix" {
+		if err := os.Remove(s.opts.Addr); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s server: %w", s.opts.Name, err)
+		}
+	}
+	ln, err := net.Listen(s.opts.Network, s.opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s server: %w", s.opts.Name, err)
+	}
+	return ln, nil
+}
+
+// ListenAndServe listens on the configured address and serves until the
+// server is shut down, in which case it returns nil.
+func (s *Server) ListenAndServe() error {
+	ln, err := s.Listen()
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Endpoint describes where the server listens, e.g. "tcp://127.0.0.1:2222".
+func (s *Server) Endpoint() string {
+	return s.opts.Network + "://" + s.opts.Addr
+}
+
+// Loopback returns the base URL and a transport reaching the server from
+// the same host, through the loopback interface or its unix socket.
+func (s *Server) Loopback() (string, *http.Transport) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	scheme := "http"
+	if s.TLS() {
+		scheme = "https"
+		// The certificate names the public host, not the loopback address
+		// dialed, and the peer is this very process.
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+
+	if s.opts.Network == "unix" {
+		path := s.opts.Addr
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		return scheme + "://localhost", tr
+	}
+
+	_, port, err := net.SplitHostPort(s.opts.Addr)
+	if err != nil {
+		port = s.opts.Addr
+	}
+	return scheme + "://" + net.JoinHostPort("127.0.0.1", port), tr
+}
+
+// Serve accepts connections on ln until the server is shut down, in which
+// case it returns nil.
+func (s *Server) Serve(ln net.Listener) error {
+	var err error
+	if s.TLS() {
+		// Certificates come from TLSConfig; ServeTLS also enables HTTP/2.
+		err = s.Server.ServeTLS(ln, "", "")
+	} else {
+		err = s.Server.Serve(ln)
+	}
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return fmt.Errorf("%s server: %w", s.opts.Name, err)
+}
@@ -0,0 +1,164 @@
+// Package shutdown stops the service in order: first it stops accepting
+// traffic, then drains consumers, then stops schedulers, and finally
+// flushes telemetry, so nothing in flight is lost along the way.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/envconfig"
+)
+
+const instrumentationName = "go-otel/shutdown"
+
+// Phase orders the stages of a shutdown: every stage of a phase completes
+// before the next phase starts.
+type Phase int
+
+const (
+	// PhaseTraffic stops accepting requests and waits for those in flight.
+	PhaseTraffic Phase = iota
+	// PhaseConsumers drains queue consumers and background workers.
+	PhaseConsumers
+	// PhaseSchedulers stops periodic jobs.
+	PhaseSchedulers
+	// PhaseTelemetry flushes and stops telemetry. Its stages are not
+	// traced, as the spans could no longer be exported.
+	PhaseTelemetry
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseTraffic:
+		return "traffic"
+	case PhaseConsumers:
+		return "consumers"
+	case PhaseSchedulers:
+		return "schedulers"
+	case PhaseTelemetry:
+		return "telemetry"
+	default:
+		return fmt.Sprintf("phase%d", int(p))
+	}
+}
+
+// Options configures the shutdown.
+type Options struct {
+	// Timeout bounds each stage. Zero means 10s.
+	Timeout time.Duration
+	// StageTimeouts overrides Timeout by stage name.
+	StageTimeouts map[string]time.Duration
+}
+
+// DefaultOptions gives each stage 10s.
+func DefaultOptions() Options {
+	return Options{Timeout: 10 * time.Second}
+}
+
+// LoadEnv overrides opts with the variables named prefix + suffix, e.g.
+// GO_OTEL_SHUTDOWN_TIMEOUT for the prefix "GO_OTEL_SHUTDOWN_".
+func (o *Options) LoadEnv(prefix string) error {
+	return envconfig.Load([]envconfig.Var{
+		{Name: prefix + "TIMEOUT", Set: envconfig.Duration(&o.Timeout)},
+		{Name: prefix + "STAGE_TIMEOUTS", Set: envconfig.DurationMap(&o.StageTimeouts)},
+	})
+}
+
+type stage struct {
+	phase Phase
+	name  string
+	fn    func(ctx context.Context) error
+}
+
+// Sequence is the ordered list of shutdown stages.
+type Sequence struct {
+	opts Options
+
+	mu     sync.Mutex
+	stages []stage
+}
+
+// New returns an empty sequence.
+func New(opts Options) *Sequence {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions().Timeout
+	}
+	return &Sequence{opts: opts}
+}
+
+// Add registers fn as the stage name of phase. Stages of a phase run in the
+// order they were added. fn must return once its context is done.
+func (s *Sequence) Add(phase Phase, name string, fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stages = append(s.stages, stage{phase: phase, name: name, fn: fn})
+}
+
+// Run runs every stage in order, each within its timeout, and returns their
+// errors joined. A failing stage does not stop the sequence. The whole
+// shutdown is a "shutdown" span with a child span per stage.
+func (s *Sequence) Run(ctx context.Context) error {
+	s.mu.Lock()
+	stages := append([]stage(nil), s.stages...)
+	s.mu.Unlock()
+	sort.SliceStable(stages, func(i, j int) bool { return stages[i].phase < stages[j].phase })
+
+	tracer := otel.Tracer(instrumentationName)
+	start := time.Now()
+	tctx, root := tracer.Start(ctx, "shutdown", trace.WithNewRoot())
+	log.Info().Int("stages", len(stages)).Msg("shutting down")
+
+	var errs []error
+	for _, st := range stages {
+		if st.phase == PhaseTelemetry && root.IsRecording() {
+			root.End()
+		}
+		if err := s.run(tctx, tracer, st); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", st.name, err))
+		}
+	}
+	root.End()
+	log.Info().Dur("took", time.Since(start)).Msg("shutdown complete")
+	return errors.Join(errs...)
+}
+
+func (s *Sequence) run(ctx context.Context, tracer trace.Tracer, st stage) error {
+	timeout := s.opts.Timeout
+	if d, ok := s.opts.StageTimeouts[st.name]; ok && d > 0 {
+		timeout = d
+	}
+
+	span := trace.SpanFromContext(context.Background())
+	if st.phase != PhaseTelemetry {
+		ctx, span = tracer.Start(ctx, "shutdown "+st.name, trace.WithAttributes(
+			attribute.String("shutdown.phase", st.phase.String()),
+			attribute.String("shutdown.stage", st.name),
+			attribute.String("shutdown.timeout", timeout.String()),
+		))
+	}
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := st.fn(ctx)
+	ev := log.Info()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		ev = log.Error().Err(err)
+	}
+	ev.Str("phase", st.phase.String()).Str("stage", st.name).Dur("took", time.Since(start)).Msg("shutdown stage done")
+	return err
+}
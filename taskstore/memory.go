@@ -0,0 +1,104 @@
+package taskstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// Memory is a Backend holding results in the process, evicting them once
+// expired.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+
+	evictions metric.Int64Counter
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// NewMemory returns a memory backend evicting expired results every
+// interval, until closed.
+func NewMemory(interval time.Duration) *Memory {
+	m := &Memory{entries: make(map[string]memoryEntry), stop: make(chan struct{})}
+	m.evictions, _ = otel.Meter(instrumentationName).Int64Counter(
+		"taskstore.evictions",
+		metric.WithDescription("Task results evicted from memory once expired."),
+	)
+	go m.sweep(interval)
+	return m
+}
+
+func (m *Memory) Put(_ context.Context, id string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[id] = memoryEntry{value: value, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) Get(_ context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[id]
+	if !ok || time.Now().After(e.expires) {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
+
+func (m *Memory) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, id)
+	return nil
+}
+
+func (m *Memory) Len(context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now, n := time.Now(), 0
+	for _, e := range m.entries {
+		if now.Before(e.expires) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Close stops the evictions.
+func (m *Memory) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	return nil
+}
+
+func (m *Memory) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			evicted := 0
+			for id, e := range m.entries {
+				if now.After(e.expires) {
+					delete(m.entries, id)
+					evicted++
+				}
+			}
+			m.mu.Unlock()
+			if evicted > 0 {
+				m.evictions.Add(context.Background(), int64(evicted))
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package taskstore
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"go-otel/internal/redis"
+)
+
+// Redis is a Backend sharing results between instances. Each result is a
+// key expiring with it; a sorted set of IDs by expiry time keeps the count
+// of results cheap.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis returns a Redis backend at rawURL, prefixing its keys with
+// prefix.
+func NewRedis(rawURL, prefix string) (*Redis, error) {
+	client, err := redis.New(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{client: client, prefix: prefix}, nil
+}
+
+func (r *Redis) key(id string) string {
+	return r.prefix + "result:" + id
+}
+
+func (r *Redis) index() string {
+	return r.prefix + "index"
+}
+
+func (r *Redis) Put(ctx context.Context, id string, value []byte, ttl time.Duration) error {
+	expires := time.Now().Add(ttl).UnixMilli()
+	if _, err := r.client.Do(ctx, "SET", r.key(id), string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		return err
+	}
+	_, err := r.client.Do(ctx, "ZADD", r.index(), strconv.FormatInt(expires, 10), id)
+	return err
+}
+
+func (r *Redis) Get(ctx context.Context, id string) ([]byte, error) {
+	v, err := r.client.Do(ctx, "GET", r.key(id))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, _ := v.(string)
+	return []byte(s), nil
+}
+
+func (r *Redis) Delete(ctx context.Context, id string) error {
+	if _, err := r.client.Do(ctx, "DEL", r.key(id)); err != nil {
+		return err
+	}
+	_, err := r.client.Do(ctx, "ZREM", r.index(), id)
+	return err
+}
+
+// Len drops the expired IDs from the index before counting it.
+func (r *Redis) Len(ctx context.Context) (int, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if _, err := r.client.Do(ctx, "ZREMRANGEBYSCORE", r.index(), "-inf", now); err != nil {
+		return 0, err
+	}
+	v, err := r.client.Do(ctx, "ZCARD", r.index())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := v.(int64)
+	return int(n), nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
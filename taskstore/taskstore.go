@@ -0,0 +1,275 @@
+// Package taskstore keeps the results of asynchronous tasks, such as
+// long-running operations, for clients to poll until they expire. Results
+// are kept in memory, or in Redis when instances must share them.
+package taskstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/envconfig"
+	"go-otel/telemetry"
+)
+
+const instrumentationName = "go-otel/taskstore"
+
+// ErrNotFound is returned for results that were never stored or expired.
+var ErrNotFound = errors.New("task result not found")
+
+// Backends.
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// Options configures the store.
+type Options struct {
+	// Backend is BackendMemory, the default, or BackendRedis.
+	Backend string
+	// TTL is how long results are kept after their last update.
+	TTL time.Duration
+	// RedisURL is the redis://[:password@]host:port[/db] of the Redis
+	// backend, and KeyPrefix the prefix of its keys.
+	RedisURL  string
+	KeyPrefix string
+	// SweepInterval is how often the memory backend evicts expired results.
+	SweepInterval time.Duration
+}
+
+// DefaultOptions keeps results in memory for an hour.
+func DefaultOptions() Options {
+	return Options{
+		Backend:       BackendMemory,
+		TTL:           time.Hour,
+		KeyPrefix:     "go-otel:tasks:",
+		SweepInterval: time.Minute,
+	}
+}
+
+// LoadEnv overrides opts with the variables named prefix + suffix, e.g.
+// GO_OTEL_TASKS_TTL for the prefix "GO_OTEL_TASKS_".
+func (o *Options) LoadEnv(prefix string) error {
+	return envconfig.Load([]envconfig.Var{
+		{Name: prefix + "BACKEND", Set: envconfig.String(&o.Backend)},
+		{Name: prefix + "TTL", Set: envconfig.Duration(&o.TTL)},
+		{Name: prefix + "REDIS_URL", Set: envconfig.String(&o.RedisURL)},
+		{Name: prefix + "KEY_PREFIX", Set: envconfig.String(&o.KeyPrefix)},
+		{Name: prefix + "SWEEP_INTERVAL", Set: envconfig.Duration(&o.SweepInterval)},
+	})
+}
+
+// Backend stores encoded results by task ID.
+type Backend interface {
+	// Put stores value under id until ttl has passed.
+	Put(ctx context.Context, id string, value []byte, ttl time.Duration) error
+	// Get returns the value of id, or ErrNotFound.
+	Get(ctx context.Context, id string) ([]byte, error)
+	Delete(ctx context.Context, id string) error
+	// Len returns the number of unexpired results.
+	Len(ctx context.Context) (int, error)
+	Close() error
+}
+
+// Status is the state of a task.
+type Status string
+
+// Task statuses.
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Result is the state of a task, as returned to clients.
+type Result struct {
+	ID        string          `json:"id"`
+	Status    Status          `json:"status"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Store keeps task results in a backend, tracing and counting each
+// operation.
+type Store struct {
+	opts    Options
+	backend Backend
+	tracer  trace.Tracer
+	attrs   attribute.Set
+
+	operations metric.Int64Counter
+	duration   metric.Float64Histogram
+}
+
+// New returns a store on the backend selected by opts.
+func New(opts Options) (*Store, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultOptions().TTL
+	}
+	if opts.SweepInterval <= 0 {
+		opts.SweepInterval = DefaultOptions().SweepInterval
+	}
+	var (
+		backend Backend
+		err     error
+	)
+	switch opts.Backend {
+	case BackendMemory, "":
+		opts.Backend = BackendMemory
+		backend = NewMemory(opts.SweepInterval)
+	case BackendRedis:
+		backend, err = NewRedis(opts.RedisURL, opts.KeyPrefix)
+	default:
+		err = fmt.Errorf("unknown task store backend %q", opts.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewWithBackend(opts, backend), nil
+}
+
+// NewWithBackend returns a store on backend, named opts.Backend in its
+// telemetry.
+func NewWithBackend(opts Options, backend Backend) *Store {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultOptions().TTL
+	}
+	s := &Store{
+		opts:    opts,
+		backend: backend,
+		tracer:  otel.Tracer(instrumentationName),
+		attrs:   attribute.NewSet(attribute.String("taskstore.backend", opts.Backend)),
+	}
+
+	meter := otel.Meter(instrumentationName)
+	s.operations, _ = meter.Int64Counter(
+		"taskstore.operations",
+		metric.WithDescription("Task store operations, by operation and result."),
+	)
+	s.duration, _ = meter.Float64Histogram(
+		"taskstore.operation.duration",
+		metric.WithDescription("Duration of task store operations."),
+		metric.WithUnit("s"),
+	)
+	if size, err := meter.Int64ObservableGauge(
+		"taskstore.size",
+		metric.WithDescription("Unexpired task results held by the store."),
+	); err == nil {
+		_, _ = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+			n, err := backend.Len(ctx)
+			if err != nil {
+				return err
+			}
+			o.ObserveInt64(size, int64(n), metric.WithAttributeSet(s.attrs))
+			return nil
+		}, size)
+	}
+	return s
+}
+
+// Put stores r, stamping its update time, until the TTL has passed.
+func (s *Store) Put(ctx context.Context, r Result) error {
+	ctx, span := s.start(ctx, "put", r.ID)
+	defer span.End()
+	start := time.Now()
+
+	if r.UpdatedAt.IsZero() {
+		r.UpdatedAt = time.Now().UTC()
+	}
+	b, err := json.Marshal(r)
+	if err == nil {
+		err = s.backend.Put(ctx, r.ID, b, s.opts.TTL)
+	}
+	s.record(ctx, span, "put", start, err)
+	return err
+}
+
+// Get returns the result of the task id, or ErrNotFound.
+func (s *Store) Get(ctx context.Context, id string) (Result, error) {
+	ctx, span := s.start(ctx, "get", id)
+	defer span.End()
+	start := time.Now()
+
+	var r Result
+	b, err := s.backend.Get(ctx, id)
+	if err == nil {
+		err = json.Unmarshal(b, &r)
+	}
+	span.SetAttributes(attribute.Bool("taskstore.hit", err == nil))
+	s.record(ctx, span, "get", start, err)
+	return r, err
+}
+
+// Delete removes the result of the task id.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	ctx, span := s.start(ctx, "delete", id)
+	defer span.End()
+	start := time.Now()
+
+	err := s.backend.Delete(ctx, id)
+	s.record(ctx, span, "delete", start, err)
+	return err
+}
+
+// Close releases the backend.
+func (s *Store) Close(context.Context) error {
+	return s.backend.Close()
+}
+
+// Handler serves the result of the task named by the "id" URL parameter,
+// or a 404 once it has expired.
+func (s *Store) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := s.Get(r.Context(), chi.URLParam(r, "id"))
+		switch {
+		case errors.Is(err, ErrNotFound):
+			telemetry.RenderError(w, r, telemetry.WithCode(err, telemetry.CodeNotFound))
+		case err != nil:
+			telemetry.RenderError(w, r, telemetry.WithCode(fmt.Errorf("task store unavailable: %w", err), telemetry.CodeUnavailable))
+		default:
+			render.JSON(w, r, res)
+		}
+	}
+}
+
+func (s *Store) start(ctx context.Context, op, id string) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "taskstore "+op, trace.WithAttributes(
+		attribute.String("taskstore.backend", s.opts.Backend),
+		attribute.String("task.id", id),
+	))
+}
+
+// record counts op, a miss when the result was not found, and marks span
+// failed on other errors.
+func (s *Store) record(ctx context.Context, span trace.Span, op string, start time.Time, err error) {
+	result := "ok"
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNotFound):
+		result = "miss"
+	default:
+		result = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("taskstore.backend", s.opts.Backend),
+		attribute.String("operation", op),
+		attribute.String("result", result),
+	)
+	s.operations.Add(ctx, 1, attrs)
+	s.duration.Record(ctx, time.Since(start).Seconds(), attrs)
+}
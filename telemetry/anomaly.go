@@ -0,0 +1,177 @@
+package telemetry
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// AnomalyOptions configures the spike detector fed by HTTPMetrics.
+type AnomalyOptions struct {
+	Enabled bool
+	// Interval is the bucket requests are aggregated over before being
+	// compared with the baseline.
+	Interval time.Duration
+	// Alpha is the weight of the newest interval in the rolling baseline.
+	Alpha float64
+	// Threshold is how many standard deviations above the baseline an
+	// interval must be to count as a spike.
+	Threshold float64
+	// MinRequests skips intervals with too little traffic to judge.
+	MinRequests int
+	// Warmup is the number of intervals used to learn the baseline before
+	// spikes are reported.
+	Warmup int
+}
+
+// DefaultAnomalyOptions flags 3 sigma spikes over 10s intervals.
+func DefaultAnomalyOptions() AnomalyOptions {
+	return AnomalyOptions{
+		Enabled:     true,
+		Interval:    10 * time.Second,
+		Alpha:       0.1,
+		Threshold:   3,
+		MinRequests: 20,
+		Warmup:      6,
+	}
+}
+
+// ewma is an exponentially weighted mean and variance.
+type ewma struct {
+	mean, variance float64
+	samples        int
+}
+
+func (e *ewma) add(x, alpha float64) {
+	if e.samples == 0 {
+		e.mean = x
+	} else {
+		diff := x - e.mean
+		incr := alpha * diff
+		e.mean += incr
+		e.variance = (1 - alpha) * (e.variance + diff*incr)
+	}
+	e.samples++
+}
+
+// zscore returns how far x lies above the mean, in standard deviations no
+// smaller than minStd.
+func (e *ewma) zscore(x, minStd float64) float64 {
+	return (x - e.mean) / math.Max(math.Sqrt(e.variance), minStd)
+}
+
+// routeWindow aggregates one route's requests in the current interval.
+type routeWindow struct {
+	start    time.Time
+	requests int
+	errors   int
+	latency  time.Duration
+
+	errorRate ewma
+	meanLat   ewma
+}
+
+// anomalyDetector compares each route's error rate and mean latency with a
+// rolling baseline and reports sharp increases as log events and metrics,
+// independently of any span.
+type anomalyDetector struct {
+	opts AnomalyOptions
+
+	anomalies metric.Int64Counter
+
+	mu     sync.Mutex
+	routes map[string]*routeWindow
+}
+
+func newAnomalyDetector(opts AnomalyOptions) *anomalyDetector {
+	if !opts.Enabled {
+		return nil
+	}
+	defaults := DefaultAnomalyOptions()
+	if opts.Interval <= 0 {
+		opts.Interval = defaults.Interval
+	}
+	if opts.Alpha <= 0 || opts.Alpha > 1 {
+		opts.Alpha = defaults.Alpha
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = defaults.Threshold
+	}
+
+	d := &anomalyDetector{opts: opts, routes: make(map[string]*routeWindow)}
+	d.anomalies, _ = selfMeter().Int64Counter(
+		"http.server.anomalies",
+		metric.WithDescription("Intervals where a route's error rate or latency spiked above its baseline."),
+	)
+	return d
+}
+
+func (d *anomalyDetector) record(ctx context.Context, route string, elapsed time.Duration, status int) {
+	if d == nil {
+		return
+	}
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w, ok := d.routes[route]
+	if !ok {
+		w = &routeWindow{start: now}
+		d.routes[route] = w
+	}
+	if now.Sub(w.start) >= d.opts.Interval {
+		d.evaluate(ctx, route, w)
+		*w = routeWindow{start: now, errorRate: w.errorRate, meanLat: w.meanLat}
+	}
+
+	w.requests++
+	w.latency += elapsed
+	if status >= http.StatusInternalServerError {
+		w.errors++
+	}
+}
+
+// evaluate closes the interval of w, reporting spikes and folding it into
+// the baseline. d.mu must be held.
+func (d *anomalyDetector) evaluate(ctx context.Context, route string, w *routeWindow) {
+	if w.requests < d.opts.MinRequests {
+		return
+	}
+	errRate := float64(w.errors) / float64(w.requests)
+	meanLat := (w.latency / time.Duration(w.requests)).Seconds()
+
+	if w.meanLat.samples >= d.opts.Warmup {
+		// Floors keep a flat baseline from turning noise into spikes.
+		d.check(ctx, route, "error_rate", errRate, &w.errorRate, 0.01)
+		d.check(ctx, route, "latency", meanLat, &w.meanLat, 0.1*w.meanLat.mean)
+	}
+
+	w.errorRate.add(errRate, d.opts.Alpha)
+	w.meanLat.add(meanLat, d.opts.Alpha)
+}
+
+func (d *anomalyDetector) check(ctx context.Context, route, signal string, value float64, baseline *ewma, minStd float64) {
+	z := baseline.zscore(value, minStd)
+	if z < d.opts.Threshold {
+		return
+	}
+
+	d.anomalies.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("http.route", route),
+		attribute.String("signal", signal),
+	))
+	log.Warn().
+		Str("http.route", route).
+		Str("signal", signal).
+		Float64("value", value).
+		Float64("baseline", baseline.mean).
+		Float64("zscore", z).
+		Msg("anomaly detected")
+}
@@ -0,0 +1,173 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Apdex zones a request can fall into.
+const (
+	apdexSatisfied  = "satisfied"
+	apdexTolerating = "tolerating"
+	apdexFrustrated = "frustrated"
+)
+
+// ApdexOptions configures the per-route Apdex score.
+type ApdexOptions struct {
+	// Threshold is the target response time T. Requests up to T satisfy,
+	// up to 4T are tolerated, and slower ones or 5xx responses frustrate.
+	Threshold time.Duration
+	// RouteThresholds overrides Threshold for chi route patterns.
+	RouteThresholds map[string]time.Duration
+	// Window is the period the apdex gauge is computed over.
+	Window time.Duration
+}
+
+// DefaultApdexOptions returns a 500ms target over one minute windows.
+func DefaultApdexOptions() ApdexOptions {
+	return ApdexOptions{Threshold: 500 * time.Millisecond, Window: time.Minute}
+}
+
+// apdexCounts are the requests seen per zone.
+type apdexCounts struct {
+	satisfied, tolerating, total int64
+}
+
+func (c apdexCounts) score() float64 {
+	return (float64(c.satisfied) + float64(c.tolerating)/2) / float64(c.total)
+}
+
+// apdexTracker counts requests per route and zone. The zone counters let
+// prometheus compute Apdex over any range; the gauge reports the score of
+// the last complete window for dashboards that want a ready-made number.
+// Setup creates one, shared by every HTTPMetrics middleware.
+type apdexTracker struct {
+	opts ApdexOptions
+
+	requests metric.Int64Counter
+	reg      metric.Registration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	current     map[string]apdexCounts
+	previous    map[string]apdexCounts
+}
+
+func newApdexTracker(opts ApdexOptions) *apdexTracker {
+	defaults := DefaultApdexOptions()
+	if opts.Threshold <= 0 {
+		opts.Threshold = defaults.Threshold
+	}
+	if opts.Window <= 0 {
+		opts.Window = defaults.Window
+	}
+
+	t := &apdexTracker{
+		opts:        opts,
+		windowStart: time.Now().Truncate(opts.Window),
+		current:     make(map[string]apdexCounts),
+	}
+	t.requests, _ = selfMeter().Int64Counter(
+		"http.server.apdex.requests",
+		metric.WithDescription("HTTP server requests by route and Apdex zone."),
+	)
+	if gauge, err := selfMeter().Float64ObservableGauge(
+		"http.server.apdex",
+		metric.WithDescription("Apdex score by route over the last complete window."),
+	); err == nil {
+		t.reg, _ = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+			for route, c := range t.snapshot() {
+				o.ObserveFloat64(gauge, c.score(), metric.WithAttributes(
+					attribute.String("http.route", route),
+					attribute.Float64("apdex.threshold", t.threshold(route).Seconds()),
+				))
+			}
+			return nil
+		}, gauge)
+	}
+	return t
+}
+
+func (t *apdexTracker) shutdown(context.Context) error {
+	if t == nil || t.reg == nil {
+		return nil
+	}
+	return t.reg.Unregister()
+}
+
+func (t *apdexTracker) threshold(route string) time.Duration {
+	if d, ok := t.opts.RouteThresholds[route]; ok && d > 0 {
+		return d
+	}
+	return t.opts.Threshold
+}
+
+func (t *apdexTracker) record(ctx context.Context, route string, elapsed time.Duration, status int) {
+	threshold := t.threshold(route)
+	zone := apdexFrustrated
+	switch {
+	case status >= http.StatusInternalServerError:
+	case elapsed <= threshold:
+		zone = apdexSatisfied
+	case elapsed <= 4*threshold:
+		zone = apdexTolerating
+	}
+
+	t.requests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("http.route", route),
+		attribute.String("apdex.zone", zone),
+	))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rotate(time.Now())
+	c := t.current[route]
+	c.total++
+	switch zone {
+	case apdexSatisfied:
+		c.satisfied++
+	case apdexTolerating:
+		c.tolerating++
+	}
+	t.current[route] = c
+}
+
+// snapshot returns the counts of the last complete window, or of the
+// current one until a window has completed.
+func (t *apdexTracker) snapshot() map[string]apdexCounts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rotate(time.Now())
+
+	src := t.previous
+	if src == nil {
+		src = t.current
+	}
+	out := make(map[string]apdexCounts, len(src))
+	for route, c := range src {
+		out[route] = c
+	}
+	return out
+}
+
+// rotate starts a new window once the current one has elapsed. t.mu must
+// be held.
+func (t *apdexTracker) rotate(now time.Time) {
+	elapsed := now.Sub(t.windowStart)
+	if elapsed < t.opts.Window {
+		return
+	}
+	if elapsed < 2*t.opts.Window {
+		t.previous = t.current
+	} else {
+		// A whole window passed without traffic.
+		t.previous = map[string]apdexCounts{}
+	}
+	t.current = make(map[string]apdexCounts)
+	t.windowStart = now.Truncate(t.opts.Window)
+}
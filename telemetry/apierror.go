@@ -0,0 +1,99 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StatusClientClosedRequest is the non-standard status, from nginx, of
+// requests the client gave up on before the response.
+const StatusClientClosedRequest = 499
+
+// ErrorResponse is the JSON body of error responses. TraceID and RequestID
+// let support find the request in the tracing UI and the logs.
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	TraceID   string `json:"trace_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// newErrorResponse returns the body of an error response to r saying msg.
+func newErrorResponse(r *http.Request, msg string) ErrorResponse {
+	e := ErrorResponse{Error: msg, RequestID: middleware.GetReqID(r.Context())}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.TraceID().IsValid() {
+		e.TraceID = sc.TraceID().String()
+	}
+	return e
+}
+
+// statusError is an error whose message is shown to API callers, with the
+// status of the response it fails.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string   { return e.err.Error() }
+func (e *statusError) Unwrap() error   { return e.err }
+func (e *statusError) HTTPStatus() int { return e.status }
+
+// WithStatus returns err for RenderError to answer with status, showing its
+// message to the caller. A nil err stays nil.
+func WithStatus(err error, status int) error {
+	if err == nil {
+		return nil
+	}
+	return &statusError{status: status, err: err}
+}
+
+// errorStatus returns the status of the response failed by err, and
+// whether its message may be shown to the caller: errors with a domain
+// error code have the status the taxonomy maps it to, and their message
+// unless it is a 5xx; other errors with an HTTPStatus method, such as those
+// from WithStatus, have their own status and message. Timeouts are
+// answered with 504 and cancellations with 499; other errors with a 500
+// that does not disclose them.
+func errorStatus(err error) (int, bool) {
+	var ce *codeError
+	var se interface{ HTTPStatus() int }
+	switch {
+	case errors.As(err, &ce):
+		return ce.HTTPStatus(), !ce.code.fault()
+	case errors.As(err, &se):
+		return se.HTTPStatus(), true
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, false
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest, false
+	default:
+		return http.StatusInternalServerError, false
+	}
+}
+
+// RenderError answers r with err as a JSON ErrorResponse carrying the trace
+// and request IDs, with the status err maps to. The error is recorded on
+// the request span with SetSpanError, and reported to the ErrorClassifier
+// through SetRequestError.
+func RenderError(w http.ResponseWriter, r *http.Request, err error) {
+	status, public := errorStatus(err)
+	var msg string
+	switch {
+	case public:
+		msg = err.Error()
+	case status == StatusClientClosedRequest:
+		msg = "Client Closed Request"
+	default:
+		msg = http.StatusText(status)
+	}
+
+	SetRequestError(r.Context(), err)
+	SetSpanError(trace.SpanFromContext(r.Context()), err)
+
+	render.Status(r, status)
+	render.JSON(w, r, newErrorResponse(r, msg))
+}
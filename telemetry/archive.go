@@ -0,0 +1,297 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// archiveWorkers bounds the payloads written concurrently. Payloads arriving
+// while all workers are busy are dropped rather than slowing requests down.
+const archiveWorkers = 4
+
+// BlobStore stores archived payloads.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// DirStore is a BlobStore writing each blob to a file under a directory.
+type DirStore string
+
+// Put writes data to key below the directory, atomically.
+func (d DirStore) Put(_ context.Context, key string, data []byte) error {
+	path := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// HTTPStore is a BlobStore uploading each blob to an object store with a
+// PUT to URL plus the key, e.g. Google Cloud Storage through its XML API
+// with an Authorization header, Azure Blob Storage through a SAS URL with
+// x-ms-blob-type: BlockBlob, or an S3 bucket through a signing proxy.
+type HTTPStore struct {
+	// URL is the prefix of the objects, e.g.
+	// https://storage.googleapis.com/bucket/archive. Its query, such as a
+	// SAS token, is kept on every upload.
+	URL string
+	// Header is sent with every upload.
+	Header http.Header
+	// Client uploads the blobs. Nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// Put uploads data to key below the URL.
+func (s HTTPStore) Put(ctx context.Context, key string, data []byte) error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for name, values := range s.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("put %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+// ArchiveOptions configures ArchivePayloads.
+type ArchiveOptions struct {
+	// Ratio is the fraction of requests archived, e.g. 0.001. The decision
+	// is derived from the trace ID, so every service of a trace archives
+	// the same requests.
+	Ratio float64
+	// MaxBodyBytes caps the bytes kept of each body. Zero means 64KiB.
+	MaxBodyBytes int
+	// Store receives the payloads. Nil disables archiving.
+	Store BlobStore
+}
+
+// ArchivedPayload is the JSON document stored per archived request.
+type ArchivedPayload struct {
+	TraceID         string      `json:"trace_id"`
+	SpanID          string      `json:"span_id"`
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     string      `json:"request_body"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseBody    string      `json:"response_body"`
+	Truncated       bool        `json:"truncated,omitempty"`
+}
+
+// sensitiveHeaders are left out of archived payloads altogether, as are
+// the header carrying rate limit keys and headers named like credentials,
+// see isSensitiveHeader.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// credentialSuffixes end the names of headers carrying credentials, e.g.
+// X-API-Key, X-Auth-Token or X-Client-Secret.
+var credentialSuffixes = []string{"-key", "-token", "-secret"}
+
+// isSensitiveHeader reports whether the header name may carry a secret:
+// one of sensitiveHeaders, keyHeader, the header carrying rate limit keys,
+// or a name ending in one of credentialSuffixes.
+func isSensitiveHeader(name, keyHeader string) bool {
+	if strings.EqualFold(name, keyHeader) {
+		return true
+	}
+	for _, s := range sensitiveHeaders {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	lower := strings.ToLower(name)
+	for _, suffix := range credentialSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchivePayloads returns middleware writing the request and response of a
+// sample of requests, redacted, to Options.Archive.Store under
+// "<trace id>/<span id>.json", or "untraced/<random id>.json" for requests
+// without a trace, to debug data dependent bugs. With
+// Options.CaptureDir, every request is written there instead. It must run
+// after the tracing middleware and is a no-op unless a store and a ratio
+// are configured.
+func (t *Telemetry) ArchivePayloads() func(http.Handler) http.Handler {
+	opts := t.opts.Archive
+	if t.opts.CaptureDir != "" {
+		opts.Ratio, opts.Store = 1, DirStore(t.opts.CaptureDir)
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 64 << 10
+	}
+	redactor := t.redactor
+	keyHeader := t.opts.RateLimit.keyHeader()
+	archived, _ := selfMeter().Int64Counter(
+		"http.server.archived",
+		metric.WithDescription("Request payloads archived, by result."),
+	)
+	workers := make(chan struct{}, archiveWorkers)
+
+	return func(next http.Handler) http.Handler {
+		if opts.Store == nil || opts.Ratio <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sc := trace.SpanContextFromContext(r.Context())
+			if !archiveSampled(sc, opts.Ratio) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := sc.TraceID().String() + "/" + sc.SpanID().String() + ".json"
+			if !sc.IsValid() {
+				// Untraced requests all share the zero IDs.
+				key = fmt.Sprintf("untraced/%016x%016x.json", rand.Uint64(), rand.Uint64())
+			}
+			p := ArchivedPayload{
+				TraceID:        sc.TraceID().String(),
+				SpanID:         sc.SpanID().String(),
+				Time:           time.Now(),
+				Method:         r.Method,
+				URL:            r.URL.String(),
+				RequestHeaders: r.Header.Clone(),
+			}
+			reqBody := &limitedBuffer{max: opts.MaxBodyBytes}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.TeeReader(r.Body, reqBody), r.Body}
+			}
+			respBody := &limitedBuffer{max: opts.MaxBodyBytes}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(respBody)
+
+			next.ServeHTTP(ww, r)
+
+			p.Status = ww.Status()
+			if p.Status == 0 {
+				p.Status = http.StatusOK
+			}
+			p.ResponseHeaders = w.Header().Clone()
+			p.RequestBody = redactor.Redact(reqBody.String())
+			p.ResponseBody = redactor.Redact(respBody.String())
+			p.Truncated = reqBody.truncated || respBody.truncated
+			for _, h := range []http.Header{p.RequestHeaders, p.ResponseHeaders} {
+				for name, values := range h {
+					if isSensitiveHeader(name, keyHeader) {
+						delete(h, name)
+						continue
+					}
+					for i, v := range values {
+						values[i] = redactor.Redact(v)
+					}
+					h[name] = values
+				}
+			}
+			p.URL = redactor.Redact(p.URL)
+
+			select {
+			case workers <- struct{}{}:
+			default:
+				archived.Add(r.Context(), 1, metric.WithAttributes(attribute.String("result", "dropped")))
+				return
+			}
+			// The request context ends with the response.
+			ctx := context.WithoutCancel(r.Context())
+			go func() {
+				defer func() { <-workers }()
+				result := "success"
+				if err := archive(ctx, opts.Store, key, p); err != nil {
+					result = "failure"
+					log.Warn().Err(err).Str("trace_id", p.TraceID).Msg("failed to archive payload")
+				}
+				archived.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+			}()
+		})
+	}
+}
+
+func archive(ctx context.Context, store BlobStore, key string, p ArchivedPayload) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, b)
+}
+
+// archiveSampled decides like TraceIDRatioBased, from the low 63 bits of
+// the trace ID, falling back to chance without a trace.
+func archiveSampled(sc trace.SpanContext, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	bound := uint64(ratio * (1 << 63))
+	if !sc.IsValid() {
+		return uint64(rand.Int63()) < bound
+	}
+	tid := sc.TraceID()
+	return binary.BigEndian.Uint64(tid[8:16])>>1 < bound
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// String returns the kept bytes, with invalid UTF-8 replaced.
+func (b *limitedBuffer) String() string {
+	return strings.ToValidUTF8(b.Buffer.String(), "�")
+}
@@ -0,0 +1,61 @@
+package telemetry
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestArchiveSampled(t *testing.T) {
+	low := traceWithLowBits(0x0000_0000_0000_0001)
+	high := traceWithLowBits(0xffff_ffff_ffff_ffff)
+	for _, tc := range []struct {
+		name  string
+		sc    trace.SpanContext
+		ratio float64
+		want  bool
+	}{
+		{"all", high, 1, true},
+		{"none", low, 0, false},
+		{"untraced none", trace.SpanContext{}, 0, false},
+		{"untraced all", trace.SpanContext{}, 1, true},
+		{"low half", low, 0.5, true},
+		{"high half", high, 0.5, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := archiveSampled(tc.sc, tc.ratio); got != tc.want {
+				t.Errorf("archiveSampled = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestArchiveSampledByTrace(t *testing.T) {
+	// Every span of a trace gets the same decision.
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		sc := traceWithLowBits(uint64(i) * 0x9e37_79b9_7f4a_7c15)
+		first := archiveSampled(sc, 0.25)
+		for j := 0; j < 3; j++ {
+			if archiveSampled(sc, 0.25) != first {
+				t.Fatalf("trace %s sampled inconsistently", sc.TraceID())
+			}
+		}
+		if first {
+			sampled++
+		}
+	}
+	if sampled < 200 || sampled > 300 {
+		t.Errorf("%d of 1000 traces sampled at 0.25, want about 250", sampled)
+	}
+}
+
+// traceWithLowBits returns a span context whose trace ID ends with bits,
+// the part archiveSampled decides from.
+func traceWithLowBits(bits uint64) trace.SpanContext {
+	tid := trace.TraceID{0: 1}
+	for i := 0; i < 8; i++ {
+		tid[15-i] = byte(bits >> (8 * i))
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: trace.SpanID{0: 1}})
+}
@@ -0,0 +1,168 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuditOptions configures Audit.
+type AuditOptions struct {
+	// Routes are the path.Match globs, e.g. "/orders/*", of the requests
+	// audited, matched against the request path and the chi route pattern.
+	// Empty disables auditing.
+	Routes []string
+	// Methods restricts auditing to these methods. Empty audits them all.
+	Methods []string
+	// ActorHeader names the request header identifying the actor when the
+	// handler does not, e.g. the user ID set by an authenticating proxy.
+	ActorHeader string
+}
+
+// DefaultAuditOptions audits nothing until routes are configured.
+func DefaultAuditOptions() AuditOptions {
+	return AuditOptions{ActorHeader: "X-User-ID"}
+}
+
+func (o AuditOptions) validate() error {
+	for _, g := range o.Routes {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("audit route %q: %w", g, err)
+		}
+	}
+	return nil
+}
+
+// AuditEvent describes an access to a resource. The fields a handler leaves
+// empty are filled in by Audit: the actor from AuditOptions.ActorHeader,
+// the action from the method and route, and the resource from the path.
+type AuditEvent struct {
+	Actor    string
+	Action   string
+	Resource string
+	// Attributes are the attributes the access decision was based on, such
+	// as the actor's tenant or the resource owner.
+	Attributes map[string]string
+}
+
+type auditEventKey struct{}
+
+// SetAuditEvent describes the request ctx belongs to for its audit event.
+// It is a no-op outside the Audit middleware or on routes not audited.
+func SetAuditEvent(ctx context.Context, e AuditEvent) {
+	if ae, ok := ctx.Value(auditEventKey{}).(*AuditEvent); ok {
+		*ae = e
+	}
+}
+
+// auditOutcome names the outcome of a request from its status.
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	case status >= http.StatusBadRequest:
+		return "failure"
+	default:
+		return "success"
+	}
+}
+
+// Audit returns middleware emitting an audit event for each request to the
+// audited routes, once it is served: the actor, action, resource, outcome
+// and trace ID are logged with "audit": true and added to the request span
+// as an "audit" event. Values are redacted with Options.RedactionRules, like
+// span attributes and log lines. It must run inside the chi router so the
+// matched route pattern is known.
+func (t *Telemetry) Audit() func(http.Handler) http.Handler {
+	opts := t.opts.Audit
+	redactor := t.redactor
+
+	return func(next http.Handler) http.Handler {
+		if len(opts.Routes) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(opts.Methods) > 0 && !slices.Contains(opts.Methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			e := &AuditEvent{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), auditEventKey{}, e)))
+
+			route := routePattern(r)
+			if !matchRoute(opts.Routes, r.URL.Path, route) {
+				return
+			}
+			if route == "" {
+				route = r.URL.Path
+			}
+			if e.Actor == "" && opts.ActorHeader != "" {
+				e.Actor = r.Header.Get(opts.ActorHeader)
+			}
+			if e.Action == "" {
+				e.Action = r.Method + " " + route
+			}
+			if e.Resource == "" {
+				e.Resource = r.URL.Path
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			recordAudit(r.Context(), redactor, e, auditOutcome(status))
+		})
+	}
+}
+
+// recordAudit logs e and adds it to the span of ctx.
+func recordAudit(ctx context.Context, redactor *Redactor, e *AuditEvent, outcome string) {
+	actor := redactor.Redact(e.Actor)
+	action := redactor.Redact(e.Action)
+	resource := redactor.Redact(e.Resource)
+	keys := make([]string, 0, len(e.Attributes))
+	for k := range e.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := []attribute.KeyValue{
+		attribute.String("audit.actor", actor),
+		attribute.String("audit.action", action),
+		attribute.String("audit.resource", resource),
+		attribute.String("audit.outcome", outcome),
+	}
+	// Warn, like admin audit events, so the trail survives raising the log
+	// level.
+	ev := log.Warn().
+		Bool("audit", true).
+		Str("actor", actor).
+		Str("action", action).
+		Str("resource", resource).
+		Str("outcome", outcome)
+	if len(keys) > 0 {
+		dict := zerolog.Dict()
+		for _, k := range keys {
+			v := redactor.Redact(e.Attributes[k])
+			dict = dict.Str(k, v)
+			attrs = append(attrs, attribute.String("audit.attributes."+k, v))
+		}
+		ev = ev.Dict("attributes", dict)
+	}
+	span := trace.SpanFromContext(ctx)
+	if sc := span.SpanContext(); sc.IsValid() {
+		ev = ev.Str("trace_id", sc.TraceID().String())
+	}
+	span.AddEvent("audit", trace.WithAttributes(attrs...))
+	ev.Msg("audit event")
+}
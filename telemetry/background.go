@@ -0,0 +1,78 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Detach returns a context with the values of ctx, such as its span and
+// baggage, that is never cancelled and has no deadline. Work started from a
+// request and outliving it, e.g. in a goroutine, should use it: spans it
+// starts are still children of the request span.
+func Detach(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
+
+// StartBackgroundSpan starts name as the root of a new trace, linked to the
+// span of ctx, for asynchronous work such as jobs queued by a request. The
+// returned context is detached from ctx but keeps its baggage. Each job
+// getting its own trace keeps the request trace short, while the link
+// leads from one to the other.
+func StartBackgroundSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	opts = append([]trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithLinks(trace.LinkFromContext(ctx, attribute.String("link.type", "origin"))),
+	}, opts...)
+	return otel.Tracer(instrumentationName).Start(Detach(ctx), name, opts...)
+}
+
+// Go runs fn in a goroutine within a background span started from ctx, see
+// StartBackgroundSpan, recording the error it returns on the span.
+func Go(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	ctx, span := StartBackgroundSpan(ctx, name)
+	go func() {
+		defer span.End()
+		if err := fn(ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}()
+}
+
+// BatchSpan starts name for work done on a batch of items, such as queued
+// messages a worker processes together, linked to the span each item came
+// from. Links without a valid span context are left out. The span is a
+// child of the span of ctx, if any. The batch size is recorded in
+// messaging.batch.message_count and in the telemetry.batch.size histogram,
+// by batch.name.
+//
+// Tracer providers keep 128 links per span by default; the size counts
+// every item regardless.
+func BatchSpan(ctx context.Context, name string, links []trace.Link, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	valid := make([]trace.Link, 0, len(links))
+	for _, l := range links {
+		if l.SpanContext.IsValid() {
+			valid = append(valid, l)
+		}
+	}
+	batchSize.Record(ctx, int64(len(links)), metric.WithAttributes(attribute.String("batch.name", name)))
+	opts = append([]trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(valid...),
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(links))),
+	}, opts...)
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// batchSize records the size of batches started with BatchSpan.
+var batchSize, _ = selfMeter().Int64Histogram(
+	"telemetry.batch.size",
+	metric.WithDescription("Items per batch processed under a BatchSpan."),
+	metric.WithExplicitBucketBoundaries(1, 2, 5, 10, 20, 50, 100, 200, 500, 1000),
+)
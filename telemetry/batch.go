@@ -0,0 +1,208 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// dropWarnInterval rate limits the "queue full" warning.
+const dropWarnInterval = 10 * time.Second
+
+// withDefaults fills zero values with the SDK defaults.
+func (b BatchOptions) withDefaults() BatchOptions {
+	if b.MaxQueueSize <= 0 {
+		b.MaxQueueSize = trace.DefaultMaxQueueSize
+	}
+	if b.MaxExportBatchSize <= 0 {
+		b.MaxExportBatchSize = trace.DefaultMaxExportBatchSize
+	}
+	if b.MaxExportBatchSize > b.MaxQueueSize {
+		b.MaxExportBatchSize = b.MaxQueueSize
+	}
+	if b.ScheduleDelay <= 0 {
+		b.ScheduleDelay = trace.DefaultScheduleDelay * time.Millisecond
+	}
+	if b.ExportTimeout <= 0 {
+		b.ExportTimeout = trace.DefaultExportTimeout * time.Millisecond
+	}
+	return b
+}
+
+// newBatchProcessor returns a batch span processor that reports the spans it
+// drops because its queue is full.
+//
+// The SDK processor drops silently, so the queue limit is enforced by
+// queueGuard in front of it instead: the guard counts spans handed to the
+// processor and spans handed to the exporter, and refuses new spans once the
+// difference reaches MaxQueueSize. The SDK processor gets enough headroom
+// that it never drops on its own. Drops are reported to tracker, when set,
+// so the local roots of the traces they truncate say so.
+func newBatchProcessor(exporter trace.SpanExporter, b BatchOptions, p ExportPriorityOptions, tracker *traceTracker, attrs ...attribute.KeyValue) trace.SpanProcessor {
+	b = b.withDefaults()
+
+	g := &queueGuard{
+		maxQueueSize: int64(b.MaxQueueSize),
+		reserved:     int64(float64(b.MaxQueueSize) * p.Reserve),
+		priority:     p,
+		tracker:      tracker,
+		attrs:        attrs,
+	}
+	g.dropped, _ = selfMeter().Int64Counter(
+		"telemetry.spans.dropped",
+		metric.WithDescription("Spans dropped because the batch span processor queue was full, by reason: queue_full, or low_priority when the room left was reserved for priority spans."),
+	)
+	if queued, err := selfMeter().Int64ObservableGauge(
+		"telemetry.spans.queued",
+		metric.WithDescription("Spans waiting in the batch span processor queue."),
+	); err == nil {
+		g.reg, _ = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+			o.ObserveInt64(queued, g.accepted.Load()-g.exported.Load(), metric.WithAttributes(g.attrs...))
+			return nil
+		}, queued)
+	}
+
+	g.SpanProcessor = trace.NewBatchSpanProcessor(
+		&countingExporter{SpanExporter: exporter, exported: &g.exported},
+		trace.WithMaxQueueSize(b.MaxQueueSize+b.MaxExportBatchSize),
+		trace.WithMaxExportBatchSize(b.MaxExportBatchSize),
+		trace.WithBatchTimeout(b.ScheduleDelay),
+		trace.WithExportTimeout(b.ExportTimeout),
+	)
+	return g
+}
+
+// queueGuard enforces the queue limit of the batch processor it wraps.
+// The last reserved places of the queue only take priority spans, so bulk
+// traffic is dropped first when the exporter cannot keep up.
+type queueGuard struct {
+	trace.SpanProcessor
+
+	maxQueueSize int64
+	reserved     int64
+	priority     ExportPriorityOptions
+	tracker      *traceTracker
+	accepted     atomic.Int64
+	exported     atomic.Int64
+	lastWarn     atomic.Int64 // unix nanos
+	sinceWarn    atomic.Int64
+
+	dropped metric.Int64Counter
+	attrs   []attribute.KeyValue
+	reg     metric.Registration
+}
+
+func (g *queueGuard) OnEnd(s trace.ReadOnlySpan) {
+	// The batch processor ignores unsampled spans, so they never queue.
+	if !s.SpanContext().IsSampled() {
+		return
+	}
+
+	limit := g.maxQueueSize
+	if g.reserved > 0 && !g.priority.prioritized(s) {
+		limit -= g.reserved
+	}
+	queued := g.accepted.Add(1) - g.exported.Load()
+	if queued > limit {
+		g.accepted.Add(-1)
+		reason := "low_priority"
+		if queued > g.maxQueueSize {
+			reason = "queue_full"
+		}
+		g.drop(reason)
+		if g.tracker != nil {
+			g.tracker.dropped(s)
+		}
+		return
+	}
+	g.SpanProcessor.OnEnd(s)
+}
+
+func (g *queueGuard) Shutdown(ctx context.Context) error {
+	if g.reg != nil {
+		_ = g.reg.Unregister()
+	}
+	return g.SpanProcessor.Shutdown(ctx)
+}
+
+func (g *queueGuard) drop(reason string) {
+	g.dropped.Add(context.Background(), 1,
+		metric.WithAttributes(withAttrs(g.attrs, attribute.String("reason", reason))...))
+
+	n := g.sinceWarn.Add(1)
+	now := time.Now().UnixNano()
+	last := g.lastWarn.Load()
+	if now-last < int64(dropWarnInterval) || !g.lastWarn.CompareAndSwap(last, now) {
+		return
+	}
+	g.sinceWarn.Add(-n)
+	log.Warn().
+		Int64("dropped", n).
+		Int64("max_queue_size", g.maxQueueSize).
+		Int64("reserved", g.reserved).
+		Msg("span queue full, dropping spans")
+}
+
+// ExportPriorityOptions selects the spans kept when the export queue of a
+// batch processor fills up.
+type ExportPriorityOptions struct {
+	// Routes are path.Match globs of the http.route of server spans kept in
+	// priority, e.g. "/checkout/*".
+	Routes []string
+	// Reserve is the fraction of the queue only priority spans may use:
+	// spans with an error status and those of priority routes. Zero
+	// disables prioritization.
+	Reserve float64
+}
+
+// DefaultExportPriorityOptions reserves a fifth of the queue for errors.
+func DefaultExportPriorityOptions() ExportPriorityOptions {
+	return ExportPriorityOptions{Reserve: 0.2}
+}
+
+func (o ExportPriorityOptions) validate() error {
+	if o.Reserve < 0 || o.Reserve >= 1 {
+		return fmt.Errorf("export priority reserve must be in [0, 1), got %g", o.Reserve)
+	}
+	for _, g := range o.Routes {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("export priority route %q: %w", g, err)
+		}
+	}
+	return nil
+}
+
+// prioritized reports whether s is kept in priority.
+func (o ExportPriorityOptions) prioritized(s trace.ReadOnlySpan) bool {
+	if s.Status().Code == codes.Error {
+		return true
+	}
+	if len(o.Routes) == 0 {
+		return false
+	}
+	for _, kv := range s.Attributes() {
+		if kv.Key == "http.route" {
+			return matchRoute(o.Routes, kv.Value.AsString())
+		}
+	}
+	return false
+}
+
+// countingExporter counts spans as they leave the batch processor queue.
+type countingExporter struct {
+	trace.SpanExporter
+	exported *atomic.Int64
+}
+
+func (e *countingExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	e.exported.Add(int64(len(spans)))
+	return e.SpanExporter.ExportSpans(ctx, spans)
+}
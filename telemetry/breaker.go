@@ -0,0 +1,135 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/metric"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"go-otel/internal/circuit"
+)
+
+// ErrExporterCircuitOpen is returned for exports failed fast because the
+// circuit breaker of their exporter is open.
+var ErrExporterCircuitOpen = errors.New("exporter circuit open")
+
+// BreakerOptions configures the circuit breaker of an exporter. While the
+// backend is down, exports fail fast instead of spending CPU and
+// connections on retries; the first export after OpenDuration probes
+// whether it is back.
+type BreakerOptions struct {
+	// FailureThreshold is the number of consecutive failed exports that
+	// opens the circuit.
+	FailureThreshold int
+	// OpenDuration is how long the circuit stays open before a trial export.
+	OpenDuration time.Duration
+}
+
+// DefaultBreakerOptions opens the circuit for 30s after 5 consecutive
+// failed exports.
+func DefaultBreakerOptions() *BreakerOptions {
+	return &BreakerOptions{FailureThreshold: 5, OpenDuration: 30 * time.Second}
+}
+
+func (o *BreakerOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.FailureThreshold <= 0 {
+		return fmt.Errorf("breaker failure threshold must be positive, got %d", o.FailureThreshold)
+	}
+	if o.OpenDuration <= 0 {
+		return fmt.Errorf("breaker open duration must be positive, got %s", o.OpenDuration)
+	}
+	return nil
+}
+
+// exporterBreaker guards the exports of one exporter.
+type exporterBreaker struct {
+	breaker *circuit.Breaker
+	attrs   []attribute.KeyValue
+	opened  metric.Int64Counter
+	reg     metric.Registration
+}
+
+func newExporterBreaker(o BreakerOptions, attrs ...attribute.KeyValue) (*exporterBreaker, error) {
+	b := &exporterBreaker{
+		breaker: &circuit.Breaker{Threshold: o.FailureThreshold, Cooldown: o.OpenDuration},
+		attrs:   attrs,
+	}
+	b.opened, _ = selfMeter().Int64Counter(
+		"telemetry.exporter.circuit.open",
+		metric.WithDescription("Times the circuit breaker of an exporter opened."),
+	)
+	state, err := selfMeter().Int64ObservableGauge(
+		"telemetry.exporter.circuit.state",
+		metric.WithDescription("State of the circuit breaker of an exporter: 0 closed, 1 half-open, 2 open."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	b.reg, err = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(state, int64(b.breaker.State()), metric.WithAttributes(attrs...))
+		return nil
+	}, state)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// do runs export unless the circuit is open. Exports cancelled by the
+// caller, such as on shutdown, say nothing about the backend and are not
+// counted.
+func (b *exporterBreaker) do(ctx context.Context, export func(context.Context) error) error {
+	ok, t := b.breaker.Allow(time.Now())
+	b.transitioned(ctx, t)
+	if !ok {
+		return ErrExporterCircuitOpen
+	}
+	err := export(ctx)
+	counted := !errors.Is(err, context.Canceled) || ctx.Err() == nil
+	b.transitioned(ctx, b.breaker.Done(time.Now(), counted, err != nil))
+	return err
+}
+
+// transitioned records a change of circuit state in the log, and in
+// telemetry.exporter.circuit.open when the circuit opened.
+func (b *exporterBreaker) transitioned(ctx context.Context, t circuit.Transition) {
+	if !t.Changed() {
+		return
+	}
+	ev := log.Info()
+	if t.To == circuit.Open {
+		b.opened.Add(ctx, 1, metric.WithAttributes(b.attrs...))
+		ev = log.Warn()
+	}
+	for _, kv := range b.attrs {
+		ev = ev.Str(strings.ReplaceAll(string(kv.Key), ".", "_"), kv.Value.Emit())
+	}
+	ev.Str("from", t.From.String()).Str("to", t.To.String()).Msg("exporter circuit state changed")
+}
+
+// breakerClient guards the uploads of an OTLP client. It sits below the
+// spool, so batches failed fast are spooled like any failed batch.
+type breakerClient struct {
+	otlptrace.Client
+	breaker *exporterBreaker
+}
+
+func (c *breakerClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	return c.breaker.do(ctx, func(ctx context.Context) error {
+		return c.Client.UploadTraces(ctx, protoSpans)
+	})
+}
+
+func (c *breakerClient) Stop(ctx context.Context) error {
+	return errors.Join(c.Client.Stop(ctx), c.breaker.reg.Unregister())
+}
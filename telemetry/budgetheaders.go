@@ -0,0 +1,155 @@
+package telemetry
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Budget headers, sent with Options.BudgetHeaders so well-behaved clients
+// can throttle themselves before they are rejected or time out.
+const (
+	// RateLimitLimitHeader is the burst of the rate limit of the client.
+	RateLimitLimitHeader = "X-RateLimit-Limit"
+	// RateLimitRemainingHeader is the number of requests the client can
+	// still send at once.
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	// RateLimitResetHeader is the number of seconds until the client has
+	// its whole burst again.
+	RateLimitResetHeader = "X-RateLimit-Reset"
+	// BudgetLimitHeader is the response time budget of the route, the
+	// timeout of RequestTimeout, in milliseconds.
+	BudgetLimitHeader = "X-Budget-Limit"
+	// BudgetRemainingHeader is the part of that budget, in milliseconds,
+	// left when the response header was sent.
+	BudgetRemainingHeader = "X-Budget-Remaining"
+)
+
+// setRateLimitHeaders sets the rate limit headers of d on h.
+func setRateLimitHeaders(h http.Header, d rateDecision) {
+	remaining := math.Max(0, d.remaining)
+	h.Set(RateLimitLimitHeader, strconv.Itoa(int(d.burst)))
+	h.Set(RateLimitRemainingHeader, strconv.Itoa(int(math.Floor(remaining))))
+	h.Set(RateLimitResetHeader, strconv.Itoa(int(math.Ceil((d.burst-remaining)/d.rate))))
+}
+
+// announcement is the remaining quota last sent to a client.
+type announcement struct {
+	remaining float64
+	rate      float64
+	burst     float64
+	at        time.Time
+}
+
+// quotaAccuracy measures how far X-RateLimit-Remaining was from the
+// tokens a client found at its next request. With one instance limiting
+// locally the two agree; replicas sharing a bucket through Redis, or
+// limiting locally to their share of it, make the header overstate what
+// is left.
+type quotaAccuracy struct {
+	errors metric.Float64Histogram
+
+	mu    sync.Mutex
+	sent  map[string]announcement
+	swept time.Time
+}
+
+func newQuotaAccuracy() *quotaAccuracy {
+	q := &quotaAccuracy{sent: make(map[string]announcement)}
+	q.errors, _ = selfMeter().Float64Histogram(
+		"rate_limit.header.error",
+		metric.WithDescription("Tokens X-RateLimit-Remaining promised a client beyond those it had at its next request, by mode."),
+		metric.WithUnit("{token}"),
+		metric.WithExplicitBucketBoundaries(-1, 0, 1, 2, 5, 10, 25, 50, 100),
+	)
+	return q
+}
+
+// record compares the decision d for key with the quota last announced to
+// it, and remembers the one announced now.
+func (q *quotaAccuracy) record(ctx context.Context, key string, d rateDecision, now time.Time) {
+	// The tokens the client had before this request.
+	had := d.remaining
+	if d.allowed {
+		had++
+	}
+	q.mu.Lock()
+	if now.Sub(q.swept) > time.Minute {
+		q.sweep(now)
+	}
+	prev, ok := q.sent[key]
+	q.sent[key] = announcement{remaining: d.remaining, rate: d.rate, burst: d.burst, at: now}
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+	expected := math.Min(prev.burst, prev.remaining+now.Sub(prev.at).Seconds()*prev.rate)
+	q.errors.Record(ctx, expected-had, metric.WithAttributes(attribute.String("rate_limit.mode", d.mode)))
+}
+
+// sweep forgets the clients whose bucket has refilled since, as their
+// next request starts over with a full one. q.mu must be held.
+func (q *quotaAccuracy) sweep(now time.Time) {
+	for key, a := range q.sent {
+		if a.remaining+now.Sub(a.at).Seconds()*a.rate >= a.burst {
+			delete(q.sent, key)
+		}
+	}
+	q.swept = now
+}
+
+// budgetWriter adds the response time budget headers just before the
+// response header is sent.
+type budgetWriter struct {
+	http.ResponseWriter
+	budget   time.Duration
+	deadline time.Time
+	sentAt   time.Time
+}
+
+func (w *budgetWriter) sendBudget() {
+	if !w.sentAt.IsZero() {
+		return
+	}
+	w.sentAt = time.Now()
+	remaining := max(0, w.deadline.Sub(w.sentAt))
+	w.Header().Set(BudgetLimitHeader, strconv.FormatInt(w.budget.Milliseconds(), 10))
+	w.Header().Set(BudgetRemainingHeader, strconv.FormatInt(remaining.Milliseconds(), 10))
+}
+
+func (w *budgetWriter) WriteHeader(code int) {
+	w.sendBudget()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *budgetWriter) Write(b []byte) (int, error) {
+	w.sendBudget()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *budgetWriter) Flush() {
+	w.sendBudget()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *budgetWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// staleness returns how much X-Budget-Remaining overstated the budget left
+// when the response ended, as the body was written after it.
+func (w *budgetWriter) staleness() (time.Duration, bool) {
+	if w.sentAt.IsZero() {
+		return 0, false
+	}
+	return time.Since(w.sentAt), true
+}
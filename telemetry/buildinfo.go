@@ -0,0 +1,118 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Build details set at link time, e.g.
+//
+//	go build -ldflags "-X go-otel/telemetry.Version=1.4.0 -X go-otel/telemetry.BuildTime=$(date -u +%FT%TZ)"
+//
+// They take precedence over what the Go toolchain records in the binary.
+var (
+	Version   string
+	Revision  string
+	BuildTime string
+)
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	Time      string `json:"time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuildInfo returns the build details set at link time, completed with
+// those recorded by the Go toolchain: the module version and, for builds
+// from a VCS checkout, the revision, commit time and whether the tree was
+// modified.
+func ReadBuildInfo() BuildInfo {
+	bi := BuildInfo{Version: Version, Revision: Revision, Time: BuildTime, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return bi.withDefaults()
+	}
+	if bi.Version == "" && info.Main.Version != "(devel)" {
+		bi.Version = info.Main.Version
+	}
+	var vcsRevision, vcsTime string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			vcsRevision = s.Value
+		case "vcs.time":
+			vcsTime = s.Value
+		case "vcs.modified":
+			bi.Modified, _ = strconv.ParseBool(s.Value)
+		}
+	}
+	if bi.Revision == "" {
+		bi.Revision = vcsRevision
+	}
+	if bi.Time == "" {
+		bi.Time = vcsTime
+	}
+	return bi.withDefaults()
+}
+
+func (bi BuildInfo) withDefaults() BuildInfo {
+	if bi.Version == "" {
+		bi.Version = "devel"
+	}
+	return bi
+}
+
+// attributes returns the resource attributes describing the build.
+func (bi BuildInfo) attributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("service.version", bi.Version)}
+	if bi.Revision != "" {
+		attrs = append(attrs,
+			attribute.String("vcs.revision", bi.Revision),
+			attribute.Bool("vcs.modified", bi.Modified),
+		)
+	}
+	if bi.Time != "" {
+		attrs = append(attrs, attribute.String("build.time", bi.Time))
+	}
+	return attrs
+}
+
+// registerBuildInfo exports the build.info gauge, always 1, whose
+// attributes describe the build, for joining against other series.
+func registerBuildInfo(bi BuildInfo) error {
+	gauge, err := selfMeter().Int64ObservableGauge(
+		"build.info",
+		metric.WithDescription("Always 1; the attributes describe the running build."),
+	)
+	if err != nil {
+		return err
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("version", bi.Version),
+		attribute.String("revision", bi.Revision),
+		attribute.String("go_version", bi.GoVersion),
+	)
+	_, err = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(gauge, 1, attrs)
+		return nil
+	}, gauge)
+	return err
+}
+
+// VersionHandler serves the build details as JSON.
+func VersionHandler() http.Handler {
+	bi := ReadBuildInfo()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, bi)
+	})
+}
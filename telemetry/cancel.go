@@ -0,0 +1,70 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/server"
+)
+
+// ErrRequestTimeout is the cause of the cancellation of requests whose
+// RequestTimeout fired.
+var ErrRequestTimeout = errors.New("request timeout")
+
+// cancellationCause names why ctx was cancelled: "timeout" for server
+// timeouts, "shutdown" for requests cut short by the server shutting down,
+// "client_disconnect" when the client went away, which is all net/http
+// reports without a cause, and "other" for causes set elsewhere.
+func cancellationCause(ctx context.Context) string {
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, ErrRequestTimeout), errors.Is(cause, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(cause, server.ErrShutdown):
+		return "shutdown"
+	case errors.Is(cause, ErrClientDisconnected), errors.Is(cause, context.Canceled):
+		return "client_disconnect"
+	default:
+		return "other"
+	}
+}
+
+// RecordCancellations returns middleware recording why requests whose
+// context was cancelled before the handler returned were cancelled, as
+// cancellation.cause on the request span and in
+// http.server.request.cancellations by route and cause, so client aborts
+// can be told from server timeouts. It must run after RequestTimeout, so
+// it sees the context the timeout cancels.
+func (t *Telemetry) RecordCancellations() func(http.Handler) http.Handler {
+	cancellations, _ := selfMeter().Int64Counter(
+		"http.server.request.cancellations",
+		metric.WithDescription("Requests cancelled before their handler returned, by route and cause."),
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			ctx := r.Context()
+			if ctx.Err() == nil {
+				return
+			}
+			cause := cancellationCause(ctx)
+			span := trace.SpanFromContext(ctx)
+			span.SetAttributes(attribute.String("cancellation.cause", cause))
+			if c := context.Cause(ctx); c != nil && c != ctx.Err() {
+				span.SetAttributes(attribute.String("cancellation.message", c.Error()))
+			}
+			// The request context is cancelled, but the count must not be.
+			cancellations.Add(context.WithoutCancel(ctx), 1, metric.WithAttributes(
+				attribute.String("http.route", routePattern(r)),
+				attribute.String("cause", cause),
+			))
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package telemetry
+
+import (
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ResponseStream writes a response in chunks flushed to the client as they
+// are ready, e.g. an NDJSON export or a large download, which net/http
+// sends with chunked transfer encoding. The request duration of
+// HTTPMetrics says little about such responses, so the time to the first
+// byte, the flushes and the duration of the whole stream are recorded in
+// metrics of their own, by route.
+//
+// Like the http.ResponseWriter it wraps, a ResponseStream must not be used
+// concurrently.
+type ResponseStream struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	rc    *http.ResponseController
+	start time.Time
+
+	firstByte time.Duration
+	wrote     bool
+	flushes   int64
+	bytes     int64
+	ended     bool
+}
+
+// NewResponseStream starts streaming the response to r through w. Times are
+// measured from the start of the request span, or from now when the request
+// is not traced. The caller must call End once the response is complete.
+func NewResponseStream(w http.ResponseWriter, r *http.Request) *ResponseStream {
+	start := time.Now()
+	if s, ok := trace.SpanFromContext(r.Context()).(sdktrace.ReadOnlySpan); ok {
+		start = s.StartTime()
+	}
+	return &ResponseStream{w: w, r: r, rc: http.NewResponseController(w), start: start}
+}
+
+// Header returns the response headers, which must be set before the first
+// Write.
+func (s *ResponseStream) Header() http.Header {
+	return s.w.Header()
+}
+
+// Write writes a chunk of the response. It is not sent until Flush.
+func (s *ResponseStream) Write(p []byte) (int, error) {
+	if !s.wrote && len(p) > 0 {
+		s.wrote = true
+		s.firstByte = time.Since(s.start)
+		trace.SpanFromContext(s.r.Context()).AddEvent("first byte")
+	}
+	n, err := s.w.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush sends the chunks written so far to the client. It fails when the
+// ResponseWriter cannot flush or the client went away.
+func (s *ResponseStream) Flush() error {
+	if err := s.rc.Flush(); err != nil {
+		return err
+	}
+	s.flushes++
+	return nil
+}
+
+// End records the stream on the request span and in the stream metrics. It
+// does not flush; the rest of the response is sent when the handler
+// returns. Later calls are no-ops.
+func (s *ResponseStream) End() {
+	if s.ended {
+		return
+	}
+	s.ended = true
+	elapsed := time.Since(s.start)
+
+	trace.SpanFromContext(s.r.Context()).SetAttributes(
+		attribute.Int64("http.response.stream.flushes", s.flushes),
+		attribute.Int64("http.response.stream.bytes", s.bytes),
+		attribute.Float64("http.response.stream.first_byte", s.firstByte.Seconds()),
+	)
+
+	ctx := s.r.Context()
+	attrs := metric.WithAttributes(attribute.String("http.route", routePattern(s.r)))
+	if s.wrote {
+		streamFirstByte.Record(ctx, s.firstByte.Seconds(), attrs)
+	}
+	streamFlushes.Record(ctx, s.flushes, attrs)
+	streamDuration.Record(ctx, elapsed.Seconds(), attrs)
+}
+
+// streamDurationBuckets extend latencyBuckets to streams lasting minutes.
+var streamDurationBuckets = append(latencyBuckets[:len(latencyBuckets):len(latencyBuckets)], 30, 60, 120, 300, 600)
+
+var (
+	streamFirstByte, _ = selfMeter().Float64Histogram(
+		"http.server.response.stream.first_byte",
+		metric.WithDescription("Time from the start of streamed responses to their first byte, by route."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...),
+	)
+	streamFlushes, _ = selfMeter().Int64Histogram(
+		"http.server.response.stream.flushes",
+		metric.WithDescription("Flushes per streamed response, by route."),
+		metric.WithExplicitBucketBoundaries(1, 2, 5, 10, 20, 50, 100, 200, 500, 1000),
+	)
+	streamDuration, _ = selfMeter().Float64Histogram(
+		"http.server.response.stream.duration",
+		metric.WithDescription("Duration of streamed responses, from the start of the request to End, by route."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(streamDurationBuckets...),
+	)
+)
@@ -0,0 +1,179 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrorClassifier names the kind of failure of a request for the error.type
+// attribute, from its response status and the error the handler reported
+// with SetRequestError, which may be nil. An empty result leaves the
+// attribute unset.
+type ErrorClassifier func(r *http.Request, status int, err error) string
+
+// DefaultErrorClassifier reports errors with a domain error code by their
+// code, timeouts, client disconnects and cancellations by name, other
+// errors by their Go type, and otherwise the status code of 4xx and 5xx
+// responses.
+func DefaultErrorClassifier(_ *http.Request, status int, err error) string {
+	if code, ok := CodeOf(err); ok {
+		return string(code)
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrClientDisconnected):
+		return "client_disconnect"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case err != nil:
+		return fmt.Sprintf("%T", err)
+	case status >= http.StatusBadRequest:
+		return strconv.Itoa(status)
+	default:
+		return ""
+	}
+}
+
+type requestErrorKey struct{}
+
+// requestError holds the error a handler reported for its request.
+type requestError struct {
+	err error
+}
+
+// SetRequestError reports err as the cause of the failure of the request
+// ctx belongs to, for the ErrorClassifier. It is a no-op outside the
+// ClassifyErrors middleware.
+func SetRequestError(ctx context.Context, err error) {
+	if re, ok := ctx.Value(requestErrorKey{}).(*requestError); ok {
+		re.err = err
+	}
+}
+
+// ClassifyErrors returns middleware recording the response status as
+// http.status_code on the request span, marking 5xx responses as errors and
+// naming failures in error.type using Options.ErrorClassifier. It must run
+// after the tracing middleware. Exported server spans get their status from
+// http.status_code whether or not it runs. Requests aborted by their client,
+// as seen by DetectDisconnects, are marked client.disconnected rather than
+// failed.
+func (t *Telemetry) ClassifyErrors() func(http.Handler) http.Handler {
+	classify := t.opts.ErrorClassifier
+	if classify == nil {
+		classify = DefaultErrorClassifier
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			re := &requestError{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), requestErrorKey{}, re)))
+
+			span := trace.SpanFromContext(r.Context())
+			if !span.IsRecording() {
+				return
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttributes(attribute.Int("http.status_code", status))
+			aborted := ClientDisconnected(r.Context())
+			if aborted {
+				span.SetAttributes(attribute.Bool("client.disconnected", true))
+				if re.err == nil {
+					re.err = ErrClientDisconnected
+				}
+			}
+			if status >= http.StatusInternalServerError && !aborted {
+				desc := http.StatusText(status)
+				if re.err != nil {
+					desc = re.err.Error()
+				}
+				span.SetStatus(codes.Error, desc)
+			}
+			if typ := classify(r, status, re.err); typ != "" {
+				span.SetAttributes(attribute.String("error.type", typ))
+			}
+		})
+	}
+}
+
+// serverStatusProcessor corrects the status of ended HTTP server spans.
+// otelchi applies client semantics, marking 4xx responses as errors, and
+// sets its status after inner middleware, dropping their descriptions.
+type serverStatusProcessor struct {
+	sdktrace.SpanProcessor
+}
+
+func (p serverStatusProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if s.SpanKind() == trace.SpanKindServer {
+		if st, ok := serverSpanStatus(s); ok {
+			s = statusSpan{ReadOnlySpan: s, status: st}
+		}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// serverSpanStatus derives the status of s from its http.status_code: 5xx
+// responses are errors described by their error.type, and errors without a
+// description on other responses, or on requests the client aborted, are
+// unset.
+func serverSpanStatus(s sdktrace.ReadOnlySpan) (sdktrace.Status, bool) {
+	var status int64
+	var errType string
+	var aborted bool
+	for _, kv := range s.Attributes() {
+		switch kv.Key {
+		case "client.disconnected":
+			aborted = kv.Value.AsBool()
+		case "http.status_code":
+			status = kv.Value.AsInt64()
+		case "error.type":
+			errType = kv.Value.AsString()
+		}
+	}
+	st := s.Status()
+	switch {
+	case status == 0:
+		return st, false
+	case aborted:
+		if st.Code != codes.Error {
+			return st, false
+		}
+		return sdktrace.Status{Code: codes.Unset}, true
+	case status >= http.StatusInternalServerError:
+		if st.Code == codes.Error && st.Description != "" {
+			return st, false
+		}
+		if errType == "" {
+			errType = http.StatusText(int(status))
+		}
+		return sdktrace.Status{Code: codes.Error, Description: errType}, true
+	case st.Code == codes.Error && st.Description == "":
+		return sdktrace.Status{Code: codes.Unset}, true
+	default:
+		return st, false
+	}
+}
+
+// statusSpan overrides the status of a span.
+type statusSpan struct {
+	sdktrace.ReadOnlySpan
+	status sdktrace.Status
+}
+
+func (s statusSpan) Status() sdktrace.Status {
+	return s.status
+}
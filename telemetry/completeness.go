@@ -0,0 +1,135 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxTrackedTraces bounds the traces whose spans are counted at once. The
+// local roots of traces started beyond it are not annotated.
+const maxTrackedTraces = 10000
+
+// traceCounts counts the sampled spans of a trace in this process.
+type traceCounts struct {
+	started, ended, dropped int
+}
+
+// traceTracker counts the spans started, ended and dropped by an export
+// queue for each trace until its local root ends, so the root can tell
+// whether the trace is complete. A trace whose root ends while children
+// are still running, or whose spans were dropped, is truncated; a short
+// trace is not.
+//
+// It must be registered after every other span processor: exporters see
+// the local root before the tracker forgets its trace.
+type traceTracker struct {
+	mu     sync.Mutex
+	traces map[trace.TraceID]*traceCounts
+}
+
+func newTraceTracker() *traceTracker {
+	return &traceTracker{traces: make(map[trace.TraceID]*traceCounts)}
+}
+
+// localRoot reports whether s is the first span of its trace in this
+// process.
+func localRoot(s sdktrace.ReadOnlySpan) bool {
+	return !s.Parent().IsValid() || s.Parent().IsRemote()
+}
+
+func (t *traceTracker) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	if !s.SpanContext().IsSampled() {
+		return
+	}
+	id := s.SpanContext().TraceID()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.traces[id]
+	if !ok {
+		if !localRoot(s) || len(t.traces) >= maxTrackedTraces {
+			return
+		}
+		c = &traceCounts{}
+		t.traces[id] = c
+	}
+	c.started++
+}
+
+func (t *traceTracker) OnEnd(s sdktrace.ReadOnlySpan) {
+	id := s.SpanContext().TraceID()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.traces[id]
+	if !ok {
+		return
+	}
+	if localRoot(s) {
+		delete(t.traces, id)
+		return
+	}
+	c.ended++
+}
+
+// dropped counts s as dropped by an export queue.
+func (t *traceTracker) dropped(s sdktrace.ReadOnlySpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.traces[s.SpanContext().TraceID()]; ok {
+		c.dropped++
+	}
+}
+
+// counts returns the counts of the trace of s, which has not ended as far
+// as the tracker knows.
+func (t *traceTracker) counts(s sdktrace.ReadOnlySpan) (traceCounts, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.traces[s.SpanContext().TraceID()]
+	if !ok {
+		return traceCounts{}, false
+	}
+	return *c, true
+}
+
+func (t *traceTracker) Shutdown(context.Context) error   { return nil }
+func (t *traceTracker) ForceFlush(context.Context) error { return nil }
+
+// completenessProcessor annotates ended local roots before they reach the
+// wrapped processor with the spans of their trace started, ended and
+// dropped in this process, and trace.local.complete, true when every span
+// started has ended and none was dropped.
+type completenessProcessor struct {
+	sdktrace.SpanProcessor
+	tracker *traceTracker
+}
+
+func (p completenessProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if localRoot(s) {
+		if c, ok := p.tracker.counts(s); ok {
+			// The root itself is counted as ended once every
+			// processor has seen it.
+			c.ended++
+			s = completenessSpan{ReadOnlySpan: s, counts: c}
+		}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+type completenessSpan struct {
+	sdktrace.ReadOnlySpan
+	counts traceCounts
+}
+
+func (s completenessSpan) Attributes() []attribute.KeyValue {
+	c := s.counts
+	return withAttrs(s.ReadOnlySpan.Attributes(),
+		attribute.Int("trace.local.spans.started", c.started),
+		attribute.Int("trace.local.spans.ended", c.ended),
+		attribute.Int("trace.local.spans.dropped", c.dropped),
+		attribute.Bool("trace.local.complete", c.ended == c.started && c.dropped == 0),
+	)
+}
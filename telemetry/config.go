@@ -0,0 +1,581 @@
+package telemetry
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"go-otel/internal/envconfig"
+)
+
+// EnvPreset names the environment variable selecting the options preset.
+const EnvPreset = "GO_OTEL_ENV"
+
+// Environment variables read by LoadEnv. The OTEL_ ones follow the
+// OpenTelemetry specification; the GO_OTEL_ ones are specific to this service.
+const (
+	envBSPMaxQueueSize       = "OTEL_BSP_MAX_QUEUE_SIZE"
+	envBSPMaxExportBatchSize = "OTEL_BSP_MAX_EXPORT_BATCH_SIZE"
+	envBSPScheduleDelay      = "OTEL_BSP_SCHEDULE_DELAY"
+	envBSPExportTimeout      = "OTEL_BSP_EXPORT_TIMEOUT"
+	envOTLPTimeout           = "OTEL_EXPORTER_OTLP_TIMEOUT"
+
+	envSDKDisabled     = "OTEL_SDK_DISABLED"
+	envTracesExporter  = "OTEL_TRACES_EXPORTER"
+	envMetricsExporter = "OTEL_METRICS_EXPORTER"
+	envLogsExporter    = "OTEL_LOGS_EXPORTER"
+
+	envOTLPMetricsInsecure             = "OTEL_EXPORTER_OTLP_METRICS_INSECURE"
+	envOTLPMetricsTemporality          = "OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE"
+	envOTLPMetricsHistogramAggregation = "OTEL_EXPORTER_OTLP_METRICS_DEFAULT_HISTOGRAM_AGGREGATION"
+
+	envOTLPRetryEnabled         = "GO_OTEL_OTLP_RETRY_ENABLED"
+	envOTLPRetryInitialInterval = "GO_OTEL_OTLP_RETRY_INITIAL_INTERVAL"
+	envOTLPRetryMaxInterval     = "GO_OTEL_OTLP_RETRY_MAX_INTERVAL"
+	envOTLPRetryMaxElapsedTime  = "GO_OTEL_OTLP_RETRY_MAX_ELAPSED_TIME"
+	envOTLPSpoolDir             = "GO_OTEL_OTLP_SPOOL_DIR"
+	envOTLPSpoolMaxBytes        = "GO_OTEL_OTLP_SPOOL_MAX_BYTES"
+
+	envExportPriorityRoutes  = "GO_OTEL_EXPORT_PRIORITY_ROUTES"
+	envExportPriorityReserve = "GO_OTEL_EXPORT_PRIORITY_RESERVE"
+
+	envBreakerFailureThreshold = "GO_OTEL_EXPORTER_BREAKER_FAILURE_THRESHOLD"
+	envBreakerOpenDuration     = "GO_OTEL_EXPORTER_BREAKER_OPEN_DURATION"
+
+	envScrapeInterval        = "GO_OTEL_METRICS_SCRAPE_INTERVAL"
+	envExponentialHistograms = "GO_OTEL_METRICS_EXPONENTIAL_HISTOGRAMS"
+	envPrometheusLegacyUnits = "GO_OTEL_PROMETHEUS_LEGACY_UNITS"
+	envNativeHistograms      = "GO_OTEL_PROMETHEUS_NATIVE_HISTOGRAMS"
+	envOpenMetrics           = "GO_OTEL_PROMETHEUS_OPENMETRICS"
+	envCreatedTimestamps     = "GO_OTEL_PROMETHEUS_CREATED_TIMESTAMPS"
+	envScrapeTargets         = "GO_OTEL_PROMETHEUS_SCRAPE_TARGETS"
+	envExemplars             = "GO_OTEL_PROMETHEUS_EXEMPLARS"
+	envExemplarsSlowerThan   = "GO_OTEL_PROMETHEUS_EXEMPLARS_SLOWER_THAN"
+	envExemplarsRatio        = "GO_OTEL_PROMETHEUS_EXEMPLARS_RATIO"
+	envPushgatewayURL        = "GO_OTEL_METRICS_PUSHGATEWAY_URL"
+	envRemoteWriteURL        = "GO_OTEL_METRICS_REMOTE_WRITE_URL"
+	envPushInterval          = "GO_OTEL_METRICS_PUSH_INTERVAL"
+	envPushJob               = "GO_OTEL_METRICS_PUSH_JOB"
+	envOTLPMetricsEndpoint   = "GO_OTEL_METRICS_OTLP_ENDPOINT"
+
+	envApdexThreshold       = "GO_OTEL_APDEX_THRESHOLD"
+	envApdexRouteThresholds = "GO_OTEL_APDEX_ROUTE_THRESHOLDS"
+	envApdexWindow          = "GO_OTEL_APDEX_WINDOW"
+
+	envSamplingPriorityHeaders = "GO_OTEL_SAMPLING_PRIORITY_HEADERS"
+
+	envServerTimingHeaders = "GO_OTEL_SERVER_TIMING_HEADERS"
+	envRequestStartHeaders = "GO_OTEL_REQUEST_START_HEADERS"
+
+	envResourceSchemaURL = "GO_OTEL_RESOURCE_SCHEMA_URL"
+
+	envMaxSpanDuration = "GO_OTEL_MAX_SPAN_DURATION"
+
+	envServerTiming   = "GO_OTEL_SERVER_TIMING"
+	envBudgetHeaders  = "GO_OTEL_BUDGET_HEADERS"
+	envTrustedProxies = "GO_OTEL_TRUSTED_PROXIES"
+
+	envRequestTimeout = "GO_OTEL_REQUEST_TIMEOUT"
+	envRouteTimeouts  = "GO_OTEL_ROUTE_TIMEOUTS"
+
+	envReadOnly = "GO_OTEL_READ_ONLY"
+	envJournal  = "GO_OTEL_ADMIN_JOURNAL"
+
+	envShadowURL     = "GO_OTEL_SHADOW_URL"
+	envShadowRatio   = "GO_OTEL_SHADOW_RATIO"
+	envShadowMethods = "GO_OTEL_SHADOW_METHODS"
+	envShadowTimeout = "GO_OTEL_SHADOW_TIMEOUT"
+
+	envRateLimit             = "GO_OTEL_RATE_LIMIT"
+	envRateLimitBurst        = "GO_OTEL_RATE_LIMIT_BURST"
+	envRateLimitKey          = "GO_OTEL_RATE_LIMIT_KEY"
+	envRateLimitHeader       = "GO_OTEL_RATE_LIMIT_HEADER"
+	envRateLimitKeysFile     = "GO_OTEL_RATE_LIMIT_KEYS_FILE"
+	envRateLimitRedis        = "GO_OTEL_RATE_LIMIT_REDIS_URL"
+	envRateLimitRedisTimeout = "GO_OTEL_RATE_LIMIT_REDIS_TIMEOUT"
+	envRateLimitReplicas     = "GO_OTEL_RATE_LIMIT_REPLICAS"
+
+	envTransientRetryAttempts    = "GO_OTEL_TRANSIENT_RETRY_MAX_ATTEMPTS"
+	envTransientRetryBackoff     = "GO_OTEL_TRANSIENT_RETRY_BACKOFF"
+	envTransientRetryBudgetRatio = "GO_OTEL_TRANSIENT_RETRY_BUDGET_RATIO"
+	envTransientRetryBudgetFloor = "GO_OTEL_TRANSIENT_RETRY_BUDGET_FLOOR"
+
+	envFaultRatio      = "GO_OTEL_FAULT_RATIO"
+	envFaultDelay      = "GO_OTEL_FAULT_DELAY"
+	envFaultStatus     = "GO_OTEL_FAULT_STATUS"
+	envFaultRoutes     = "GO_OTEL_FAULT_ROUTES"
+	envFaultAllTraffic = "GO_OTEL_FAULT_ALL_TRAFFIC"
+
+	envAuditRoutes      = "GO_OTEL_AUDIT_ROUTES"
+	envAuditMethods     = "GO_OTEL_AUDIT_METHODS"
+	envAuditActorHeader = "GO_OTEL_AUDIT_ACTOR_HEADER"
+
+	envConfigHash          = "GO_OTEL_CONFIG_HASH"
+	envConfigDriftInterval = "GO_OTEL_CONFIG_DRIFT_INTERVAL"
+
+	envMemorySampleInterval = "GO_OTEL_MEMORY_SAMPLE_INTERVAL"
+
+	envRelayListen    = "GO_OTEL_RELAY_LISTEN"
+	envRelayUpstream  = "GO_OTEL_RELAY_UPSTREAM"
+	envRelayInsecure  = "GO_OTEL_RELAY_INSECURE"
+	envRelayBatchSize = "GO_OTEL_RELAY_BATCH_SIZE"
+	envRelayInterval  = "GO_OTEL_RELAY_INTERVAL"
+
+	envSpanResourceAttributes = "GO_OTEL_SPAN_RESOURCE_ATTRIBUTES"
+	envPodLabelsFile          = "GO_OTEL_POD_LABELS_FILE"
+	envPodAnnotationsFile     = "GO_OTEL_POD_ANNOTATIONS_FILE"
+	envPodMetadataKeys        = "GO_OTEL_POD_METADATA_KEYS"
+	envPodMetadataInterval    = "GO_OTEL_POD_METADATA_INTERVAL"
+
+	envSpanAlertThresholds = "GO_OTEL_SPAN_ALERT_THRESHOLDS"
+	envSpanAlertWebhook    = "GO_OTEL_SPAN_ALERT_WEBHOOK_URL"
+
+	envHotRouteBaselineFile = "GO_OTEL_HOT_ROUTE_BASELINE_FILE"
+	envHotRouteRatio        = "GO_OTEL_HOT_ROUTE_SAMPLE_RATIO"
+	envHotRouteThreshold    = "GO_OTEL_HOT_ROUTE_THRESHOLD"
+	envHotRouteWindow       = "GO_OTEL_HOT_ROUTE_WINDOW"
+
+	envReloadFile     = "GO_OTEL_RELOAD_FILE"
+	envReloadInterval = "GO_OTEL_RELOAD_INTERVAL"
+
+	envOTLPHeadersFile = "GO_OTEL_OTLP_HEADERS_FILE"
+	envOTLPCAFile      = "GO_OTEL_OTLP_CA_FILE"
+	envOTLPCertFile    = "GO_OTEL_OTLP_CERT_FILE"
+	envOTLPKeyFile     = "GO_OTEL_OTLP_KEY_FILE"
+
+	envArchiveDir     = "GO_OTEL_ARCHIVE_DIR"
+	envArchiveRatio   = "GO_OTEL_ARCHIVE_RATIO"
+	envArchiveURL     = "GO_OTEL_ARCHIVE_URL"
+	envArchiveHeaders = "GO_OTEL_ARCHIVE_HEADERS"
+	envCaptureDir     = "GO_OTEL_CAPTURE_DIR"
+
+	envTaxonomyFile = "GO_OTEL_TAXONOMY_FILE"
+
+	envUntracedRoutes  = "GO_OTEL_UNTRACED_ROUTES"
+	envUnmeteredRoutes = "GO_OTEL_UNMETERED_ROUTES"
+
+	envAnomalyEnabled   = "GO_OTEL_ANOMALY_ENABLED"
+	envAnomalyInterval  = "GO_OTEL_ANOMALY_INTERVAL"
+	envAnomalyThreshold = "GO_OTEL_ANOMALY_THRESHOLD"
+
+	envLogFormat     = "GO_OTEL_LOG_FORMAT"
+	envLogTimeFormat = "GO_OTEL_LOG_TIME_FORMAT"
+	envLogCaller     = "GO_OTEL_LOG_CALLER"
+	envLogLevel      = "GO_OTEL_LOG_LEVEL"
+)
+
+// LoadEnv overrides opts with any values set in the environment.
+func (o *Options) LoadEnv() error {
+	var batch BatchOptions
+	var timeout time.Duration
+	var retry RetryOptions
+	var retrySet, retryEnabledSet bool
+	var spool SpoolOptions
+	var breaker BreakerOptions
+	var breakerSet bool
+	var legacyUnits, legacyUnitsSet, exponential bool
+	var pushgatewayURL, remoteWriteURL, pushJob string
+	var nativeHistograms []string
+	var openMetrics, openMetricsSet, created, createdSet bool
+	var scrapeTargets map[string]string
+	var exemplars ExemplarOptions
+	var exemplarsSet, exemplarsOff bool
+	var pushInterval time.Duration
+	var otlpMetricsEndpoint string
+	var otlpMetricsInsecure bool
+	var temporality Temporality
+	var histogramAggregation string
+	var alertThresholds map[string]time.Duration
+	var alertWebhook string
+	var archiveURL string
+	var archiveHeaders map[string]string
+	var sdkDisabled bool
+	var tracesExporters, metricsExporters, logsExporters []string
+
+	err := envconfig.Load([]envconfig.Var{
+		{Name: envSDKDisabled, Set: envconfig.Bool(&sdkDisabled)},
+		{Name: envTracesExporter, Set: envconfig.List(&tracesExporters)},
+		{Name: envMetricsExporter, Set: envconfig.List(&metricsExporters)},
+		{Name: envLogsExporter, Set: envconfig.List(&logsExporters)},
+		{Name: envBSPMaxQueueSize, Set: envconfig.Int(&batch.MaxQueueSize)},
+		{Name: envBSPMaxExportBatchSize, Set: envconfig.Int(&batch.MaxExportBatchSize)},
+		{Name: envBSPScheduleDelay, Set: envconfig.Millis(&batch.ScheduleDelay)},
+		{Name: envBSPExportTimeout, Set: envconfig.Millis(&batch.ExportTimeout)},
+		{Name: envOTLPTimeout, Set: envconfig.Millis(&timeout)},
+		{Name: envOTLPRetryEnabled, Set: envconfig.Flagged(&retryEnabledSet, envconfig.Bool(&retry.Enabled))},
+		{Name: envOTLPRetryInitialInterval, Set: envconfig.Flagged(&retrySet, envconfig.Duration(&retry.InitialInterval))},
+		{Name: envOTLPRetryMaxInterval, Set: envconfig.Flagged(&retrySet, envconfig.Duration(&retry.MaxInterval))},
+		{Name: envOTLPRetryMaxElapsedTime, Set: envconfig.Flagged(&retrySet, envconfig.Duration(&retry.MaxElapsedTime))},
+		{Name: envOTLPSpoolDir, Set: envconfig.String(&spool.Dir)},
+		{Name: envOTLPSpoolMaxBytes, Set: envconfig.Int64(&spool.MaxBytes)},
+		{Name: envExportPriorityRoutes, Set: envconfig.List(&o.ExportPriority.Routes)},
+		{Name: envExportPriorityReserve, Set: envconfig.Float(&o.ExportPriority.Reserve)},
+		{Name: envBreakerFailureThreshold, Set: envconfig.Flagged(&breakerSet, envconfig.Int(&breaker.FailureThreshold))},
+		{Name: envBreakerOpenDuration, Set: envconfig.Duration(&breaker.OpenDuration)},
+		{Name: envScrapeInterval, Set: envconfig.Duration(&o.ScrapeInterval)},
+		{Name: envMaxSpanDuration, Set: envconfig.Duration(&o.MaxSpanDuration)},
+		{Name: envExponentialHistograms, Set: envconfig.Bool(&exponential)},
+		{Name: envPrometheusLegacyUnits, Set: envconfig.Flagged(&legacyUnitsSet, envconfig.Bool(&legacyUnits))},
+		{Name: envNativeHistograms, Set: envconfig.List(&nativeHistograms)},
+		{Name: envOpenMetrics, Set: envconfig.Flagged(&openMetricsSet, envconfig.Bool(&openMetrics))},
+		{Name: envCreatedTimestamps, Set: envconfig.Flagged(&createdSet, envconfig.Bool(&created))},
+		{Name: envScrapeTargets, Set: envconfig.Map(&scrapeTargets)},
+		{Name: envExemplars, Set: envconfig.Flagged(&exemplarsSet, func(s string) error {
+			s = strings.ToLower(s)
+			exemplarsOff = s == "off"
+			exemplars.Policy = ExemplarPolicy(s)
+			return nil
+		})},
+		{Name: envExemplarsSlowerThan, Set: envconfig.Flagged(&exemplarsSet, envconfig.Duration(&exemplars.SlowerThan))},
+		{Name: envExemplarsRatio, Set: envconfig.Flagged(&exemplarsSet, envconfig.Float(&exemplars.Ratio))},
+		{Name: envPushgatewayURL, Set: envconfig.String(&pushgatewayURL)},
+		{Name: envRemoteWriteURL, Set: envconfig.String(&remoteWriteURL)},
+		{Name: envPushInterval, Set: envconfig.Duration(&pushInterval)},
+		{Name: envPushJob, Set: envconfig.String(&pushJob)},
+		{Name: envOTLPMetricsEndpoint, Set: envconfig.String(&otlpMetricsEndpoint)},
+		{Name: envOTLPMetricsInsecure, Set: envconfig.Bool(&otlpMetricsInsecure)},
+		{Name: envOTLPMetricsTemporality, Set: func(s string) error {
+			temporality = Temporality(strings.ToLower(s))
+			return nil
+		}},
+		{Name: envOTLPMetricsHistogramAggregation, Set: func(s string) error {
+			switch s = strings.ToLower(s); s {
+			case "explicit_bucket_histogram", "base2_exponential_bucket_histogram":
+				histogramAggregation = s
+				return nil
+			default:
+				return fmt.Errorf("unknown histogram aggregation %q", s)
+			}
+		}},
+		{Name: envApdexThreshold, Set: envconfig.Duration(&o.Apdex.Threshold)},
+		{Name: envApdexRouteThresholds, Set: envconfig.DurationMap(&o.Apdex.RouteThresholds)},
+		{Name: envApdexWindow, Set: envconfig.Duration(&o.Apdex.Window)},
+		{Name: envSamplingPriorityHeaders, Set: envconfig.List(&o.SamplingPriority.Headers)},
+		{Name: envServerTimingHeaders, Set: envconfig.List(&o.EdgeTiming.ServerTimingHeaders)},
+		{Name: envRequestStartHeaders, Set: envconfig.List(&o.EdgeTiming.RequestStartHeaders)},
+		{Name: envResourceSchemaURL, Set: envconfig.String(&o.ResourceSchemaURL)},
+		{Name: envServerTiming, Set: envconfig.Bool(&o.ServerTiming)},
+		{Name: envBudgetHeaders, Set: envconfig.Bool(&o.BudgetHeaders)},
+		{Name: envTrustedProxies, Set: envconfig.List(&o.TrustedProxies)},
+		{Name: envRequestTimeout, Set: envconfig.Duration(&o.Timeout.Default)},
+		{Name: envRouteTimeouts, Set: envconfig.DurationMap(&o.Timeout.Routes)},
+		{Name: envReadOnly, Set: envconfig.Bool(&o.ReadOnly)},
+		{Name: envJournal, Set: envconfig.String(&o.Journal)},
+		{Name: envShadowURL, Set: envconfig.String(&o.Shadow.URL)},
+		{Name: envShadowRatio, Set: envconfig.Float(&o.Shadow.Ratio)},
+		{Name: envShadowMethods, Set: envconfig.List(&o.Shadow.Methods)},
+		{Name: envShadowTimeout, Set: envconfig.Duration(&o.Shadow.Timeout)},
+		{Name: envRateLimit, Set: envconfig.Float(&o.RateLimit.Rate)},
+		{Name: envRateLimitBurst, Set: envconfig.Int(&o.RateLimit.Burst)},
+		{Name: envRateLimitKey, Set: envconfig.String(&o.RateLimit.Key)},
+		{Name: envRateLimitHeader, Set: envconfig.String(&o.RateLimit.Header)},
+		{Name: envRateLimitKeysFile, Set: envconfig.String(&o.RateLimit.KeysFile)},
+		{Name: envRateLimitRedis, Set: envconfig.String(&o.RateLimit.RedisURL)},
+		{Name: envRateLimitRedisTimeout, Set: envconfig.Duration(&o.RateLimit.RedisTimeout)},
+		{Name: envRateLimitReplicas, Set: envconfig.Int(&o.RateLimit.Replicas)},
+		{Name: envTransientRetryAttempts, Set: envconfig.Int(&o.TransientRetry.MaxAttempts)},
+		{Name: envTransientRetryBackoff, Set: envconfig.Duration(&o.TransientRetry.Backoff)},
+		{Name: envTransientRetryBudgetRatio, Set: envconfig.Float(&o.TransientRetry.BudgetRatio)},
+		{Name: envTransientRetryBudgetFloor, Set: envconfig.Float(&o.TransientRetry.BudgetFloor)},
+		{Name: envFaultRatio, Set: envconfig.Float(&o.Faults.Ratio)},
+		{Name: envFaultDelay, Set: envconfig.Duration(&o.Faults.Delay)},
+		{Name: envFaultStatus, Set: envconfig.Int(&o.Faults.Status)},
+		{Name: envFaultRoutes, Set: envconfig.List(&o.Faults.Routes)},
+		{Name: envFaultAllTraffic, Set: envconfig.Bool(&o.Faults.AllTraffic)},
+		{Name: envAuditRoutes, Set: envconfig.List(&o.Audit.Routes)},
+		{Name: envAuditMethods, Set: envconfig.List(&o.Audit.Methods)},
+		{Name: envAuditActorHeader, Set: envconfig.String(&o.Audit.ActorHeader)},
+		{Name: envConfigHash, Set: envconfig.String(&o.Drift.ExpectedHash)},
+		{Name: envConfigDriftInterval, Set: envconfig.Duration(&o.Drift.Interval)},
+		{Name: envMemorySampleInterval, Set: envconfig.Duration(&o.Memory.Interval)},
+		{Name: envRelayListen, Set: envconfig.String(&o.Relay.Listen)},
+		{Name: envRelayUpstream, Set: envconfig.String(&o.Relay.Upstream)},
+		{Name: envRelayInsecure, Set: envconfig.Bool(&o.Relay.Insecure)},
+		{Name: envRelayBatchSize, Set: envconfig.Int(&o.Relay.BatchSize)},
+		{Name: envRelayInterval, Set: envconfig.Duration(&o.Relay.Interval)},
+		{Name: envSpanResourceAttributes, Set: envconfig.List(&o.SpanResourceAttributes)},
+		{Name: envPodLabelsFile, Set: envconfig.String(&o.PodMetadata.LabelsPath)},
+		{Name: envPodAnnotationsFile, Set: envconfig.String(&o.PodMetadata.AnnotationsPath)},
+		{Name: envPodMetadataKeys, Set: envconfig.Map(&o.PodMetadata.Keys)},
+		{Name: envPodMetadataInterval, Set: envconfig.Duration(&o.PodMetadata.Interval)},
+		{Name: envSpanAlertThresholds, Set: envconfig.DurationMap(&alertThresholds)},
+		{Name: envSpanAlertWebhook, Set: envconfig.String(&alertWebhook)},
+		{Name: envHotRouteBaselineFile, Set: envconfig.String(&o.HotRoutes.BaselineFile)},
+		{Name: envHotRouteRatio, Set: envconfig.Float(&o.HotRoutes.Ratio)},
+		{Name: envHotRouteThreshold, Set: envconfig.Float(&o.HotRoutes.Threshold)},
+		{Name: envHotRouteWindow, Set: envconfig.Duration(&o.HotRoutes.Window)},
+		{Name: envReloadFile, Set: envconfig.String(&o.Reload.Path)},
+		{Name: envOTLPHeadersFile, Set: envconfig.String(&o.Credentials.HeadersFile)},
+		{Name: envOTLPCAFile, Set: envconfig.String(&o.Credentials.CAFile)},
+		{Name: envOTLPCertFile, Set: envconfig.String(&o.Credentials.CertFile)},
+		{Name: envOTLPKeyFile, Set: envconfig.String(&o.Credentials.KeyFile)},
+		{Name: envReloadInterval, Set: envconfig.Duration(&o.Reload.Interval)},
+		{Name: envArchiveDir, Set: func(s string) error {
+			o.Archive.Store = DirStore(s)
+			return nil
+		}},
+		{Name: envArchiveRatio, Set: envconfig.Float(&o.Archive.Ratio)},
+		{Name: envArchiveURL, Set: envconfig.String(&archiveURL)},
+		{Name: envArchiveHeaders, Set: func(s string) (err error) {
+			archiveHeaders, err = parseHeaders(s)
+			return err
+		}},
+		{Name: envCaptureDir, Set: envconfig.String(&o.CaptureDir)},
+		{Name: envTaxonomyFile, Set: func(s string) (err error) {
+			o.Taxonomy, err = readTaxonomyFile(s)
+			return err
+		}},
+		{Name: envUntracedRoutes, Set: envconfig.List(&o.Routes.Untraced)},
+		{Name: envUnmeteredRoutes, Set: envconfig.List(&o.Routes.Unmetered)},
+		{Name: envAnomalyEnabled, Set: envconfig.Bool(&o.Anomaly.Enabled)},
+		{Name: envAnomalyInterval, Set: envconfig.Duration(&o.Anomaly.Interval)},
+		{Name: envAnomalyThreshold, Set: envconfig.Float(&o.Anomaly.Threshold)},
+		{Name: envLogFormat, Set: envconfig.String((*string)(&o.Log.Format))},
+		{Name: envLogTimeFormat, Set: envconfig.String(&o.Log.TimeFormat)},
+		{Name: envLogCaller, Set: envconfig.Bool(&o.Log.Caller)},
+		{Name: envLogLevel, Set: func(s string) (err error) {
+			o.Log.Level, err = zerolog.ParseLevel(s)
+			return err
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if archiveURL != "" {
+		header := make(http.Header, len(archiveHeaders))
+		for name, value := range archiveHeaders {
+			header.Set(name, value)
+		}
+		o.Archive.Store = HTTPStore{URL: archiveURL, Header: header}
+	}
+
+	// Sorted, so the hooks keep their order between runs.
+	routes := make([]string, 0, len(alertThresholds))
+	for route := range alertThresholds {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		actions := []SpanAction{CountAlert(), LogAlert()}
+		if alertWebhook != "" {
+			actions = append(actions, PostWebhook(alertWebhook, 0))
+		}
+		o.SpanHooks = append(o.SpanHooks, SpanHook{
+			Name:    "slow " + route,
+			Match:   AllSpans(SpanOnRoute(route), SpanSlowerThan(alertThresholds[route])),
+			Actions: actions,
+		})
+	}
+
+	// Pushing is added alongside the preset exporters, so the scrape
+	// endpoint keeps working.
+	if pushgatewayURL != "" {
+		o.MetricExporters = append(o.MetricExporters, MetricExporterOptions{
+			Kind: MetricExporterPushgateway, URL: pushgatewayURL, Job: pushJob, Interval: pushInterval,
+			Breaker: DefaultBreakerOptions(),
+		})
+	}
+	if remoteWriteURL != "" {
+		o.MetricExporters = append(o.MetricExporters, MetricExporterOptions{
+			Kind: MetricExporterRemoteWrite, URL: remoteWriteURL, Job: pushJob, Interval: pushInterval,
+			Breaker: DefaultBreakerOptions(),
+		})
+	}
+	if otlpMetricsEndpoint != "" {
+		o.MetricExporters = append(o.MetricExporters, MetricExporterOptions{
+			Kind: MetricExporterOTLP, Endpoint: otlpMetricsEndpoint, Insecure: otlpMetricsInsecure,
+			Interval: pushInterval,
+		})
+	}
+
+	for i := range o.MetricExporters {
+		eo := &o.MetricExporters[i]
+		switch eo.Kind {
+		case MetricExporterOTLP:
+			if timeout > 0 {
+				eo.Timeout = timeout
+			}
+			if temporality != "" {
+				eo.Temporality = temporality
+			}
+			switch histogramAggregation {
+			case "explicit_bucket_histogram":
+				eo.ExponentialHistograms = nil
+				continue
+			case "base2_exponential_bucket_histogram":
+				if eo.ExponentialHistograms == nil {
+					eo.ExponentialHistograms = &ExponentialHistogramOptions{}
+				}
+				continue
+			}
+		case MetricExporterPrometheus, MetricExporterPushgateway, MetricExporterRemoteWrite:
+			if legacyUnitsSet {
+				eo.LegacyUnits = legacyUnits
+			}
+			if eo.Kind != MetricExporterPrometheus {
+				eo.Breaker = mergeBreaker(eo.Breaker, breaker, breakerSet)
+				continue
+			}
+			if nativeHistograms != nil {
+				eo.NativeHistograms = nativeHistograms
+			}
+			if openMetricsSet {
+				eo.OpenMetrics = openMetrics
+			}
+			if createdSet {
+				eo.CreatedTimestamps = created
+			}
+			if scrapeTargets != nil {
+				eo.ScrapeTargets = targetsOf(scrapeTargets)
+			}
+			switch {
+			case exemplarsOff:
+				eo.Exemplars = nil
+			case exemplarsSet:
+				eo.Exemplars = mergeExemplars(eo.Exemplars, exemplars)
+			}
+			continue
+		}
+		if exponential && eo.ExponentialHistograms == nil {
+			eo.ExponentialHistograms = &ExponentialHistogramOptions{}
+		}
+	}
+
+	for i := range o.TraceExporters {
+		eo := &o.TraceExporters[i]
+		eo.Batch = mergeBatch(eo.Batch, batch)
+		if eo.Kind != ExporterOTLP {
+			continue
+		}
+		if timeout > 0 {
+			eo.Timeout = timeout
+		}
+		if retrySet || retryEnabledSet {
+			eo.Retry = mergeRetry(eo.Retry, retry, retryEnabledSet)
+		}
+		if spool.Dir != "" {
+			// Exporters must not share a spool directory.
+			eo.Spool = &SpoolOptions{Dir: filepath.Join(spool.Dir, strconv.Itoa(i)), MaxBytes: spool.MaxBytes}
+		}
+		eo.Breaker = mergeBreaker(eo.Breaker, breaker, breakerSet)
+	}
+
+	// The switches of the specification win over everything above, so
+	// operators can silence a signal whatever the preset. Exporters other
+	// than none are configured by the options, so other values are ignored.
+	if sdkDisabled {
+		o.Disabled = true
+	}
+	if sdkDisabled || slices.Contains(tracesExporters, "none") {
+		o.TraceExporters = nil
+	}
+	if sdkDisabled || slices.Contains(metricsExporters, "none") {
+		o.MetricExporters = nil
+	}
+	if slices.Contains(logsExporters, "none") {
+		// Logs are written by zerolog, not exported, so there is no
+		// pipeline to turn off.
+		log.Warn().Msgf("%s=none is ignored: logs are written to the console, not exported", envLogsExporter)
+	}
+	return nil
+}
+
+// targetsOf returns the scrape targets of a name=url map, sorted by name.
+func targetsOf(m map[string]string) []ScrapeTarget {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	targets := make([]ScrapeTarget, 0, len(names))
+	for _, name := range names {
+		targets = append(targets, ScrapeTarget{Name: name, URL: m[name]})
+	}
+	return targets
+}
+
+// mergeExemplars overrides the exemplar options of an exporter, if any,
+// with those set in the environment.
+func mergeExemplars(o *ExemplarOptions, env ExemplarOptions) *ExemplarOptions {
+	var merged ExemplarOptions
+	if o != nil {
+		merged = *o
+	}
+	if env.Policy != "" {
+		merged.Policy = env.Policy
+	}
+	if env.SlowerThan != 0 {
+		merged.SlowerThan = env.SlowerThan
+	}
+	if env.Ratio != 0 {
+		merged.Ratio = env.Ratio
+	}
+	return &merged
+}
+
+func mergeBatch(b, env BatchOptions) BatchOptions {
+	if env.MaxQueueSize > 0 {
+		b.MaxQueueSize = env.MaxQueueSize
+	}
+	if env.MaxExportBatchSize > 0 {
+		b.MaxExportBatchSize = env.MaxExportBatchSize
+	}
+	if env.ScheduleDelay > 0 {
+		b.ScheduleDelay = env.ScheduleDelay
+	}
+	if env.ExportTimeout > 0 {
+		b.ExportTimeout = env.ExportTimeout
+	}
+	return b
+}
+
+func mergeRetry(r *RetryOptions, env RetryOptions, enabledSet bool) *RetryOptions {
+	merged := RetryOptions{Enabled: true}
+	if r != nil {
+		merged = *r
+	}
+	if enabledSet {
+		merged.Enabled = env.Enabled
+	}
+	if env.InitialInterval > 0 {
+		merged.InitialInterval = env.InitialInterval
+	}
+	if env.MaxInterval > 0 {
+		merged.MaxInterval = env.MaxInterval
+	}
+	if env.MaxElapsedTime > 0 {
+		merged.MaxElapsedTime = env.MaxElapsedTime
+	}
+	return &merged
+}
+
+// mergeBreaker overrides b with the breaker options set in the environment.
+// A failure threshold of zero disables the breaker.
+func mergeBreaker(b *BreakerOptions, env BreakerOptions, thresholdSet bool) *BreakerOptions {
+	if thresholdSet && env.FailureThreshold == 0 {
+		return nil
+	}
+	if !thresholdSet && env.OpenDuration == 0 {
+		return b
+	}
+	merged := *DefaultBreakerOptions()
+	if b != nil {
+		merged = *b
+	}
+	if env.FailureThreshold > 0 {
+		merged.FailureThreshold = env.FailureThreshold
+	}
+	if env.OpenDuration > 0 {
+		merged.OpenDuration = env.OpenDuration
+	}
+	return &merged
+}
@@ -0,0 +1,94 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// configConstraints are the checks of ValidateConfig a schema can express,
+// by property path, array items marked with [].
+var configConstraints = map[string]map[string]any{
+	"sample_ratio": {"minimum": 0, "maximum": 1},
+	"log_level": {"enum": []string{
+		zerolog.TraceLevel.String(), zerolog.DebugLevel.String(), zerolog.InfoLevel.String(),
+		zerolog.WarnLevel.String(), zerolog.ErrorLevel.String(), zerolog.FatalLevel.String(),
+		zerolog.PanicLevel.String(), zerolog.Disabled.String(),
+	}},
+	"taxonomy[]":       {"required": []string{"tag", "value"}},
+	"taxonomy[].tag":   {"pattern": tagNamePattern.String()},
+	"taxonomy[].value": {"minLength": 1},
+}
+
+// ConfigSchema returns the JSON Schema of the reload file, generated from
+// ReloadableConfig so the two cannot drift apart. Checks a schema cannot
+// express, such as the syntax of globs and regular expressions, are left
+// to ValidateConfig.
+func ConfigSchema() ([]byte, error) {
+	s := schemaOf(reflect.TypeOf(ReloadableConfig{}), "")
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "go-otel reload file"
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// schemaOf returns the schema of values of t, at property path p.
+func schemaOf(t reflect.Type, p string) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	s := map[string]any{}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := jsonName(f)
+			if name == "-" {
+				continue
+			}
+			fp := name
+			if p != "" {
+				fp = p + "." + name
+			}
+			props[name] = schemaOf(f.Type, fp)
+		}
+		s["type"] = "object"
+		s["properties"] = props
+		s["additionalProperties"] = false
+	case reflect.Slice, reflect.Array:
+		s["type"] = "array"
+		s["items"] = schemaOf(t.Elem(), p+"[]")
+	case reflect.Map:
+		s["type"] = "object"
+		s["additionalProperties"] = schemaOf(t.Elem(), p+"[]")
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		s["type"] = "number"
+	}
+	for k, v := range configConstraints[p] {
+		s[k] = v
+	}
+	return s
+}
+
+// jsonName returns the name of f in the file. Fields without a tag are
+// named in lower case, as documented; encoding/json matches them
+// regardless of case.
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	return name
+}
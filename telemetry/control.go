@@ -0,0 +1,184 @@
+package telemetry
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ratioSampler pairs a sampler with the ratio it was built from.
+type ratioSampler struct {
+	trace.Sampler
+	ratio float64
+}
+
+// dynamicSampler is a sampler whose ratio can be swapped while spans are
+// being started.
+type dynamicSampler struct {
+	current atomic.Pointer[ratioSampler]
+	hot     *hotRoutes
+	// verbose samples every trace while the verbose tracing window is
+	// open, whatever the ratio and the parent decide.
+	verbose atomic.Bool
+}
+
+func newDynamicSampler(ratio float64, hot *hotRoutes) *dynamicSampler {
+	s := &dynamicSampler{hot: hot}
+	s.set(ratio)
+	return s
+}
+
+func (s *dynamicSampler) set(ratio float64) {
+	s.current.Store(&ratioSampler{Sampler: newSampler(ratio, s.hot), ratio: ratio})
+}
+
+func (s *dynamicSampler) ratio() float64 {
+	return s.current.Load().ratio
+}
+
+func (s *dynamicSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	if s.verbose.Load() {
+		return trace.AlwaysSample().ShouldSample(p)
+	}
+	return s.current.Load().ShouldSample(p)
+}
+
+func (s *dynamicSampler) Description() string {
+	return s.current.Load().Description()
+}
+
+// SampleRatio returns the fraction of new traces currently sampled.
+func (t *Telemetry) SampleRatio() float64 {
+	return t.sampler.ratio()
+}
+
+// SetSampleRatio changes the fraction of new traces sampled. Spans already
+// started keep their decision.
+func (t *Telemetry) SetSampleRatio(ratio float64) error {
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1, got %g", ratio)
+	}
+	t.sampler.set(ratio)
+	return nil
+}
+
+// LogLevel returns the minimum level of logged events.
+func (t *Telemetry) LogLevel() zerolog.Level {
+	return zerolog.GlobalLevel()
+}
+
+// SetLogLevel changes the minimum level of logged events.
+func (t *Telemetry) SetLogLevel(level zerolog.Level) {
+	zerolog.SetGlobalLevel(level)
+}
+
+// Control is the runtime tunable state served by ControlHandler. Fields
+// left out of an update are unchanged.
+type Control struct {
+	SampleRatio *float64 `json:"sample_ratio,omitempty"`
+	LogLevel    *string  `json:"log_level,omitempty"`
+	ReadOnly    *bool    `json:"read_only,omitempty"`
+}
+
+func (t *Telemetry) control() Control {
+	ratio, level, readOnly := t.SampleRatio(), t.LogLevel().String(), t.ReadOnly()
+	return Control{SampleRatio: &ratio, LogLevel: &level, ReadOnly: &readOnly}
+}
+
+// ControlHandler serves the sample ratio, log level and read-only mode as
+// JSON on GET, and applies a partial Control on PUT or PATCH, so operators
+// can turn up tracing or logging, or stop writes, without a restart.
+func (t *Telemetry) ControlHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut, http.MethodPatch:
+			var c Control
+			if err := render.DecodeJSON(r.Body, &c); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := c.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := t.journal.record(r, JournalEntry{Action: journalControl, Control: &c}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := t.applyControl(c); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, PATCH")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		render.JSON(w, r, t.control())
+	})
+}
+
+// FlushHandler exports the telemetry recorded so far on POST, e.g. before
+// taking an instance out of service.
+func (t *Telemetry) FlushHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := t.journal.record(r, JournalEntry{Action: journalFlush}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := t.ForceFlush(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (c Control) validate() error {
+	if c.LogLevel != nil {
+		if _, err := zerolog.ParseLevel(*c.LogLevel); err != nil {
+			return err
+		}
+	}
+	if c.SampleRatio != nil && (*c.SampleRatio < 0 || *c.SampleRatio > 1) {
+		return fmt.Errorf("sample ratio must be between 0 and 1, got %g", *c.SampleRatio)
+	}
+	return nil
+}
+
+// applyControl validates every field of c before changing anything.
+func (t *Telemetry) applyControl(c Control) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if c.SampleRatio != nil {
+		// Validated above.
+		_ = t.SetSampleRatio(*c.SampleRatio)
+	}
+	if c.LogLevel != nil {
+		level, _ := zerolog.ParseLevel(*c.LogLevel)
+		t.SetLogLevel(level)
+	}
+	if c.ReadOnly != nil {
+		t.SetReadOnly(*c.ReadOnly)
+	}
+
+	// Warn so the change is recorded even when the level was just raised.
+	log.Warn().
+		Float64("sample_ratio", t.SampleRatio()).
+		Str("log_level", t.LogLevel().String()).
+		Bool("read_only", t.ReadOnly()).
+		Msg("runtime telemetry settings changed")
+	return nil
+}
@@ -0,0 +1,300 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/credentials"
+
+	"go-otel/internal/principal"
+)
+
+// CredentialsOptions points the OTLP exporters and the relay at their
+// credentials, files such as a mounted Kubernetes secret, so they can be
+// rotated with RotateCredentials without a restart.
+type CredentialsOptions struct {
+	// HeadersFile holds the headers sent with every export, e.g. an API
+	// key, as key=value pairs separated by commas or newlines, values
+	// percent-encoded like OTEL_EXPORTER_OTLP_HEADERS.
+	HeadersFile string
+	// CAFile is the PEM bundle of the CAs the receiver is verified
+	// against. Empty uses the system roots.
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key, for
+	// receivers requiring mutual TLS.
+	CertFile string
+	KeyFile  string
+}
+
+func (o CredentialsOptions) validate() error {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return errors.New("exporter client certificate and key files must be set together")
+	}
+	return nil
+}
+
+// exporterCredentials is the material read from CredentialsOptions.
+type exporterCredentials struct {
+	headers map[string]string
+	tls     credentials.TransportCredentials
+	// certExpiry is when the client certificate expires, if any.
+	certExpiry time.Time
+}
+
+// loadCredentials reads the files of o.
+func loadCredentials(o CredentialsOptions) (*exporterCredentials, error) {
+	c := &exporterCredentials{headers: map[string]string{}}
+	if o.HeadersFile != "" {
+		b, err := os.ReadFile(o.HeadersFile)
+		if err != nil {
+			return nil, fmt.Errorf("exporter headers: %w", err)
+		}
+		if c.headers, err = parseHeaders(string(b)); err != nil {
+			return nil, fmt.Errorf("exporter headers %s: %w", o.HeadersFile, err)
+		}
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CAFile != "" {
+		b, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("exporter CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("exporter CA %s: no PEM certificate", o.CAFile)
+		}
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("exporter client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			c.certExpiry = leaf.NotAfter
+		}
+	}
+	c.tls = credentials.NewTLS(cfg)
+	return c, nil
+}
+
+// parseHeaders parses key=value pairs separated by commas or newlines.
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" || strings.HasPrefix(pair, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		v, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+		headers[strings.ToLower(key)] = v
+	}
+	return headers, nil
+}
+
+// credentialStore hands the current credentials to the OTLP connections.
+// Headers are read for every export, so new ones apply at once; TLS
+// material applies to new connections, so rotating closes the connections
+// established with the previous one and gRPC dials again.
+type credentialStore struct {
+	opts    CredentialsOptions
+	current atomic.Pointer[exporterCredentials]
+
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+// exporterCreds are the credentials of the connections dialed by dialOTLP,
+// set up by Setup.
+var exporterCreds = newCredentialStore(CredentialsOptions{}, &exporterCredentials{
+	headers: map[string]string{},
+	tls:     credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12}),
+})
+
+func newCredentialStore(o CredentialsOptions, c *exporterCredentials) *credentialStore {
+	s := &credentialStore{opts: o, conns: make(map[*trackedConn]struct{})}
+	s.current.Store(c)
+	return s
+}
+
+// setExporterCredentials reads the credentials of o for the connections
+// dialed from now on.
+func setExporterCredentials(o CredentialsOptions) error {
+	c, err := loadCredentials(o)
+	if err != nil {
+		return err
+	}
+	exporterCreds = newCredentialStore(o, c)
+	return nil
+}
+
+// rotate reads the credentials again, keeping the current ones if they
+// cannot be, and closes the connections established with the previous
+// ones. It returns the number of connections closed.
+func (s *credentialStore) rotate() (*exporterCredentials, int, error) {
+	c, err := loadCredentials(s.opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.current.Store(c)
+	s.mu.Lock()
+	conns := make([]*trackedConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	return c, len(conns), nil
+}
+
+// transport returns the transport credentials of the OTLP connections.
+func (s *credentialStore) transport() credentials.TransportCredentials {
+	return rotatingTLS{store: s}
+}
+
+// GetRequestMetadata returns the current headers, for each export.
+func (s *credentialStore) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return s.current.Load().headers, nil
+}
+
+// RequireTransportSecurity is false, so insecure connections to local
+// collectors get the headers too.
+func (s *credentialStore) RequireTransportSecurity() bool {
+	return false
+}
+
+// rotatingTLS performs handshakes with the current TLS material of its
+// store, and tracks the connections, so rotating can close them.
+type rotatingTLS struct {
+	store *credentialStore
+}
+
+func (r rotatingTLS) ClientHandshake(ctx context.Context, authority string, raw net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := r.store.current.Load().tls.ClientHandshake(ctx, authority, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	tc := &trackedConn{Conn: conn, store: r.store}
+	r.store.mu.Lock()
+	r.store.conns[tc] = struct{}{}
+	r.store.mu.Unlock()
+	return tc, info, nil
+}
+
+func (r rotatingTLS) ServerHandshake(net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("exporter credentials are client credentials")
+}
+
+func (r rotatingTLS) Info() credentials.ProtocolInfo {
+	return r.store.current.Load().tls.Info()
+}
+
+func (r rotatingTLS) Clone() credentials.TransportCredentials {
+	return r
+}
+
+func (r rotatingTLS) OverrideServerName(string) error {
+	return errors.New("overriding the server name is not supported")
+}
+
+// trackedConn forgets itself in its store once closed.
+type trackedConn struct {
+	net.Conn
+	store *credentialStore
+	once  sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.store.mu.Lock()
+		delete(c.store.conns, c)
+		c.store.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// CredentialsRotation is the response of CredentialsHandler.
+type CredentialsRotation struct {
+	// Headers are the names of the headers now sent with exports.
+	Headers []string `json:"headers"`
+	// ClientCertExpiry is when the client certificate now presented
+	// expires, if there is one.
+	ClientCertExpiry *time.Time `json:"client_cert_expiry,omitempty"`
+	// ConnectionsClosed is the number of connections closed to be dialed
+	// again with the new material.
+	ConnectionsClosed int `json:"connections_closed"`
+}
+
+// RotateCredentials reads the exporter credentials of
+// Options.Credentials again and applies them to the OTLP exporters and
+// the relay without a restart. If they cannot be read, the current ones
+// are kept.
+func (t *Telemetry) RotateCredentials() (CredentialsRotation, error) {
+	c, closed, err := exporterCreds.rotate()
+	if err != nil {
+		return CredentialsRotation{}, err
+	}
+	rot := CredentialsRotation{Headers: make([]string, 0, len(c.headers)), ConnectionsClosed: closed}
+	for name := range c.headers {
+		rot.Headers = append(rot.Headers, name)
+	}
+	sort.Strings(rot.Headers)
+	if !c.certExpiry.IsZero() {
+		rot.ClientCertExpiry = &c.certExpiry
+	}
+	return rot, nil
+}
+
+// CredentialsHandler rotates the exporter credentials on POST, see
+// RotateCredentials, e.g. once the secret they are read from was updated.
+// Each rotation is an audit event, with outcome success or failure.
+func (t *Telemetry) CredentialsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := t.journal.record(r, JournalEntry{Action: journalRotateCredentials}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p, _ := principal.FromContext(r.Context())
+		e := &AuditEvent{Actor: p.Name, Action: "rotate exporter credentials", Resource: "otlp exporters"}
+		rot, err := t.RotateCredentials()
+		if err != nil {
+			e.Attributes = map[string]string{"error": err.Error()}
+			recordAudit(r.Context(), t.redactor, e, "failure")
+			log.Error().Err(err).Msg("failed to rotate exporter credentials, keeping the current ones")
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		recordAudit(r.Context(), t.redactor, e, "success")
+		log.Info().Strs("headers", rot.Headers).Int("connections_closed", rot.ConnectionsClosed).Msg("exporter credentials rotated")
+		render.JSON(w, r, rot)
+	})
+}
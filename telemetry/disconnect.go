@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrClientDisconnected is the cause of the cancellation of requests whose
+// client went away before the response was complete.
+var ErrClientDisconnected = errors.New("client disconnected")
+
+type disconnectKey struct{}
+
+// ClientDisconnected reports whether the client of the request of ctx went
+// away while it was being served. It is always false outside the
+// DetectDisconnects middleware.
+func ClientDisconnected(ctx context.Context) bool {
+	d, ok := ctx.Value(disconnectKey{}).(*atomic.Bool)
+	return ok && d.Load()
+}
+
+// DetectDisconnects returns middleware that notices clients going away
+// mid-request. The request context is then cancelled with
+// ErrClientDisconnected as its cause, so downstream work stops early, a
+// "client disconnected" event marks the moment on the request span and the
+// request is counted in http.server.request.aborted by route.
+//
+// Aborted requests are recorded with status 499 by HTTPMetrics and are not
+// errors for ClassifyErrors, whatever the handler answered to nobody. It
+// must run after the tracing middleware and before the others; deadlines
+// set before it are not kept.
+//
+// Disconnects are seen as soon as net/http sees them: for HTTP/1, once the
+// handler has read the request body. WebSocket and SSE connections, which
+// end when their client leaves, are not watched.
+func (t *Telemetry) DetectDisconnects() func(http.Handler) http.Handler {
+	aborted, _ := selfMeter().Int64Counter(
+		"http.server.request.aborted",
+		metric.WithDescription("Requests whose client disconnected before the response was complete, by route."),
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streamKind(r) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			parent := r.Context()
+			d := &atomic.Bool{}
+			// The cancellation of parent is passed on by hand, so the
+			// disconnect is flagged before the handler sees it.
+			ctx, cancel := context.WithCancelCause(
+				context.WithValue(context.WithoutCancel(parent), disconnectKey{}, d))
+			defer cancel(nil)
+			r = r.WithContext(ctx)
+
+			stop := context.AfterFunc(parent, func() {
+				// Other causes, such as a shutdown, are not aborts.
+				if cancellationCause(parent) != "client_disconnect" {
+					cancel(context.Cause(parent))
+					return
+				}
+				d.Store(true)
+				trace.SpanFromContext(parent).AddEvent("client disconnected")
+				cancel(ErrClientDisconnected)
+			})
+			defer stop()
+
+			next.ServeHTTP(w, r)
+
+			if d.Load() {
+				aborted.Add(context.WithoutCancel(ctx), 1, metric.WithAttributes(
+					attribute.String("http.route", routePattern(r))))
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"go-otel/internal/circuit"
+	"go-otel/internal/redis"
+)
+
+const (
+	// defaultRedisTimeout bounds a rate limit check against Redis, which
+	// every request waits for.
+	defaultRedisTimeout = 50 * time.Millisecond
+	// redisFailureThreshold failed checks in a row switch the limiter to
+	// local limits, for redisRetryAfter before Redis is tried again.
+	redisFailureThreshold = 3
+	redisRetryAfter       = 5 * time.Second
+)
+
+// tokenBucketScript takes a token from the bucket of KEYS[1], a hash of
+// its tokens and last refill in milliseconds of Redis time, refilling at
+// ARGV[1] per second up to ARGV[2]. It returns {allowed, wait in ms,
+// tokens left in thousandths}.
+// Redis time keeps the clocks of the replicas out of it.
+const tokenBucketScript = `
+if redis.replicate_commands then redis.replicate_commands() end
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(b[1]) or burst
+local last = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait, math.floor(tokens * 1000)}
+`
+
+// distributedLimiter keeps the token buckets of clients in Redis, so the
+// limit holds across replicas. While Redis is unreachable, it limits
+// clients locally to the share of the limit of the instance.
+type distributedLimiter struct {
+	client  *redis.Client
+	prefix  string
+	rate    string
+	burst   string
+	limit   rateDecision
+	timeout time.Duration
+	local   *limiter
+	breaker *circuit.Breaker
+
+	coordination metric.Float64Histogram
+	fallbacks    metric.Int64Counter
+}
+
+func newDistributedLimiter(opts RateLimitOptions, service string, local *limiter) (*distributedLimiter, error) {
+	client, err := redis.New(opts.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.RedisTimeout <= 0 {
+		opts.RedisTimeout = defaultRedisTimeout
+	}
+	d := &distributedLimiter{
+		client:  client,
+		prefix:  "go-otel:ratelimit:" + service + ":",
+		rate:    strconv.FormatFloat(opts.Rate, 'g', -1, 64),
+		burst:   strconv.Itoa(opts.Burst),
+		limit:   rateDecision{rate: opts.Rate, burst: float64(opts.Burst), mode: "distributed"},
+		timeout: opts.RedisTimeout,
+		local:   local,
+		breaker: &circuit.Breaker{Threshold: redisFailureThreshold, Cooldown: redisRetryAfter},
+	}
+	d.coordination, _ = selfMeter().Float64Histogram(
+		"rate_limit.coordination.duration",
+		metric.WithDescription("Duration of rate limit checks against Redis, by result."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1),
+	)
+	d.fallbacks, _ = selfMeter().Int64Counter(
+		"rate_limit.fallback",
+		metric.WithDescription("Rate limit checks made locally because Redis was unreachable."),
+	)
+	return d, nil
+}
+
+// allow takes a token from the bucket of key in Redis, or locally when
+// Redis is unreachable, and reports which of the two decided.
+func (d *distributedLimiter) allow(ctx context.Context, key string, now time.Time) rateDecision {
+	ok, t := d.breaker.Allow(now)
+	d.transitioned(t)
+	if !ok {
+		d.fallbacks.Add(ctx, 1)
+		return d.local.allow(key, now)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), d.timeout)
+	defer cancel()
+	start := time.Now()
+	decision, err := d.take(ctx, key)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	d.coordination.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("result", result)))
+	d.transitioned(d.breaker.Done(time.Now(), true, err != nil))
+	if err != nil {
+		log.Debug().Err(err).Msg("rate limit check against redis failed")
+		d.fallbacks.Add(ctx, 1)
+		return d.local.allow(key, now)
+	}
+	return decision
+}
+
+func (d *distributedLimiter) take(ctx context.Context, key string) (rateDecision, error) {
+	reply, err := d.client.Do(ctx, "EVAL", tokenBucketScript, "1", d.prefix+key, d.rate, d.burst)
+	if err != nil {
+		return rateDecision{}, err
+	}
+	values, ok := reply.([]any)
+	if !ok || len(values) != 3 {
+		return rateDecision{}, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+	allowed, ok1 := values[0].(int64)
+	wait, ok2 := values[1].(int64)
+	remaining, ok3 := values[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return rateDecision{}, errors.New("unexpected rate limit script reply types")
+	}
+	decision := d.limit
+	decision.allowed = allowed == 1
+	decision.wait = time.Duration(wait) * time.Millisecond
+	decision.remaining = float64(remaining) / 1000
+	return decision, nil
+}
+
+// transitioned logs the limiter switching between Redis and local limits.
+func (d *distributedLimiter) transitioned(t circuit.Transition) {
+	switch {
+	case !t.Changed():
+	case t.To == circuit.Open:
+		log.Warn().Str("redis", d.client.Addr()).Dur("retry_after", redisRetryAfter).Msg("redis unreachable, rate limiting locally")
+	case t.To == circuit.Closed:
+		log.Info().Str("redis", d.client.Addr()).Msg("rate limiting through redis again")
+	}
+}
+
+// localShare returns the limiter applying the share of opts of one of
+// replicas instances.
+func localShare(opts RateLimitOptions) *limiter {
+	replicas := float64(max(opts.Replicas, 1))
+	return &limiter{
+		rate:    opts.Rate / replicas,
+		burst:   math.Max(1, math.Ceil(float64(opts.Burst)/replicas)),
+		buckets: make(map[string]*bucket),
+	}
+}
@@ -0,0 +1,213 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// DriftOptions configures config drift detection.
+type DriftOptions struct {
+	// Interval is how often the effective config is hashed. Zero means
+	// one minute; negative disables detection.
+	Interval time.Duration
+	// ExpectedHash is the ConfigHash the deployment expects, e.g. from a
+	// pod annotation. Empty means the hash at startup.
+	ExpectedHash string
+}
+
+// ConfigHash returns the SHA-256 of the effective config, as served by
+// ConfigHandler.
+func (t *Telemetry) ConfigHash() string {
+	return hashConfig(flattenConfig(t.RuntimeConfig()))
+}
+
+// hashConfig hashes the sorted key=value lines of a flattened config.
+func hashConfig(flat map[string]string) string {
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, flat[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// flattenConfig turns rc into dotted keys, e.g. "trace_exporters.0.kind",
+// so two configs can be compared field by field.
+func flattenConfig(rc RuntimeConfig) map[string]string {
+	b, _ := json.Marshal(rc)
+	var v any
+	_ = json.Unmarshal(b, &v)
+	flat := make(map[string]string)
+	flatten(flat, "", v)
+	return flat
+}
+
+func flatten(flat map[string]string, prefix string, v any) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			flatten(flat, join(k), e)
+		}
+	case []any:
+		for i, e := range v {
+			flatten(flat, join(fmt.Sprint(i)), e)
+		}
+	default:
+		flat[prefix] = fmt.Sprint(v)
+	}
+}
+
+// driftDetector periodically compares the effective config with the
+// expected one, reporting drift in the config.drift gauge and logging the
+// fields that changed since startup.
+type driftDetector struct {
+	t        *Telemetry
+	expected string
+	baseline map[string]string
+
+	mu       sync.Mutex
+	checked  bool
+	drifted  bool
+	lastHash string
+
+	reg  metric.Registration
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func startDriftDetector(t *Telemetry, opts DriftOptions) (*driftDetector, error) {
+	if opts.Interval < 0 {
+		return nil, nil
+	}
+	if opts.Interval == 0 {
+		opts.Interval = time.Minute
+	}
+
+	d := &driftDetector{t: t, baseline: flattenConfig(t.RuntimeConfig()), done: make(chan struct{})}
+	d.lastHash = hashConfig(d.baseline)
+	d.expected = opts.ExpectedHash
+	if d.expected == "" {
+		d.expected = d.lastHash
+	}
+	log.Info().Str("config_hash", d.lastHash).Msg("effective telemetry config")
+
+	gauge, err := selfMeter().Int64ObservableGauge(
+		"config.drift",
+		metric.WithDescription("1 while the effective config differs from the expected one."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.reg, err = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		v := int64(0)
+		if d.drifted {
+			v = 1
+		}
+		o.ObserveInt64(gauge, v, metric.WithAttributes(attribute.String("config.hash", d.lastHash)))
+		return nil
+	}, gauge)
+	if err != nil {
+		return nil, err
+	}
+
+	d.check()
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stop = cancel
+	go d.run(ctx, opts.Interval)
+	return d, nil
+}
+
+func (d *driftDetector) run(ctx context.Context, interval time.Duration) {
+	defer close(d.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.check()
+		}
+	}
+}
+
+func (d *driftDetector) check() {
+	current := flattenConfig(d.t.RuntimeConfig())
+	hash := hashConfig(current)
+
+	d.mu.Lock()
+	wasDrifted := d.drifted
+	changed := hash != d.lastHash || !d.checked
+	d.checked = true
+	d.lastHash = hash
+	d.drifted = hash != d.expected
+	drifted := d.drifted
+	d.mu.Unlock()
+
+	switch {
+	case !changed:
+		return
+	case !drifted:
+		if wasDrifted {
+			log.Info().Str("config_hash", hash).Msg("config matches the expected hash again")
+		}
+		return
+	}
+	ev := log.Warn().Str("config_hash", hash).Str("expected_hash", d.expected)
+	if diff := diffConfig(d.baseline, current); len(diff) > 0 {
+		ev = ev.Strs("changed_since_startup", diff)
+	}
+	ev.Msg("config drift detected")
+}
+
+// diffConfig lists the keys whose values differ, as "key: old -> new".
+func diffConfig(before, after map[string]string) []string {
+	var diff []string
+	for k, v := range after {
+		if old, ok := before[k]; !ok || old != v {
+			diff = append(diff, fmt.Sprintf("%s: %q -> %q", k, old, v))
+		}
+	}
+	for k, old := range before {
+		if _, ok := after[k]; !ok {
+			diff = append(diff, fmt.Sprintf("%s: %q -> removed", k, old))
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func (d *driftDetector) shutdown(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.stop()
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return d.reg.Unregister()
+}
@@ -0,0 +1,124 @@
+package telemetry
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxEdgeTimings caps the attributes taken from one timing header, so a
+// misbehaving proxy cannot bloat every span.
+const maxEdgeTimings = 16
+
+// EdgeTimingOptions configures which upstream timing headers are recorded
+// on the request span.
+type EdgeTimingOptions struct {
+	// ServerTimingHeaders use the Server-Timing syntax, e.g.
+	// "cdn-cache;desc=HIT, edge;dur=12.5". Each metric with a duration
+	// becomes an edge.timing.<name>.dur_ms attribute.
+	ServerTimingHeaders []string
+	// RequestStartHeaders carry when a proxy received the request, as
+	// "t=<unix time>" in seconds, milliseconds or microseconds like NGINX's
+	// X-Request-Start. The time since then is recorded as
+	// edge.queue_time_ms.
+	RequestStartHeaders []string
+}
+
+// DefaultEdgeTimingOptions reads Server-Timing and the common request start
+// headers.
+func DefaultEdgeTimingOptions() EdgeTimingOptions {
+	return EdgeTimingOptions{
+		ServerTimingHeaders: []string{"Server-Timing"},
+		RequestStartHeaders: []string{"X-Request-Start", "X-Queue-Start"},
+	}
+}
+
+// EdgeTiming returns middleware adding upstream timing headers to the
+// request span, so traces show the time spent before the request reached
+// the service. It must run after the tracing middleware.
+func (t *Telemetry) EdgeTiming() func(http.Handler) http.Handler {
+	opts := t.opts.EdgeTiming
+	return func(next http.Handler) http.Handler {
+		if len(opts.ServerTimingHeaders) == 0 && len(opts.RequestStartHeaders) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received := time.Now()
+			if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+				span.SetAttributes(edgeTimingAttrs(r.Header, opts, received)...)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func edgeTimingAttrs(h http.Header, opts EdgeTimingOptions, received time.Time) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, name := range opts.ServerTimingHeaders {
+		for _, v := range h.Values(name) {
+			attrs = appendServerTiming(attrs, v)
+		}
+	}
+	for _, name := range opts.RequestStartHeaders {
+		if start, ok := parseRequestStart(h.Get(name)); ok {
+			if queued := received.Sub(start); queued >= 0 {
+				attrs = append(attrs, attribute.Float64("edge.queue_time_ms", float64(queued)/float64(time.Millisecond)))
+			}
+			break
+		}
+	}
+	return attrs
+}
+
+// appendServerTiming appends the metrics of one Server-Timing header value
+// that carry a duration.
+func appendServerTiming(attrs []attribute.KeyValue, header string) []attribute.KeyValue {
+	n := 0
+	for _, metric := range strings.Split(header, ",") {
+		params := strings.Split(metric, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if !strings.EqualFold(k, "dur") {
+				continue
+			}
+			if dur, err := strconv.ParseFloat(strings.Trim(v, `"`), 64); err == nil {
+				attrs = append(attrs, attribute.Float64("edge.timing."+name+".dur_ms", dur))
+				n++
+			}
+			break
+		}
+		if n == maxEdgeTimings {
+			break
+		}
+	}
+	return attrs
+}
+
+// parseRequestStart parses "t=<unix time>" or a bare unix time, guessing
+// the unit from its magnitude.
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	if v == "" {
+		return time.Time{}, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return time.Time{}, false
+	}
+	switch {
+	case f > 1e15:
+		return time.UnixMicro(int64(f)), true
+	case f > 1e12:
+		return time.UnixMilli(int64(f)), true
+	default:
+		return time.Unix(0, int64(f*float64(time.Second))), true
+	}
+}
@@ -0,0 +1,107 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Propagator returns the propagator Setup installs globally: W3C trace
+// context and baggage.
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// MiddlewareOptions configures HTTPMiddleware.
+type MiddlewareOptions struct {
+	// ServerName names the server in request spans. Empty means the
+	// service name.
+	ServerName string
+	// Routes, when the router is chi, lets the middleware match the route
+	// before serving the request, so request spans are named and sampled
+	// by route, and per-route timeouts and rate limits apply.
+	Routes chi.Routes
+}
+
+// HTTPMiddleware returns the whole telemetry middleware stack as one
+// middleware, in the order the service mounts it, for services that bring
+// their own router and server: tracing with the global propagator, request
+// tagging, metrics, error classification, auditing, rate limiting, timeouts, the
+// Server-Timing header and fault injection.
+//
+// It can wrap any http.Handler. Routers other than chi report the route
+// pattern they matched with SetRoute; until they do, the middleware knows
+// the request by its path only.
+func (t *Telemetry) HTTPMiddleware(opts MiddlewareOptions) func(http.Handler) http.Handler {
+	if opts.ServerName == "" {
+		opts.ServerName = t.opts.ServiceName
+	}
+	tracing := []otelchi.Option{
+		otelchi.WithTracerProvider(t.TracerProvider),
+		otelchi.WithPropagators(Propagator()),
+		otelchi.WithFilter(t.Traced),
+	}
+	if opts.Routes != nil {
+		tracing = append(tracing, otelchi.WithChiRoutes(opts.Routes))
+	}
+	stack := []func(http.Handler) http.Handler{
+		t.SamplingPriority(),
+		otelchi.Middleware(opts.ServerName, tracing...),
+		t.TagRequests(),
+		t.Streams(),
+		t.DetectDisconnects(),
+		t.EdgeTiming(),
+		t.HTTPMetrics(),
+		t.RequestSchema(),
+		t.ClassifyErrors(),
+		t.Audit(),
+		t.ArchivePayloads(),
+		t.CaptureVerbose(),
+		t.ShadowCompare(),
+		t.Recoverer(),
+		t.RateLimit(),
+		t.RejectWrites(),
+		t.RequestTimeout(),
+		t.RecordCancellations(),
+		t.ServerTiming(),
+		t.InjectFaults(),
+	}
+	return func(next http.Handler) http.Handler {
+		h := next
+		for i := len(stack) - 1; i >= 0; i-- {
+			h = stack[i](h)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The middleware reads the matched route from the chi route
+			// context; outside chi, it holds what SetRoute reports.
+			if chi.RouteContext(r.Context()) == nil {
+				rctx := chi.NewRouteContext()
+				rctx.Routes = opts.Routes
+				r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetRoute reports the route pattern r matched, e.g. "/users/:id" from
+// gin's FullPath, for routers other than chi wrapped by HTTPMiddleware.
+// The route names the request span and labels the request metrics. chi
+// routers report the route themselves.
+func SetRoute(r *http.Request, route string) {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		rctx.RoutePatterns = []string{route}
+	}
+}
+
+// Handler serves the prometheus scrape endpoints, /metrics and
+// /metrics/metadata, for mounting on an existing router.
+func (t *Telemetry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", t.MetricsHandler())
+	mux.Handle("/metrics/metadata", MetadataHandler(t.Gatherer(), t.opts.ScrapeInterval))
+	return mux
+}
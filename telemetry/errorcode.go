@@ -0,0 +1,196 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorCode is a domain error code, e.g. "not_found", which the error
+// taxonomy maps to the status of every protocol the service answers in,
+// and to the status of spans.
+type ErrorCode string
+
+// Error codes of the default taxonomy.
+const (
+	CodeInvalidArgument    ErrorCode = "invalid_argument"
+	CodeUnauthenticated    ErrorCode = "unauthenticated"
+	CodePermissionDenied   ErrorCode = "permission_denied"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeAlreadyExists      ErrorCode = "already_exists"
+	CodeConflict           ErrorCode = "conflict"
+	CodeFailedPrecondition ErrorCode = "failed_precondition"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeCanceled           ErrorCode = "canceled"
+	CodeInternal           ErrorCode = "internal"
+	CodeUnimplemented      ErrorCode = "unimplemented"
+	CodeUnavailable        ErrorCode = "unavailable"
+	CodeDeadlineExceeded   ErrorCode = "deadline_exceeded"
+)
+
+// ErrorMapping is what an error code maps to. Codes mapped to 5xx statuses
+// are failures of the service: they mark spans as errors, and their
+// messages are not shown to callers.
+type ErrorMapping struct {
+	// HTTPStatus is the status of REST responses, 400 to 599.
+	HTTPStatus int
+	// GRPCCode is the gRPC status code, other than OK.
+	GRPCCode grpccodes.Code
+	// GraphQLCode is the code in the extensions of GraphQL errors. Empty
+	// means the error code in upper case.
+	GraphQLCode string
+}
+
+func (m ErrorMapping) validate() error {
+	var errs []error
+	if m.HTTPStatus < 400 || m.HTTPStatus > 599 {
+		errs = append(errs, fmt.Errorf("http status must be 4xx or 5xx, got %d", m.HTTPStatus))
+	}
+	if m.GRPCCode == grpccodes.OK || m.GRPCCode > grpccodes.Unauthenticated {
+		errs = append(errs, fmt.Errorf("grpc code must be a failure, got %s", m.GRPCCode))
+	}
+	return errors.Join(errs...)
+}
+
+func validateErrorCodes(m map[ErrorCode]ErrorMapping) error {
+	var errs []error
+	for code, em := range m {
+		if !tagNamePattern.MatchString(string(code)) {
+			errs = append(errs, fmt.Errorf("error code %q must be snake_case", code))
+		}
+		if err := em.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("error code %s: %w", code, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DefaultErrorCodes returns the default taxonomy, which follows the
+// mapping of gRPC codes to HTTP statuses of the Google API design guide.
+func DefaultErrorCodes() map[ErrorCode]ErrorMapping {
+	return map[ErrorCode]ErrorMapping{
+		CodeInvalidArgument:    {HTTPStatus: http.StatusBadRequest, GRPCCode: grpccodes.InvalidArgument, GraphQLCode: "BAD_USER_INPUT"},
+		CodeUnauthenticated:    {HTTPStatus: http.StatusUnauthorized, GRPCCode: grpccodes.Unauthenticated},
+		CodePermissionDenied:   {HTTPStatus: http.StatusForbidden, GRPCCode: grpccodes.PermissionDenied, GraphQLCode: "FORBIDDEN"},
+		CodeNotFound:           {HTTPStatus: http.StatusNotFound, GRPCCode: grpccodes.NotFound},
+		CodeAlreadyExists:      {HTTPStatus: http.StatusConflict, GRPCCode: grpccodes.AlreadyExists},
+		CodeConflict:           {HTTPStatus: http.StatusConflict, GRPCCode: grpccodes.Aborted},
+		CodeFailedPrecondition: {HTTPStatus: http.StatusBadRequest, GRPCCode: grpccodes.FailedPrecondition},
+		CodeRateLimited:        {HTTPStatus: http.StatusTooManyRequests, GRPCCode: grpccodes.ResourceExhausted},
+		CodeCanceled:           {HTTPStatus: StatusClientClosedRequest, GRPCCode: grpccodes.Canceled},
+		CodeInternal:           {HTTPStatus: http.StatusInternalServerError, GRPCCode: grpccodes.Internal, GraphQLCode: "INTERNAL_SERVER_ERROR"},
+		CodeUnimplemented:      {HTTPStatus: http.StatusNotImplemented, GRPCCode: grpccodes.Unimplemented},
+		CodeUnavailable:        {HTTPStatus: http.StatusServiceUnavailable, GRPCCode: grpccodes.Unavailable},
+		CodeDeadlineExceeded:   {HTTPStatus: http.StatusGatewayTimeout, GRPCCode: grpccodes.DeadlineExceeded},
+	}
+}
+
+// errorCodes is the taxonomy in effect: the defaults, with
+// Options.ErrorCodes applied by Setup.
+var errorCodes atomic.Pointer[map[ErrorCode]ErrorMapping]
+
+func init() {
+	setErrorCodes(nil)
+}
+
+// setErrorCodes puts the taxonomy of the defaults overridden and extended
+// by m in effect.
+func setErrorCodes(m map[ErrorCode]ErrorMapping) {
+	taxonomy := DefaultErrorCodes()
+	for code, em := range m {
+		taxonomy[code] = em
+	}
+	errorCodes.Store(&taxonomy)
+}
+
+// mapping returns the mapping of code, falling back on that of internal
+// errors for codes the taxonomy does not know.
+func (c ErrorCode) mapping() ErrorMapping {
+	m := *errorCodes.Load()
+	if em, ok := m[c]; ok {
+		return em
+	}
+	return m[CodeInternal]
+}
+
+// fault reports whether the service is at fault for errors of code c.
+func (c ErrorCode) fault() bool {
+	return c.mapping().HTTPStatus >= http.StatusInternalServerError
+}
+
+// codeError is an error with a domain error code.
+type codeError struct {
+	code ErrorCode
+	err  error
+}
+
+func (e *codeError) Error() string { return e.err.Error() }
+func (e *codeError) Unwrap() error { return e.err }
+
+// HTTPStatus returns the status REST responses failed by e answer with.
+func (e *codeError) HTTPStatus() int { return e.code.mapping().HTTPStatus }
+
+// GRPCStatus returns the status gRPC servers answer e with. Only callers
+// of 4xx codes see the message.
+func (e *codeError) GRPCStatus() *status.Status {
+	em := e.code.mapping()
+	msg := e.err.Error()
+	if e.code.fault() {
+		msg = em.GRPCCode.String()
+	}
+	return status.New(em.GRPCCode, msg)
+}
+
+// Extensions returns the extensions of the GraphQL error reporting e, as
+// read by gqlgen.
+func (e *codeError) Extensions() map[string]any {
+	code := e.code.mapping().GraphQLCode
+	if code == "" {
+		code = strings.ToUpper(string(e.code))
+	}
+	return map[string]any{"code": code}
+}
+
+// WithCode returns err with the domain error code, which RenderError,
+// gRPC servers and GraphQL servers map through the error taxonomy. A nil
+// err stays nil.
+func WithCode(err error, code ErrorCode) error {
+	if err == nil {
+		return nil
+	}
+	return &codeError{code: code, err: err}
+}
+
+// CodeOf returns the domain error code of err, if it has one.
+func CodeOf(err error) (ErrorCode, bool) {
+	var ce *codeError
+	if errors.As(err, &ce) {
+		return ce.code, true
+	}
+	return "", false
+}
+
+// SetSpanError records err on span, with its domain error code as
+// error.code, and marks the span failed if the service is at fault, that
+// is if err maps to a 5xx status. Handlers of every protocol call it, so
+// span statuses agree with response statuses.
+func SetSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	if code, ok := CodeOf(err); ok {
+		span.SetAttributes(attribute.String("error.code", string(code)))
+	}
+	if httpStatus, _ := errorStatus(err); httpStatus >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
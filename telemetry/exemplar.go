@@ -0,0 +1,244 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ExemplarPolicy selects the requests whose trace may become an exemplar of
+// the request duration histogram.
+type ExemplarPolicy string
+
+const (
+	// ExemplarsAll selects every sampled request.
+	ExemplarsAll ExemplarPolicy = "all"
+	// ExemplarsErrors selects sampled requests answered with a 5xx status.
+	ExemplarsErrors ExemplarPolicy = "errors"
+	// ExemplarsSlow selects sampled requests slower than
+	// ExemplarOptions.SlowerThan.
+	ExemplarsSlow ExemplarPolicy = "slow"
+)
+
+// ExemplarOptions configures which requests link their trace to the
+// http.server.request.duration histogram of the prometheus exporter, so
+// the exemplars Prometheus stores stay few and predictable. Exemplars are
+// only served in the OpenMetrics and protobuf formats.
+type ExemplarOptions struct {
+	// Policy selects the requests that may contribute exemplars. Empty
+	// means all.
+	Policy ExemplarPolicy
+	// SlowerThan is the duration above which a request is slow, for the
+	// slow policy.
+	SlowerThan time.Duration
+	// Ratio is the fraction of the selected requests contributing
+	// exemplars. Zero means 1.
+	Ratio float64
+}
+
+func (o *ExemplarOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	var errs []error
+	switch o.Policy {
+	case "", ExemplarsAll, ExemplarsErrors:
+		if o.SlowerThan != 0 {
+			errs = append(errs, errors.New("exemplars slower than only applies to the slow policy"))
+		}
+	case ExemplarsSlow:
+		if o.SlowerThan <= 0 {
+			errs = append(errs, fmt.Errorf("exemplars slower than must be positive for the slow policy, got %s", o.SlowerThan))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown exemplar policy %q", o.Policy))
+	}
+	if o.Ratio < 0 || o.Ratio > 1 {
+		errs = append(errs, fmt.Errorf("exemplar ratio must be within [0, 1], got %g", o.Ratio))
+	}
+	return errors.Join(errs...)
+}
+
+// exemplarFamilies are the names of the request duration histogram, with
+// and without its unit suffix.
+var exemplarFamilies = map[string]bool{
+	"http_server_request_duration_seconds": true,
+	"http_server_request_duration":         true,
+}
+
+// scopeLabels are added by the prometheus exporter to every series, and
+// are not recorded attributes.
+var scopeLabels = map[string]bool{"otel_scope_name": true, "otel_scope_version": true}
+
+// exemplarStore keeps the last exemplar admitted by the policy for each
+// bucket of each series of the request duration histogram.
+type exemplarStore struct {
+	opts ExemplarOptions
+
+	mu sync.Mutex
+	// exemplars are keyed by series signature, then by bucket upper bound.
+	exemplars map[string]map[float64]*dto.Exemplar
+}
+
+// newExemplarStore returns nil if opts is nil, which disables exemplars.
+func newExemplarStore(opts *ExemplarOptions) *exemplarStore {
+	if opts == nil {
+		return nil
+	}
+	return &exemplarStore{opts: *opts, exemplars: make(map[string]map[float64]*dto.Exemplar)}
+}
+
+// admits reports whether a request answered with status after elapsed may
+// contribute an exemplar.
+func (s *exemplarStore) admits(elapsed time.Duration, status int) bool {
+	switch s.opts.Policy {
+	case ExemplarsErrors:
+		if status < http.StatusInternalServerError {
+			return false
+		}
+	case ExemplarsSlow:
+		if elapsed <= s.opts.SlowerThan {
+			return false
+		}
+	}
+	return s.opts.Ratio == 0 || rand.Float64() < s.opts.Ratio
+}
+
+// offer keeps the trace of sc as the exemplar of the bucket elapsed falls
+// in, for the series of attrs, if the policy admits the request.
+func (s *exemplarStore) offer(sc trace.SpanContext, attrs []attribute.KeyValue, elapsed time.Duration, status int) {
+	if s == nil || !sc.IsSampled() || !s.admits(elapsed, status) {
+		return
+	}
+	v := elapsed.Seconds()
+	bound := math.Inf(1)
+	for _, b := range latencyBuckets {
+		if v <= b {
+			bound = b
+			break
+		}
+	}
+	labels := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		labels[sanitizeLabel(string(kv.Key))] = kv.Value.Emit()
+	}
+	key := seriesSignature(labels)
+	e := &dto.Exemplar{
+		Label: []*dto.LabelPair{
+			{Name: proto.String("trace_id"), Value: proto.String(sc.TraceID().String())},
+			{Name: proto.String("span_id"), Value: proto.String(sc.SpanID().String())},
+		},
+		Value:     proto.Float64(v),
+		Timestamp: timestamppb.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buckets, ok := s.exemplars[key]
+	if !ok {
+		buckets = make(map[float64]*dto.Exemplar)
+		s.exemplars[key] = buckets
+	}
+	buckets[bound] = e
+}
+
+// lookup returns the exemplars of the series labeled labels, by bucket
+// upper bound.
+func (s *exemplarStore) lookup(labels []*dto.LabelPair) map[float64]*dto.Exemplar {
+	m := make(map[string]string, len(labels))
+	for _, lp := range labels {
+		if !scopeLabels[lp.GetName()] {
+			m[lp.GetName()] = lp.GetValue()
+		}
+	}
+	key := seriesSignature(m)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buckets := make(map[float64]*dto.Exemplar, len(s.exemplars[key]))
+	for bound, e := range s.exemplars[key] {
+		buckets[bound] = e
+	}
+	return buckets
+}
+
+// seriesSignature identifies a series by its labels, in name order.
+func seriesSignature(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%q,", name, labels[name])
+	}
+	return b.String()
+}
+
+// sanitizeLabel turns an attribute key into the label name the prometheus
+// exporter gives it.
+func sanitizeLabel(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// exemplarGatherer attaches the exemplars of the store to the buckets of
+// the request duration histogram.
+type exemplarGatherer struct {
+	prometheus.Gatherer
+	store *exemplarStore
+}
+
+func newExemplarGatherer(g prometheus.Gatherer, store *exemplarStore) prometheus.Gatherer {
+	if store == nil {
+		return g
+	}
+	return exemplarGatherer{Gatherer: g, store: store}
+}
+
+func (g exemplarGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, mf := range families {
+		if mf.GetType() != dto.MetricType_HISTOGRAM || !exemplarFamilies[mf.GetName()] {
+			continue
+		}
+		for _, m := range mf.Metric {
+			exemplars := g.store.lookup(m.Label)
+			if len(exemplars) == 0 {
+				continue
+			}
+			h := m.GetHistogram()
+			for _, b := range h.GetBucket() {
+				if e, ok := exemplars[b.GetUpperBound()]; ok {
+					b.Exemplar = e
+				}
+			}
+			// The +Inf bucket is implicit in the gathered histogram.
+			if e, ok := exemplars[math.Inf(1)]; ok {
+				h.Bucket = append(h.Bucket, &dto.Bucket{
+					CumulativeCount: proto.Uint64(h.GetSampleCount()),
+					UpperBound:      proto.Float64(math.Inf(1)),
+					Exemplar:        e,
+				})
+			}
+		}
+	}
+	return families, err
+}
@@ -0,0 +1,127 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrInjectedFault is the request error of requests failed by InjectFaults.
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultOptions configures InjectFaults.
+type FaultOptions struct {
+	// Ratio is the fraction of eligible requests faults are injected into.
+	// Zero disables fault injection.
+	Ratio float64
+	// Delay is added before serving those requests.
+	Delay time.Duration
+	// Status, when set, answers those requests after the delay instead of
+	// serving them, e.g. 503.
+	Status int
+	// Routes are the path.Match globs of the eligible requests, matched
+	// against the request path and the chi route pattern. Empty means
+	// every route.
+	Routes []string
+	// AllTraffic makes real requests eligible too. By default only
+	// synthetic ones, carrying SyntheticHeader, are, so alerting can be
+	// validated continuously without affecting users.
+	AllTraffic bool
+}
+
+func (o FaultOptions) validate() error {
+	var errs []error
+	if o.Ratio < 0 || o.Ratio > 1 {
+		errs = append(errs, fmt.Errorf("fault ratio must be between 0 and 1, got %g", o.Ratio))
+	}
+	if o.Delay < 0 {
+		errs = append(errs, fmt.Errorf("fault delay must not be negative, got %s", o.Delay))
+	}
+	if o.Status != 0 && (o.Status < 400 || o.Status > 599) {
+		errs = append(errs, fmt.Errorf("fault status must be 4xx or 5xx, got %d", o.Status))
+	}
+	if o.Ratio > 0 && o.Delay == 0 && o.Status == 0 {
+		errs = append(errs, errors.New("fault injection needs a delay or a status"))
+	}
+	for _, g := range o.Routes {
+		if _, err := path.Match(g, ""); err != nil {
+			errs = append(errs, fmt.Errorf("fault route %q: %w", g, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// InjectFaults returns middleware delaying, and optionally failing, a
+// ratio of the synthetic requests, or of every request with
+// FaultOptions.AllTraffic. Request spans of those requests get
+// fault.injected, so alerts firing on them can be told apart from real
+// incidents, and each fault is counted in http.server.faults by route and
+// kind. It must run last, so the fault looks like a slow or failing
+// handler to the rest of the stack, timeouts included.
+func (t *Telemetry) InjectFaults() func(http.Handler) http.Handler {
+	opts := t.opts.Faults
+	faults, _ := selfMeter().Int64Counter(
+		"http.server.faults",
+		metric.WithDescription("Faults injected into requests, by route and kind."),
+	)
+
+	return func(next http.Handler) http.Handler {
+		if opts.Ratio <= 0 {
+			return next
+		}
+		if opts.AllTraffic {
+			log.Warn().Float64("ratio", opts.Ratio).Dur("delay", opts.Delay).Int("status", opts.Status).
+				Msg("injecting faults into real traffic")
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !opts.AllTraffic && !IsSynthetic(r) ||
+				len(opts.Routes) > 0 && !matchRoute(opts.Routes, r.URL.Path, routePattern(r)) ||
+				rand.Float64() >= opts.Ratio {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			kind := "delay"
+			if opts.Status != 0 {
+				kind = "error"
+			}
+			span := trace.SpanFromContext(r.Context())
+			span.SetAttributes(attribute.Bool("fault.injected", true))
+			span.AddEvent("fault injected", trace.WithAttributes(
+				attribute.String("fault.kind", kind),
+				attribute.String("fault.delay", opts.Delay.String()),
+				attribute.Int("fault.status", opts.Status),
+			))
+			faults.Add(r.Context(), 1, metric.WithAttributes(
+				attribute.String("http.route", routePattern(r)),
+				attribute.String("fault.kind", kind),
+			))
+
+			if opts.Delay > 0 {
+				timer := time.NewTimer(opts.Delay)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+			if opts.Status == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			SetRequestError(r.Context(), ErrInjectedFault)
+			render.Status(r, opts.Status)
+			render.JSON(w, r, newErrorResponse(r, http.StatusText(opts.Status)))
+		})
+	}
+}
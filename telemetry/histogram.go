@@ -0,0 +1,46 @@
+package telemetry
+
+import (
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+// latencyBuckets are the explicit bucket boundaries, in seconds, of the
+// duration histograms. The SDK defaults are meant for milliseconds and would
+// put nearly every request in the first bucket.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+// ExponentialHistogramOptions tunes base-2 exponential histograms, which
+// adapt their buckets to the recorded values and suit latency heatmaps.
+// Zero values use the SDK defaults.
+type ExponentialHistogramOptions struct {
+	// MaxSize is the most buckets kept per histogram.
+	MaxSize int32
+	// MaxScale caps the resolution, between -10 and 20.
+	MaxScale int32
+}
+
+func (o ExponentialHistogramOptions) aggregation() metric.AggregationBase2ExponentialHistogram {
+	agg := metric.AggregationBase2ExponentialHistogram{MaxSize: 160, MaxScale: 20}
+	if o.MaxSize > 0 {
+		agg.MaxSize = o.MaxSize
+	}
+	if o.MaxScale != 0 {
+		agg.MaxScale = o.MaxScale
+	}
+	return agg
+}
+
+// aggregationSelector returns the reader aggregation for eo: exponential
+// histograms when requested, the SDK defaults otherwise.
+func aggregationSelector(eo MetricExporterOptions) metric.AggregationSelector {
+	if eo.ExponentialHistograms == nil {
+		return metric.DefaultAggregationSelector
+	}
+	exp := eo.ExponentialHistograms.aggregation()
+	return func(kind metric.InstrumentKind) metric.Aggregation {
+		if kind == metric.InstrumentKindHistogram {
+			return exp
+		}
+		return metric.DefaultAggregationSelector(kind)
+	}
+}
@@ -0,0 +1,345 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// HotRouteOptions configures profile-guided sampling: routes whose recent
+// latency regressed against a baseline measured by previous builds are
+// sampled at a higher ratio, focusing trace volume where it helps most.
+type HotRouteOptions struct {
+	// BaselineFile keeps the mean latency of each route between runs.
+	// Empty disables the mode.
+	BaselineFile string
+	// Ratio is the sample ratio of regressed routes. Zero means 1.
+	Ratio float64
+	// Threshold is how many times slower than its baseline a route must be
+	// to count as regressed. Zero means 1.5.
+	Threshold float64
+	// Window is the period of recent requests compared with the baseline,
+	// and how often the baseline file is saved. Zero means one minute.
+	Window time.Duration
+	// MinRequests skips windows with too little traffic to judge. Zero
+	// means 20.
+	MinRequests int
+}
+
+func (o HotRouteOptions) validate() error {
+	var errs []error
+	if o.Ratio < 0 || o.Ratio > 1 {
+		errs = append(errs, fmt.Errorf("hot route sample ratio must be between 0 and 1, got %g", o.Ratio))
+	}
+	if o.Threshold != 0 && o.Threshold <= 1 {
+		errs = append(errs, fmt.Errorf("hot route threshold must be above 1, got %g", o.Threshold))
+	}
+	if o.Window < 0 {
+		errs = append(errs, fmt.Errorf("hot route window must not be negative, got %s", o.Window))
+	}
+	if o.MinRequests < 0 {
+		errs = append(errs, fmt.Errorf("hot route min requests must not be negative, got %d", o.MinRequests))
+	}
+	return errors.Join(errs...)
+}
+
+// routeLatency is the latency of a route over many requests.
+type routeLatency struct {
+	Requests int64   `json:"requests"`
+	Seconds  float64 `json:"seconds"`
+}
+
+func (l routeLatency) mean() float64 {
+	return l.Seconds / float64(l.Requests)
+}
+
+// buildLatency is the latency of the routes of one build.
+type buildLatency struct {
+	Build  string                  `json:"build"`
+	Routes map[string]routeLatency `json:"routes"`
+}
+
+// hotRouteFile is the content of the baseline file: the latencies of the
+// previous build, which routes are compared with, and those of the running
+// build so far, which become the baseline once another build runs.
+type hotRouteFile struct {
+	Baseline buildLatency `json:"baseline"`
+	Current  buildLatency `json:"current"`
+}
+
+// hotRoutes tracks which routes regressed against the baseline.
+type hotRoutes struct {
+	opts  HotRouteOptions
+	build string
+
+	mu       sync.Mutex
+	baseline map[string]routeLatency
+	prev     buildLatency
+	current  map[string]routeLatency
+	window   map[string]routeLatency
+
+	hot atomic.Pointer[map[string]bool]
+
+	reg  metric.Registration
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// buildID names the running build in the baseline file: its VCS revision,
+// or its version when built outside a checkout.
+func buildID(bi BuildInfo) string {
+	switch {
+	case bi.Revision == "":
+		return bi.Version
+	case bi.Modified:
+		return bi.Revision + "+dirty"
+	default:
+		return bi.Revision
+	}
+}
+
+func startHotRoutes(opts HotRouteOptions, build string) (*hotRoutes, error) {
+	if opts.BaselineFile == "" {
+		return nil, nil
+	}
+	if opts.Ratio == 0 {
+		opts.Ratio = 1
+	}
+	if opts.Threshold == 0 {
+		opts.Threshold = 1.5
+	}
+	if opts.Window == 0 {
+		opts.Window = time.Minute
+	}
+	if opts.MinRequests == 0 {
+		opts.MinRequests = 20
+	}
+
+	h := &hotRoutes{
+		opts:     opts,
+		build:    build,
+		baseline: map[string]routeLatency{},
+		current:  map[string]routeLatency{},
+		window:   map[string]routeLatency{},
+		done:     make(chan struct{}),
+	}
+	h.hot.Store(&map[string]bool{})
+	h.load()
+
+	gauge, err := selfMeter().Int64ObservableGauge(
+		"telemetry.sampling.hot_routes",
+		metric.WithDescription("Routes sampled at the hot route ratio because their latency regressed against the baseline of the previous build."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	h.reg, err = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(gauge, int64(len(*h.hot.Load())))
+		return nil
+	}, gauge)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.stop = cancel
+	go h.run(ctx)
+	return h, nil
+}
+
+// load reads the baseline file. A missing or unreadable file starts a new
+// one: sampling then stays as configured until a build has a baseline.
+func (h *hotRoutes) load() {
+	b, err := os.ReadFile(h.opts.BaselineFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var f hotRouteFile
+	if err == nil {
+		err = json.Unmarshal(b, &f)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("file", h.opts.BaselineFile).Msg("ignoring unreadable hot route baseline")
+		return
+	}
+
+	switch {
+	case f.Current.Build == h.build:
+		// A restart of the same build keeps comparing with the build
+		// before it.
+		h.prev = f.Baseline
+		for route, l := range f.Current.Routes {
+			h.current[route] = l
+		}
+	case len(f.Current.Routes) > 0:
+		h.prev = f.Current
+	default:
+		h.prev = f.Baseline
+	}
+	for route, l := range h.prev.Routes {
+		if l.Requests > 0 {
+			h.baseline[route] = l
+		}
+	}
+	log.Info().Str("baseline_build", h.prev.Build).Int("routes", len(h.baseline)).Msg("hot route baseline loaded")
+}
+
+// record adds a request of route to the current window.
+func (h *hotRoutes) record(route string, elapsed time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l := h.window[route]
+	l.Requests++
+	l.Seconds += elapsed.Seconds()
+	h.window[route] = l
+}
+
+// isHot reports whether route regressed in the last window.
+func (h *hotRoutes) isHot(route string) bool {
+	return (*h.hot.Load())[route]
+}
+
+func (h *hotRoutes) run(ctx context.Context) {
+	defer close(h.done)
+	ticker := time.NewTicker(h.opts.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.evaluate()
+			h.save()
+		}
+	}
+}
+
+// evaluate compares the routes of the window ending with their baseline
+// and folds the window into the latencies of the running build.
+func (h *hotRoutes) evaluate() {
+	h.mu.Lock()
+	window := h.window
+	h.window = map[string]routeLatency{}
+	for route, l := range window {
+		c := h.current[route]
+		c.Requests += l.Requests
+		c.Seconds += l.Seconds
+		h.current[route] = c
+	}
+	h.mu.Unlock()
+
+	prev := *h.hot.Load()
+	hot := make(map[string]bool, len(prev))
+	for route := range prev {
+		hot[route] = true
+	}
+	for route, l := range window {
+		base, ok := h.baseline[route]
+		if !ok || l.Requests < int64(h.opts.MinRequests) {
+			continue
+		}
+		regressed := l.mean() >= h.opts.Threshold*base.mean()
+		switch {
+		case regressed && !hot[route]:
+			hot[route] = true
+			log.Warn().Str("http.route", route).Float64("mean", l.mean()).Float64("baseline", base.mean()).
+				Float64("sample_ratio", h.opts.Ratio).Msg("route latency regressed, raising its sampling")
+		case !regressed && hot[route]:
+			delete(hot, route)
+			log.Info().Str("http.route", route).Float64("mean", l.mean()).Float64("baseline", base.mean()).
+				Msg("route latency back to baseline, restoring its sampling")
+		}
+	}
+	h.hot.Store(&hot)
+}
+
+// save writes the baseline file, through a temporary file so a crash
+// cannot leave it truncated.
+func (h *hotRoutes) save() {
+	h.mu.Lock()
+	f := hotRouteFile{Baseline: h.prev, Current: buildLatency{Build: h.build, Routes: make(map[string]routeLatency, len(h.current))}}
+	for route, l := range h.current {
+		f.Current.Routes[route] = l
+	}
+	h.mu.Unlock()
+
+	b, err := json.Marshal(f)
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(h.opts.BaselineFile), "."+filepath.Base(h.opts.BaselineFile)+".tmp")
+		if err = os.WriteFile(tmp, b, 0o644); err == nil {
+			err = os.Rename(tmp, h.opts.BaselineFile)
+		}
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("file", h.opts.BaselineFile).Msg("failed to save hot route baseline")
+	}
+}
+
+// shutdown stops the evaluation and saves the latencies of the last window.
+func (h *hotRoutes) shutdown(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	h.stop()
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	h.evaluate()
+	h.save()
+	return h.reg.Unregister()
+}
+
+// hotRouteSampler samples the root spans of regressed routes, named by
+// their http.route attribute, at the hot route ratio when the wrapped
+// sampler drops them. Ratio samplers decide from the trace ID, so the
+// traces it keeps are a superset of those the wrapped sampler keeps.
+type hotRouteSampler struct {
+	trace.Sampler
+	hot     *hotRoutes
+	boosted trace.Sampler
+}
+
+func newHotRouteSampler(s trace.Sampler, hot *hotRoutes) trace.Sampler {
+	if hot == nil {
+		return s
+	}
+	return hotRouteSampler{Sampler: s, hot: hot, boosted: trace.TraceIDRatioBased(hot.opts.Ratio)}
+}
+
+func (s hotRouteSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	res := s.Sampler.ShouldSample(p)
+	if res.Decision == trace.RecordAndSample {
+		return res
+	}
+	for _, kv := range p.Attributes {
+		if kv.Key != "http.route" || !s.hot.isHot(kv.Value.AsString()) {
+			continue
+		}
+		if boosted := s.boosted.ShouldSample(p); boosted.Decision == trace.RecordAndSample {
+			boosted.Attributes = append(boosted.Attributes, attribute.Bool("sampling.hot_route", true))
+			return boosted
+		}
+	}
+	return res
+}
+
+func (s hotRouteSampler) Description() string {
+	return "HotRouteSampler{" + s.Sampler.Description() + "}"
+}
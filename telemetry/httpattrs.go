@@ -0,0 +1,113 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SyntheticHeader marks requests sent by the service's own probes and test
+// traffic rather than real clients.
+const SyntheticHeader = "X-Synthetic"
+
+// IsSynthetic reports whether r was sent as synthetic traffic.
+func IsSynthetic(r *http.Request) bool {
+	return r.Header.Get(SyntheticHeader) != ""
+}
+
+// parseTrustedProxies parses IPs and CIDR prefixes.
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			addr, err := netip.ParseAddr(p)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", p, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", p, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func trusted(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddress returns the address of the client that sent r. Forwarding
+// headers are only believed when set by a trusted proxy: X-Forwarded-For is
+// walked from the right, skipping trusted hops, and X-Real-IP is used when
+// there is no X-Forwarded-For.
+func clientAddress(r *http.Request, proxies []netip.Prefix) (addr string, port string) {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr, ""
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !trusted(proxies, peer) {
+		return host, port
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !trusted(proxies, hop) {
+			return hop.String(), ""
+		}
+	}
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return real.String(), ""
+	}
+	return host, port
+}
+
+// clientAttrs describes who sent r, and whether it was synthetic.
+func clientAttrs(r *http.Request, proxies []netip.Prefix) []attribute.KeyValue {
+	addr, port := clientAddress(r, proxies)
+	attrs := []attribute.KeyValue{attribute.String("client.address", addr)}
+	if port != "" {
+		attrs = append(attrs, attribute.String("client.port", port))
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		attrs = append(attrs, attribute.String("network.peer.address", host))
+	}
+	if ua := r.UserAgent(); ua != "" {
+		attrs = append(attrs, attribute.String("user_agent.original", ua))
+	}
+	if IsSynthetic(r) {
+		attrs = append(attrs, attribute.String("user_agent.synthetic.type", "test"))
+	}
+	return attrs
+}
+
+// countingBody counts the bytes of a request body read by the handler.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
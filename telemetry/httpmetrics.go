@@ -0,0 +1,125 @@
+package telemetry
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HTTPMetrics returns middleware recording the duration and body sizes of
+// every request by route, method and status, and feeding the per-route Apdex
+// score and anomaly detector. It also adds the client address, user agent
+// and body sizes to the request span; they are left out of the metrics to
+// keep their cardinality bounded.
+//
+// It must run inside the chi router so the matched route pattern is known.
+// Routes matching Options.Routes.Unmetered are not recorded.
+func (t *Telemetry) HTTPMetrics() func(http.Handler) http.Handler {
+	duration, _ := selfMeter().Float64Histogram(
+		"http.server.request.duration",
+		metric.WithDescription("Duration of HTTP server requests."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...),
+	)
+	requestSize, _ := selfMeter().Int64Histogram(
+		"http.server.request.body.size",
+		metric.WithDescription("Size of HTTP server request bodies."),
+		metric.WithUnit("By"),
+	)
+	responseSize, _ := selfMeter().Int64Histogram(
+		"http.server.response.body.size",
+		metric.WithDescription("Size of HTTP server response bodies."),
+		metric.WithUnit("By"),
+	)
+	apdex := t.apdex
+	anomalies := newAnomalyDetector(t.opts.Anomaly)
+	// Validated by Setup.
+	proxies, _ := parseTrustedProxies(t.opts.TrustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			var body *countingBody
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &countingBody{ReadCloser: r.Body}
+				r.Body = body
+			}
+
+			next.ServeHTTP(ww, r)
+
+			reqBytes := r.ContentLength
+			if body != nil && body.n > reqBytes {
+				reqBytes = body.n
+			}
+			reqBytes = max(reqBytes, 0)
+			respBytes := int64(ww.BytesWritten())
+
+			if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+				span.SetAttributes(clientAttrs(r, proxies)...)
+				span.SetAttributes(
+					attribute.Int64("http.request.body.size", reqBytes),
+					attribute.Int64("http.response.body.size", respBytes),
+				)
+			}
+
+			elapsed := time.Since(start)
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			// A stream is measured up to its handshake; the connection
+			// is measured by Streams.
+			if hs, d, ok := handshake(r); ok {
+				status, elapsed = hs, d
+			}
+			// Nobody received what the handler answered an aborted request.
+			if ClientDisconnected(r.Context()) {
+				status = StatusClientClosedRequest
+			}
+			route := routePattern(r)
+			if !t.metered(r, route) {
+				return
+			}
+
+			tags, _ := r.Context().Value(requestTagsKey{}).([]attribute.KeyValue)
+			kvs := append([]attribute.KeyValue{
+				attribute.String("http.route", route),
+				attribute.String("http.request.method", r.Method),
+				attribute.Int("http.response.status_code", status),
+				attribute.String("url.scheme", scheme(r)),
+				attribute.String("network.protocol.version", fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor)),
+			}, tags...)
+			attrs := metric.WithAttributes(kvs...)
+			duration.Record(r.Context(), elapsed.Seconds(), attrs)
+			t.exemplars.offer(trace.SpanContextFromContext(r.Context()), kvs, elapsed, status)
+			requestSize.Record(r.Context(), reqBytes, attrs)
+			responseSize.Record(r.Context(), respBytes, attrs)
+			apdex.record(r.Context(), route, elapsed, status)
+			anomalies.record(r.Context(), route, elapsed, status)
+			t.hot.record(route, elapsed)
+		})
+	}
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// routePattern returns the chi route pattern that matched r, e.g.
+// "/users/{id}", or an empty string if none did.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
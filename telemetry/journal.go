@@ -0,0 +1,151 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/principal"
+)
+
+// Journal actions.
+const (
+	journalControl           = "control"
+	journalFlush             = "flush"
+	journalVerbose           = "verbose"
+	journalRotateCredentials = "rotate_credentials"
+)
+
+// JournalEntry is an admin action recorded in the journal before it was
+// carried out.
+type JournalEntry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Principal string    `json:"principal,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	// Control is the change requested by a control action.
+	Control *Control `json:"control,omitempty"`
+	// Window is how long a verbose action opened the verbose tracing
+	// window for; "0s" closes it.
+	Window string `json:"window,omitempty"`
+}
+
+// journal is an append-only file of JSON lines recording admin actions,
+// each synced to disk before the action is carried out, so the runtime
+// settings they changed are restored after a crash or restart.
+type journal struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openJournal opens the journal at path, creating it if needed, and
+// returns the entries it holds. A torn last line, left by a crash while it
+// was written, is cut off.
+func openJournal(path string) (*journal, []JournalEntry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("admin journal: %w", err)
+	}
+	var entries []JournalEntry
+	var good int64
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Join(fmt.Errorf("admin journal: %w", err), f.Close())
+		}
+		var e JournalEntry
+		if json.Unmarshal(bytes.TrimSpace(line), &e) != nil {
+			break
+		}
+		entries = append(entries, e)
+		good += int64(len(line))
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() > good {
+		log.Warn().Str("path", path).Int64("bytes", fi.Size()-good).Msg("cutting off torn admin journal entries")
+		if err := f.Truncate(good); err != nil {
+			return nil, nil, errors.Join(fmt.Errorf("admin journal: %w", err), f.Close())
+		}
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		return nil, nil, errors.Join(fmt.Errorf("admin journal: %w", err), f.Close())
+	}
+	return &journal{f: f}, entries, nil
+}
+
+// record appends e, the action of r, to the journal and syncs it.
+func (j *journal) record(r *http.Request, e JournalEntry) error {
+	if j == nil {
+		return nil
+	}
+	e.Time = time.Now().UTC()
+	if p, ok := principal.FromContext(r.Context()); ok {
+		e.Principal, e.Role = p.Name, p.Role.String()
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		e.TraceID = sc.TraceID().String()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("admin journal: %w", err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("admin journal: %w", err)
+	}
+	return nil
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// replayJournal folds the control actions of entries, oldest first, into
+// the settings they leave in effect, and applies them. Entries that no
+// longer validate are skipped.
+func (t *Telemetry) replayJournal(entries []JournalEntry) {
+	var state Control
+	n := 0
+	for _, e := range entries {
+		if e.Action != journalControl || e.Control == nil || e.Control.validate() != nil {
+			continue
+		}
+		if e.Control.SampleRatio != nil {
+			state.SampleRatio = e.Control.SampleRatio
+		}
+		if e.Control.LogLevel != nil {
+			state.LogLevel = e.Control.LogLevel
+		}
+		if e.Control.ReadOnly != nil {
+			state.ReadOnly = e.Control.ReadOnly
+		}
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	// Validated above.
+	_ = t.applyControl(state)
+	log.Info().Int("entries", n).Msg("replayed admin journal")
+}
@@ -0,0 +1,65 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// NewLogger returns the service logger configured by opts.
+//
+// The JSON timestamp format and the level are zerolog globals, so NewLogger
+// sets zerolog.TimeFieldFormat and the global level as a side effect.
+func NewLogger(opts Options) (zerolog.Logger, error) {
+	var redactor *Redactor
+	if len(opts.RedactionRules) > 0 {
+		var err error
+		if redactor, err = NewRedactor(opts.RedactionRules); err != nil {
+			return zerolog.Logger{}, err
+		}
+	}
+	return newLogger(opts, redactor)
+}
+
+// newLogger returns the service logger, scrubbing its lines with redactor
+// unless it is nil.
+func newLogger(opts Options, redactor *Redactor) (zerolog.Logger, error) {
+	dst := opts.Log.Output
+	if dst == nil {
+		dst = os.Stderr
+	}
+	var out io.Writer
+	switch opts.Log.Format {
+	case LogJSON, "":
+		if opts.Log.TimeFormat != "" {
+			zerolog.TimeFieldFormat = opts.Log.TimeFormat
+		}
+		out = dst
+	case LogConsole:
+		timeFormat := opts.Log.TimeFormat
+		if timeFormat == "" {
+			timeFormat = time.TimeOnly
+		}
+		out = zerolog.ConsoleWriter{Out: dst, TimeFormat: timeFormat}
+	default:
+		return zerolog.Logger{}, fmt.Errorf("unknown log format %q", opts.Log.Format)
+	}
+
+	if opts.LogSchema != nil {
+		out = NewSchemaWriter(out, opts.LogSchema)
+	}
+	if redactor != nil {
+		out = NewScrubWriter(out, redactor)
+	}
+
+	zerolog.SetGlobalLevel(opts.Log.Level)
+
+	ctx := zerolog.New(out).With().Timestamp()
+	if opts.Log.Caller {
+		ctx = ctx.Caller()
+	}
+	return ctx.Logger(), nil
+}
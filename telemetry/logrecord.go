@@ -0,0 +1,228 @@
+package telemetry
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+// LogRecordOptions configures how log lines map to OTLP log records, as
+// backends disagree on where they expect fields: some search the body,
+// others only index attributes.
+type LogRecordOptions struct {
+	// Severities overrides DefaultSeverities, the severity number of each
+	// level.
+	Severities map[zerolog.Level]logspb.SeverityNumber
+	// BodyFields are the fields kept in the body, which is then a map of
+	// them and the message. Empty means the body is the message alone.
+	BodyFields []string
+	// Attributes are the fields that become attributes. Empty means every
+	// field but those of the body. Fields in neither are dropped.
+	Attributes []string
+}
+
+func (o LogRecordOptions) validate() error {
+	var errs []error
+	for level, sev := range o.Severities {
+		if sev < logspb.SeverityNumber_SEVERITY_NUMBER_TRACE || sev > logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4 {
+			errs = append(errs, fmt.Errorf("log severity of %s must be within [1, 24], got %d", level, sev))
+		}
+	}
+	body := make(map[string]bool, len(o.BodyFields))
+	for _, f := range o.BodyFields {
+		body[f] = true
+	}
+	for _, f := range o.Attributes {
+		if body[f] {
+			errs = append(errs, fmt.Errorf("log field %q cannot be both in the body and an attribute", f))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DefaultSeverities returns the severity numbers of the OpenTelemetry log
+// data model for each level.
+func DefaultSeverities() map[zerolog.Level]logspb.SeverityNumber {
+	return map[zerolog.Level]logspb.SeverityNumber{
+		zerolog.TraceLevel: logspb.SeverityNumber_SEVERITY_NUMBER_TRACE,
+		zerolog.DebugLevel: logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+		zerolog.InfoLevel:  logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		zerolog.WarnLevel:  logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+		zerolog.ErrorLevel: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+		zerolog.FatalLevel: logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+		zerolog.PanicLevel: logspb.SeverityNumber_SEVERITY_NUMBER_FATAL2,
+	}
+}
+
+// LogRecordMapper turns the JSON lines of the service logger into OTLP log
+// records, for a logs pipeline. The service writes its logs to the
+// console and exports none, so it is left to such a pipeline, e.g. one
+// tailing the console, to use it.
+type LogRecordMapper struct {
+	severities map[zerolog.Level]logspb.SeverityNumber
+	body       map[string]bool
+	attributes map[string]bool
+}
+
+// NewLogRecordMapper returns the mapper configured by opts.
+func NewLogRecordMapper(opts LogRecordOptions) (*LogRecordMapper, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	m := &LogRecordMapper{severities: DefaultSeverities(), body: make(map[string]bool)}
+	for level, sev := range opts.Severities {
+		m.severities[level] = sev
+	}
+	for _, f := range opts.BodyFields {
+		m.body[f] = true
+	}
+	if len(opts.Attributes) > 0 {
+		m.attributes = make(map[string]bool, len(opts.Attributes))
+		for _, f := range opts.Attributes {
+			m.attributes[f] = true
+		}
+	}
+	return m, nil
+}
+
+// Map returns the log record of line. The level, time, trace_id and
+// span_id fields go to their own record fields; the message and the other
+// fields go to the body or attributes as configured.
+func (m *LogRecordMapper) Map(line []byte) (*logspb.LogRecord, error) {
+	fields, err := parseLogLine(line)
+	if err != nil {
+		return nil, err
+	}
+	rec := &logspb.LogRecord{ObservedTimeUnixNano: uint64(time.Now().UnixNano())}
+	var msg *commonpb.AnyValue
+	var body []*commonpb.KeyValue
+	for _, f := range fields {
+		switch f.key {
+		case zerolog.LevelFieldName:
+			var s string
+			if json.Unmarshal(f.value, &s) == nil {
+				rec.SeverityText = s
+				if level, err := zerolog.ParseLevel(s); err == nil {
+					rec.SeverityNumber = m.severities[level]
+				}
+			}
+			continue
+		case zerolog.TimestampFieldName:
+			if t, ok := logTime(f.value); ok {
+				rec.TimeUnixNano = uint64(t.UnixNano())
+			}
+			continue
+		case "trace_id":
+			if id, ok := hexID(f.value, 16); ok {
+				rec.TraceId = id
+				continue
+			}
+		case "span_id":
+			if id, ok := hexID(f.value, 8); ok {
+				rec.SpanId = id
+				continue
+			}
+		case zerolog.MessageFieldName:
+			msg = jsonAnyValue(f.value)
+			continue
+		}
+		kv := &commonpb.KeyValue{Key: f.key, Value: jsonAnyValue(f.value)}
+		switch {
+		case m.body[f.key]:
+			body = append(body, kv)
+		case m.attributes == nil || m.attributes[f.key]:
+			rec.Attributes = append(rec.Attributes, kv)
+		}
+	}
+	switch {
+	case len(m.body) == 0:
+		rec.Body = msg
+	case msg != nil:
+		body = append([]*commonpb.KeyValue{{Key: zerolog.MessageFieldName, Value: msg}}, body...)
+		fallthrough
+	default:
+		rec.Body = &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: body}}}
+	}
+	return rec, nil
+}
+
+// logTime parses a time field written with zerolog.TimeFieldFormat.
+func logTime(v json.RawMessage) (time.Time, bool) {
+	var n json.Number
+	if err := json.Unmarshal(v, &n); err == nil {
+		i, err := n.Int64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		switch zerolog.TimeFieldFormat {
+		case zerolog.TimeFormatUnixMs:
+			return time.UnixMilli(i), true
+		case zerolog.TimeFormatUnixMicro:
+			return time.UnixMicro(i), true
+		case zerolog.TimeFormatUnixNano:
+			return time.Unix(0, i), true
+		default:
+			return time.Unix(i, 0), true
+		}
+	}
+	var s string
+	if json.Unmarshal(v, &s) != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(zerolog.TimeFieldFormat, s)
+	return t, err == nil
+}
+
+// hexID decodes a JSON string holding an ID of size bytes in hex.
+func hexID(v json.RawMessage, size int) ([]byte, bool) {
+	var s string
+	if json.Unmarshal(v, &s) != nil {
+		return nil, false
+	}
+	id, err := hex.DecodeString(s)
+	return id, err == nil && len(id) == size
+}
+
+// jsonAnyValue converts a JSON value to an OTLP value, integers staying
+// integers.
+func jsonAnyValue(v json.RawMessage) *commonpb.AnyValue {
+	switch jsonType(v) {
+	case FieldString:
+		var s string
+		_ = json.Unmarshal(v, &s)
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+	case FieldBool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v[0] == 't'}}
+	case FieldNumber:
+		n := json.Number(strings.TrimSpace(string(v)))
+		if i, err := n.Int64(); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+		}
+		f, _ := n.Float64()
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}}
+	case FieldArray:
+		var items []json.RawMessage
+		_ = json.Unmarshal(v, &items)
+		values := make([]*commonpb.AnyValue, len(items))
+		for i, item := range items {
+			values[i] = jsonAnyValue(item)
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case FieldObject:
+		fields, _ := parseLogLine(v)
+		kvs := make([]*commonpb.KeyValue, len(fields))
+		for i, f := range fields {
+			kvs[i] = &commonpb.KeyValue{Key: f.key, Value: jsonAnyValue(f.value)}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: kvs}}}
+	default:
+		return &commonpb.AnyValue{}
+	}
+}
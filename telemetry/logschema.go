@@ -0,0 +1,257 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// FieldType is the JSON type a log field must have.
+type FieldType string
+
+const (
+	FieldString FieldType = "string"
+	FieldNumber FieldType = "number"
+	FieldBool   FieldType = "bool"
+	FieldObject FieldType = "object"
+	FieldArray  FieldType = "array"
+)
+
+// SchemaMode selects what happens to a log line that violates the schema.
+type SchemaMode string
+
+const (
+	// SchemaFix repairs what it can of the line and lists every violation
+	// in a "schema_violations" field.
+	SchemaFix SchemaMode = "fix"
+	// SchemaReject drops the line and logs an error describing it instead.
+	SchemaReject SchemaMode = "reject"
+)
+
+// LogSchema describes the fields every log line must respect.
+type LogSchema struct {
+	Mode SchemaMode
+
+	// Required keys must be present on every line.
+	Required []string
+	// Types pins the JSON type of a key whenever it is present.
+	Types map[string]FieldType
+	// Reserved keys are owned by the logging pipeline and may only appear
+	// once; a second occurrence means application code shadowed them.
+	Reserved []string
+}
+
+// DefaultLogSchema reserves zerolog's own keys and requires a level and message.
+func DefaultLogSchema() *LogSchema {
+	return &LogSchema{
+		Mode:     SchemaFix,
+		Required: []string{zerolog.LevelFieldName, zerolog.MessageFieldName},
+		Types: map[string]FieldType{
+			zerolog.LevelFieldName:   FieldString,
+			zerolog.MessageFieldName: FieldString,
+		},
+		Reserved: []string{
+			zerolog.TimestampFieldName,
+			zerolog.LevelFieldName,
+			zerolog.MessageFieldName,
+			zerolog.CallerFieldName,
+		},
+	}
+}
+
+// schemaWriter validates each JSON log line against a schema before passing
+// it on. It costs a JSON round trip per line, so it is meant for debug mode.
+type schemaWriter struct {
+	schema   *LogSchema
+	reserved map[string]bool
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewSchemaWriter wraps out so that every line zerolog writes is checked
+// against schema.
+func NewSchemaWriter(out io.Writer, schema *LogSchema) io.Writer {
+	reserved := make(map[string]bool, len(schema.Reserved))
+	for _, k := range schema.Reserved {
+		reserved[k] = true
+	}
+	return &schemaWriter{schema: schema, reserved: reserved, out: out}
+}
+
+type logField struct {
+	key   string
+	value json.RawMessage
+}
+
+func (w *schemaWriter) Write(p []byte) (int, error) {
+	fields, err := parseLogLine(p)
+	if err != nil {
+		// Not a JSON object, so there is nothing to enforce.
+		return w.write(p, len(p))
+	}
+
+	fields, violations := w.check(fields)
+	if len(violations) == 0 {
+		return w.write(p, len(p))
+	}
+
+	if w.schema.Mode == SchemaReject {
+		return w.write(rejectedLine(p, violations), len(p))
+	}
+
+	v, _ := json.Marshal(violations)
+	fields = append(fields, logField{key: "schema_violations", value: v})
+	return w.write(encodeLogLine(fields), len(p))
+}
+
+func (w *schemaWriter) write(line []byte, n int) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// check returns the repaired fields together with a description of every
+// violation found. A reserved key set twice keeps zerolog's occurrence and
+// renames the other ones "fields_<key>". Missing required keys are only
+// reported, as any value made up for them would hide the violation.
+func (w *schemaWriter) check(fields []logField) ([]logField, []string) {
+	var violations []string
+	owner := w.owners(fields)
+	seen := make(map[string]bool, len(fields))
+	fixed := fields[:0]
+
+	for i, f := range fields {
+		if j, ok := owner[f.key]; ok && i != j {
+			violations = append(violations, fmt.Sprintf("reserved key %q set twice", f.key))
+			f.key = "fields_" + f.key
+		}
+		seen[f.key] = true
+
+		if want, ok := w.schema.Types[f.key]; ok {
+			if got := jsonType(f.value); got != want && got != "null" {
+				violations = append(violations, fmt.Sprintf("key %q is %s, want %s", f.key, got, want))
+				if want != FieldString {
+					continue
+				}
+				f.value, _ = json.Marshal(string(f.value))
+			}
+		}
+		fixed = append(fixed, f)
+	}
+
+	for _, k := range w.schema.Required {
+		if !seen[k] {
+			violations = append(violations, fmt.Sprintf("required key %q missing", k))
+		}
+	}
+
+	return fixed, violations
+}
+
+// owners returns, for each reserved key set more than once, the index of
+// the occurrence zerolog wrote. The level is written when the event is
+// created, before any field; the message, and the timestamp and caller of
+// the logger context, are written by Msg, after every field.
+func (w *schemaWriter) owners(fields []logField) map[string]int {
+	count := make(map[string]int)
+	for _, f := range fields {
+		if w.reserved[f.key] {
+			count[f.key]++
+		}
+	}
+	owner := make(map[string]int)
+	for i, f := range fields {
+		if count[f.key] < 2 {
+			continue
+		}
+		if _, ok := owner[f.key]; ok && f.key == zerolog.LevelFieldName {
+			continue
+		}
+		owner[f.key] = i
+	}
+	return owner
+}
+
+func parseLogLine(p []byte) ([]logField, error) {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+
+	var fields []logField
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected token %v", t)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, logField{key: key, value: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func encodeLogLine(fields []logField) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.key)
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(f.value)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func rejectedLine(p []byte, violations []string) []byte {
+	line, _ := json.Marshal(map[string]any{
+		zerolog.LevelFieldName:   zerolog.LevelErrorValue,
+		zerolog.MessageFieldName: "log line rejected by schema",
+		"schema_violations":      violations,
+		"line":                   string(bytes.TrimSpace(p)),
+	})
+	return append(line, '\n')
+}
+
+func jsonType(v json.RawMessage) FieldType {
+	if len(v) == 0 {
+		return "null"
+	}
+	switch v[0] {
+	case '"':
+		return FieldString
+	case '{':
+		return FieldObject
+	case '[':
+		return FieldArray
+	case 't', 'f':
+		return FieldBool
+	case 'n':
+		return "null"
+	default:
+		return FieldNumber
+	}
+}
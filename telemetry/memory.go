@@ -0,0 +1,171 @@
+package telemetry
+
+import (
+	"context"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// MemoryOptions configures memory watermark tracking.
+type MemoryOptions struct {
+	// Interval is how often memory usage is sampled. Zero means one
+	// second; negative disables tracking.
+	Interval time.Duration
+}
+
+// Memory samples read from runtime/metrics, which, unlike
+// runtime.ReadMemStats, does not stop the world.
+const (
+	memHeapSample     = "/memory/classes/heap/objects:bytes"
+	memTotalSample    = "/memory/classes/total:bytes"
+	memReleasedSample = "/memory/classes/heap/released:bytes"
+	gcCyclesSample    = "/gc/cycles/total:gc-cycles"
+)
+
+// memoryUsage is a sample, or the maximum of samples, of the memory held by
+// the Go runtime: the heap objects, live or not yet swept, and all memory
+// mapped minus the heap returned to the OS.
+type memoryUsage struct {
+	heap, total uint64
+}
+
+func (u memoryUsage) max(v memoryUsage) memoryUsage {
+	return memoryUsage{heap: max(u.heap, v.heap), total: max(u.total, v.total)}
+}
+
+// memoryWatermark tracks the peak memory usage of the current and previous
+// wall-clock hours and of the deployment, i.e. since startup. Instantaneous
+// gauges miss the peaks between scrapes that capacity planning has to
+// account for.
+type memoryWatermark struct {
+	samples []metrics.Sample
+
+	mu        sync.Mutex
+	hour      time.Time
+	current   memoryUsage
+	previous  memoryUsage
+	sincePrev bool
+	overall   memoryUsage
+
+	reg  metric.Registration
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func startMemoryWatermark(opts MemoryOptions) (*memoryWatermark, error) {
+	if opts.Interval < 0 {
+		return nil, nil
+	}
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+
+	m := &memoryWatermark{
+		samples: []metrics.Sample{
+			{Name: memHeapSample},
+			{Name: memTotalSample},
+			{Name: memReleasedSample},
+			{Name: gcCyclesSample},
+		},
+		done: make(chan struct{}),
+	}
+	watermark, err := selfMeter().Int64ObservableGauge(
+		"process.memory.watermark",
+		metric.WithDescription("Peak memory held by the Go runtime, by memory.type (heap or total) and window: hour, previous_hour or deployment."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	gcCycles, err := selfMeter().Int64ObservableCounter(
+		"go.gc.cycles",
+		metric.WithDescription("Completed GC cycles; its increase between scrapes is the GC cycles run meanwhile."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	m.reg, err = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		cycles := m.sample()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		observe := func(window string, u memoryUsage) {
+			o.ObserveInt64(watermark, int64(u.heap), metric.WithAttributes(
+				attribute.String("memory.type", "heap"), attribute.String("window", window)))
+			o.ObserveInt64(watermark, int64(u.total), metric.WithAttributes(
+				attribute.String("memory.type", "total"), attribute.String("window", window)))
+		}
+		observe("hour", m.current)
+		if m.sincePrev {
+			observe("previous_hour", m.previous)
+		}
+		observe("deployment", m.overall)
+		o.ObserveInt64(gcCycles, int64(cycles))
+		return nil
+	}, watermark, gcCycles)
+	if err != nil {
+		return nil, err
+	}
+
+	m.sample()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stop = cancel
+	go m.run(ctx, opts.Interval)
+	return m, nil
+}
+
+func (m *memoryWatermark) run(ctx context.Context, interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sample()
+		}
+	}
+}
+
+// sample reads the memory usage into the watermarks, moving to a new hour
+// when one started, and returns the GC cycles completed so far.
+func (m *memoryWatermark) sample() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics.Read(m.samples)
+	value := func(i int) uint64 {
+		if m.samples[i].Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+		return m.samples[i].Value.Uint64()
+	}
+	u := memoryUsage{heap: value(0), total: value(1) - value(2)}
+
+	hour := time.Now().Truncate(time.Hour)
+	if !hour.Equal(m.hour) {
+		if !m.hour.IsZero() {
+			m.previous, m.sincePrev = m.current, true
+		}
+		m.hour, m.current = hour, memoryUsage{}
+	}
+	m.current = m.current.max(u)
+	m.overall = m.overall.max(u)
+	return value(3)
+}
+
+func (m *memoryWatermark) shutdown(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	m.stop()
+	select {
+	case <-m.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return m.reg.Unregister()
+}
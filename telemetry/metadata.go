@@ -0,0 +1,81 @@
+package telemetry
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultScrapeInterval is suggested to scrapers when none is configured.
+const defaultScrapeInterval = 15 * time.Second
+
+// knownUnits are the base units prometheus names end in, per its naming
+// conventions.
+var knownUnits = []string{"seconds", "bytes", "ratio", "percent", "celsius", "meters", "volts", "amperes", "joules", "grams"}
+
+// MetricMetadata describes one metric family.
+type MetricMetadata struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// MetricsMetadata is the body served by MetadataHandler.
+type MetricsMetadata struct {
+	// ScrapeInterval is the interval the service suggests it is scraped at.
+	ScrapeInterval string           `json:"scrape_interval"`
+	Metrics        []MetricMetadata `json:"metrics"`
+}
+
+// MetadataHandler serves the description, type and unit of every metric
+// family in g as JSON, so tooling can generate recording rules.
+func MetadataHandler(g prometheus.Gatherer, scrapeInterval time.Duration) http.Handler {
+	if scrapeInterval <= 0 {
+		scrapeInterval = defaultScrapeInterval
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := g.Gather()
+		if err != nil && len(families) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		md := MetricsMetadata{
+			ScrapeInterval: scrapeInterval.String(),
+			Metrics:        make([]MetricMetadata, 0, len(families)),
+		}
+		for _, mf := range families {
+			unit := mf.GetUnit()
+			if unit == "" {
+				unit = unitFromName(mf.GetName())
+			}
+			md.Metrics = append(md.Metrics, MetricMetadata{
+				Name: mf.GetName(),
+				Type: strings.ToLower(mf.GetType().String()),
+				Help: mf.GetHelp(),
+				Unit: unit,
+			})
+		}
+		sort.Slice(md.Metrics, func(i, j int) bool { return md.Metrics[i].Name < md.Metrics[j].Name })
+
+		render.JSON(w, r, md)
+	})
+}
+
+// unitFromName infers the unit from a conventional prometheus metric name,
+// e.g. "http_request_duration_seconds" or "process_resident_memory_bytes".
+func unitFromName(name string) string {
+	name = strings.TrimSuffix(name, "_total")
+	for _, u := range knownUnits {
+		if strings.HasSuffix(name, "_"+u) {
+			return u
+		}
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// NewMeterProvider creates a meter provider with one reader per configured
+// exporter. The caller owns the provider and must shut it down.
+func NewMeterProvider(ctx context.Context, opts Options) (*metric.MeterProvider, error) {
+	res, err := newResource(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newMeterProvider(ctx, opts, res)
+}
+
+func newMeterProvider(ctx context.Context, opts Options, res *resource.Resource) (*metric.MeterProvider, error) {
+	mpOpts := []metric.Option{metric.WithResource(res)}
+
+	// The readers built so far hold connections and files, released if a
+	// later one fails.
+	var built []metric.Reader
+	fail := func(err error) (*metric.MeterProvider, error) {
+		for _, reader := range built {
+			err = errors.Join(err, reader.Shutdown(ctx))
+		}
+		return nil, err
+	}
+	for i, eo := range opts.MetricExporters {
+		if eo.Job == "" {
+			eo.Job = opts.ServiceName
+		}
+		reader, err := newMetricReader(ctx, eo,
+			attribute.Int("exporter.index", i),
+			attribute.String("exporter.kind", string(eo.Kind)),
+			attribute.String("exporter.signal", "metrics"),
+		)
+		if err != nil {
+			return fail(fmt.Errorf("metric exporter %d (%s): %w", i, eo.Kind, err))
+		}
+		built = append(built, reader)
+		mpOpts = append(mpOpts, metric.WithReader(reader))
+	}
+	for _, reader := range opts.MetricReaders {
+		mpOpts = append(mpOpts, metric.WithReader(reader))
+	}
+	for _, eo := range opts.MetricExporters {
+		if eo.Kind != MetricExporterPrometheus || len(eo.NativeHistograms) == 0 {
+			continue
+		}
+		reader, err := newNativeHistogramReader(eo.LegacyUnits)
+		if err != nil {
+			return fail(fmt.Errorf("native histograms: %w", err))
+		}
+		mpOpts = append(mpOpts, metric.WithReader(reader), metric.WithView(nativeHistogramViews(eo.NativeHistograms)...))
+	}
+
+	return metric.NewMeterProvider(mpOpts...), nil
+}
+
+func newMetricReader(ctx context.Context, eo MetricExporterOptions, attrs ...attribute.KeyValue) (metric.Reader, error) {
+	switch eo.Kind {
+	case MetricExporterPrometheus:
+		// The exporter embeds a default OpenTelemetry Reader and
+		// implements prometheus.Collector on the default registry.
+		if eo.ExponentialHistograms != nil {
+			return nil, fmt.Errorf("exponential histograms are not supported by the prometheus exporter")
+		}
+		var promOpts []prometheus.Option
+		if eo.LegacyUnits {
+			promOpts = append(promOpts, prometheus.WithoutUnits())
+		}
+		return prometheus.New(promOpts...)
+
+	case MetricExporterStdout:
+		w, f, err := openOutput(eo.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		stdoutOpts := []stdoutmetric.Option{
+			stdoutmetric.WithWriter(w),
+			stdoutmetric.WithAggregationSelector(aggregationSelector(eo)),
+		}
+		if eo.PrettyPrint {
+			stdoutOpts = append(stdoutOpts, stdoutmetric.WithPrettyPrint())
+		}
+		var exporter metric.Exporter
+		exporter, err = stdoutmetric.New(stdoutOpts...)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			exporter = &fileMetricExporter{Exporter: exporter, f: f}
+		}
+
+		var readerOpts []metric.PeriodicReaderOption
+		if eo.Interval > 0 {
+			readerOpts = append(readerOpts, metric.WithInterval(eo.Interval))
+		}
+		return metric.NewPeriodicReader(exporter, readerOpts...), nil
+
+	case MetricExporterPushgateway, MetricExporterRemoteWrite:
+		return newPushReader(eo)
+
+	case MetricExporterOTLP:
+		return newOTLPMetricReader(ctx, eo, attrs...)
+
+	default:
+		return nil, fmt.Errorf("unknown exporter kind %q", eo.Kind)
+	}
+}
+
+// fileMetricExporter closes the underlying file once the exporter is shut down.
+type fileMetricExporter struct {
+	metric.Exporter
+	f *os.File
+}
+
+func (e *fileMetricExporter) Shutdown(ctx context.Context) error {
+	err := e.Exporter.Shutdown(ctx)
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
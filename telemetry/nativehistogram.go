@@ -0,0 +1,257 @@
+package telemetry
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// nativeHistogramAggregation aggregates the instruments opted into native
+// histograms. Prometheus accepts schemas up to 8.
+var nativeHistogramAggregation = metric.AggregationBase2ExponentialHistogram{MaxSize: 160, MaxScale: 8}
+
+// nativeHistogramViews aggregates the histogram instruments matching names,
+// which may use * and ? wildcards, into exponential buckets. The views
+// apply to every reader; the prometheus exporter drops such histograms, so
+// a nativeHistogramReader must expose them.
+func nativeHistogramViews(names []string) []metric.View {
+	views := make([]metric.View, 0, len(names))
+	for _, name := range names {
+		views = append(views, metric.NewView(
+			metric.Instrument{Name: name, Kind: metric.InstrumentKindHistogram},
+			metric.Stream{Aggregation: nativeHistogramAggregation},
+		))
+	}
+	return views
+}
+
+// nativeHistogramReader reads the exponential histograms and exposes them
+// on the default prometheus registry as native histograms, named and
+// labelled as the prometheus exporter names its metrics. Other instruments
+// are dropped, so it only aggregates what it exposes.
+type nativeHistogramReader struct {
+	*metric.ManualReader
+	collector *nativeHistogramCollector
+}
+
+func newNativeHistogramReader(legacyUnits bool) (*nativeHistogramReader, error) {
+	reader := metric.NewManualReader(metric.WithAggregationSelector(func(metric.InstrumentKind) metric.Aggregation {
+		return metric.AggregationDrop{}
+	}))
+	c := &nativeHistogramCollector{reader: reader, legacyUnits: legacyUnits}
+	if err := promclient.Register(c); err != nil {
+		return nil, err
+	}
+	return &nativeHistogramReader{ManualReader: reader, collector: c}, nil
+}
+
+// Shutdown unregisters the collector, so the stack can be set up again.
+func (r *nativeHistogramReader) Shutdown(ctx context.Context) error {
+	promclient.Unregister(r.collector)
+	return r.ManualReader.Shutdown(ctx)
+}
+
+type nativeHistogramCollector struct {
+	reader      *metric.ManualReader
+	legacyUnits bool
+}
+
+// Describe sends nothing, making the collector unchecked, as the
+// histograms are only known once recorded.
+func (c *nativeHistogramCollector) Describe(chan<- *promclient.Desc) {}
+
+func (c *nativeHistogramCollector) Collect(ch chan<- promclient.Metric) {
+	var rm metricdata.ResourceMetrics
+	if err := c.reader.Collect(context.Background(), &rm); err != nil {
+		otel.Handle(err)
+		return
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.ExponentialHistogram[int64]:
+				collectNative(ch, c.name(m), m.Description, sm.Scope, data.DataPoints)
+			case metricdata.ExponentialHistogram[float64]:
+				collectNative(ch, c.name(m), m.Description, sm.Scope, data.DataPoints)
+			}
+		}
+	}
+}
+
+// name follows the naming of the prometheus exporter for histograms.
+func (c *nativeHistogramCollector) name(m metricdata.Metrics) string {
+	name := strings.Map(sanitizeMetricRune, m.Name)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	if suffix, ok := promUnitSuffixes[m.Unit]; ok && !c.legacyUnits && !strings.HasSuffix(name, suffix) {
+		name += suffix
+	}
+	return name
+}
+
+// promUnitSuffixes are the unit suffixes the prometheus exporter appends to
+// metric names.
+var promUnitSuffixes = map[string]string{
+	"d": "_days", "h": "_hours", "min": "_minutes", "s": "_seconds",
+	"ms": "_milliseconds", "us": "_microseconds", "ns": "_nanoseconds",
+	"By": "_bytes", "KiBy": "_kibibytes", "MiBy": "_mebibytes", "GiBy": "_gibibytes", "TiBy": "_tibibytes",
+	"KBy": "_kilobytes", "MBy": "_megabytes", "GBy": "_gigabytes", "TBy": "_terabytes",
+	"m": "_meters", "V": "_volts", "A": "_amperes", "J": "_joules", "W": "_watts", "g": "_grams",
+	"Cel": "_celsius", "Hz": "_hertz", "1": "_ratio", "%": "_percent",
+}
+
+func sanitizeMetricRune(r rune) rune {
+	if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == ':' {
+		return r
+	}
+	return '_'
+}
+
+func sanitizeLabelRune(r rune) rune {
+	if r == ':' {
+		return '_'
+	}
+	return sanitizeMetricRune(r)
+}
+
+func collectNative[N int64 | float64](ch chan<- promclient.Metric, name, help string, scope instrumentation.Scope, points []metricdata.ExponentialHistogramDataPoint[N]) {
+	for _, dp := range points {
+		h, ok := nativeHistogram(dp)
+		if !ok {
+			continue
+		}
+		labels := map[string]string{
+			"otel_scope_name":    scope.Name,
+			"otel_scope_version": scope.Version,
+		}
+		for _, kv := range dp.Attributes.ToSlice() {
+			key := strings.Map(sanitizeLabelRune, string(kv.Key))
+			if v, dup := labels[key]; dup {
+				labels[key] = v + ";" + kv.Value.Emit()
+				continue
+			}
+			labels[key] = kv.Value.Emit()
+		}
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values := make([]string, len(keys))
+		for i, k := range keys {
+			values[i] = labels[k]
+		}
+		desc := promclient.NewDesc(name, help, keys, nil)
+		ch <- nativeMetric{desc: desc, labels: promclient.MakeLabelPairs(desc, values), histogram: h}
+	}
+}
+
+// nativeHistogram converts an exponential histogram data point. They share
+// their bucketing, but OpenTelemetry bucket i spans (base^i, base^(i+1)]
+// while prometheus bucket i spans (base^(i-1), base^i]. Points of a scale
+// prometheus does not accept are skipped.
+func nativeHistogram[N int64 | float64](dp metricdata.ExponentialHistogramDataPoint[N]) (*dto.Histogram, bool) {
+	if dp.Scale < -4 || dp.Scale > 8 {
+		return nil, false
+	}
+	h := &dto.Histogram{
+		SampleCount:      proto.Uint64(dp.Count),
+		SampleSum:        proto.Float64(float64(dp.Sum)),
+		Schema:           proto.Int32(dp.Scale),
+		ZeroThreshold:    proto.Float64(dp.ZeroThreshold),
+		ZeroCount:        proto.Uint64(dp.ZeroCount),
+		CreatedTimestamp: timestamppb.New(dp.StartTime),
+	}
+	h.PositiveSpan, h.PositiveDelta = nativeBuckets(dp.PositiveBucket)
+	h.NegativeSpan, h.NegativeDelta = nativeBuckets(dp.NegativeBucket)
+	if len(h.PositiveSpan) == 0 && len(h.NegativeSpan) == 0 {
+		// An empty span marks the histogram as native to parsers.
+		h.PositiveSpan = []*dto.BucketSpan{{Offset: proto.Int32(0), Length: proto.Uint32(0)}}
+	}
+	return h, true
+}
+
+// nativeBuckets encodes the non-empty buckets of b as spans of consecutive
+// buckets and the deltas between their counts.
+func nativeBuckets(b metricdata.ExponentialBucket) ([]*dto.BucketSpan, []int64) {
+	var (
+		spans  []*dto.BucketSpan
+		deltas []int64
+		prev   int64
+		next   int32
+	)
+	for i, n := range b.Counts {
+		if n == 0 {
+			continue
+		}
+		idx := b.Offset + int32(i) + 1
+		switch {
+		case len(spans) == 0:
+			spans = append(spans, &dto.BucketSpan{Offset: proto.Int32(idx), Length: proto.Uint32(0)})
+		case idx != next:
+			spans = append(spans, &dto.BucketSpan{Offset: proto.Int32(idx - next), Length: proto.Uint32(0)})
+		}
+		*spans[len(spans)-1].Length++
+		deltas = append(deltas, int64(n)-prev)
+		prev, next = int64(n), idx+1
+	}
+	return spans, deltas
+}
+
+type nativeMetric struct {
+	desc      *promclient.Desc
+	labels    []*dto.LabelPair
+	histogram *dto.Histogram
+}
+
+func (m nativeMetric) Desc() *promclient.Desc {
+	return m.desc
+}
+
+func (m nativeMetric) Write(out *dto.Metric) error {
+	out.Label = m.labels
+	out.Histogram = m.histogram
+	return nil
+}
+
+// createdGatherer sets the created timestamp of counters, histograms and
+// summaries that have none to start. The SDK starts every cumulative
+// series when its instrument is created, after the stack is set up, so the
+// timestamp is at worst early, which only means Prometheus assumes a zero
+// value for longer than needed.
+type createdGatherer struct {
+	promclient.Gatherer
+	start *timestamppb.Timestamp
+}
+
+func newCreatedGatherer(g promclient.Gatherer, start time.Time) promclient.Gatherer {
+	return createdGatherer{Gatherer: g, start: timestamppb.New(start)}
+}
+
+func (g createdGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			switch {
+			case m.Counter != nil && m.Counter.CreatedTimestamp == nil:
+				m.Counter.CreatedTimestamp = g.start
+			case m.Histogram != nil && m.Histogram.CreatedTimestamp == nil:
+				m.Histogram.CreatedTimestamp = g.start
+			case m.Summary != nil && m.Summary.CreatedTimestamp == nil:
+				m.Summary.CreatedTimestamp = g.start
+			}
+		}
+	}
+	return families, err
+}
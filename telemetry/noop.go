@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// InitNoop registers providers recording nothing and a logger writing
+// nothing, for benchmarks of code built on the stack. Unlike the no-op
+// providers of the OpenTelemetry API, spans get real IDs and the
+// propagator is the one of Setup, so trace context flows through contexts
+// and headers as in production without the cost of recording or
+// exporting. The middleware of the returned Telemetry works as usual.
+//
+// Its Shutdown puts the previous global providers, propagator and logger
+// back, so the no-ops can be scoped to a test or benchmark. Tracers and
+// meters must be obtained after InitNoop: those obtained from the global
+// providers before any was registered keep delegating to the first one.
+func InitNoop() *Telemetry {
+	prevTP, prevMP := otel.GetTracerProvider(), otel.GetMeterProvider()
+	prevPropagator, prevLogger := otel.GetTextMapPropagator(), log.Logger
+
+	opts := DefaultOptions("noop")
+	opts.TraceExporters, opts.MetricExporters = nil, nil
+	log.Logger = zerolog.Nop()
+	redactor, _ := NewRedactor(nil)
+	t := newNoop(opts, redactor)
+	t.restore = func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetMeterProvider(prevMP)
+		otel.SetTextMapPropagator(prevPropagator)
+		log.Logger = prevLogger
+	}
+	return t
+}
+
+// newNoop registers providers recording nothing and the propagator of
+// Setup, and returns a Telemetry around them.
+func newNoop(opts Options, redactor *Redactor) *Telemetry {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample()))
+	mp := sdkmetric.NewMeterProvider()
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	otel.SetTextMapPropagator(Propagator())
+
+	t := &Telemetry{
+		TracerProvider: tp,
+		MeterProvider:  mp,
+		opts:           opts,
+		sampler:        newDynamicSampler(0, nil),
+		redactor:       redactor,
+		start:          time.Now(),
+	}
+	t.apdex = newApdexTracker(opts.Apdex)
+	routes := opts.Routes
+	t.routes.Store(&routes)
+	taxonomy := opts.Taxonomy
+	t.taxonomy.Store(&taxonomy)
+	return t
+}
+
+func (t *Telemetry) shutdownNoop(context.Context) error {
+	if t.restore != nil {
+		t.restore()
+	}
+	return nil
+}
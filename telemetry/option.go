@@ -0,0 +1,119 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+)
+
+// Option changes Options. Options are applied in order, so later ones win.
+type Option func(*Options)
+
+// NewOptions returns the prod defaults for svcName with opts applied, or a
+// descriptive error if the result is invalid.
+func NewOptions(svcName string, opts ...Option) (Options, error) {
+	o := DefaultOptions(svcName)
+	o.Apply(opts...)
+	return o, o.Validate()
+}
+
+// New sets up telemetry from NewOptions.
+func New(ctx context.Context, svcName string, opts ...Option) (*Telemetry, error) {
+	o, err := NewOptions(svcName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return Setup(ctx, o)
+}
+
+// Apply applies opts to o.
+func (o *Options) Apply(opts ...Option) {
+	for _, opt := range opts {
+		opt(o)
+	}
+}
+
+// WithServiceName sets the service name.
+func WithServiceName(name string) Option {
+	return func(o *Options) { o.ServiceName = name }
+}
+
+// WithPreset replaces everything but the service name with the defaults of
+// preset. Unknown presets are left to Validate.
+func WithPreset(preset Preset) Option {
+	return func(o *Options) {
+		p, err := PresetOptions(preset, o.ServiceName)
+		if err != nil {
+			o.Preset = preset
+			return
+		}
+		*o = p
+	}
+}
+
+// WithOTLPEndpoint sends traces to endpoint, a host:port. It retargets the
+// configured OTLP exporters, or adds a batched one if there is none.
+func WithOTLPEndpoint(endpoint string, insecure bool) Option {
+	return func(o *Options) {
+		found := false
+		for i := range o.TraceExporters {
+			if eo := &o.TraceExporters[i]; eo.Kind == ExporterOTLP {
+				eo.Endpoint, eo.Insecure = endpoint, insecure
+				found = true
+			}
+		}
+		if !found {
+			o.TraceExporters = append(o.TraceExporters, TraceExporterOptions{
+				Kind:      ExporterOTLP,
+				Processor: ProcessorBatch,
+				Endpoint:  endpoint,
+				Insecure:  insecure,
+			})
+		}
+	}
+}
+
+// WithSampleRatio samples ratio of new traces.
+func WithSampleRatio(ratio float64) Option {
+	return func(o *Options) { o.SampleRatio = ratio }
+}
+
+// WithTraceExporter adds a trace exporter.
+func WithTraceExporter(eo TraceExporterOptions) Option {
+	return func(o *Options) { o.TraceExporters = append(o.TraceExporters, eo) }
+}
+
+// WithMetricExporter adds a metric exporter.
+func WithMetricExporter(eo MetricExporterOptions) Option {
+	return func(o *Options) { o.MetricExporters = append(o.MetricExporters, eo) }
+}
+
+// WithoutTraces removes every trace exporter. Spans are still created and
+// sampled, but go nowhere.
+func WithoutTraces() Option {
+	return func(o *Options) { o.TraceExporters = nil }
+}
+
+// WithoutMetrics removes every metric exporter.
+func WithoutMetrics() Option {
+	return func(o *Options) { o.MetricExporters = nil }
+}
+
+// WithLogFormat sets the log format.
+func WithLogFormat(format LogFormat) Option {
+	return func(o *Options) { o.Log.Format = format }
+}
+
+// WithRedactionRules replaces the redaction rules.
+func WithRedactionRules(rules ...RedactionRule) Option {
+	return func(o *Options) { o.RedactionRules = rules }
+}
+
+// WithApdexThreshold sets the default Apdex target response time.
+func WithApdexThreshold(threshold time.Duration) Option {
+	return func(o *Options) { o.Apdex.Threshold = threshold }
+}
+
+// WithSpanHook adds a hook run on ended spans.
+func WithSpanHook(h SpanHook) Option {
+	return func(o *Options) { o.SpanHooks = append(o.SpanHooks, h) }
+}
@@ -0,0 +1,446 @@
+// Package telemetry wires up OpenTelemetry tracing and metrics for the service.
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Preset names a set of defaults tuned for an environment.
+type Preset string
+
+const (
+	// PresetProd exports to a collector and logs JSON.
+	PresetProd Preset = "prod"
+	// PresetDev prints everything to the console.
+	PresetDev Preset = "dev"
+	// PresetDebug is the dev preset tuned for stepping through the service
+	// in a debugger such as Delve.
+	PresetDebug Preset = "debug"
+)
+
+// LogFormat selects how log lines are written.
+type LogFormat string
+
+const (
+	// LogJSON writes one JSON object per line.
+	LogJSON LogFormat = "json"
+	// LogConsole writes colored, human friendly lines.
+	LogConsole LogFormat = "console"
+)
+
+// ExporterKind identifies a trace exporter backend.
+type ExporterKind string
+
+const (
+	// ExporterOTLP sends spans over OTLP/gRPC, e.g. to an otel collector or Jaeger.
+	ExporterOTLP ExporterKind = "otlp"
+	// ExporterStdout writes spans as JSON to stdout or a file.
+	ExporterStdout ExporterKind = "stdout"
+)
+
+// MetricExporterKind identifies a metric exporter backend.
+type MetricExporterKind string
+
+const (
+	// MetricExporterPrometheus exposes metrics on the default prometheus registry.
+	MetricExporterPrometheus MetricExporterKind = "prometheus"
+	// MetricExporterStdout periodically writes metrics as JSON to stdout or a file.
+	MetricExporterStdout MetricExporterKind = "stdout"
+	// MetricExporterPushgateway pushes metrics to a Prometheus Pushgateway,
+	// for jobs that do not live long enough to be scraped.
+	MetricExporterPushgateway MetricExporterKind = "pushgateway"
+	// MetricExporterRemoteWrite sends metrics to a Prometheus remote-write
+	// endpoint.
+	MetricExporterRemoteWrite MetricExporterKind = "remote_write"
+	// MetricExporterOTLP periodically sends metrics over OTLP/gRPC.
+	MetricExporterOTLP MetricExporterKind = "otlp"
+)
+
+// Temporality is the temporality preference of OTLP metric exporters, named
+// as in OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE.
+type Temporality string
+
+const (
+	// TemporalityCumulative reports every instrument as a running total.
+	TemporalityCumulative Temporality = "cumulative"
+	// TemporalityDelta reports counters and histograms as the change since
+	// the last export, as backends such as Dynatrace require. Up-down
+	// counters stay cumulative.
+	TemporalityDelta Temporality = "delta"
+	// TemporalityLowMemory reports synchronous counters and histograms as
+	// deltas, and observable ones as running totals, so neither needs the
+	// previous value kept.
+	TemporalityLowMemory Temporality = "lowmemory"
+)
+
+// ProcessorKind selects the span processor used in front of an exporter.
+type ProcessorKind string
+
+const (
+	// ProcessorBatch buffers spans and exports them in the background.
+	ProcessorBatch ProcessorKind = "batch"
+	// ProcessorSimple exports every span synchronously when it ends.
+	ProcessorSimple ProcessorKind = "simple"
+)
+
+// TraceExporterOptions configures a single trace exporter.
+type TraceExporterOptions struct {
+	Kind      ExporterKind
+	Processor ProcessorKind
+
+	// Endpoint is the host:port of the OTLP receiver.
+	Endpoint string
+	// Insecure disables TLS for OTLP exporters.
+	Insecure bool
+	// Timeout bounds a single OTLP export call. Zero uses the exporter default.
+	Timeout time.Duration
+	// Retry tunes OTLP export retries. Nil uses the exporter defaults.
+	Retry *RetryOptions
+	// Spool persists batches that still fail after retries and replays them
+	// once the receiver is reachable again. Nil disables spooling.
+	Spool *SpoolOptions
+	// Breaker fails exports fast while the receiver is down. Nil disables
+	// it.
+	Breaker *BreakerOptions
+
+	// Batch tunes the batch span processor.
+	Batch BatchOptions
+
+	// Path is the file stdout exporters write to. Empty means os.Stdout.
+	Path string
+	// PrettyPrint indents stdout output.
+	PrettyPrint bool
+}
+
+// BatchOptions tunes a batch span processor. Zero values use the SDK defaults.
+type BatchOptions struct {
+	// MaxQueueSize is the number of spans buffered before new ones are dropped.
+	MaxQueueSize int
+	// MaxExportBatchSize is the largest number of spans sent in one export.
+	MaxExportBatchSize int
+	// ScheduleDelay is the longest a span waits before its batch is exported.
+	ScheduleDelay time.Duration
+	// ExportTimeout bounds how long the processor waits on one export.
+	ExportTimeout time.Duration
+}
+
+// RetryOptions configures retries of failed OTLP exports.
+type RetryOptions struct {
+	Enabled bool
+	// InitialInterval is the wait before the first retry.
+	InitialInterval time.Duration
+	// MaxInterval caps the exponential backoff between retries.
+	MaxInterval time.Duration
+	// MaxElapsedTime is how long a batch is retried before it is dropped.
+	MaxElapsedTime time.Duration
+}
+
+// MetricExporterOptions configures a single metric exporter.
+type MetricExporterOptions struct {
+	Kind MetricExporterKind
+
+	// Interval is how often push-based exporters collect. Zero uses the SDK
+	// default, except for pushgateway and remote_write exporters, which
+	// then only push on flush and shutdown.
+	Interval time.Duration
+
+	// Endpoint is the host:port of the receiver of otlp exporters.
+	Endpoint string
+	// Insecure disables TLS for otlp exporters.
+	Insecure bool
+	// Timeout bounds a single export call of otlp exporters. Zero uses the
+	// exporter default.
+	Timeout time.Duration
+	// Temporality is the temporality preference of otlp exporters. Empty
+	// means cumulative.
+	Temporality Temporality
+
+	// URL is the Pushgateway base URL, or the remote-write endpoint.
+	URL string
+	// Job is the job label of pushed metrics. Empty means the service name.
+	Job string
+	// Breaker fails pushes fast while the receiver is down. Nil disables
+	// it.
+	Breaker *BreakerOptions
+
+	// Path is the file stdout exporters write to. Empty means os.Stdout.
+	Path string
+	// PrettyPrint indents stdout output.
+	PrettyPrint bool
+
+	// ExponentialHistograms aggregates histograms into exponential buckets
+	// instead of fixed ones. The prometheus exporter cannot export them.
+	ExponentialHistograms *ExponentialHistogramOptions
+
+	// LegacyUnits keeps prometheus names free of unit suffixes and values
+	// in the units they were recorded in, for dashboards that predate unit
+	// conversion.
+	LegacyUnits bool
+
+	// NativeHistograms names the histogram instruments, e.g.
+	// "http.server.*", exposed as prometheus native histograms instead of
+	// fixed buckets. Prometheus only scrapes them in the protobuf format.
+	NativeHistograms []string
+	// OpenMetrics offers the OpenMetrics text format to scrapers asking
+	// for it.
+	OpenMetrics bool
+	// CreatedTimestamps adds the time counters, histograms and summaries
+	// started counting, in the protobuf format, so Prometheus can tell
+	// their first value from an increase.
+	CreatedTimestamps bool
+	// ScrapeTargets are co-located prometheus endpoints scraped along with
+	// the service and served with its metrics.
+	ScrapeTargets []ScrapeTarget
+	// Exemplars links the request duration histogram to the traces of the
+	// requests its policy selects. Nil disables exemplars.
+	Exemplars *ExemplarOptions
+}
+
+// LogOptions configures the service logger.
+type LogOptions struct {
+	Format LogFormat
+	// TimeFormat is a time layout, or one of zerolog's "UNIXTIME",
+	// "UNIXMS", "UNIXMICRO" and "UNIXNANO". Empty means RFC 3339 for JSON
+	// and a short clock for the console.
+	TimeFormat string
+	// Caller adds the file:line of the log call to every line.
+	Caller bool
+	// Level is the minimum level logged. The zero value logs everything
+	// from debug up.
+	Level zerolog.Level
+	// Output receives the log lines. Nil means stderr.
+	Output io.Writer
+}
+
+// Options configures the telemetry stack.
+type Options struct {
+	ServiceName string
+	// ResourceSchemaURL is the semantic conventions schema the resource
+	// follows. Empty uses the version this package is written against.
+	ResourceSchemaURL string
+	// Preset records which defaults these options started from.
+	Preset Preset
+	// Disabled makes Setup register providers recording nothing, as
+	// InitNoop does, whatever the other options say, as
+	// OTEL_SDK_DISABLED=true asks. Logging is unaffected.
+	Disabled bool
+
+	// SampleRatio is the fraction of new traces sampled. Parent decisions are
+	// always honored.
+	SampleRatio float64
+	// SampleAll samples every span, whatever the sample ratio, parent
+	// decisions and sampling priorities say.
+	SampleAll bool
+	// SamplingPriority configures upstream headers that override
+	// SampleRatio.
+	SamplingPriority SamplingPriorityOptions
+	// MaxSpanDuration is the longest plausible span. Longer spans, and
+	// those ending before they start, are flagged before export. Zero only
+	// flags the latter.
+	MaxSpanDuration time.Duration
+
+	// TraceExporters are all fed every span, each through its own processor.
+	TraceExporters []TraceExporterOptions
+	// ExportPriority selects the spans batch processors keep when their
+	// queue fills up.
+	ExportPriority ExportPriorityOptions
+	// MetricExporters each get their own reader on the meter provider.
+	MetricExporters []MetricExporterOptions
+	// ScrapeInterval is suggested to scrapers through /metrics/metadata.
+	ScrapeInterval time.Duration
+	// Apdex configures the per-route Apdex score of HTTPMetrics.
+	Apdex ApdexOptions
+	// Anomaly configures the error rate and latency spike detector of
+	// HTTPMetrics.
+	Anomaly AnomalyOptions
+	// Routes excludes routes from tracing and HTTPMetrics.
+	Routes RouteFilterOptions
+	// Taxonomy tags requests with business tags, such as their customer
+	// tier, in their span, metrics and logs.
+	Taxonomy []TaxonomyRule
+	// TrustedProxies are the IPs and CIDR prefixes of proxies whose
+	// X-Forwarded-For and X-Real-IP headers name the client.
+	TrustedProxies []string
+	// EdgeTiming selects the upstream timing headers added to request spans.
+	EdgeTiming EdgeTimingOptions
+	// ErrorClassifier names failed requests in the error.type span
+	// attribute. Nil uses DefaultErrorClassifier.
+	ErrorClassifier ErrorClassifier
+	// ErrorCodes overrides and extends DefaultErrorCodes, the taxonomy
+	// mapping the domain error codes of WithCode to protocol statuses.
+	ErrorCodes map[ErrorCode]ErrorMapping
+	// Archive samples request and response payloads to blob storage, a
+	// directory or an object store.
+	Archive ArchiveOptions
+	// CaptureDir, with the dev preset, receives every request and its
+	// response, redacted, as archived payloads for the replay subcommand.
+	CaptureDir string
+	// Timeout bounds the duration of requests; see RequestTimeout.
+	Timeout TimeoutOptions
+	// ReadOnly starts the service in read-only mode; see RejectWrites.
+	ReadOnly bool
+	// Journal is the path of an append-only file recording the actions of
+	// ControlHandler and FlushHandler before they are carried out. The
+	// settings changed through ControlHandler are restored from it at
+	// Setup, so they survive restarts. Empty disables it.
+	Journal string
+	// Drift detects changes of the effective config at runtime.
+	Drift DriftOptions
+	// Memory tracks the peak memory usage per hour and since startup.
+	Memory MemoryOptions
+	// Relay accepts OTLP from local processes and relays it upstream.
+	Relay RelayOptions
+	// HotRoutes raises the sampling of routes whose latency regressed
+	// against the previous build.
+	HotRoutes HotRouteOptions
+	// Reload applies changes to the sample ratio, log level, route filters
+	// and redaction rules from a file while running.
+	Reload ReloadOptions
+	// Credentials are the files the OTLP exporters and the relay read
+	// their headers and TLS material from; see RotateCredentials.
+	Credentials CredentialsOptions
+	// Shadow mirrors a sample of requests to a candidate implementation.
+	Shadow ShadowOptions
+	// RateLimit limits the rate of requests per client; see RateLimit.
+	RateLimit RateLimitOptions
+	// TransientRetry configures the retries of RetryTransient.
+	TransientRetry TransientRetryOptions
+	// Faults injects latency and errors into synthetic requests; see
+	// InjectFaults.
+	Faults FaultOptions
+	// Audit records who accessed what on sensitive routes; see Audit.
+	Audit AuditOptions
+	// ServerTiming sends request phase durations to clients in a
+	// Server-Timing header.
+	ServerTiming bool
+	// BudgetHeaders sends clients what is left of their rate limit and of
+	// the response time budget of the route, in X-RateLimit-* and
+	// X-Budget-* response headers, so they can throttle themselves.
+	BudgetHeaders bool
+
+	// RedactionRules scrub matching text from log lines and span attributes.
+	RedactionRules []RedactionRule
+
+	Log LogOptions
+	// LogSchema, when set, checks every log line against a field schema.
+	// Meant for debug mode, as it re-parses each line.
+	LogSchema *LogSchema
+	// SpanSchema, when set, logs spans of the wrong kind or missing the
+	// attributes their kind requires, to catch instrumentation bugs. Meant
+	// for debug mode too.
+	SpanSchema *SpanSchema
+
+	// PodMetadata adds the pod labels and annotations mounted with the
+	// downward API to the resource and spans.
+	PodMetadata PodMetadataOptions
+
+	// SpanResourceAttributes names the resource attributes copied to every
+	// span, for backends dropping resource attributes on ingestion.
+	SpanResourceAttributes []string
+
+	// SpanHooks fire callbacks on ended spans matching their predicates.
+	SpanHooks []SpanHook
+
+	// SpanProcessors receive every span besides the trace exporters, after
+	// redaction. MetricReaders read the metrics besides the metric
+	// exporters. Both let code embedding the stack, such as oteltest,
+	// capture telemetry in-process.
+	SpanProcessors []sdktrace.SpanProcessor
+	MetricReaders  []sdkmetric.Reader
+}
+
+// PresetOptions returns the defaults for preset. An empty preset means prod.
+func PresetOptions(preset Preset, svcName string) (Options, error) {
+	switch preset {
+	case PresetProd, "":
+		return DefaultOptions(svcName), nil
+	case PresetDev:
+		return DevOptions(svcName), nil
+	case PresetDebug:
+		return DebugOptions(svcName), nil
+	default:
+		return Options{}, fmt.Errorf("unknown preset %q", preset)
+	}
+}
+
+// DefaultOptions returns the prod preset: traces go to a local collector,
+// metrics to prometheus and logs are JSON.
+func DefaultOptions(svcName string) Options {
+	return Options{
+		ServiceName:     svcName,
+		Preset:          PresetProd,
+		SampleRatio:     1,
+		MaxSpanDuration: defaultMaxSpanDuration,
+		TraceExporters: []TraceExporterOptions{
+			{
+				Kind:      ExporterOTLP,
+				Processor: ProcessorBatch,
+				Endpoint:  "localhost:4317",
+				Insecure:  true,
+				Breaker:   DefaultBreakerOptions(),
+			},
+		},
+		MetricExporters: []MetricExporterOptions{
+			{Kind: MetricExporterPrometheus},
+		},
+		ScrapeInterval:         defaultScrapeInterval,
+		Apdex:                  DefaultApdexOptions(),
+		Anomaly:                DefaultAnomalyOptions(),
+		Routes:                 DefaultRouteFilterOptions(),
+		EdgeTiming:             DefaultEdgeTimingOptions(),
+		ExportPriority:         DefaultExportPriorityOptions(),
+		Audit:                  DefaultAuditOptions(),
+		RedactionRules:         DefaultRedactionRules(),
+		Log:                    LogOptions{Format: LogJSON, Caller: true},
+		SpanResourceAttributes: DefaultSpanResourceAttributes(),
+	}
+}
+
+// DevOptions returns the dev preset: everything is sampled and printed to
+// stdout, so no collector is needed.
+func DevOptions(svcName string) Options {
+	return Options{
+		ServiceName:     svcName,
+		Preset:          PresetDev,
+		SampleRatio:     1,
+		MaxSpanDuration: defaultMaxSpanDuration,
+		TraceExporters: []TraceExporterOptions{
+			{Kind: ExporterStdout, Processor: ProcessorSimple, PrettyPrint: true},
+		},
+		MetricExporters: []MetricExporterOptions{
+			{Kind: MetricExporterStdout, Interval: 10 * time.Second, PrettyPrint: true},
+		},
+		Apdex:          DefaultApdexOptions(),
+		Anomaly:        DefaultAnomalyOptions(),
+		Routes:         DefaultRouteFilterOptions(),
+		EdgeTiming:     DefaultEdgeTimingOptions(),
+		ExportPriority: DefaultExportPriorityOptions(),
+		Audit:          DefaultAuditOptions(),
+		ServerTiming:   true,
+		RedactionRules: DefaultRedactionRules(),
+		Log:            LogOptions{Format: LogConsole, Caller: true},
+		LogSchema:      DefaultLogSchema(),
+		SpanSchema:     DefaultSpanSchema(),
+	}
+}
+
+// DebugOptions returns the debug preset, for stepping through the service
+// in a debugger: every span is sampled, even under an unsampled parent,
+// and, as with dev, printed on the goroutine ending it, so telemetry is
+// complete and in order when a breakpoint is hit. Spans are not flagged for
+// lasting long, and anomaly detection is off, as breakpoints stretch every
+// request.
+func DebugOptions(svcName string) Options {
+	o := DevOptions(svcName)
+	o.Preset = PresetDebug
+	o.SampleAll = true
+	o.MaxSpanDuration = 0
+	o.Anomaly.Enabled = false
+	return o
+}
@@ -0,0 +1,225 @@
+// Package oteltest sets up the telemetry stack with in-memory exporters, so
+// services built on it can assert their instrumentation in unit tests.
+//
+// A Stack registers itself as the global tracer and meter provider and
+// logger, so tests using one must not run in parallel.
+package oteltest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"go-otel/telemetry"
+)
+
+// Stack is the telemetry stack with every span, metric and log line kept in
+// memory.
+type Stack struct {
+	*telemetry.Telemetry
+
+	spans  *tracetest.InMemoryExporter
+	reader *sdkmetric.ManualReader
+	logs   *logBuffer
+}
+
+// New sets up a Stack for the test from the prod defaults, sampling every
+// trace, with opts applied and the exporters replaced by in-memory ones. It
+// is shut down when the test ends.
+func New(tb testing.TB, opts ...telemetry.Option) *Stack {
+	tb.Helper()
+
+	s := &Stack{
+		spans:  tracetest.NewInMemoryExporter(),
+		reader: sdkmetric.NewManualReader(),
+		logs:   &logBuffer{},
+	}
+	defaults := []telemetry.Option{
+		telemetry.WithSampleRatio(1),
+		func(o *telemetry.Options) { o.Drift.Interval = -1 },
+	}
+	o, err := telemetry.NewOptions("oteltest", append(defaults, opts...)...)
+	if err != nil {
+		tb.Fatalf("oteltest: %v", err)
+	}
+	o.Apply(telemetry.WithoutTraces(), telemetry.WithoutMetrics())
+	// Spans are exported as they end, so they can be asserted right away.
+	o.SpanProcessors = append(o.SpanProcessors, sdktrace.NewSimpleSpanProcessor(s.spans))
+	o.MetricReaders = append(o.MetricReaders, s.reader)
+	o.Log.Format = telemetry.LogJSON
+	o.Log.Output = s.logs
+
+	s.Telemetry, err = telemetry.Setup(context.Background(), o)
+	if err != nil {
+		tb.Fatalf("oteltest: %v", err)
+	}
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			tb.Errorf("oteltest: shutdown: %v", err)
+		}
+	})
+	return s
+}
+
+// Spans returns the spans ended so far, in the order they ended.
+func (s *Stack) Spans() tracetest.SpanStubs {
+	return s.spans.GetSpans()
+}
+
+// Metrics collects the current metrics.
+func (s *Stack) Metrics(tb testing.TB) metricdata.ResourceMetrics {
+	tb.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := s.reader.Collect(context.Background(), &rm); err != nil {
+		tb.Fatalf("oteltest: collect metrics: %v", err)
+	}
+	return rm
+}
+
+// Logs returns the log lines written so far, decoded.
+func (s *Stack) Logs() []map[string]any {
+	return s.logs.entries()
+}
+
+// Reset forgets the spans and log lines recorded so far. Metrics are
+// cumulative and cannot be reset.
+func (s *Stack) Reset() {
+	s.spans.Reset()
+	s.logs.reset()
+}
+
+// RequireSpan fails the test unless a span named name with every one of
+// attrs has ended, and returns the last such span.
+func (s *Stack) RequireSpan(tb testing.TB, name string, attrs ...attribute.KeyValue) tracetest.SpanStub {
+	tb.Helper()
+	spans := s.Spans()
+	for i := len(spans) - 1; i >= 0; i-- {
+		if spans[i].Name == name && hasAttrs(attribute.NewSet(spans[i].Attributes...), attrs) {
+			return spans[i]
+		}
+	}
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	tb.Fatalf("oteltest: no span %q with %v, got %q", name, attrs, names)
+	return tracetest.SpanStub{}
+}
+
+// RequireCounterValue fails the test unless the counter or up-down counter
+// name adds up to value over the data points with every one of attrs. A
+// counter nothing was added to yet has no data, and counts as zero.
+func (s *Stack) RequireCounterValue(tb testing.TB, name string, value float64, attrs ...attribute.KeyValue) {
+	tb.Helper()
+	m, ok := s.findMetric(tb, name)
+	if !ok {
+		if value != 0 {
+			tb.Fatalf("oteltest: no metric %q", name)
+		}
+		return
+	}
+	var got float64
+	switch data := m.Data.(type) {
+	case metricdata.Sum[int64]:
+		for _, dp := range data.DataPoints {
+			if hasAttrs(dp.Attributes, attrs) {
+				got += float64(dp.Value)
+			}
+		}
+	case metricdata.Sum[float64]:
+		for _, dp := range data.DataPoints {
+			if hasAttrs(dp.Attributes, attrs) {
+				got += dp.Value
+			}
+		}
+	default:
+		tb.Fatalf("oteltest: metric %q is a %T, not a counter", name, m.Data)
+	}
+	if got != value {
+		tb.Fatalf("oteltest: counter %q with %v is %g, want %g", name, attrs, got, value)
+	}
+}
+
+// RequireLog fails the test unless a line with level and message has been
+// logged, and returns the last such line.
+func (s *Stack) RequireLog(tb testing.TB, level, message string) map[string]any {
+	tb.Helper()
+	entries := s.Logs()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i]["level"] == level && entries[i]["message"] == message {
+			return entries[i]
+		}
+	}
+	tb.Fatalf("oteltest: no %s log %q among %d lines", level, message, len(entries))
+	return nil
+}
+
+func (s *Stack) findMetric(tb testing.TB, name string) (metricdata.Metrics, bool) {
+	tb.Helper()
+	rm := s.Metrics(tb)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m, true
+			}
+		}
+	}
+	return metricdata.Metrics{}, false
+}
+
+// hasAttrs reports whether set holds every one of attrs.
+func hasAttrs(set attribute.Set, attrs []attribute.KeyValue) bool {
+	for _, kv := range attrs {
+		if v, ok := set.Value(kv.Key); !ok || v != kv.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// logBuffer keeps the log lines written to it.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+func (b *logBuffer) entries() []map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []map[string]any
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			e = map[string]any{"message": fmt.Sprintf("undecodable log line: %s", sc.Text())}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
@@ -0,0 +1,155 @@
+package oteltest_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"go-otel/telemetry/oteltest"
+)
+
+// recorder is a testing.TB recording a fatal failure instead of failing
+// the test, to check the helpers fail when they should.
+type recorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...any) {
+	r.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+// fails reports whether fn fails the test, running it on a goroutine of
+// its own as Fatalf stops it.
+func fails(t *testing.T, fn func(tb testing.TB)) bool {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(r)
+	}()
+	<-done
+	return r.failure != ""
+}
+
+func TestRequireSpan(t *testing.T) {
+	s := oteltest.New(t)
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.SetAttributes(attribute.String("job", "a"))
+	span.End()
+	_, span = otel.Tracer("test").Start(context.Background(), "work")
+	span.SetAttributes(attribute.String("job", "b"))
+	span.End()
+
+	got := s.RequireSpan(t, "work", attribute.String("job", "a"))
+	if !got.SpanContext.Equal(s.Spans()[0].SpanContext) {
+		t.Errorf("RequireSpan returned %s, want the first span", got.SpanContext.SpanID())
+	}
+	got = s.RequireSpan(t, "work")
+	if !got.SpanContext.Equal(s.Spans()[1].SpanContext) {
+		t.Errorf("RequireSpan returned %s, want the last matching span", got.SpanContext.SpanID())
+	}
+
+	for _, tc := range []struct {
+		name  string
+		attrs []attribute.KeyValue
+	}{
+		{"other", nil},
+		{"work", []attribute.KeyValue{attribute.String("job", "c")}},
+		{"work", []attribute.KeyValue{attribute.Int("job", 1)}},
+	} {
+		if !fails(t, func(tb testing.TB) { s.RequireSpan(tb, tc.name, tc.attrs...) }) {
+			t.Errorf("RequireSpan(%q, %v) passed, want a failure", tc.name, tc.attrs)
+		}
+	}
+}
+
+func TestRequireCounterValue(t *testing.T) {
+	s := oteltest.New(t)
+	counter, err := otel.Meter("test").Int64Counter("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	counter.Add(ctx, 2, metricAttrs("a", "ok"))
+	counter.Add(ctx, 3, metricAttrs("b", "ok"))
+	counter.Add(ctx, 1, metricAttrs("b", "error"))
+
+	s.RequireCounterValue(t, "jobs", 6)
+	s.RequireCounterValue(t, "jobs", 5, attribute.String("result", "ok"))
+	s.RequireCounterValue(t, "jobs", 1, attribute.String("job", "b"), attribute.String("result", "error"))
+	s.RequireCounterValue(t, "jobs", 0, attribute.String("job", "c"))
+	// Nothing added yet counts as zero.
+	s.RequireCounterValue(t, "never.added", 0)
+
+	for _, tc := range []struct {
+		name  string
+		value float64
+		attrs []attribute.KeyValue
+	}{
+		{"jobs", 7, nil},
+		{"jobs", 2, []attribute.KeyValue{attribute.String("job", "b")}},
+		{"never.added", 1, nil},
+	} {
+		if !fails(t, func(tb testing.TB) { s.RequireCounterValue(tb, tc.name, tc.value, tc.attrs...) }) {
+			t.Errorf("RequireCounterValue(%q, %g, %v) passed, want a failure", tc.name, tc.value, tc.attrs)
+		}
+	}
+}
+
+func TestRequireCounterValueNotACounter(t *testing.T) {
+	s := oteltest.New(t)
+	h, err := otel.Meter("test").Float64Histogram("latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Record(context.Background(), 1)
+
+	if !fails(t, func(tb testing.TB) { s.RequireCounterValue(tb, "latency", 1) }) {
+		t.Error("RequireCounterValue on a histogram passed, want a failure")
+	}
+}
+
+func TestRequireLog(t *testing.T) {
+	s := oteltest.New(t)
+	log.Info().Str("n", "1").Msg("started")
+	log.Warn().Msg("slow")
+	log.Info().Str("n", "2").Msg("started")
+
+	if got := s.RequireLog(t, "info", "started"); got["n"] != "2" {
+		t.Errorf("RequireLog returned n=%v, want the last line, n=2", got["n"])
+	}
+	s.RequireLog(t, "warn", "slow")
+
+	if !fails(t, func(tb testing.TB) { s.RequireLog(tb, "error", "slow") }) {
+		t.Error("RequireLog of a missing line passed, want a failure")
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := oteltest.New(t)
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	log.Info().Msg("done")
+
+	s.Reset()
+	if n := len(s.Spans()); n != 0 {
+		t.Errorf("%d spans after Reset, want none", n)
+	}
+	if n := len(s.Logs()); n != 0 {
+		t.Errorf("%d log lines after Reset, want none", n)
+	}
+}
+
+func metricAttrs(job, result string) metric.AddOption {
+	return metric.WithAttributes(attribute.String("job", job), attribute.String("result", result))
+}
@@ -0,0 +1,101 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// dialOTLP dials the OTLP receiver at endpoint, observing the state of the
+// connection and the records the receiver rejects, with the credentials
+// of Options.Credentials. The connection is
+// established right away rather than by the first export, so a slow or
+// unreachable collector shows in telemetry.exporter.grpc.state from
+// startup.
+func dialOTLP(ctx context.Context, endpoint string, insecureConn bool, attrs ...attribute.KeyValue) (*grpc.ClientConn, metric.Registration, error) {
+	creds := exporterCreds.transport()
+	if insecureConn {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.DialContext(ctx, endpoint,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(exporterCreds),
+		grpc.WithUserAgent(instrumentationName),
+		grpc.WithUnaryInterceptor(newPartialSuccessObserver(attrs...).intercept),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.Connect()
+	reg, err := observeConnState(conn, attrs...)
+	if err != nil {
+		return nil, nil, errors.Join(err, conn.Close())
+	}
+	return conn, reg, nil
+}
+
+// newOTLPClient dials the OTLP receiver described by eo. The connection is
+// dialed here rather than by otlptracegrpc so its state can be observed.
+func newOTLPClient(ctx context.Context, eo TraceExporterOptions, attrs ...attribute.KeyValue) (otlptrace.Client, error) {
+	conn, reg, err := dialOTLP(ctx, eo.Endpoint, eo.Insecure, attrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithGRPCConn(conn)}
+	if eo.Timeout > 0 {
+		clientOpts = append(clientOpts, otlptracegrpc.WithTimeout(eo.Timeout))
+	}
+	if r := eo.Retry; r != nil {
+		clientOpts = append(clientOpts, otlptracegrpc.WithRetry(otlptracegrpc.RetryConfig{
+			Enabled:         r.Enabled,
+			InitialInterval: r.InitialInterval,
+			MaxInterval:     r.MaxInterval,
+			MaxElapsedTime:  r.MaxElapsedTime,
+		}))
+	}
+
+	var client otlptrace.Client = &connClient{
+		Client: otlptracegrpc.NewClient(clientOpts...),
+		conn:   conn,
+		reg:    reg,
+	}
+	if eo.Breaker != nil {
+		breaker, err := newExporterBreaker(*eo.Breaker, attrs...)
+		if err != nil {
+			return nil, errors.Join(err, client.Stop(ctx))
+		}
+		client = &breakerClient{Client: client, breaker: breaker}
+	}
+	if eo.Spool == nil {
+		return client, nil
+	}
+	spool, err := newSpoolClient(client, *eo.Spool)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("spool: %w", err), client.Stop(ctx))
+	}
+	return spool, nil
+}
+
+// connClient closes the connection it was given once the client stops,
+// since otlptracegrpc leaves connections passed to it open.
+type connClient struct {
+	otlptrace.Client
+	conn *grpc.ClientConn
+	reg  metric.Registration
+}
+
+func (c *connClient) Stop(ctx context.Context) error {
+	return errors.Join(
+		c.Client.Stop(ctx),
+		c.reg.Unregister(),
+		c.conn.Close(),
+	)
+}
@@ -0,0 +1,85 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc"
+)
+
+// temporalitySelector returns the temporality of each instrument kind for
+// the preference t, following the OpenTelemetry specification.
+func temporalitySelector(t Temporality) sdkmetric.TemporalitySelector {
+	switch t {
+	case TemporalityDelta:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindHistogram,
+				sdkmetric.InstrumentKindObservableCounter:
+				return metricdata.DeltaTemporality
+			default:
+				return metricdata.CumulativeTemporality
+			}
+		}
+	case TemporalityLowMemory:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindHistogram:
+				return metricdata.DeltaTemporality
+			default:
+				return metricdata.CumulativeTemporality
+			}
+		}
+	default:
+		return sdkmetric.DefaultTemporalitySelector
+	}
+}
+
+// newOTLPMetricReader periodically exports to the OTLP receiver described
+// by eo, over a connection observed like those of trace exporters.
+func newOTLPMetricReader(ctx context.Context, eo MetricExporterOptions, attrs ...attribute.KeyValue) (sdkmetric.Reader, error) {
+	conn, reg, err := dialOTLP(ctx, eo.Endpoint, eo.Insecure, attrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	exporterOpts := []otlpmetricgrpc.Option{
+		otlpmetricgrpc.WithGRPCConn(conn),
+		otlpmetricgrpc.WithTemporalitySelector(temporalitySelector(eo.Temporality)),
+		otlpmetricgrpc.WithAggregationSelector(aggregationSelector(eo)),
+	}
+	if eo.Timeout > 0 {
+		exporterOpts = append(exporterOpts, otlpmetricgrpc.WithTimeout(eo.Timeout))
+	}
+	exporter, err := otlpmetricgrpc.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, errors.Join(err, reg.Unregister(), conn.Close())
+	}
+
+	var readerOpts []sdkmetric.PeriodicReaderOption
+	if eo.Interval > 0 {
+		readerOpts = append(readerOpts, sdkmetric.WithInterval(eo.Interval))
+	}
+	return sdkmetric.NewPeriodicReader(&connMetricExporter{Exporter: exporter, conn: conn, reg: reg}, readerOpts...), nil
+}
+
+// connMetricExporter closes the connection it was given once the exporter
+// shuts down, since otlpmetricgrpc leaves connections passed to it open.
+type connMetricExporter struct {
+	sdkmetric.Exporter
+	conn *grpc.ClientConn
+	reg  metric.Registration
+}
+
+func (e *connMetricExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(
+		e.Exporter.Shutdown(ctx),
+		e.reg.Unregister(),
+		e.conn.Close(),
+	)
+}
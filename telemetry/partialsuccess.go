@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+)
+
+// partialSuccessObserver reads the partial success of the OTLP export
+// responses of one exporter: an export the collector accepted while
+// rejecting some of its records, e.g. spans with invalid IDs or data points
+// over a limit. Rejected records are counted in telemetry.exporter.rejected
+// by signal, and the collector's reason is logged, at most every
+// dropWarnInterval.
+type partialSuccessObserver struct {
+	attrs     []attribute.KeyValue
+	rejected  metric.Int64Counter
+	lastWarn  atomic.Int64 // unix nanos
+	sinceWarn atomic.Int64
+}
+
+func newPartialSuccessObserver(attrs ...attribute.KeyValue) *partialSuccessObserver {
+	o := &partialSuccessObserver{attrs: attrs}
+	o.rejected, _ = selfMeter().Int64Counter(
+		"telemetry.exporter.rejected",
+		metric.WithDescription("Records the collector rejected from exports it otherwise accepted, by signal."),
+	)
+	return o
+}
+
+// intercept is a gRPC unary client interceptor observing export responses.
+// It clears the partial success it handled, so the OTLP client does not
+// report it again as an SDK error.
+func (o *partialSuccessObserver) intercept(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+		return err
+	}
+	var (
+		signal   string
+		rejected int64
+		msg      string
+	)
+	switch r := reply.(type) {
+	case *coltracepb.ExportTraceServiceResponse:
+		if r.PartialSuccess == nil {
+			return nil
+		}
+		signal, rejected, msg = "traces", r.PartialSuccess.GetRejectedSpans(), r.PartialSuccess.GetErrorMessage()
+		r.PartialSuccess = nil
+	case *colmetricspb.ExportMetricsServiceResponse:
+		if r.PartialSuccess == nil {
+			return nil
+		}
+		signal, rejected, msg = "metrics", r.PartialSuccess.GetRejectedDataPoints(), r.PartialSuccess.GetErrorMessage()
+		r.PartialSuccess = nil
+	case *collogspb.ExportLogsServiceResponse:
+		if r.PartialSuccess == nil {
+			return nil
+		}
+		signal, rejected, msg = "logs", r.PartialSuccess.GetRejectedLogRecords(), r.PartialSuccess.GetErrorMessage()
+		r.PartialSuccess = nil
+	default:
+		return nil
+	}
+	o.observe(ctx, signal, rejected, msg)
+	return nil
+}
+
+// observe records a partial success. The collector may send a message
+// without rejecting anything, as a warning.
+func (o *partialSuccessObserver) observe(ctx context.Context, signal string, rejected int64, msg string) {
+	if rejected == 0 && msg == "" {
+		return
+	}
+	if rejected > 0 {
+		o.rejected.Add(ctx, rejected, metric.WithAttributes(withAttrs(o.attrs, attribute.String("signal", signal))...))
+	}
+
+	n := o.sinceWarn.Add(rejected)
+	now := time.Now().UnixNano()
+	last := o.lastWarn.Load()
+	if now-last < int64(dropWarnInterval) || !o.lastWarn.CompareAndSwap(last, now) {
+		return
+	}
+	o.sinceWarn.Add(-n)
+	ev := log.Warn()
+	for _, kv := range o.attrs {
+		ev = ev.Str(strings.ReplaceAll(string(kv.Key), ".", "_"), kv.Value.Emit())
+	}
+	ev.Str("signal", signal).
+		Int64("rejected", n).
+		Str("reason", msg).
+		Msg("collector rejected records")
+}
@@ -0,0 +1,229 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// PodMetadataOptions reads the labels and annotations of the pod from
+// files mounted with the Kubernetes downward API, into resource and span
+// attributes.
+type PodMetadataOptions struct {
+	// LabelsPath is the file of the pod labels, e.g. /etc/podinfo/labels.
+	LabelsPath string
+	// AnnotationsPath is the file of the pod annotations. Annotations can
+	// be large, so only those mapped in Keys are read.
+	AnnotationsPath string
+	// Keys maps label and annotation keys to attribute names. Empty
+	// means every label, as k8s.pod.label.<key>.
+	Keys map[string]string
+	// Interval is how often the files are checked for changes. Zero
+	// means 10s.
+	Interval time.Duration
+}
+
+func (o PodMetadataOptions) validate() error {
+	if o.Interval < 0 {
+		return fmt.Errorf("pod metadata interval must not be negative, got %s", o.Interval)
+	}
+	for key, name := range o.Keys {
+		if key == "" || name == "" {
+			return fmt.Errorf("pod metadata key %q must map to an attribute name, got %q", key, name)
+		}
+	}
+	return nil
+}
+
+// podMetadata keeps the attributes read from the downward API files up to
+// date. Kubernetes updates the files when labels or annotations change, so
+// the resource keeps the values of startup and spans get the current
+// ones.
+type podMetadata struct {
+	opts  PodMetadataOptions
+	attrs atomic.Pointer[[]attribute.KeyValue]
+	hash  [sha256.Size]byte
+	stop  context.CancelFunc
+	done  chan struct{}
+}
+
+// readPodMetadata returns the attributes of the files of opts. Missing
+// files, as outside Kubernetes, have none.
+func readPodMetadata(opts PodMetadataOptions) ([]attribute.KeyValue, [sha256.Size]byte, error) {
+	h := sha256.New()
+	var attrs []attribute.KeyValue
+	for _, f := range []struct {
+		path, prefix string
+		all          bool
+	}{
+		{opts.LabelsPath, "k8s.pod.label.", len(opts.Keys) == 0},
+		{opts.AnnotationsPath, "k8s.pod.annotation.", false},
+	} {
+		if f.path == "" {
+			continue
+		}
+		b, err := os.ReadFile(f.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, [sha256.Size]byte{}, fmt.Errorf("pod metadata: %w", err)
+		}
+		h.Write(b)
+		values, err := parseDownwardAPI(b)
+		if err != nil {
+			return nil, [sha256.Size]byte{}, fmt.Errorf("pod metadata %s: %w", f.path, err)
+		}
+		for key, value := range values {
+			name, ok := opts.Keys[key]
+			switch {
+			case ok:
+			case f.all:
+				name = f.prefix + key
+			default:
+				continue
+			}
+			attrs = append(attrs, attribute.String(name, value))
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return attrs, sum, nil
+}
+
+// parseDownwardAPI parses the key="value" lines the downward API writes,
+// values quoted as Go strings.
+func parseDownwardAPI(b []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		key, quoted, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not key=\"value\"", line)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			value = quoted
+		}
+		values[key] = value
+	}
+	return values, sc.Err()
+}
+
+// startPodMetadata reads the files of opts and watches them for changes,
+// or returns nil if there are none.
+func startPodMetadata(opts PodMetadataOptions) (*podMetadata, error) {
+	if opts.LabelsPath == "" && opts.AnnotationsPath == "" {
+		return nil, nil
+	}
+	if opts.Interval == 0 {
+		opts.Interval = 10 * time.Second
+	}
+	attrs, hash, err := readPodMetadata(opts)
+	if err != nil {
+		return nil, err
+	}
+	p := &podMetadata{opts: opts, hash: hash, done: make(chan struct{})}
+	p.attrs.Store(&attrs)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stop = cancel
+	go p.run(ctx)
+	return p, nil
+}
+
+// attributes returns the current attributes, nil-safe.
+func (p *podMetadata) attributes() []attribute.KeyValue {
+	if p == nil {
+		return nil
+	}
+	return *p.attrs.Load()
+}
+
+func (p *podMetadata) run(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.check()
+		}
+	}
+}
+
+// check rereads the files when their content changed. The files are
+// compared by content, as the downward API swaps a symlink rather than
+// writing them.
+func (p *podMetadata) check() {
+	attrs, hash, err := readPodMetadata(p.opts)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to refresh pod metadata, keeping the current attributes")
+		return
+	}
+	if hash == p.hash {
+		return
+	}
+	p.hash = hash
+	p.attrs.Store(&attrs)
+	log.Info().Int("attributes", len(attrs)).Msg("pod metadata changed")
+}
+
+func (p *podMetadata) shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	p.stop()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// podMetadataProcessor stamps spans with the current pod attributes when
+// they start. Attributes the span was started with are kept.
+type podMetadataProcessor struct {
+	pod *podMetadata
+}
+
+func (p podMetadataProcessor) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	attrs := p.pod.attributes()
+	if len(attrs) == 0 {
+		return
+	}
+	set := make(map[attribute.Key]bool, len(s.Attributes()))
+	for _, kv := range s.Attributes() {
+		set[kv.Key] = true
+	}
+	for _, kv := range attrs {
+		if !set[kv.Key] {
+			s.SetAttributes(kv)
+		}
+	}
+}
+
+func (p podMetadataProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (p podMetadataProcessor) Shutdown(context.Context) error   { return nil }
+func (p podMetadataProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,95 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// SamplingPriorityOptions lets gateways and CDNs in front of the service
+// force the sampling decision of new traces.
+type SamplingPriorityOptions struct {
+	// Headers are checked in order and the first one present wins. Values
+	// are integers, positive to keep the trace and zero or negative to drop
+	// it, as with X-Datadog-Sampling-Priority, or booleans.
+	Headers []string
+}
+
+type priorityKey struct{}
+
+// withSamplingPriority returns a copy of ctx carrying an upstream priority.
+func withSamplingPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func samplingPriority(ctx context.Context) (int, bool) {
+	p, ok := ctx.Value(priorityKey{}).(int)
+	return p, ok
+}
+
+func parsePriority(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	if p, err := strconv.Atoi(s); err == nil {
+		return p, true
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		if b {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// SamplingPriority returns middleware reading the configured priority
+// headers, so the sampler honors them for traces started by the request. It
+// must run before the tracing middleware, and is a no-op without headers.
+func (t *Telemetry) SamplingPriority() func(http.Handler) http.Handler {
+	headers := t.opts.SamplingPriority.Headers
+	return func(next http.Handler) http.Handler {
+		if len(headers) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, h := range headers {
+				if p, ok := parsePriority(r.Header.Get(h)); ok {
+					r = r.WithContext(withSamplingPriority(r.Context(), p))
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// prioritySampler keeps or drops traces carrying an upstream priority and
+// defers to the wrapped sampler for the rest. It only sees root spans, as
+// it sits behind the parent based sampler.
+type prioritySampler struct {
+	trace.Sampler
+}
+
+func (s prioritySampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	priority, ok := samplingPriority(p.ParentContext)
+	if !ok {
+		return s.Sampler.ShouldSample(p)
+	}
+	decision := trace.Drop
+	if priority > 0 {
+		decision = trace.RecordAndSample
+	}
+	return trace.SamplingResult{
+		Decision:   decision,
+		Attributes: []attribute.KeyValue{attribute.Int("sampling.priority", priority)},
+		Tracestate: oteltrace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s prioritySampler) Description() string {
+	return "PrioritySampler{" + s.Sampler.Description() + "}"
+}
@@ -0,0 +1,127 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// envCarrier carries trace context in environment variables, the
+// propagation fields upper-cased: TRACEPARENT, TRACESTATE and BAGGAGE, as
+// CI systems and the otel-cli set them.
+type envCarrier struct {
+	lookup func(string) (string, bool)
+	env    []string
+}
+
+func (c *envCarrier) Get(key string) string {
+	v, _ := c.lookup(strings.ToUpper(key))
+	return v
+}
+
+func (c *envCarrier) Set(key, value string) {
+	c.env = append(c.env, strings.ToUpper(key)+"="+value)
+}
+
+func (c *envCarrier) Keys() []string {
+	return otel.GetTextMapPropagator().Fields()
+}
+
+// ExtractEnv returns ctx with the trace context and baggage of the
+// TRACEPARENT, TRACESTATE and BAGGAGE environment variables, so a CLI
+// invocation or job started by a traced process joins its trace. Without
+// them ctx is returned as is.
+func ExtractEnv(ctx context.Context) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, &envCarrier{lookup: os.LookupEnv})
+}
+
+// InjectEnv returns env with the trace context and baggage of ctx added as
+// TRACEPARENT, TRACESTATE and BAGGAGE, for the environment of a child
+// process, e.g. exec.Cmd.Env. Variables of those names already in env are
+// replaced.
+func InjectEnv(ctx context.Context, env []string) []string {
+	c := &envCarrier{lookup: func(string) (string, bool) { return "", false }}
+	otel.GetTextMapPropagator().Inject(ctx, c)
+	if len(c.env) == 0 {
+		return env
+	}
+	out := make([]string, 0, len(env)+len(c.env))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		replaced := false
+		for _, field := range otel.GetTextMapPropagator().Fields() {
+			if strings.EqualFold(name, field) {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			out = append(out, kv)
+		}
+	}
+	return append(out, c.env...)
+}
+
+// messageCarrier carries trace context in message headers, matching their
+// names regardless of case, as brokers and client libraries disagree on it.
+type messageCarrier map[string]string
+
+func (c messageCarrier) Get(key string) string {
+	if v, ok := c[key]; ok {
+		return v
+	}
+	for k, v := range c {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c messageCarrier) Set(key, value string) {
+	for k := range c {
+		if strings.EqualFold(k, key) {
+			delete(c, k)
+		}
+	}
+	c[key] = value
+}
+
+func (c messageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ExtractMessage returns ctx with the trace context and baggage carried in
+// the headers of a message, for consumers of queues and streams.
+func ExtractMessage(ctx context.Context, headers map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, messageCarrier(headers))
+}
+
+// InjectMessage sets the trace context and baggage of ctx in the headers
+// of a message before it is published, so its consumer can extract them.
+func InjectMessage(ctx context.Context, headers map[string]string) {
+	otel.GetTextMapPropagator().Inject(ctx, messageCarrier(headers))
+}
+
+// StartConsumerSpan starts the consumer span of a message received from
+// source, a queue or topic, as a child of the span that published it when
+// its headers carry one. Work done for the message belongs in the returned
+// context.
+func StartConsumerSpan(ctx context.Context, source string, headers map[string]string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	opts = append([]trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", source),
+			attribute.String("messaging.operation", "process"),
+		),
+	}, opts...)
+	return otel.Tracer(instrumentationName).Start(ExtractMessage(ctx, headers), source+" process", opts...)
+}
@@ -0,0 +1,264 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// pushReader is a prometheus exporter on a registry of its own, whose
+// metrics are pushed every interval, on ForceFlush and on Shutdown, for
+// jobs that do not live long enough to be scraped.
+type pushReader struct {
+	metric.Reader
+	gatherer promclient.Gatherer
+	send     func(ctx context.Context, g promclient.Gatherer) error
+	breaker  *exporterBreaker
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newPushReader(eo MetricExporterOptions) (metric.Reader, error) {
+	reg := promclient.NewRegistry()
+	promOpts := []prometheus.Option{prometheus.WithRegisterer(reg)}
+	if eo.LegacyUnits {
+		promOpts = append(promOpts, prometheus.WithoutUnits())
+	}
+	exporter, err := prometheus.New(promOpts...)
+	if err != nil {
+		return nil, err
+	}
+	r := &pushReader{
+		Reader:   exporter,
+		gatherer: reg,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if !eo.LegacyUnits {
+		r.gatherer = NewUnitGatherer(reg)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch eo.Kind {
+	case MetricExporterPushgateway:
+		r.send = func(ctx context.Context, g promclient.Gatherer) error {
+			return push.New(eo.URL, eo.Job).Client(client).Gatherer(g).PushContext(ctx)
+		}
+	case MetricExporterRemoteWrite:
+		r.send = func(ctx context.Context, g promclient.Gatherer) error {
+			return remoteWrite(ctx, client, eo.URL, eo.Job, g)
+		}
+	}
+
+	if eo.Breaker != nil {
+		r.breaker, err = newExporterBreaker(*eo.Breaker, attribute.String("exporter.kind", string(eo.Kind)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if eo.Interval > 0 {
+		go r.run(eo.Interval)
+	} else {
+		close(r.done)
+	}
+	return r, nil
+}
+
+func (r *pushReader) run(interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := r.ForceFlush(ctx); err != nil {
+				otel.Handle(err)
+			}
+			cancel()
+		}
+	}
+}
+
+// ForceFlush pushes the metrics recorded so far.
+func (r *pushReader) ForceFlush(ctx context.Context) error {
+	send := func(ctx context.Context) error { return r.send(ctx, r.gatherer) }
+	var err error
+	if r.breaker != nil {
+		err = r.breaker.do(ctx, send)
+	} else {
+		err = send(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("push metrics: %w", err)
+	}
+	return nil
+}
+
+// Shutdown pushes the final metrics before shutting the exporter down.
+func (r *pushReader) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+	err := r.ForceFlush(ctx)
+	if serr := r.Reader.Shutdown(ctx); err == nil {
+		err = serr
+	}
+	if r.breaker != nil {
+		if uerr := r.breaker.reg.Unregister(); err == nil {
+			err = uerr
+		}
+	}
+	return err
+}
+
+// remoteWrite sends the gathered metrics to a Prometheus remote-write
+// endpoint, with a job label.
+func remoteWrite(ctx context.Context, client *http.Client, url, job string, g promclient.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	body := snappyEncode(encodeWriteRequest(families, job, time.Now()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+type promLabel struct {
+	name, value string
+}
+
+// encodeWriteRequest encodes families as a remote-write WriteRequest
+// protobuf, flattening histograms and summaries into their series.
+func encodeWriteRequest(families []*dto.MetricFamily, job string, now time.Time) []byte {
+	ts := now.UnixMilli()
+	var b []byte
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			labels := []promLabel{{"job", job}}
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() != "job" {
+					labels = append(labels, promLabel{lp.GetName(), lp.GetValue()})
+				}
+			}
+			series := func(suffix string, value float64, extra ...promLabel) {
+				ls := append([]promLabel{{"__name__", name + suffix}}, labels...)
+				b = appendTimeSeries(b, append(ls, extra...), value, ts)
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				series("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				series("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				series("", m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, bk := range h.GetBucket() {
+					series("_bucket", float64(bk.GetCumulativeCount()), promLabel{"le", formatFloat(bk.GetUpperBound())})
+				}
+				series("_bucket", float64(h.GetSampleCount()), promLabel{"le", "+Inf"})
+				series("_sum", h.GetSampleSum())
+				series("_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					series("", q.GetValue(), promLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				series("_sum", s.GetSampleSum())
+				series("_count", float64(s.GetSampleCount()))
+			}
+		}
+	}
+	return b
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// appendTimeSeries appends a TimeSeries of one sample, field 1 of
+// WriteRequest. Receivers require labels sorted by name.
+func appendTimeSeries(b []byte, labels []promLabel, value float64, ts int64) []byte {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	var s []byte
+	for _, l := range labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.value)
+		s = protowire.AppendTag(s, 1, protowire.BytesType)
+		s = protowire.AppendBytes(s, lb)
+	}
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(ts))
+	s = protowire.AppendTag(s, 2, protowire.BytesType)
+	s = protowire.AppendBytes(s, sample)
+
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, s)
+}
+
+// snappyEncode frames src as a snappy block of literals. Remote write
+// requires snappy framing but not compression, and metric batches are
+// small enough for the size not to matter.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/(1<<16)*3+16), uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), 1<<16)
+		switch l := n - 1; {
+		case l < 60:
+			dst = append(dst, byte(l)<<2)
+		case l < 1<<8:
+			dst = append(dst, 60<<2, byte(l))
+		default:
+			dst = append(dst, 61<<2, byte(l), byte(l>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
@@ -0,0 +1,342 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/redis"
+)
+
+// Rate limit keys.
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByAPIKey = "api-key"
+)
+
+// RateLimitOptions configures RateLimit.
+type RateLimitOptions struct {
+	// Rate is the sustained number of requests per second allowed per
+	// client. Zero disables rate limiting.
+	Rate float64
+	// Burst is the number of requests a client may send at once. Zero
+	// means Rate rounded up.
+	Burst int
+	// Key identifies clients: RateLimitByIP, the default, or
+	// RateLimitByAPIKey. Requests without a valid API key are limited by
+	// IP, so clients cannot get fresh buckets by making keys up.
+	Key string
+	// Header carries the API key. Empty means X-API-Key.
+	Header string
+	// ValidKey reports whether key is an API key of a client, e.g. by
+	// looking it up where the API authenticates them. With
+	// RateLimitByAPIKey, it or KeysFile is required.
+	ValidKey func(key string) bool
+	// KeysFile lists the API keys of clients, one per line, when ValidKey
+	// is nil. It is read once, by Setup, which sets ValidKey from it.
+	KeysFile string
+
+	// RedisURL, redis://[:password@]host:port[/db], keeps the buckets in
+	// Redis, so the limit holds across replicas. Empty limits each
+	// instance on its own.
+	RedisURL string
+	// RedisTimeout bounds each check against Redis. Zero means 50ms.
+	RedisTimeout time.Duration
+	// Replicas is the number of instances sharing the limit. While Redis
+	// is unreachable, each limits clients locally to its share of the
+	// rate and burst. Zero means 1.
+	Replicas int
+}
+
+// keyHeader returns the header carrying API keys.
+func (o RateLimitOptions) keyHeader() string {
+	if o.Header == "" {
+		return "X-API-Key"
+	}
+	return o.Header
+}
+
+func (o RateLimitOptions) validate() error {
+	switch o.Key {
+	case "", RateLimitByIP, RateLimitByAPIKey:
+	default:
+		return fmt.Errorf("unknown rate limit key %q", o.Key)
+	}
+	if o.Rate < 0 || o.Burst < 0 {
+		return fmt.Errorf("rate limit rate and burst must not be negative, got %g and %d", o.Rate, o.Burst)
+	}
+	if o.RedisTimeout < 0 || o.Replicas < 0 {
+		return fmt.Errorf("rate limit redis timeout and replicas must not be negative, got %s and %d", o.RedisTimeout, o.Replicas)
+	}
+	if o.RedisURL != "" {
+		if _, err := redis.New(o.RedisURL); err != nil {
+			return fmt.Errorf("rate limit: %w", err)
+		}
+	}
+	if o.Key == RateLimitByAPIKey && o.ValidKey == nil && o.KeysFile == "" {
+		return errors.New("rate limiting by API key requires the valid keys, from ValidKey or a keys file")
+	}
+	return nil
+}
+
+// apiKeyID returns the identifier of an API key in bucket names, a hash,
+// so keys do not end up in memory dumps or Redis.
+func apiKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadAPIKeys sets o.ValidKey from the keys of o.KeysFile, unless it is
+// already set.
+func (o *RateLimitOptions) loadAPIKeys() error {
+	if o.Key != RateLimitByAPIKey || o.ValidKey != nil {
+		return nil
+	}
+	ids, err := readAPIKeys(o.KeysFile)
+	if err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+	o.ValidKey = func(key string) bool { return ids[apiKeyID(key)] }
+	return nil
+}
+
+// readAPIKeys returns the IDs of the keys listed in path, one per line,
+// skipping blank lines and # comments.
+func readAPIKeys(path string) (map[string]bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("API keys: %w", err)
+	}
+	ids := make(map[string]bool)
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids[apiKeyID(line)] = true
+	}
+	return ids, nil
+}
+
+// bucket is the token bucket of one client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter holds a token bucket per client.
+type limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// rateDecision is the outcome of taking a token for a request.
+type rateDecision struct {
+	allowed bool
+	// wait is how long until a token is available, when not allowed.
+	wait time.Duration
+	// remaining is the tokens left in the bucket, after the one taken.
+	remaining float64
+	// rate and burst are those of the limit that decided.
+	rate, burst float64
+	// mode is "local" or "distributed".
+	mode string
+}
+
+// allow takes a token from the bucket of key, or reports how long until
+// one is available.
+func (l *limiter) allow(key string, now time.Time) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > time.Minute {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	d := rateDecision{rate: l.rate, burst: l.burst, mode: "local"}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.allowed, d.remaining = true, b.tokens
+		return d
+	}
+	d.wait, d.remaining = time.Duration((1-b.tokens)/l.rate*float64(time.Second)), b.tokens
+	return d
+}
+
+// sweep forgets the clients whose bucket has refilled, as they would start
+// over with a full one anyway. l.mu must be held.
+func (l *limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// utilization returns the number of clients tracked and the largest share
+// of a bucket in use.
+func (l *limiter) utilization(now time.Time) (int, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	highest := 0.0
+	for _, b := range l.buckets {
+		tokens := math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		highest = math.Max(highest, 1-tokens/l.burst)
+	}
+	return len(l.buckets), highest
+}
+
+// rateLimitError is the body of 429 responses.
+type rateLimitError struct {
+	ErrorResponse
+	RetryAfter string `json:"retry_after"`
+}
+
+// RateLimit returns middleware limiting each client, by IP or API key, to
+// Options.RateLimit with a token bucket. Rejected requests get a 429 with
+// Retry-After, a "rate_limited" event on their span and are counted in
+// http.server.rate_limited by route. The rate_limit.clients and
+// rate_limit.utilization gauges show how close clients are to their limit,
+// in the local buckets. With a Redis URL, the buckets are shared by the
+// replicas through Redis, and kept locally only while it is unreachable;
+// rate_limit.coordination.duration measures the checks against Redis. It
+// must run after the tracing middleware.
+//
+// With Options.BudgetHeaders, responses tell clients their limit and what
+// is left of it in X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset, and rate_limit.header.error measures how far the
+// remaining quota announced was from what the client found at its next
+// request.
+func (t *Telemetry) RateLimit() func(http.Handler) http.Handler {
+	opts := t.opts.RateLimit
+	if opts.Key == "" {
+		opts.Key = RateLimitByIP
+	}
+	opts.Header = opts.keyHeader()
+	validKey := opts.ValidKey
+	if validKey == nil {
+		// Set by Setup from the keys file when limiting by API key.
+		validKey = func(string) bool { return false }
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = int(math.Ceil(opts.Rate))
+	}
+	if opts.Rate <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	l := &limiter{rate: opts.Rate, burst: float64(opts.Burst), buckets: make(map[string]*bucket)}
+	allow := func(_ context.Context, key string, now time.Time) rateDecision {
+		return l.allow(key, now)
+	}
+	if opts.RedisURL != "" {
+		l = localShare(opts)
+		// Validated by Setup.
+		d, _ := newDistributedLimiter(opts, t.opts.ServiceName, l)
+		allow = d.allow
+	}
+	proxies, _ := parseTrustedProxies(t.opts.TrustedProxies)
+	var accuracy *quotaAccuracy
+	if t.opts.BudgetHeaders {
+		accuracy = newQuotaAccuracy()
+	}
+
+	rejections, _ := selfMeter().Int64Counter(
+		"http.server.rate_limited",
+		metric.WithDescription("Requests rejected by the rate limiter, by route."),
+	)
+	clients, err := selfMeter().Int64ObservableGauge(
+		"rate_limit.clients",
+		metric.WithDescription("Clients tracked by the rate limiter."),
+	)
+	if err == nil {
+		utilization, err := selfMeter().Float64ObservableGauge(
+			"rate_limit.utilization",
+			metric.WithDescription("Largest share of its rate limit a client has used."),
+		)
+		if err == nil {
+			_, _ = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+				n, u := l.utilization(time.Now())
+				o.ObserveInt64(clients, int64(n))
+				o.ObserveFloat64(utilization, u)
+				return nil
+			}, clients, utilization)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var key, keyType string
+			if apiKey := r.Header.Get(opts.Header); opts.Key == RateLimitByAPIKey && apiKey != "" && validKey(apiKey) {
+				key, keyType = apiKeyID(apiKey), RateLimitByAPIKey
+			} else {
+				addr, _ := clientAddress(r, proxies)
+				key, keyType = addr, RateLimitByIP
+			}
+			now := time.Now()
+			decision := allow(r.Context(), keyType+"/"+key, now)
+			if accuracy != nil {
+				setRateLimitHeaders(w.Header(), decision)
+				accuracy.record(r.Context(), keyType+"/"+key, decision, now)
+			}
+			if decision.allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			wait, mode := decision.wait, decision.mode
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			rejections.Add(r.Context(), 1, metric.WithAttributes(
+				attribute.String("http.route", routeOf(r)),
+				attribute.String("rate_limit.key", keyType),
+				attribute.String("rate_limit.mode", mode),
+			))
+			trace.SpanFromContext(r.Context()).AddEvent("rate_limited", trace.WithAttributes(
+				attribute.String("rate_limit.key", keyType),
+				attribute.String("rate_limit.mode", mode),
+				attribute.Float64("rate_limit.rate", opts.Rate),
+				attribute.Int("rate_limit.burst", opts.Burst),
+				attribute.Int("retry_after", retryAfter),
+			))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			render.Status(r, http.StatusTooManyRequests)
+			render.JSON(w, r, rateLimitError{ErrorResponse: newErrorResponse(r, "rate limit exceeded"), RetryAfter: wait.Round(time.Millisecond).String()})
+		})
+	}
+}
+
+// routeOf returns the route pattern r will be served by, for middleware
+// answering before the router has matched it.
+func routeOf(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.Routes != nil {
+		mctx := chi.NewRouteContext()
+		if rctx.Routes.Match(mctx, r.Method, r.URL.Path) {
+			return mctx.RoutePattern()
+		}
+	}
+	return r.URL.Path
+}
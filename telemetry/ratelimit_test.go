@@ -0,0 +1,82 @@
+package telemetry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newLimiter(rate, burst float64) *limiter {
+	return &limiter{rate: rate, burst: burst, buckets: make(map[string]*bucket)}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	l := newLimiter(1, 3)
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		if d := l.allow("a", now); !d.allowed || d.remaining != float64(3-i) {
+			t.Fatalf("request %d: allowed %t with %g left, want allowed with %d", i, d.allowed, d.remaining, 3-i)
+		}
+	}
+	d := l.allow("a", now)
+	if d.allowed || d.wait != time.Second {
+		t.Fatalf("request over the burst: allowed %t, wait %s, want denied for 1s", d.allowed, d.wait)
+	}
+	if d := l.allow("b", now); !d.allowed {
+		t.Fatal("another client was denied, want a bucket of its own")
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	l := newLimiter(2, 2)
+	now := time.Now()
+	l.allow("a", now)
+	l.allow("a", now)
+
+	if d := l.allow("a", now.Add(250*time.Millisecond)); d.allowed || d.wait != 250*time.Millisecond {
+		t.Fatalf("after 250ms: allowed %t, wait %s, want denied for 250ms", d.allowed, d.wait)
+	}
+	if d := l.allow("a", now.Add(500*time.Millisecond)); !d.allowed {
+		t.Fatal("after 500ms: denied, want the token refilled at 2/s")
+	}
+	// The bucket never holds more than the burst.
+	if d := l.allow("a", now.Add(time.Hour)); !d.allowed || d.remaining != 1 {
+		t.Fatalf("after an hour: allowed %t with %g left, want allowed with 1", d.allowed, d.remaining)
+	}
+}
+
+func TestLimiterUtilization(t *testing.T) {
+	l := newLimiter(1, 4)
+	now := time.Now()
+	l.allow("a", now)
+	l.allow("b", now)
+	l.allow("b", now)
+	if n, u := l.utilization(now); n != 2 || u != 0.5 {
+		t.Fatalf("utilization = %d, %g, want 2 clients, 0.5", n, u)
+	}
+	if _, u := l.utilization(now.Add(time.Minute)); u != 0 {
+		t.Fatalf("utilization a minute later = %g, want 0", u)
+	}
+}
+
+func TestReadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("# staging\nkey-1\n\n  key-2  \n#key-3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	o := RateLimitOptions{Key: RateLimitByAPIKey, KeysFile: path}
+	if err := o.loadAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"key-1": true, "key-2": true, "key-3": false, "# staging": false, "": false} {
+		if got := o.ValidKey(key); got != want {
+			t.Errorf("ValidKey(%q) = %t, want %t", key, got, want)
+		}
+	}
+
+	o = RateLimitOptions{Key: RateLimitByAPIKey, KeysFile: filepath.Join(t.TempDir(), "missing")}
+	if err := o.loadAPIKeys(); err == nil {
+		t.Error("loadAPIKeys of a missing file succeeded, want an error")
+	}
+}
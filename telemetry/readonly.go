@@ -0,0 +1,58 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ReadOnly reports whether mutating requests are rejected.
+func (t *Telemetry) ReadOnly() bool {
+	return t.readOnly.Load()
+}
+
+// SetReadOnly switches read-only mode, in which RejectWrites refuses
+// mutating requests.
+func (t *Telemetry) SetReadOnly(readOnly bool) {
+	t.readOnly.Store(readOnly)
+}
+
+// RejectWrites returns middleware answering requests other than GET, HEAD
+// and OPTIONS with 503 while the service is read-only, for incident
+// containment and failover drills. Reads keep working. The mode is
+// exported as the service.read_only gauge.
+func (t *Telemetry) RejectWrites() func(http.Handler) http.Handler {
+	if gauge, err := selfMeter().Int64ObservableGauge(
+		"service.read_only",
+		metric.WithDescription("1 while mutating requests are rejected."),
+	); err == nil {
+		_, _ = selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+			v := int64(0)
+			if t.ReadOnly() {
+				v = 1
+			}
+			o.ObserveInt64(gauge, v)
+			return nil
+		}, gauge)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if t.ReadOnly() {
+					trace.SpanFromContext(r.Context()).AddEvent("rejected: read-only mode")
+					w.Header().Set("Retry-After", "60")
+					render.Status(r, http.StatusServiceUnavailable)
+					render.JSON(w, r, newErrorResponse(r, "service is read-only"))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
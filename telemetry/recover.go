@@ -0,0 +1,72 @@
+package telemetry
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recoverer returns middleware that turns a panic into a 500 response,
+// recording it as an exception on the request span, counting it in
+// http.server.panics and logging it with its stack. It replaces chi's
+// Recoverer and must run after the tracing middleware and HTTPMetrics, so
+// both see the 500.
+func (t *Telemetry) Recoverer() func(http.Handler) http.Handler {
+	panics, _ := selfMeter().Int64Counter(
+		"http.server.panics",
+		metric.WithDescription("Panics recovered while serving HTTP requests."),
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+				if rvr == http.ErrAbortHandler {
+					// Aborts are how handlers hang up on purpose.
+					panic(rvr)
+				}
+
+				err, ok := rvr.(error)
+				if !ok {
+					err = fmt.Errorf("%v", rvr)
+				}
+				route := routePattern(r)
+				SetRequestError(r.Context(), err)
+
+				span := trace.SpanFromContext(r.Context())
+				span.RecordError(err, trace.WithStackTrace(true))
+				span.SetStatus(codes.Error, "panic: "+err.Error())
+
+				panics.Add(r.Context(), 1, metric.WithAttributes(attribute.String("http.route", route)))
+
+				log.Error().
+					Err(err).
+					Str("http.method", r.Method).
+					Str("http.target", r.URL.Path).
+					Str("http.route", route).
+					Str("request_id", middleware.GetReqID(r.Context())).
+					Str("trace_id", span.SpanContext().TraceID().String()).
+					Bytes("stack", debug.Stack()).
+					Msg("recovered from panic")
+
+				if r.Header.Get("Connection") != "Upgrade" {
+					render.Status(r, http.StatusInternalServerError)
+					render.JSON(w, r, newErrorResponse(r, http.StatusText(http.StatusInternalServerError)))
+				}
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// RedactionRule replaces every match of Pattern with Replacement. The
+// replacement may reference capture groups, e.g. "$1".
+type RedactionRule struct {
+	Name        string
+	Pattern     string
+	Replacement string
+}
+
+// DefaultRedactionRules scrubs emails, card numbers and credentials.
+func DefaultRedactionRules() []RedactionRule {
+	return []RedactionRule{
+		{
+			Name:        "email",
+			Pattern:     `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+			Replacement: "[REDACTED]",
+		},
+		{
+			Name:        "card",
+			Pattern:     `\b(?:\d[ -]?){12,15}\d\b`,
+			Replacement: "[REDACTED]",
+		},
+		{
+			Name:        "token",
+			Pattern:     `(?i)\b(bearer|token|api[_-]?key|secret|password)(\s*[:=]\s*|\s+)[A-Za-z0-9._~+/-]+=*`,
+			Replacement: "$1$2[REDACTED]",
+		},
+	}
+}
+
+// Redactor applies a set of redaction rules. It is shared by the log
+// scrubber and the span redaction processor so both hide the same data.
+// Its rules can be replaced while it is in use.
+type Redactor struct {
+	rules atomic.Pointer[[]compiledRule]
+}
+
+type compiledRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// NewRedactor compiles rules.
+func NewRedactor(rules []RedactionRule) (*Redactor, error) {
+	r := &Redactor{}
+	if err := r.set(rules); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// set replaces the rules of r, unless one does not compile.
+func (r *Redactor) set(rules []RedactionRule) error {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("redaction rule %q: %w", rule.Name, err)
+		}
+		compiled = append(compiled, compiledRule{re: re, replacement: rule.Replacement})
+	}
+	r.rules.Store(&compiled)
+	return nil
+}
+
+// Redact returns s with every rule applied.
+func (r *Redactor) Redact(s string) string {
+	for _, rule := range *r.rules.Load() {
+		s = rule.re.ReplaceAllString(s, rule.replacement)
+	}
+	return s
+}
+
+// redactValue walks a decoded JSON value and redacts every string in it.
+func (r *Redactor) redactValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.Redact(v)
+	case map[string]any:
+		for k, e := range v {
+			v[k] = r.redactValue(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = r.redactValue(e)
+		}
+	}
+	return v
+}
+
+func (r *Redactor) redactAttrs(attrs []attribute.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, len(attrs))
+	for i, kv := range attrs {
+		switch kv.Value.Type() {
+		case attribute.STRING:
+			kv.Value = attribute.StringValue(r.Redact(kv.Value.AsString()))
+		case attribute.STRINGSLICE:
+			ss := kv.Value.AsStringSlice()
+			for j := range ss {
+				ss[j] = r.Redact(ss[j])
+			}
+			kv.Value = attribute.StringSliceValue(ss)
+		}
+		out[i] = kv
+	}
+	return out
+}
+
+// scrubWriter redacts the string values of each JSON log line before
+// passing it on. zerolog hooks cannot rewrite fields that were already
+// encoded, so scrubbing happens at the writer instead.
+type scrubWriter struct {
+	redactor *Redactor
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewScrubWriter wraps out so that every line zerolog writes is redacted.
+func NewScrubWriter(out io.Writer, redactor *Redactor) io.Writer {
+	return &scrubWriter{redactor: redactor, out: out}
+}
+
+func (w *scrubWriter) Write(p []byte) (int, error) {
+	line := p
+	if fields, err := parseLogLine(p); err == nil {
+		for i, f := range fields {
+			switch jsonType(f.value) {
+			case FieldString, FieldObject, FieldArray:
+			default:
+				continue
+			}
+			var v any
+			if err := json.Unmarshal(f.value, &v); err != nil {
+				continue
+			}
+			fields[i].value, _ = json.Marshal(w.redactor.redactValue(v))
+		}
+		line = encodeLogLine(fields)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactProcessor redacts ended spans before handing them to the wrapped
+// processor and its exporter.
+type redactProcessor struct {
+	sdktrace.SpanProcessor
+	redactor *Redactor
+}
+
+// NewRedactProcessor wraps next so that span attributes, event attributes
+// and status descriptions are redacted before export.
+func NewRedactProcessor(next sdktrace.SpanProcessor, redactor *Redactor) sdktrace.SpanProcessor {
+	return &redactProcessor{SpanProcessor: next, redactor: redactor}
+}
+
+func (p *redactProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	p.SpanProcessor.OnEnd(&redactedSpan{ReadOnlySpan: s, redactor: p.redactor})
+}
+
+// redactedSpan overrides the parts of a span that may carry user data.
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+	redactor *Redactor
+}
+
+func (s *redactedSpan) Attributes() []attribute.KeyValue {
+	return s.redactor.redactAttrs(s.ReadOnlySpan.Attributes())
+}
+
+func (s *redactedSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+	out := make([]sdktrace.Event, len(events))
+	for i, e := range events {
+		e.Attributes = s.redactor.redactAttrs(e.Attributes)
+		out[i] = e
+	}
+	return out
+}
+
+func (s *redactedSpan) Status() sdktrace.Status {
+	st := s.ReadOnlySpan.Status()
+	st.Description = s.redactor.Redact(st.Description)
+	return st
+}
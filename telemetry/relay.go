@@ -0,0 +1,426 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RelayOptions configures the relay mode, in which the process accepts
+// OTLP/gRPC from sidecars and other local processes and relays it to the
+// upstream collector, for hosts without an agent.
+type RelayOptions struct {
+	// Listen is the host:port the OTLP/gRPC receiver listens on, e.g.
+	// "127.0.0.1:4317". Empty disables the relay.
+	Listen string
+	// Upstream is the host:port of the collector relayed to. Empty means
+	// the endpoint of the first OTLP trace exporter.
+	Upstream string
+	// Insecure disables TLS towards Upstream. It is ignored when Upstream
+	// is empty, the trace exporter deciding.
+	Insecure bool
+	// BatchSize is the most resources sent in one export. Zero means 512.
+	BatchSize int
+	// Interval is the longest records wait before they are sent. Zero
+	// means one second.
+	Interval time.Duration
+	// MaxQueueSize is the most resources buffered per signal; exports
+	// beyond are refused, for the sender to retry. Zero means 8192.
+	MaxQueueSize int
+}
+
+func (o RelayOptions) validate(traceExporters []TraceExporterOptions) error {
+	if o.Listen == "" {
+		return nil
+	}
+	var errs []error
+	if _, _, err := net.SplitHostPort(o.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("relay listen address %q must be host:port: %w", o.Listen, err))
+	}
+	if o.Upstream != "" {
+		if err := validateEndpoint(o.Upstream); err != nil {
+			errs = append(errs, fmt.Errorf("relay upstream: %w", err))
+		}
+	} else if _, ok := otlpTraceExporter(traceExporters); !ok {
+		errs = append(errs, errors.New("relay needs an upstream or an OTLP trace exporter"))
+	}
+	if o.BatchSize < 0 || o.Interval < 0 || o.MaxQueueSize < 0 {
+		errs = append(errs, errors.New("relay batch size, interval and max queue size must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// otlpTraceExporter returns the first OTLP trace exporter of exporters.
+func otlpTraceExporter(exporters []TraceExporterOptions) (TraceExporterOptions, bool) {
+	for _, eo := range exporters {
+		if eo.Kind == ExporterOTLP {
+			return eo, true
+		}
+	}
+	return TraceExporterOptions{}, false
+}
+
+// relay receives OTLP traces, metrics and logs and relays them upstream in
+// batches, adding the attributes of its own resource the senders did not
+// set, such as host.name, but not service.*, telemetry.sdk.* or
+// process.* ones, which describe the relay itself.
+type relay struct {
+	server *grpc.Server
+	conn   *grpc.ClientConn
+	reg    metric.Registration
+	attrs  []*commonpb.KeyValue
+
+	traces  *relayQueue[*tracepb.ResourceSpans]
+	metrics *relayQueue[*metricspb.ResourceMetrics]
+	logs    *relayQueue[*logspb.ResourceLogs]
+
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func startRelay(ctx context.Context, opts Options, res *resource.Resource) (*relay, error) {
+	ro := opts.Relay
+	if ro.Listen == "" {
+		return nil, nil
+	}
+	if ro.BatchSize == 0 {
+		ro.BatchSize = 512
+	}
+	if ro.Interval == 0 {
+		ro.Interval = time.Second
+	}
+	if ro.MaxQueueSize == 0 {
+		ro.MaxQueueSize = 8192
+	}
+	upstream, insecureConn := ro.Upstream, ro.Insecure
+	if upstream == "" {
+		eo, _ := otlpTraceExporter(opts.TraceExporters)
+		upstream, insecureConn = eo.Endpoint, eo.Insecure
+	}
+
+	ln, err := net.Listen("tcp", ro.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("relay: %w", err)
+	}
+	conn, reg, err := dialOTLP(ctx, upstream, insecureConn, attribute.String("exporter.kind", "relay"))
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("relay: %w", err), ln.Close())
+	}
+
+	m := newRelayMetrics()
+	r := &relay{server: grpc.NewServer(), conn: conn, reg: reg, attrs: relayAttrs(res)}
+	traceClient := coltracepb.NewTraceServiceClient(conn)
+	r.traces = newRelayQueue(ro, "traces", m, func(ctx context.Context, batch []*tracepb.ResourceSpans) error {
+		_, err := traceClient.Export(ctx, &coltracepb.ExportTraceServiceRequest{ResourceSpans: batch})
+		return err
+	})
+	metricsClient := colmetricspb.NewMetricsServiceClient(conn)
+	r.metrics = newRelayQueue(ro, "metrics", m, func(ctx context.Context, batch []*metricspb.ResourceMetrics) error {
+		_, err := metricsClient.Export(ctx, &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: batch})
+		return err
+	})
+	logsClient := collogspb.NewLogsServiceClient(conn)
+	r.logs = newRelayQueue(ro, "logs", m, func(ctx context.Context, batch []*logspb.ResourceLogs) error {
+		_, err := logsClient.Export(ctx, &collogspb.ExportLogsServiceRequest{ResourceLogs: batch})
+		return err
+	})
+	coltracepb.RegisterTraceServiceServer(r.server, relayTraceService{r: r})
+	colmetricspb.RegisterMetricsServiceServer(r.server, relayMetricsService{r: r})
+	collogspb.RegisterLogsServiceServer(r.server, relayLogsService{r: r})
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	r.stop = cancel
+	r.wg.Add(3)
+	go func() { defer r.wg.Done(); r.traces.run(runCtx) }()
+	go func() { defer r.wg.Done(); r.metrics.run(runCtx) }()
+	go func() { defer r.wg.Done(); r.logs.run(runCtx) }()
+	go func() {
+		if err := r.server.Serve(ln); err != nil {
+			log.Error().Err(err).Msg("relay receiver stopped")
+		}
+	}()
+	log.Info().Str("listen", ro.Listen).Str("upstream", upstream).Msg("relaying OTLP")
+	return r, nil
+}
+
+// relayAttrs returns the attributes of res the relay adds to the resources
+// it relays.
+func relayAttrs(res *resource.Resource) []*commonpb.KeyValue {
+	var attrs []*commonpb.KeyValue
+	for _, kv := range res.Attributes() {
+		k := string(kv.Key)
+		if strings.HasPrefix(k, "service.") || strings.HasPrefix(k, "telemetry.sdk.") || strings.HasPrefix(k, "process.") {
+			continue
+		}
+		attrs = append(attrs, &commonpb.KeyValue{Key: k, Value: anyValue(kv.Value)})
+	}
+	return attrs
+}
+
+// anyValue converts an attribute value to its OTLP form.
+func anyValue(v attribute.Value) *commonpb.AnyValue {
+	array := func(n int, elem func(i int) *commonpb.AnyValue) *commonpb.AnyValue {
+		values := make([]*commonpb.AnyValue, n)
+		for i := range values {
+			values[i] = elem(i)
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	}
+	switch v.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}}
+	case attribute.BOOLSLICE:
+		s := v.AsBoolSlice()
+		return array(len(s), func(i int) *commonpb.AnyValue { return anyValue(attribute.BoolValue(s[i])) })
+	case attribute.INT64SLICE:
+		s := v.AsInt64Slice()
+		return array(len(s), func(i int) *commonpb.AnyValue { return anyValue(attribute.Int64Value(s[i])) })
+	case attribute.FLOAT64SLICE:
+		s := v.AsFloat64Slice()
+		return array(len(s), func(i int) *commonpb.AnyValue { return anyValue(attribute.Float64Value(s[i])) })
+	case attribute.STRINGSLICE:
+		s := v.AsStringSlice()
+		return array(len(s), func(i int) *commonpb.AnyValue { return anyValue(attribute.StringValue(s[i])) })
+	default:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.Emit()}}
+	}
+}
+
+// merge adds the relay attributes missing from res, which may be nil.
+func (r *relay) merge(res *resourcepb.Resource) *resourcepb.Resource {
+	if res == nil {
+		res = &resourcepb.Resource{}
+	}
+	set := make(map[string]bool, len(res.Attributes))
+	for _, kv := range res.Attributes {
+		set[kv.Key] = true
+	}
+	for _, kv := range r.attrs {
+		if !set[kv.Key] {
+			res.Attributes = append(res.Attributes, kv)
+		}
+	}
+	return res
+}
+
+// shutdown stops receiving, waiting for exports being received until ctx
+// is done, then sends what is buffered and closes the upstream connection.
+func (r *relay) shutdown(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	stopped := make(chan struct{})
+	go func() {
+		r.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		r.server.Stop()
+	}
+	r.stop()
+	r.wg.Wait()
+	return errors.Join(
+		r.traces.flush(ctx),
+		r.metrics.flush(ctx),
+		r.logs.flush(ctx),
+		r.reg.Unregister(),
+		r.conn.Close(),
+	)
+}
+
+var errRelayQueueFull = status.Error(grpccodes.ResourceExhausted, "relay queue full")
+
+type relayTraceService struct {
+	coltracepb.UnimplementedTraceServiceServer
+	r *relay
+}
+
+func (s relayTraceService) Export(_ context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	for _, rs := range req.ResourceSpans {
+		rs.Resource = s.r.merge(rs.Resource)
+	}
+	if !s.r.traces.add(req.ResourceSpans) {
+		return nil, errRelayQueueFull
+	}
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+type relayMetricsService struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	r *relay
+}
+
+func (s relayMetricsService) Export(_ context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	for _, rm := range req.ResourceMetrics {
+		rm.Resource = s.r.merge(rm.Resource)
+	}
+	if !s.r.metrics.add(req.ResourceMetrics) {
+		return nil, errRelayQueueFull
+	}
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+type relayLogsService struct {
+	collogspb.UnimplementedLogsServiceServer
+	r *relay
+}
+
+func (s relayLogsService) Export(_ context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	for _, rl := range req.ResourceLogs {
+		rl.Resource = s.r.merge(rl.Resource)
+	}
+	if !s.r.logs.add(req.ResourceLogs) {
+		return nil, errRelayQueueFull
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+// relayMetrics count the resources through the relay, by signal.
+type relayMetrics struct {
+	received, forwarded, dropped metric.Int64Counter
+}
+
+func newRelayMetrics() *relayMetrics {
+	m := &relayMetrics{}
+	m.received, _ = selfMeter().Int64Counter(
+		"telemetry.relay.received",
+		metric.WithDescription("Resources received by the relay, by signal."),
+	)
+	m.forwarded, _ = selfMeter().Int64Counter(
+		"telemetry.relay.forwarded",
+		metric.WithDescription("Resources the relay sent upstream, by signal."),
+	)
+	m.dropped, _ = selfMeter().Int64Counter(
+		"telemetry.relay.dropped",
+		metric.WithDescription("Resources the relay refused or failed to send upstream, by signal."),
+	)
+	return m
+}
+
+// relayQueue buffers the resources of one signal and sends them upstream
+// in batches.
+type relayQueue[T any] struct {
+	signal  string
+	opts    RelayOptions
+	metrics *relayMetrics
+	attrs   metric.MeasurementOption
+	send    func(context.Context, []T) error
+
+	mu    sync.Mutex
+	items []T
+	kick  chan struct{}
+
+	lastWarn atomic.Int64 // unix nanos
+}
+
+func newRelayQueue[T any](opts RelayOptions, signal string, m *relayMetrics, send func(context.Context, []T) error) *relayQueue[T] {
+	return &relayQueue[T]{
+		signal:  signal,
+		opts:    opts,
+		metrics: m,
+		attrs:   metric.WithAttributes(attribute.String("signal", signal)),
+		send:    send,
+		kick:    make(chan struct{}, 1),
+	}
+}
+
+// add buffers items, or reports false when the queue has no room for them.
+func (q *relayQueue[T]) add(items []T) bool {
+	ctx := context.Background()
+	q.mu.Lock()
+	full := len(q.items)+len(items) > q.opts.MaxQueueSize
+	if !full {
+		q.items = append(q.items, items...)
+	}
+	ready := len(q.items) >= q.opts.BatchSize
+	q.mu.Unlock()
+
+	if full {
+		q.metrics.dropped.Add(ctx, int64(len(items)), q.attrs)
+		return false
+	}
+	q.metrics.received.Add(ctx, int64(len(items)), q.attrs)
+	if ready {
+		select {
+		case q.kick <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// take removes up to a batch from the queue.
+func (q *relayQueue[T]) take() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := min(len(q.items), q.opts.BatchSize)
+	batch := q.items[:n:n]
+	q.items = q.items[n:]
+	return batch
+}
+
+func (q *relayQueue[T]) run(ctx context.Context) {
+	ticker := time.NewTicker(q.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.kick:
+		}
+		_ = q.flush(ctx)
+	}
+}
+
+// flush sends the queue upstream, batch by batch. Batches that fail are
+// dropped: the sender was already told they were accepted.
+func (q *relayQueue[T]) flush(ctx context.Context) error {
+	var errs []error
+	for batch := q.take(); len(batch) > 0; batch = q.take() {
+		sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		err := q.send(sendCtx, batch)
+		cancel()
+		if err == nil {
+			q.metrics.forwarded.Add(ctx, int64(len(batch)), q.attrs)
+			continue
+		}
+		q.metrics.dropped.Add(ctx, int64(len(batch)), q.attrs)
+		errs = append(errs, err)
+		now := time.Now().UnixNano()
+		if last := q.lastWarn.Load(); now-last >= int64(dropWarnInterval) && q.lastWarn.CompareAndSwap(last, now) {
+			log.Warn().Err(err).Str("signal", q.signal).Msg("relay failed to send upstream, dropping batch")
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,321 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ReloadOptions configures live reloading of settings from a file, such as
+// a mounted Kubernetes ConfigMap.
+type ReloadOptions struct {
+	// Path is the JSON file holding a ReloadableConfig. Empty disables
+	// reloading.
+	Path string
+	// Interval is how often the file is checked for changes. Zero means
+	// ten seconds.
+	Interval time.Duration
+}
+
+func (o ReloadOptions) validate() error {
+	if o.Interval < 0 {
+		return fmt.Errorf("reload interval must not be negative, got %s", o.Interval)
+	}
+	return nil
+}
+
+// ReloadableConfig holds the settings that can change without a restart.
+// Settings left out of the file keep their value from Options. Exporters
+// and their connections are never touched.
+type ReloadableConfig struct {
+	SampleRatio    *float64            `json:"sample_ratio,omitempty"`
+	LogLevel       *string             `json:"log_level,omitempty"`
+	Routes         *RouteFilterOptions `json:"routes,omitempty"`
+	RedactionRules *[]RedactionRule    `json:"redaction_rules,omitempty"`
+	Taxonomy       *[]TaxonomyRule     `json:"taxonomy,omitempty"`
+}
+
+// reloadable is the live state ReloadableConfig sets.
+type reloadable struct {
+	sampleRatio    float64
+	logLevel       zerolog.Level
+	routes         RouteFilterOptions
+	redactionRules []RedactionRule
+	taxonomy       []TaxonomyRule
+}
+
+// readReloadFile parses the reload file at path over the settings of base,
+// validating the result.
+func readReloadFile(path string, base Options) (reloadable, []byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return reloadable{}, nil, err
+	}
+	s, err := parseReloadFile(path, b, base)
+	return s, b, err
+}
+
+// parseReloadFile parses b, the content of the reload file at path, over
+// the settings of base, validating the result.
+func parseReloadFile(path string, b []byte, base Options) (reloadable, error) {
+	s, err := parseConfig(b, base)
+	if err != nil {
+		return reloadable{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ValidateConfig reports why b is not a valid reload file, a JSON
+// ReloadableConfig, if it is not: unknown fields, values of the wrong type
+// and invalid settings are all errors. Setup and reloads validate the file
+// the same way, so a config passing it in a pipeline is accepted by the
+// service. ConfigSchema describes the same file for editors and generic
+// validators.
+func ValidateConfig(b []byte) error {
+	_, err := parseConfig(b, DefaultOptions(""))
+	return err
+}
+
+// parseConfig parses b, a reload file, over the settings of base,
+// validating the result.
+func parseConfig(b []byte, base Options) (reloadable, error) {
+	var c ReloadableConfig
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return reloadable{}, err
+	}
+
+	s := reloadable{
+		sampleRatio:    base.SampleRatio,
+		logLevel:       base.Log.Level,
+		routes:         base.Routes,
+		redactionRules: base.RedactionRules,
+		taxonomy:       base.Taxonomy,
+	}
+	var (
+		errs []error
+		err  error
+	)
+	if c.SampleRatio != nil {
+		s.sampleRatio = *c.SampleRatio
+		if s.sampleRatio < 0 || s.sampleRatio > 1 {
+			errs = append(errs, fmt.Errorf("sample ratio must be between 0 and 1, got %g", s.sampleRatio))
+		}
+	}
+	if c.LogLevel != nil {
+		if s.logLevel, err = zerolog.ParseLevel(*c.LogLevel); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Routes != nil {
+		s.routes = *c.Routes
+		if err := s.routes.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.RedactionRules != nil {
+		s.redactionRules = *c.RedactionRules
+		if _, err := NewRedactor(s.redactionRules); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Taxonomy != nil {
+		s.taxonomy = *c.Taxonomy
+		if err := validateTaxonomy(s.taxonomy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return reloadable{}, err
+	}
+	return s, nil
+}
+
+// overlay returns o with the settings of s.
+func (s reloadable) overlay(o Options) Options {
+	o.SampleRatio = s.sampleRatio
+	o.Log.Level = s.logLevel
+	o.Routes = s.routes
+	o.RedactionRules = s.redactionRules
+	o.Taxonomy = s.taxonomy
+	return o
+}
+
+// changes lists the settings that differ from those of prev, as
+// "name: old -> new".
+func (s reloadable) changes(prev reloadable) []string {
+	var changes []string
+	add := func(name string, old, new any) {
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, old, new))
+	}
+	if s.sampleRatio != prev.sampleRatio {
+		add("sample_ratio", prev.sampleRatio, s.sampleRatio)
+	}
+	if s.logLevel != prev.logLevel {
+		add("log_level", prev.logLevel, s.logLevel)
+	}
+	if !slices.Equal(s.routes.Untraced, prev.routes.Untraced) {
+		add("routes.untraced", prev.routes.Untraced, s.routes.Untraced)
+	}
+	if !slices.Equal(s.routes.Unmetered, prev.routes.Unmetered) {
+		add("routes.unmetered", prev.routes.Unmetered, s.routes.Unmetered)
+	}
+	if !slices.Equal(s.redactionRules, prev.redactionRules) {
+		add("redaction_rules", ruleNames(prev.redactionRules), ruleNames(s.redactionRules))
+	}
+	if !reflect.DeepEqual(s.taxonomy, prev.taxonomy) {
+		add("taxonomy", taxonomyTags(prev.taxonomy), taxonomyTags(s.taxonomy))
+	}
+	return changes
+}
+
+func ruleNames(rules []RedactionRule) []string {
+	names := make([]string, len(rules))
+	for i, r := range rules {
+		names[i] = r.Name
+	}
+	return names
+}
+
+func taxonomyTags(rules []TaxonomyRule) []string {
+	tags := make([]string, len(rules))
+	for i, r := range rules {
+		tags[i] = r.Tag + "=" + r.Value
+	}
+	return tags
+}
+
+// configWatcher polls the reload file and applies its changes. A file that
+// cannot be read or is invalid leaves the settings as they are.
+type configWatcher struct {
+	t    *Telemetry
+	path string
+	base Options
+
+	hash     [sha256.Size]byte
+	applied  reloadable
+	lastErr  string
+	stop     context.CancelFunc
+	done     chan struct{}
+	interval time.Duration
+}
+
+func startConfigWatcher(t *Telemetry, base Options, initial []byte) *configWatcher {
+	if t.opts.Reload.Path == "" {
+		return nil
+	}
+	w := &configWatcher{
+		t:        t,
+		path:     t.opts.Reload.Path,
+		base:     base,
+		applied:  reloadable{t.opts.SampleRatio, t.opts.Log.Level, t.opts.Routes, t.opts.RedactionRules, t.opts.Taxonomy},
+		interval: t.opts.Reload.Interval,
+		done:     make(chan struct{}),
+	}
+	if w.interval == 0 {
+		w.interval = 10 * time.Second
+	}
+	if initial != nil {
+		w.hash = sha256.Sum256(initial)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.stop = cancel
+	go w.run(ctx)
+	return w
+}
+
+func (w *configWatcher) run(ctx context.Context) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check applies the file if its content changed. The file is compared by
+// content, as ConfigMap updates replace the symlink it is reached through
+// rather than the file.
+func (w *configWatcher) check() {
+	b, err := os.ReadFile(w.path)
+	if err == nil && sha256.Sum256(b) == w.hash {
+		return
+	}
+	var s reloadable
+	if err == nil {
+		s, err = parseReloadFile(w.path, b, w.base)
+	}
+	if err != nil {
+		if err.Error() != w.lastErr {
+			w.lastErr = err.Error()
+			log.Error().Err(err).Msg("telemetry config reload failed, keeping the current settings")
+		}
+		return
+	}
+	w.lastErr = ""
+	w.hash = sha256.Sum256(b)
+
+	changes := s.changes(w.applied)
+	if len(changes) == 0 {
+		return
+	}
+	// Only the settings that changed in the file are applied, so those
+	// changed since through ControlHandler are kept.
+	prev := w.applied
+	if !slices.Equal(s.redactionRules, prev.redactionRules) {
+		// Validated by readReloadFile.
+		_ = w.t.redactor.set(s.redactionRules)
+	}
+	if s.sampleRatio != prev.sampleRatio {
+		_ = w.t.SetSampleRatio(s.sampleRatio)
+	}
+	if s.logLevel != prev.logLevel {
+		w.t.SetLogLevel(s.logLevel)
+	}
+	routes, taxonomy := s.routes, s.taxonomy
+	w.t.routes.Store(&routes)
+	w.t.taxonomy.Store(&taxonomy)
+	w.applied = s
+
+	_, span := otel.Tracer(instrumentationName).Start(context.Background(), "telemetry config reload",
+		trace.WithNewRoot())
+	span.AddEvent("config changed", trace.WithAttributes(
+		attribute.String("config.file", w.path),
+		attribute.StringSlice("config.changes", changes),
+	))
+	span.End()
+	// Warn so the change is recorded even when the level was just raised.
+	log.Warn().Str("config_file", w.path).Strs("changes", changes).Msg("telemetry config reloaded")
+}
+
+func (w *configWatcher) shutdown(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	w.stop()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,59 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// newResource describes the service to every backend: its name and build,
+// the labels and annotations of its pod, the SDK, host and runtime, and
+// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES, which take precedence. Detectors failing to describe something are
+// logged rather than fatal.
+func newResource(ctx context.Context, opts Options) (*resource.Resource, error) {
+	schemaURL := opts.ResourceSchemaURL
+	if schemaURL == "" {
+		schemaURL = semconv.SchemaURL
+	}
+	pod, _, err := readPodMetadata(opts.PodMetadata)
+	if err != nil {
+		return nil, err
+	}
+	base := resource.NewWithAttributes(schemaURL,
+		append(append(ReadBuildInfo().attributes(), pod...), semconv.ServiceName(opts.ServiceName))...)
+
+	detected, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithProcessRuntimeName(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithFromEnv(),
+	)
+	switch {
+	case err == nil:
+	case errors.Is(err, resource.ErrPartialResource), errors.Is(err, resource.ErrSchemaURLConflict):
+		log.Warn().Err(err).Msg("incomplete resource detection")
+	default:
+		return nil, err
+	}
+	return mergeResources(base, detected), nil
+}
+
+// mergeResources merges b into a, b's attributes winning. Unlike
+// resource.Merge it cannot fail: when the schema URLs differ, as they do
+// when detectors follow another semconv version, the attributes are merged
+// regardless and a's schema URL is kept.
+func mergeResources(a, b *resource.Resource) *resource.Resource {
+	merged, err := resource.Merge(a, b)
+	if err == nil {
+		return merged
+	}
+	merged, _ = resource.Merge(
+		resource.NewSchemaless(a.Attributes()...),
+		resource.NewSchemaless(b.Attributes()...),
+	)
+	return resource.NewWithAttributes(a.SchemaURL(), merged.Attributes()...)
+}
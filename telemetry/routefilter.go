@@ -0,0 +1,59 @@
+package telemetry
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+)
+
+// RouteFilterOptions turns instrumentation off for noisy routes. Entries are
+// path.Match globs, e.g. "/health/*", matched against the request path and,
+// for metrics, the chi route pattern.
+type RouteFilterOptions struct {
+	// Untraced routes start no spans.
+	Untraced []string
+	// Unmetered routes are left out of the HTTP request metrics.
+	Unmetered []string
+}
+
+// DefaultRouteFilterOptions keeps scrapes and liveness probes out of traces.
+func DefaultRouteFilterOptions() RouteFilterOptions {
+	return RouteFilterOptions{
+		Untraced: []string{"/ping", "/healthz", "/readyz", "/metrics", "/metrics/*"},
+	}
+}
+
+func (o RouteFilterOptions) validate() error {
+	for _, globs := range [][]string{o.Untraced, o.Unmetered} {
+		for _, g := range globs {
+			if _, err := path.Match(g, ""); err != nil {
+				return fmt.Errorf("route filter %q: %w", g, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchRoute reports whether any glob matches one of paths. The globs are
+// validated up front, so match errors cannot occur.
+func matchRoute(globs []string, paths ...string) bool {
+	for _, g := range globs {
+		for _, p := range paths {
+			if ok, _ := path.Match(g, p); ok && p != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Traced reports whether r should be traced. It matches the otelchi filter
+// signature: pass it to otelchi.WithFilter.
+func (t *Telemetry) Traced(r *http.Request) bool {
+	return !matchRoute(t.routes.Load().Untraced, r.URL.Path)
+}
+
+// metered reports whether requests to r should be recorded by HTTPMetrics.
+func (t *Telemetry) metered(r *http.Request, route string) bool {
+	return !matchRoute(t.routes.Load().Unmetered, r.URL.Path, route)
+}
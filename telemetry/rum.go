@@ -0,0 +1,160 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Limits on what one RUM request may submit.
+const (
+	rumMaxBodyBytes = 64 << 10
+	rumMaxEvents    = 100
+)
+
+// RUM event types.
+const (
+	RUMWebVital = "web_vital"
+	RUMError    = "error"
+)
+
+// rumVitals are the web vitals accepted, keeping the metric cardinality
+// bounded. CLS is a score; the others are milliseconds.
+var rumVitals = map[string]bool{"CLS": true, "FCP": true, "FID": true, "INP": true, "LCP": true, "TTFB": true}
+
+// RUMEvent is one browser telemetry event.
+type RUMEvent struct {
+	Type string `json:"type"`
+	// Page is the path the event happened on.
+	Page string `json:"page,omitempty"`
+	// Traceparent is the W3C trace context of the page load or request
+	// the event belongs to.
+	Traceparent string `json:"traceparent,omitempty"`
+
+	// Name and Value describe web vitals, e.g. LCP and 1234.5.
+	Name  string  `json:"name,omitempty"`
+	Value float64 `json:"value,omitempty"`
+
+	// Message and Stack describe errors.
+	Message string `json:"message,omitempty"`
+	Stack   string `json:"stack,omitempty"`
+}
+
+// RUMBatch is the body accepted by RUMHandler.
+type RUMBatch struct {
+	Events []RUMEvent `json:"events"`
+}
+
+func (e RUMEvent) validate() error {
+	switch e.Type {
+	case RUMWebVital:
+		if !rumVitals[e.Name] {
+			return fmt.Errorf("unknown web vital %q", e.Name)
+		}
+		if e.Value < 0 {
+			return fmt.Errorf("web vital %s: negative value", e.Name)
+		}
+	case RUMError:
+		if e.Message == "" {
+			return fmt.Errorf("error without message")
+		}
+	default:
+		return fmt.Errorf("unknown event type %q", e.Type)
+	}
+	return nil
+}
+
+// RUMHandler accepts browser telemetry POSTed as a RUMBatch: web vitals
+// are recorded in the rum.web_vital histograms and errors are logged and
+// counted in rum.errors. Events carrying a traceparent are correlated with
+// that trace, in log fields and metric exemplars. The batch is rejected
+// with 400 if any event is invalid.
+func (t *Telemetry) RUMHandler() http.Handler {
+	durations, _ := selfMeter().Float64Histogram(
+		"rum.web_vital.duration",
+		metric.WithDescription("Timing web vitals reported by browsers."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...),
+	)
+	shifts, _ := selfMeter().Float64Histogram(
+		"rum.web_vital.layout_shift",
+		metric.WithDescription("Cumulative layout shift scores reported by browsers."),
+		metric.WithExplicitBucketBoundaries(0.01, 0.05, 0.1, 0.15, 0.25, 0.5, 1),
+	)
+	errs, _ := selfMeter().Int64Counter(
+		"rum.errors",
+		metric.WithDescription("Errors reported by browsers."),
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		var batch RUMBatch
+		r.Body = http.MaxBytesReader(w, r.Body, rumMaxBodyBytes)
+		if err := DecodeJSON(r, &batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(batch.Events) > rumMaxEvents {
+			http.Error(w, fmt.Sprintf("at most %d events per batch", rumMaxEvents), http.StatusBadRequest)
+			return
+		}
+		for i, e := range batch.Events {
+			if err := e.validate(); err != nil {
+				http.Error(w, fmt.Sprintf("event %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		for _, e := range batch.Events {
+			ctx := rumContext(r.Context(), e.Traceparent)
+			sc := trace.SpanContextFromContext(ctx)
+
+			switch e.Type {
+			case RUMWebVital:
+				vital := metric.WithAttributes(attribute.String("web_vital.name", e.Name))
+				if e.Name == "CLS" {
+					shifts.Record(ctx, e.Value, vital)
+				} else {
+					durations.Record(ctx, e.Value/1000, vital)
+				}
+
+			case RUMError:
+				errs.Add(ctx, 1)
+				ev := log.Warn().
+					Str("rum.message", e.Message).
+					Str("rum.page", e.Page).
+					Str("user_agent.original", r.UserAgent())
+				if e.Stack != "" {
+					ev = ev.Str("rum.stack", e.Stack)
+				}
+				if sc.IsValid() {
+					ev = ev.Str("trace_id", sc.TraceID().String()).Str("span_id", sc.SpanID().String())
+				}
+				ev.Msg("browser error")
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// rumContext returns ctx carrying the remote span context in traceparent,
+// if it is valid.
+func rumContext(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{"traceparent": strings.TrimSpace(traceparent)}
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
@@ -0,0 +1,90 @@
+package telemetry
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+)
+
+// TraceExporterInfo describes a running trace exporter.
+type TraceExporterInfo struct {
+	Kind      ExporterKind  `json:"kind"`
+	Processor ProcessorKind `json:"processor"`
+	Endpoint  string        `json:"endpoint,omitempty"`
+	Insecure  bool          `json:"insecure,omitempty"`
+	Path      string        `json:"path,omitempty"`
+	Spool     string        `json:"spool,omitempty"`
+}
+
+// MetricExporterInfo describes a running metric exporter.
+type MetricExporterInfo struct {
+	Kind     MetricExporterKind `json:"kind"`
+	Interval string             `json:"interval,omitempty"`
+	Path     string             `json:"path,omitempty"`
+	URL      string             `json:"url,omitempty"`
+}
+
+// RuntimeConfig is the effective telemetry configuration, as served by
+// ConfigHandler.
+type RuntimeConfig struct {
+	ServiceName     string               `json:"service_name"`
+	Preset          Preset               `json:"preset"`
+	Sampler         string               `json:"sampler"`
+	Resource        map[string]string    `json:"resource"`
+	SchemaURL       string               `json:"schema_url"`
+	TraceExporters  []TraceExporterInfo  `json:"trace_exporters"`
+	MetricExporters []MetricExporterInfo `json:"metric_exporters"`
+	LogFormat       LogFormat            `json:"log_format"`
+	LogLevel        string               `json:"log_level"`
+	ReadOnly        bool                 `json:"read_only"`
+}
+
+// RuntimeConfig returns the configuration the providers were built from,
+// with the sampler as currently set.
+func (t *Telemetry) RuntimeConfig() RuntimeConfig {
+	rc := RuntimeConfig{
+		ServiceName:     t.opts.ServiceName,
+		Preset:          t.opts.Preset,
+		Sampler:         t.sampler.Description(),
+		Resource:        make(map[string]string),
+		SchemaURL:       t.resource.SchemaURL(),
+		TraceExporters:  make([]TraceExporterInfo, 0, len(t.opts.TraceExporters)),
+		MetricExporters: make([]MetricExporterInfo, 0, len(t.opts.MetricExporters)),
+		LogFormat:       t.opts.Log.Format,
+		LogLevel:        t.LogLevel().String(),
+		ReadOnly:        t.ReadOnly(),
+	}
+	for _, kv := range t.resource.Attributes() {
+		rc.Resource[string(kv.Key)] = kv.Value.Emit()
+	}
+	for _, eo := range t.opts.TraceExporters {
+		info := TraceExporterInfo{Kind: eo.Kind, Processor: eo.Processor, Path: eo.Path}
+		if eo.Kind == ExporterOTLP {
+			info.Endpoint = eo.Endpoint
+			info.Insecure = eo.Insecure
+			if eo.Spool != nil {
+				info.Spool = eo.Spool.Dir
+			}
+		}
+		rc.TraceExporters = append(rc.TraceExporters, info)
+	}
+	for _, eo := range t.opts.MetricExporters {
+		info := MetricExporterInfo{Kind: eo.Kind, Path: eo.Path}
+		if u, err := url.Parse(eo.URL); err == nil && eo.URL != "" {
+			info.URL = u.Redacted()
+		}
+		if eo.Interval > 0 {
+			info.Interval = eo.Interval.String()
+		}
+		rc.MetricExporters = append(rc.MetricExporters, info)
+	}
+	return rc
+}
+
+// ConfigHandler serves RuntimeConfig as JSON.
+func (t *Telemetry) ConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, t.RuntimeConfig())
+	})
+}
@@ -0,0 +1,251 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// knownContentTypes are the media types recorded as such by RequestSchema.
+// Others are recorded as "other", as clients choose them freely.
+var knownContentTypes = map[string]bool{
+	"application/json":                  true,
+	"application/x-www-form-urlencoded": true,
+	"multipart/form-data":               true,
+	"text/plain":                        true,
+	"application/octet-stream":          true,
+	"application/xml":                   true,
+	"text/xml":                          true,
+	"application/x-protobuf":            true,
+	"application/grpc":                  true,
+	"application/cbor":                  true,
+	"application/x-ndjson":              true,
+	"application/merge-patch+json":      true,
+	"application/json-patch+json":       true,
+}
+
+// contentTypeLabel returns the bounded label of a Content-Type header.
+func contentTypeLabel(header string) string {
+	if header == "" {
+		return "none"
+	}
+	mt, _, err := mime.ParseMediaType(header)
+	switch {
+	case err != nil:
+		return "invalid"
+	case knownContentTypes[mt]:
+		return mt
+	default:
+		return "other"
+	}
+}
+
+// maxUnknownFieldNames bounds the unknown field names added to a span.
+const maxUnknownFieldNames = 10
+
+// requestSchema collects what DecodeJSON found in one request.
+type requestSchema struct {
+	mu      sync.Mutex
+	unknown int
+	invalid bool
+}
+
+type requestSchemaKey struct{}
+
+// DecodeJSON decodes the JSON body of r into v, a pointer to a struct, and
+// reports the fields of the body v has no place for. Encoding/json drops
+// them silently; here they are added to the request span in an "unknown
+// fields" event and counted in http.server.request.unknown_fields by
+// RequestSchema, so API owners see clients sending what they do not read.
+// Bodies that do not decode are counted as invalid.
+func DecodeJSON(r *http.Request, v any) error {
+	b, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	s, _ := r.Context().Value(requestSchemaKey{}).(*requestSchema)
+	if err != nil {
+		if s != nil {
+			s.mu.Lock()
+			s.invalid = true
+			s.mu.Unlock()
+		}
+		return err
+	}
+
+	var doc any
+	if json.Unmarshal(b, &doc) != nil {
+		return nil
+	}
+	var unknown []string
+	unknownFields(reflect.TypeOf(v), doc, "", &unknown)
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	if s != nil {
+		s.mu.Lock()
+		s.unknown += len(unknown)
+		s.mu.Unlock()
+	}
+	names := unknown[:min(len(unknown), maxUnknownFieldNames)]
+	trace.SpanFromContext(r.Context()).AddEvent("unknown fields", trace.WithAttributes(
+		attribute.Int("unknown_fields.count", len(unknown)),
+		attribute.StringSlice("unknown_fields.names", names),
+	))
+	return nil
+}
+
+// unknownFields appends to out the paths, e.g. "items[].colour", of the
+// object keys of doc that t has no field for. Only structs are checked;
+// maps and interfaces accept anything.
+func unknownFields(t reflect.Type, doc any, path string, out *[]string) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if items, ok := doc.([]any); ok {
+			for _, item := range items {
+				unknownFields(t.Elem(), item, path+"[]", out)
+			}
+		}
+	case reflect.Struct:
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for key, val := range obj {
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			ft, ok := fields[strings.ToLower(key)]
+			if !ok {
+				if !slices.Contains(*out, p) {
+					*out = append(*out, p)
+				}
+				continue
+			}
+			unknownFields(ft, val, p, out)
+		}
+	}
+}
+
+// jsonFields returns the types of the fields encoding/json decodes into t
+// by lowercased name, as it matches names case-insensitively.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for n, et := range jsonFields(ft) {
+					if _, ok := fields[n]; !ok {
+						fields[n] = et
+					}
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = ft
+	}
+	return fields
+}
+
+// RequestSchema returns middleware recording the shape of request
+// payloads by route: their content type in http.server.request.content_types,
+// their size by content type in http.server.request.payload.size, and the
+// unknown fields and invalid bodies found by DecodeJSON in
+// http.server.request.unknown_fields and http.server.request.invalid_payloads.
+// Requests without a body are not recorded. It must run inside the chi
+// router so the matched route pattern is known.
+func (t *Telemetry) RequestSchema() func(http.Handler) http.Handler {
+	contentTypes, _ := selfMeter().Int64Counter(
+		"http.server.request.content_types",
+		metric.WithDescription("Requests with a body, by route and content type."),
+	)
+	payloadSize, _ := selfMeter().Int64Histogram(
+		"http.server.request.payload.size",
+		metric.WithDescription("Size of request bodies, by route and content type."),
+		metric.WithUnit("By"),
+	)
+	unknown, _ := selfMeter().Int64Counter(
+		"http.server.request.unknown_fields",
+		metric.WithDescription("Fields of JSON request bodies the handler does not read, by route."),
+	)
+	invalid, _ := selfMeter().Int64Counter(
+		"http.server.request.invalid_payloads",
+		metric.WithDescription("Request bodies that failed to decode, by route."),
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body := &countingBody{ReadCloser: r.Body}
+			r.Body = body
+			s := &requestSchema{}
+			r = r.WithContext(context.WithValue(r.Context(), requestSchemaKey{}, s))
+
+			next.ServeHTTP(w, r)
+
+			route := routePattern(r)
+			if !t.metered(r, route) {
+				return
+			}
+			ct := contentTypeLabel(r.Header.Get("Content-Type"))
+			size := max(r.ContentLength, body.n)
+			attrs := metric.WithAttributes(
+				attribute.String("http.route", route),
+				attribute.String("http.request.method", r.Method),
+				attribute.String("content_type", ct),
+			)
+			contentTypes.Add(r.Context(), 1, attrs)
+			payloadSize.Record(r.Context(), max(size, 0), attrs)
+
+			s.mu.Lock()
+			n, bad := s.unknown, s.invalid
+			s.mu.Unlock()
+			routeAttrs := metric.WithAttributes(attribute.String("http.route", route))
+			if n > 0 {
+				unknown.Add(r.Context(), int64(n), routeAttrs)
+			}
+			if bad {
+				invalid.Add(r.Context(), 1, routeAttrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// ScrapeTarget is a co-located prometheus endpoint, e.g. a sidecar, whose
+// metrics are served with the service's own, so a pod needs one scrape
+// target only.
+type ScrapeTarget struct {
+	// Name labels the target's series as scrape_target.
+	Name string
+	// URL is the target's scrape endpoint, e.g.
+	// "http://localhost:9102/metrics".
+	URL string
+}
+
+func (st ScrapeTarget) validate() error {
+	if st.Name == "" {
+		return errors.New("scrape target name is required")
+	}
+	if u, err := url.Parse(st.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("scrape target %s: url %q must be an absolute http(s) URL", st.Name, st.URL)
+	}
+	return nil
+}
+
+const (
+	// scrapeTargetLabel names the target on its series.
+	scrapeTargetLabel = "scrape_target"
+	// scrapeTargetTimeout bounds the scrape of a target, which delays the
+	// scrape of the service.
+	scrapeTargetTimeout = 5 * time.Second
+	// scrapeAccept asks targets for the text format, which every
+	// prometheus client serves.
+	scrapeAccept = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+)
+
+// proxyGatherer adds the series of the scrape targets, scraped in parallel
+// at every gather, to those of the wrapped gatherer. A target family of the
+// same name and type as a local one is merged into it; of another type, it
+// is dropped. scrape_target_up reports which targets answered.
+type proxyGatherer struct {
+	prometheus.Gatherer
+	targets []ScrapeTarget
+	client  *http.Client
+}
+
+func newProxyGatherer(g prometheus.Gatherer, targets []ScrapeTarget) prometheus.Gatherer {
+	if len(targets) == 0 {
+		return g
+	}
+	return proxyGatherer{Gatherer: g, targets: targets, client: &http.Client{Timeout: scrapeTargetTimeout}}
+}
+
+func (g proxyGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+
+	scraped := make([]map[string]*dto.MetricFamily, len(g.targets))
+	var wg sync.WaitGroup
+	for i, st := range g.targets {
+		wg.Add(1)
+		go func(i int, st ScrapeTarget) {
+			defer wg.Done()
+			mfs, err := g.scrape(st)
+			if err != nil {
+				log.Debug().Err(err).Str("target", st.Name).Msg("scrape target failed")
+				return
+			}
+			scraped[i] = mfs
+		}(i, st)
+	}
+	wg.Wait()
+
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+	up := &dto.MetricFamily{
+		Name: proto.String("scrape_target_up"),
+		Help: proto.String("Whether the last scrape of a co-located scrape target succeeded."),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for i, st := range g.targets {
+		v := 0.0
+		if scraped[i] != nil {
+			v = 1
+		}
+		up.Metric = append(up.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String(scrapeTargetLabel), Value: proto.String(st.Name)}},
+			Gauge: &dto.Gauge{Value: proto.Float64(v)},
+		})
+
+		for name, mf := range scraped[i] {
+			for _, m := range mf.Metric {
+				setTargetLabel(m, st.Name)
+			}
+			local, ok := byName[name]
+			switch {
+			case !ok:
+				byName[name] = mf
+				families = append(families, mf)
+			case local.GetType() == mf.GetType():
+				local.Metric = append(local.Metric, mf.Metric...)
+			default:
+				log.Debug().Str("target", st.Name).Str("family", name).Msg("dropping scrape target family conflicting with a local one")
+			}
+		}
+	}
+	if _, ok := byName[up.GetName()]; !ok {
+		families = append(families, up)
+	}
+	return families, err
+}
+
+func (g proxyGatherer) scrape(st ScrapeTarget) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequest(http.MethodGet, st.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", scrapeAccept)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape answered %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// setTargetLabel labels m with the target it was scraped from, replacing
+// any scrape_target label the target set itself.
+func setTargetLabel(m *dto.Metric, target string) {
+	for _, lp := range m.Label {
+		if lp.GetName() == scrapeTargetLabel {
+			lp.Value = proto.String(target)
+			return
+		}
+	}
+	m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(scrapeTargetLabel), Value: proto.String(target)})
+}
@@ -0,0 +1,151 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// selfMeter creates the instruments describing the telemetry pipeline and
+// the served requests. It is resolved when the instruments are created, so
+// they report to the meter provider registered last, rather than to the
+// first one as a package-level Meter would. Instruments created before
+// Setup still follow the provider it registers.
+func selfMeter() metric.Meter {
+	return otel.Meter(instrumentationName)
+}
+
+// setErrorHandler routes errors raised inside the OpenTelemetry SDK, such as
+// failed exports, to zerolog instead of the default stderr printer.
+func setErrorHandler() {
+	errCount, _ := selfMeter().Int64Counter(
+		"telemetry.errors",
+		metric.WithDescription("Errors reported by the OpenTelemetry SDK."),
+	)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		errCount.Add(context.Background(), 1)
+		log.Error().Err(err).Msg("opentelemetry error")
+	}))
+}
+
+// spanCountProcessor counts spans as they start and end.
+type spanCountProcessor struct {
+	started metric.Int64Counter
+	ended   metric.Int64Counter
+}
+
+func newSpanCountProcessor() trace.SpanProcessor {
+	p := &spanCountProcessor{}
+	p.started, _ = selfMeter().Int64Counter(
+		"telemetry.spans.started",
+		metric.WithDescription("Recording spans started."),
+	)
+	p.ended, _ = selfMeter().Int64Counter(
+		"telemetry.spans.ended",
+		metric.WithDescription("Recording spans ended."),
+	)
+	return p
+}
+
+func (p *spanCountProcessor) OnStart(ctx context.Context, _ trace.ReadWriteSpan) {
+	p.started.Add(ctx, 1)
+}
+
+func (p *spanCountProcessor) OnEnd(s trace.ReadOnlySpan) {
+	p.ended.Add(context.Background(), 1,
+		metric.WithAttributes(attribute.Bool("sampled", s.SpanContext().IsSampled())))
+}
+
+func (p *spanCountProcessor) Shutdown(context.Context) error   { return nil }
+func (p *spanCountProcessor) ForceFlush(context.Context) error { return nil }
+
+// instrumentedExporter records the outcome and latency of every export.
+type instrumentedExporter struct {
+	trace.SpanExporter
+	attrs []attribute.KeyValue
+
+	exported metric.Int64Counter
+	errors   metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+func newInstrumentedExporter(exporter trace.SpanExporter, attrs ...attribute.KeyValue) trace.SpanExporter {
+	e := &instrumentedExporter{SpanExporter: exporter, attrs: attrs}
+	e.exported, _ = selfMeter().Int64Counter(
+		"telemetry.spans.exported",
+		metric.WithDescription("Spans handed to an exporter, by result."),
+	)
+	e.errors, _ = selfMeter().Int64Counter(
+		"telemetry.export.errors",
+		metric.WithDescription("Failed span exports."),
+	)
+	e.duration, _ = selfMeter().Float64Histogram(
+		"telemetry.export.duration",
+		metric.WithDescription("Time taken to export a batch of spans."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...),
+	)
+	return e
+}
+
+func (e *instrumentedExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	start := time.Now()
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	elapsed := time.Since(start).Seconds()
+
+	result := "success"
+	if err != nil {
+		result = "error"
+		e.errors.Add(ctx, 1, metric.WithAttributes(e.attrs...))
+	}
+	attrs := metric.WithAttributes(withAttrs(e.attrs, attribute.String("result", result))...)
+	e.exported.Add(ctx, int64(len(spans)), attrs)
+	e.duration.Record(ctx, elapsed, attrs)
+	return err
+}
+
+// grpcStates are reported by observeConnState, one series each.
+var grpcStates = []connectivity.State{
+	connectivity.Idle,
+	connectivity.Connecting,
+	connectivity.Ready,
+	connectivity.TransientFailure,
+	connectivity.Shutdown,
+}
+
+// observeConnState reports the state of an exporter's gRPC connection: the
+// series for the current state is 1, all others are 0.
+func observeConnState(conn *grpc.ClientConn, attrs ...attribute.KeyValue) (metric.Registration, error) {
+	gauge, err := selfMeter().Int64ObservableGauge(
+		"telemetry.exporter.grpc.state",
+		metric.WithDescription("State of the exporter gRPC connection; 1 for the current state."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return selfMeter().RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		current := conn.GetState()
+		for _, s := range grpcStates {
+			var v int64
+			if s == current {
+				v = 1
+			}
+			o.ObserveInt64(gauge, v, metric.WithAttributes(
+				withAttrs(attrs, attribute.String("state", s.String()))...))
+		}
+		return nil
+	}, gauge)
+}
+
+// withAttrs returns a new slice holding attrs followed by extra, leaving
+// attrs untouched.
+func withAttrs(attrs []attribute.KeyValue, extra ...attribute.KeyValue) []attribute.KeyValue {
+	return append(attrs[:len(attrs):len(attrs)], extra...)
+}
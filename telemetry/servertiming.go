@@ -0,0 +1,135 @@
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phaseTimings collects the phase durations of one request, in the order
+// the phases were first recorded.
+type phaseTimings struct {
+	mu     sync.Mutex
+	names  []string
+	totals map[string]time.Duration
+}
+
+func (p *phaseTimings) add(name string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.totals[name]; !ok {
+		p.names = append(p.names, name)
+	}
+	p.totals[name] += d
+}
+
+// header formats the phases and total as a Server-Timing value, e.g.
+// "db;dur=5.31, render;dur=0.42, total;dur=6.1".
+func (p *phaseTimings) header(total time.Duration) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var b strings.Builder
+	for _, name := range p.names {
+		writeServerTiming(&b, name, p.totals[name])
+		b.WriteString(", ")
+	}
+	writeServerTiming(&b, "total", total)
+	return b.String()
+}
+
+func writeServerTiming(b *strings.Builder, name string, d time.Duration) {
+	b.WriteString(name)
+	b.WriteString(";dur=")
+	b.WriteString(strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64))
+}
+
+type phaseTimingsKey struct{}
+
+// StartPhase starts timing phase name, e.g. "auth" or "db", of the request
+// ctx belongs to, and returns a func ending it. Phases recorded more than
+// once add up. Names must be HTTP tokens. It is a no-op outside the
+// ServerTiming middleware.
+func StartPhase(ctx context.Context, name string) func() {
+	start := time.Now()
+	return func() { AddPhase(ctx, name, time.Since(start)) }
+}
+
+// AddPhase records d against phase name of the request ctx belongs to.
+func AddPhase(ctx context.Context, name string, d time.Duration) {
+	if p, ok := ctx.Value(phaseTimingsKey{}).(*phaseTimings); ok {
+		p.add(name, d)
+	}
+}
+
+// ServerTiming returns middleware collecting the phases recorded with
+// StartPhase and AddPhase, and sending them with the total time in a
+// Server-Timing response header, so browsers and synthetic monitors see
+// where time went. Phases still running when the handler writes its
+// response are left out. It is a no-op unless Options.ServerTiming is set,
+// as the header reveals server internals.
+func (t *Telemetry) ServerTiming() func(http.Handler) http.Handler {
+	enabled := t.opts.ServerTiming
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := &phaseTimings{totals: make(map[string]time.Duration)}
+			tw := &timingWriter{ResponseWriter: w, timings: p, start: time.Now()}
+			next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), phaseTimingsKey{}, p)))
+		})
+	}
+}
+
+// timingWriter adds the Server-Timing header just before the response
+// header is sent.
+type timingWriter struct {
+	http.ResponseWriter
+	timings *phaseTimings
+	start   time.Time
+	sent    bool
+}
+
+func (w *timingWriter) sendTiming() {
+	if w.sent {
+		return
+	}
+	w.sent = true
+	w.Header().Add("Server-Timing", w.timings.header(time.Since(w.start)))
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.sendTiming()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.sendTiming()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	w.sendTiming()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket libraries take over the connection.
+func (w *timingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hj.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
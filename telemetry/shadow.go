@@ -0,0 +1,250 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// shadowWorkers bounds the requests mirrored concurrently. Requests arriving
+// while all workers are busy are not mirrored.
+const shadowWorkers = 8
+
+// ShadowOptions configures ShadowCompare.
+type ShadowOptions struct {
+	// URL is the base URL of the candidate implementation. Empty disables
+	// mirroring.
+	URL string
+	// Ratio is the fraction of requests mirrored.
+	Ratio float64
+	// Methods are the methods mirrored. Empty means GET and HEAD, as the
+	// candidate would otherwise apply writes a second time.
+	Methods []string
+	// Timeout bounds each mirrored request. Zero means 10s.
+	Timeout time.Duration
+	// MaxBodyBytes is the largest request body mirrored. Zero means 64KiB.
+	MaxBodyBytes int
+}
+
+func (o ShadowOptions) validate() error {
+	if o.URL != "" {
+		u, err := url.Parse(o.URL)
+		if err != nil {
+			return fmt.Errorf("shadow url: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("shadow url %q must be an absolute http(s) URL", o.URL)
+		}
+	}
+	if o.Ratio < 0 || o.Ratio > 1 {
+		return fmt.Errorf("shadow ratio must be between 0 and 1, got %g", o.Ratio)
+	}
+	return nil
+}
+
+// shadowResult is what a response is compared by.
+type shadowResult struct {
+	status int
+	hash   string
+}
+
+// ShadowCompare returns middleware mirroring a sample of requests to
+// Options.Shadow.URL once they have been served, comparing the status and
+// body hash of both responses. Clients only ever get the primary response.
+// Each comparison is a "shadow compare" span linked to the request, counted
+// in shadow.comparisons by route and result, and mismatches are logged. It
+// must run after the tracing middleware.
+func (t *Telemetry) ShadowCompare() func(http.Handler) http.Handler {
+	opts := t.opts.Shadow
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 64 << 10
+	}
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+	methods := make(map[string]bool, len(opts.Methods))
+	for _, m := range opts.Methods {
+		methods[m] = true
+	}
+	comparisons, _ := selfMeter().Int64Counter(
+		"shadow.comparisons",
+		metric.WithDescription("Requests mirrored to the candidate, by route and result."),
+	)
+	client := &http.Client{Timeout: opts.Timeout}
+	workers := make(chan struct{}, shadowWorkers)
+
+	return func(next http.Handler) http.Handler {
+		if opts.URL == "" || opts.Ratio <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !methods[r.Method] || !archiveSampled(trace.SpanContextFromContext(r.Context()), opts.Ratio) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, ok := bufferBody(r, opts.MaxBodyBytes)
+			h := sha256.New()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(h)
+
+			next.ServeHTTP(ww, r)
+			if !ok {
+				return
+			}
+
+			primary := shadowResult{status: ww.Status(), hash: hex.EncodeToString(h.Sum(nil))}
+			if primary.status == 0 {
+				primary.status = http.StatusOK
+			}
+			route := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			select {
+			case workers <- struct{}{}:
+			default:
+				comparisons.Add(r.Context(), 1, metric.WithAttributes(
+					attribute.String("http.route", route),
+					attribute.String("result", "dropped"),
+				))
+				return
+			}
+			mirror := r.Clone(Detach(r.Context()))
+			go func() {
+				defer func() { <-workers }()
+				result := compareShadow(client, opts.URL, mirror, body, route, primary)
+				comparisons.Add(mirror.Context(), 1, metric.WithAttributes(
+					attribute.String("http.route", route),
+					attribute.String("result", result),
+				))
+			}()
+		})
+	}
+}
+
+// compareShadow sends r to the candidate at base and compares its response with
+// primary, returning the result: match, mismatch or error.
+func compareShadow(client *http.Client, base string, r *http.Request, body []byte, route string, primary shadowResult) string {
+	ctx, span := StartBackgroundSpan(r.Context(), "shadow compare",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.route", route),
+			attribute.String("server.address", shadowHost(base)),
+			attribute.Int("shadow.primary.status_code", primary.status),
+			attribute.String("shadow.primary.body_sha256", primary.hash),
+		),
+	)
+	defer span.End()
+
+	candidate, err := sendShadow(ctx, client, base, r, body)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Warn().Err(err).Str("route", route).Msg("shadow request failed")
+		return "error"
+	}
+	span.SetAttributes(
+		attribute.Int("shadow.candidate.status_code", candidate.status),
+		attribute.String("shadow.candidate.body_sha256", candidate.hash),
+	)
+
+	var diff []string
+	if candidate.status != primary.status {
+		diff = append(diff, "status")
+	}
+	if candidate.hash != primary.hash {
+		diff = append(diff, "body")
+	}
+	span.SetAttributes(attribute.Bool("shadow.match", len(diff) == 0))
+	if len(diff) == 0 {
+		return "match"
+	}
+	span.SetAttributes(attribute.StringSlice("shadow.diff", diff))
+	log.Warn().
+		Str("route", route).
+		Str("method", r.Method).
+		Str("url", r.URL.RequestURI()).
+		Strs("diff", diff).
+		Int("primary_status", primary.status).
+		Int("candidate_status", candidate.status).
+		Str("trace_id", span.SpanContext().TraceID().String()).
+		Msg("shadow response mismatch")
+	return "mismatch"
+}
+
+// shadowHost returns the host name of the candidate at base, which
+// ShadowOptions.validate ensured is an absolute URL.
+func shadowHost(base string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// sendShadow replays r against base, returning the candidate's result.
+func sendShadow(ctx context.Context, client *http.Client, base string, r *http.Request, body []byte) (shadowResult, error) {
+	target, err := url.JoinPath(base, r.URL.EscapedPath())
+	if err != nil {
+		return shadowResult{}, err
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return shadowResult{}, err
+	}
+	req.Header = r.Header.Clone()
+	for _, name := range []string{"Connection", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Traceparent", "Tracestate"} {
+		req.Header.Del(name)
+	}
+	req.Header.Set(SyntheticHeader, "shadow")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return shadowResult{}, err
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return shadowResult{}, err
+	}
+	return shadowResult{status: resp.StatusCode, hash: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// bufferBody reads the body of r so it can be sent again, leaving r able to
+// read it as before. It reports false, reading no more than needed to tell,
+// when the body is longer than limit.
+func bufferBody(r *http.Request, limit int) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	return b, err == nil && len(b) <= limit
+}
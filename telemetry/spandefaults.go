@@ -0,0 +1,59 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// DefaultSpanResourceAttributes are the deployment attributes copied from
+// the resource to every span: environment, region, version and instance.
+func DefaultSpanResourceAttributes() []string {
+	return []string{
+		"deployment.environment",
+		"cloud.region",
+		"service.version",
+		"service.instance.id",
+		"host.name",
+	}
+}
+
+// spanDefaultsProcessor stamps spans with resource attributes when they
+// start, so they survive backends that drop resource attributes on
+// ingestion. Attributes the span was started with are kept.
+type spanDefaultsProcessor struct {
+	attrs []attribute.KeyValue
+}
+
+// newSpanDefaultsProcessor copies the attributes of res named by keys,
+// skipping those res lacks. It returns nil if none is left.
+func newSpanDefaultsProcessor(res *resource.Resource, keys []string) sdktrace.SpanProcessor {
+	var attrs []attribute.KeyValue
+	for _, k := range keys {
+		if v, ok := res.Set().Value(attribute.Key(k)); ok {
+			attrs = append(attrs, attribute.KeyValue{Key: attribute.Key(k), Value: v})
+		}
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return spanDefaultsProcessor{attrs: attrs}
+}
+
+func (p spanDefaultsProcessor) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	set := make(map[attribute.Key]bool, len(p.attrs))
+	for _, kv := range s.Attributes() {
+		set[kv.Key] = true
+	}
+	for _, kv := range p.attrs {
+		if !set[kv.Key] {
+			s.SetAttributes(kv)
+		}
+	}
+}
+
+func (p spanDefaultsProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (p spanDefaultsProcessor) Shutdown(context.Context) error   { return nil }
+func (p spanDefaultsProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,97 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// defaultMaxSpanDuration is the longest span duration taken at face value.
+const defaultMaxSpanDuration = 24 * time.Hour
+
+// durationAnomaly names what is wrong with the duration of s: "negative"
+// when it ends before it starts, "implausible" when it lasts longer than
+// max, and "" otherwise. Spans timed by the SDK use the monotonic clock and
+// are immune, but explicit timestamps are wall-clock ones, which jump with
+// clock adjustments and suspended VMs.
+func durationAnomaly(s sdktrace.ReadOnlySpan, max time.Duration) string {
+	d := s.EndTime().Sub(s.StartTime())
+	switch {
+	case d < 0:
+		return "negative"
+	case max > 0 && d > max:
+		return "implausible"
+	default:
+		return ""
+	}
+}
+
+// spanDurationProcessor corrects the duration of ended spans before they
+// reach the wrapped processor, so latency percentiles are not skewed by
+// clock jumps. Negative durations become zero; implausible ones are kept.
+// Both are flagged in span.duration.anomaly, along with the original
+// duration in span.duration.original_ns.
+type spanDurationProcessor struct {
+	sdktrace.SpanProcessor
+	max time.Duration
+}
+
+func (p spanDurationProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if anomaly := durationAnomaly(s, p.max); anomaly != "" {
+		ds := durationSpan{ReadOnlySpan: s, end: s.EndTime(), anomaly: anomaly}
+		if anomaly == "negative" {
+			ds.end = s.StartTime()
+		}
+		s = ds
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// durationSpan overrides the end time of a span and flags it.
+type durationSpan struct {
+	sdktrace.ReadOnlySpan
+	end     time.Time
+	anomaly string
+}
+
+func (s durationSpan) EndTime() time.Time {
+	return s.end
+}
+
+func (s durationSpan) Attributes() []attribute.KeyValue {
+	return withAttrs(s.ReadOnlySpan.Attributes(),
+		attribute.String("span.duration.anomaly", s.anomaly),
+		attribute.Int64("span.duration.original_ns", int64(s.ReadOnlySpan.EndTime().Sub(s.StartTime()))),
+	)
+}
+
+// spanDurationCounter counts the spans flagged by spanDurationProcessor.
+// It is registered once, while the processor wraps every exporter.
+type spanDurationCounter struct {
+	max       time.Duration
+	anomalies metric.Int64Counter
+}
+
+func newSpanDurationCounter(max time.Duration) sdktrace.SpanProcessor {
+	c := &spanDurationCounter{max: max}
+	c.anomalies, _ = selfMeter().Int64Counter(
+		"telemetry.spans.duration_anomalies",
+		metric.WithDescription("Spans ended with a negative or implausible duration, by anomaly."),
+	)
+	return c
+}
+
+func (c *spanDurationCounter) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (c *spanDurationCounter) OnEnd(s sdktrace.ReadOnlySpan) {
+	if anomaly := durationAnomaly(s, c.max); anomaly != "" {
+		c.anomalies.Add(context.Background(), 1,
+			metric.WithAttributes(attribute.String("anomaly", anomaly)))
+	}
+}
+
+func (c *spanDurationCounter) Shutdown(context.Context) error   { return nil }
+func (c *spanDurationCounter) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,221 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// SpanHook fires its actions on ended spans its predicate matches, for
+// lightweight SLO breach detection in process, e.g. checkouts slower than
+// two seconds. Hooks only see sampled spans, with the status the exporters
+// see.
+type SpanHook struct {
+	// Name identifies the hook in alerts.
+	Name string
+	// Match selects the spans the hook fires on. Nil matches every span.
+	Match SpanPredicate
+	// Actions are run, in order, on every matching span. They run on the
+	// goroutine ending the span, so they must be quick.
+	Actions []SpanAction
+}
+
+// SpanPredicate reports whether an ended span matches.
+type SpanPredicate func(s sdktrace.ReadOnlySpan) bool
+
+// SpanAction reacts to a span matched by the hook named hook.
+type SpanAction func(hook string, s sdktrace.ReadOnlySpan)
+
+// SpanSlowerThan matches spans that lasted longer than d.
+func SpanSlowerThan(d time.Duration) SpanPredicate {
+	return func(s sdktrace.ReadOnlySpan) bool {
+		return s.EndTime().Sub(s.StartTime()) > d
+	}
+}
+
+// SpanFailed matches spans with an error status.
+func SpanFailed() SpanPredicate {
+	return func(s sdktrace.ReadOnlySpan) bool {
+		return s.Status().Code == codes.Error
+	}
+}
+
+// SpanOnRoute matches the spans of requests to route, a chi route pattern
+// such as "/checkout/{id}".
+func SpanOnRoute(route string) SpanPredicate {
+	return func(s sdktrace.ReadOnlySpan) bool {
+		v, ok := spanAttr(s, "http.route")
+		return ok && v.AsString() == route
+	}
+}
+
+// SpanNamed matches spans named name.
+func SpanNamed(name string) SpanPredicate {
+	return func(s sdktrace.ReadOnlySpan) bool {
+		return s.Name() == name
+	}
+}
+
+// AllSpans matches spans all of preds match.
+func AllSpans(preds ...SpanPredicate) SpanPredicate {
+	return func(s sdktrace.ReadOnlySpan) bool {
+		for _, p := range preds {
+			if !p(s) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnySpan matches spans any of preds matches.
+func AnySpan(preds ...SpanPredicate) SpanPredicate {
+	return func(s sdktrace.ReadOnlySpan) bool {
+		for _, p := range preds {
+			if p(s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// spanAlerts counts the spans matched by hooks with CountAlert.
+var spanAlerts, _ = selfMeter().Int64Counter(
+	"telemetry.span.alerts",
+	metric.WithDescription("Ended spans matched by span hooks, by hook."),
+)
+
+// CountAlert counts matching spans in telemetry.span.alerts by hook.
+func CountAlert() SpanAction {
+	return func(hook string, _ sdktrace.ReadOnlySpan) {
+		spanAlerts.Add(context.Background(), 1, metric.WithAttributes(attribute.String("hook", hook)))
+	}
+}
+
+// SpanAlert describes a span matched by a hook, as logged by LogAlert and
+// posted by PostWebhook.
+type SpanAlert struct {
+	Hook       string    `json:"hook"`
+	TraceID    string    `json:"trace_id"`
+	SpanID     string    `json:"span_id"`
+	Span       string    `json:"span"`
+	Route      string    `json:"route,omitempty"`
+	Status     string    `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+	Time       time.Time `json:"time"`
+}
+
+func newSpanAlert(hook string, s sdktrace.ReadOnlySpan) SpanAlert {
+	a := SpanAlert{
+		Hook:       hook,
+		TraceID:    s.SpanContext().TraceID().String(),
+		SpanID:     s.SpanContext().SpanID().String(),
+		Span:       s.Name(),
+		Status:     s.Status().Code.String(),
+		DurationMS: float64(s.EndTime().Sub(s.StartTime())) / float64(time.Millisecond),
+		Time:       s.EndTime(),
+	}
+	if v, ok := spanAttr(s, "http.route"); ok {
+		a.Route = v.AsString()
+	}
+	return a
+}
+
+// LogAlert logs matching spans at warn level as "span alert".
+func LogAlert() SpanAction {
+	return func(hook string, s sdktrace.ReadOnlySpan) {
+		a := newSpanAlert(hook, s)
+		log.Warn().
+			Str("hook", a.Hook).
+			Str("trace_id", a.TraceID).
+			Str("span_id", a.SpanID).
+			Str("span", a.Span).
+			Str("http.route", a.Route).
+			Str("status", a.Status).
+			Float64("duration_ms", a.DurationMS).
+			Msg("span alert")
+	}
+}
+
+// maxWebhooksInFlight bounds the alerts a PostWebhook action sends at once;
+// alerts beyond are dropped rather than queued, so a storm of matching
+// spans cannot pile up goroutines.
+const maxWebhooksInFlight = 4
+
+// PostWebhook POSTs matching spans as a JSON SpanAlert to url, in the
+// background and within timeout; zero means five seconds. Failures and
+// alerts dropped while maxWebhooksInFlight are being sent are logged.
+func PostWebhook(url string, timeout time.Duration) SpanAction {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	inFlight := make(chan struct{}, maxWebhooksInFlight)
+	return func(hook string, s sdktrace.ReadOnlySpan) {
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			log.Warn().Str("hook", hook).Msg("span alert webhook busy, dropping alert")
+			return
+		}
+		body, _ := json.Marshal(newSpanAlert(hook, s))
+		go func() {
+			defer func() { <-inFlight }()
+			if err := postAlert(client, url, body); err != nil {
+				log.Warn().Err(err).Str("hook", hook).Msg("span alert webhook failed")
+			}
+		}()
+	}
+}
+
+func postAlert(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// spanHookProcessor runs the hooks on ended spans.
+type spanHookProcessor struct {
+	hooks []SpanHook
+}
+
+func (p spanHookProcessor) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (p spanHookProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	for _, h := range p.hooks {
+		if h.Match != nil && !h.Match(s) {
+			continue
+		}
+		for _, act := range h.Actions {
+			act(h.Name, s)
+		}
+	}
+}
+
+func (p spanHookProcessor) Shutdown(context.Context) error   { return nil }
+func (p spanHookProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,138 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanSchema describes the kinds and attributes spans must have.
+type SpanSchema struct {
+	// Required lists, per span kind, groups of attribute keys: a span of
+	// that kind must carry at least one key of every group.
+	Required map[trace.SpanKind][][]string
+	// KindAttributes are keys that only make sense on spans of the listed
+	// kinds, such as http.request.method on SERVER and CLIENT spans. Other
+	// kinds carrying them are probably mislabelled.
+	KindAttributes map[string][]trace.SpanKind
+}
+
+// DefaultSpanSchema requires SERVER spans to name their route and CLIENT
+// spans their peer, and HTTP and RPC attributes to be on SERVER or CLIENT
+// spans.
+func DefaultSpanSchema() *SpanSchema {
+	remote := []trace.SpanKind{trace.SpanKindServer, trace.SpanKindClient}
+	return &SpanSchema{
+		Required: map[trace.SpanKind][][]string{
+			trace.SpanKindServer: {{"http.route", "rpc.method"}},
+			trace.SpanKindClient: {{"server.address", "network.peer.address", "peer.service", "url.full", "net.peer.name"}},
+		},
+		KindAttributes: map[string][]trace.SpanKind{
+			"http.request.method":       remote,
+			"http.response.status_code": remote,
+			"rpc.system":                remote,
+		},
+	}
+}
+
+// maxSpanViolations bounds the distinct violations logged, so a misbehaving
+// span name with high cardinality cannot flood the logs.
+const maxSpanViolations = 1000
+
+// spanSchemaProcessor logs the ended spans that violate a schema. Each
+// violation is logged once per span name, as the same instrumentation
+// produces it on every request. Like the log schema, it is meant for debug
+// mode.
+type spanSchemaProcessor struct {
+	schema *SpanSchema
+
+	mu     sync.Mutex
+	logged map[string]bool
+}
+
+func newSpanSchemaProcessor(schema *SpanSchema) sdktrace.SpanProcessor {
+	return &spanSchemaProcessor{schema: schema, logged: make(map[string]bool)}
+}
+
+func (p *spanSchemaProcessor) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (p *spanSchemaProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	violations := p.check(s)
+	if len(violations) == 0 {
+		return
+	}
+
+	key := s.Name() + "\x00" + strings.Join(violations, "\x00")
+	p.mu.Lock()
+	seen := p.logged[key]
+	if !seen && len(p.logged) < maxSpanViolations {
+		p.logged[key] = true
+	}
+	p.mu.Unlock()
+	if seen {
+		return
+	}
+
+	log.Warn().
+		Str("span_name", s.Name()).
+		Str("span_kind", s.SpanKind().String()).
+		Str("scope", s.InstrumentationScope().Name).
+		Str("trace_id", s.SpanContext().TraceID().String()).
+		Strs("span_violations", violations).
+		Msg("span violates schema")
+}
+
+// check returns a description of every way s violates the schema.
+func (p *spanSchemaProcessor) check(s sdktrace.ReadOnlySpan) []string {
+	kind := s.SpanKind()
+	keys := make(map[attribute.Key]bool, len(s.Attributes()))
+	for _, kv := range s.Attributes() {
+		keys[kv.Key] = true
+	}
+
+	var violations []string
+	for _, group := range p.schema.Required[kind] {
+		found := false
+		for _, k := range group {
+			if keys[attribute.Key(k)] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violations = append(violations, fmt.Sprintf("%s span has none of %s", kind, strings.Join(group, ", ")))
+		}
+	}
+	for k, kinds := range p.schema.KindAttributes {
+		if !keys[attribute.Key(k)] || slices.Contains(kinds, kind) {
+			continue
+		}
+		violations = append(violations, fmt.Sprintf("%s span has %s, want kind %s", kind, k, joinKinds(kinds)))
+	}
+	// A server span is entered from another process; one started under a
+	// local parent usually means a handler is instrumented twice.
+	if kind == trace.SpanKindServer && s.Parent().IsValid() && !s.Parent().IsRemote() {
+		violations = append(violations, "server span has a local parent")
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+func joinKinds(kinds []trace.SpanKind) string {
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		names[i] = k.String()
+	}
+	return strings.Join(names, " or ")
+}
+
+func (p *spanSchemaProcessor) Shutdown(context.Context) error   { return nil }
+func (p *spanSchemaProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,251 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	spoolExt             = ".pb"
+	defaultSpoolMaxBytes = 64 << 20
+)
+
+// SpoolOptions configures the disk spool of an OTLP exporter.
+type SpoolOptions struct {
+	// Dir holds the spooled batches. Each exporter needs its own directory.
+	Dir string
+	// MaxBytes bounds the spool size; the oldest batches are evicted first.
+	// Zero means 64 MiB.
+	MaxBytes int64
+}
+
+// spoolClient persists batches its wrapped client failed to upload and
+// replays them, oldest first, once an upload succeeds again.
+type spoolClient struct {
+	otlptrace.Client
+	opts SpoolOptions
+
+	mu    sync.Mutex // guards the spool directory
+	size  int64
+	seq   atomic.Uint64
+	wg    sync.WaitGroup
+	ready chan struct{} // holds a token while no replay is running
+
+	// ctx cancels a running replay on Stop; what is left stays on disk.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newSpoolClient(client otlptrace.Client, opts SpoolOptions) (*spoolClient, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultSpoolMaxBytes
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &spoolClient{Client: client, opts: opts, ready: make(chan struct{}, 1)}
+	c.ready <- struct{}{}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		c.size += f.size
+	}
+	return c, nil
+}
+
+func (c *spoolClient) Start(ctx context.Context) error {
+	if err := c.Client.Start(ctx); err != nil {
+		return err
+	}
+	c.replay()
+	return nil
+}
+
+func (c *spoolClient) Stop(ctx context.Context) error {
+	c.cancel()
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return c.Client.Stop(ctx)
+}
+
+func (c *spoolClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	err := c.Client.UploadTraces(ctx, protoSpans)
+	if err == nil {
+		c.replay()
+		return nil
+	}
+
+	if serr := c.store(protoSpans); serr != nil {
+		return fmt.Errorf("%w (spooling failed: %v)", err, serr)
+	}
+	return fmt.Errorf("%w (batch spooled to %s)", err, c.opts.Dir)
+}
+
+// store writes a batch to the spool, evicting the oldest batches if the
+// spool would grow past MaxBytes.
+func (c *spoolClient) store(protoSpans []*tracepb.ResourceSpans) error {
+	b, err := proto.Marshal(&tracepb.TracesData{ResourceSpans: protoSpans})
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) > c.opts.MaxBytes {
+		return fmt.Errorf("batch of %d bytes exceeds spool size", len(b))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size+int64(len(b)) > c.opts.MaxBytes {
+		if err := c.evict(c.size + int64(len(b)) - c.opts.MaxBytes); err != nil {
+			return err
+		}
+	}
+
+	// Names sort in the order batches were spooled.
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), c.seq.Add(1)%1e6, spoolExt)
+	tmp := filepath.Join(c.opts.Dir, name+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.opts.Dir, name)); err != nil {
+		return err
+	}
+	c.size += int64(len(b))
+	return nil
+}
+
+// evict removes the oldest batches until at least n bytes are freed.
+// c.mu must be held.
+func (c *spoolClient) evict(n int64) error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	var freed int64
+	for _, f := range files {
+		if freed >= n {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		freed += f.size
+		c.size -= f.size
+		log.Warn().Str("file", f.path).Msg("spool full, evicted oldest span batch")
+	}
+	return nil
+}
+
+// replay uploads spooled batches in the background, stopping at the first
+// failure. Only one replay runs at a time.
+func (c *spoolClient) replay() {
+	select {
+	case <-c.ready:
+	default:
+		return
+	}
+
+	c.mu.Lock()
+	empty := c.size == 0
+	c.mu.Unlock()
+	if empty {
+		c.ready <- struct{}{}
+		return
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() { c.ready <- struct{}{} }()
+
+		if err := c.replayAll(c.ctx); err != nil {
+			log.Warn().Err(err).Msg("span spool replay stopped")
+		}
+	}()
+}
+
+func (c *spoolClient) replayAll(ctx context.Context) error {
+	c.mu.Lock()
+	files, err := c.files()
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		b, err := os.ReadFile(f.path)
+		if os.IsNotExist(err) {
+			continue // evicted meanwhile
+		}
+		if err != nil {
+			return err
+		}
+
+		var data tracepb.TracesData
+		if err := proto.Unmarshal(b, &data); err != nil {
+			log.Error().Err(err).Str("file", f.path).Msg("dropping corrupt span batch from spool")
+		} else if err := c.Client.UploadTraces(ctx, data.ResourceSpans); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		if err := os.Remove(f.path); err == nil {
+			c.size -= f.size
+		}
+		c.mu.Unlock()
+	}
+
+	if len(files) > 0 {
+		log.Info().Int("batches", len(files)).Msg("replayed spooled span batches")
+	}
+	return nil
+}
+
+type spoolFile struct {
+	path string
+	size int64
+}
+
+// files lists the spooled batches, oldest first.
+func (c *spoolClient) files() ([]spoolFile, error) {
+	entries, err := os.ReadDir(c.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []spoolFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), spoolExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{path: filepath.Join(c.opts.Dir, e.Name()), size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
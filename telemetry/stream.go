@@ -0,0 +1,266 @@
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Stream kinds, in the stream.kind attribute.
+const (
+	StreamWebSocket = "websocket"
+	StreamSSE       = "sse"
+)
+
+// streamKind returns the kind of long-lived connection r asks for, or an
+// empty string for a plain request.
+func streamKind(r *http.Request) string {
+	switch {
+	case strings.EqualFold(r.Header.Get("Upgrade"), "websocket"):
+		return StreamWebSocket
+	case strings.Contains(r.Header.Get("Accept"), "text/event-stream"):
+		return StreamSSE
+	default:
+		return ""
+	}
+}
+
+type streamKey struct{}
+
+// Stream is a WebSocket connection or Server-Sent Events stream served by
+// the Streams middleware. Its methods are safe for concurrent use, and
+// no-ops on a nil Stream or before the connection is established.
+type Stream struct {
+	kind    string
+	start   time.Time
+	metrics *streamMetrics
+
+	mu        sync.Mutex
+	answered  bool
+	opened    bool
+	status    int
+	handshake time.Duration
+	route     string
+	span      trace.Span
+}
+
+// StreamFromContext returns the stream the request of ctx serves, or nil
+// outside the Streams middleware.
+func StreamFromContext(ctx context.Context) *Stream {
+	s, _ := ctx.Value(streamKey{}).(*Stream)
+	return s
+}
+
+// Message records a message sent or received, "sent" or "received" in
+// direction, of size bytes as an event on the connection span.
+func (s *Stream) Message(ctx context.Context, direction string, size int) {
+	if s == nil || !s.established() {
+		return
+	}
+	s.span.AddEvent("message", trace.WithAttributes(
+		attribute.String("message.direction", direction),
+		attribute.Int("message.size", size),
+	))
+	s.metrics.messages.Add(ctx, 1, metric.WithAttributes(s.attrs(direction)...))
+}
+
+// StartMessage starts a span, child of the connection span, for handling a
+// message, e.g. one a WebSocket client sent. The caller must end it.
+func (s *Stream) StartMessage(ctx context.Context, name, direction string) (context.Context, trace.Span) {
+	if s == nil || !s.established() {
+		return otel.Tracer(instrumentationName).Start(ctx, name)
+	}
+	s.metrics.messages.Add(ctx, 1, metric.WithAttributes(s.attrs(direction)...))
+	return otel.Tracer(instrumentationName).Start(trace.ContextWithSpan(ctx, s.span), name,
+		trace.WithAttributes(attribute.String("message.direction", direction)))
+}
+
+func (s *Stream) established() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opened
+}
+
+func (s *Stream) attrs(direction string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.route", s.route),
+		attribute.String("stream.kind", s.kind),
+	}
+	if direction != "" {
+		attrs = append(attrs, attribute.String("message.direction", direction))
+	}
+	return attrs
+}
+
+// open ends the request span once the response to the handshake is sent,
+// with status 101 for WebSocket, and starts the connection span, the root
+// of a new trace linked to the request. Responses refusing the stream leave
+// the request as any other.
+func (s *Stream) open(r *http.Request, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.answered {
+		return
+	}
+	s.answered = true
+	if s.kind == StreamWebSocket && status != http.StatusSwitchingProtocols ||
+		s.kind == StreamSSE && status != http.StatusOK {
+		return
+	}
+	s.opened = true
+	s.status = status
+	s.handshake = time.Since(s.start)
+	s.route = routePattern(r)
+
+	ctx := r.Context()
+	req := trace.SpanFromContext(ctx)
+	if s.route != "" {
+		req.SetName(s.route)
+		req.SetAttributes(attribute.String("http.route", s.route))
+	}
+	req.SetAttributes(
+		attribute.Int("http.status_code", status),
+		attribute.String("stream.kind", s.kind),
+	)
+	req.End()
+
+	_, s.span = otel.Tracer(instrumentationName).Start(ctx, s.kind+" connection",
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithLinks(trace.LinkFromContext(ctx, attribute.String("link.type", "origin"))),
+		trace.WithAttributes(s.attrs("")...),
+	)
+	s.metrics.active.Add(ctx, 1, metric.WithAttributes(s.attrs("")...))
+}
+
+// close ends the connection span when the handler returns.
+func (s *Stream) close(ctx context.Context) {
+	if !s.established() {
+		return
+	}
+	attrs := metric.WithAttributes(s.attrs("")...)
+	s.metrics.active.Add(ctx, -1, attrs)
+	s.metrics.duration.Record(ctx, time.Since(s.start).Seconds(), attrs)
+	s.span.End()
+}
+
+type streamMetrics struct {
+	duration metric.Float64Histogram
+	active   metric.Int64UpDownCounter
+	messages metric.Int64Counter
+}
+
+// Streams returns middleware for WebSocket and Server-Sent Events
+// endpoints, which hold their request for the lifetime of the connection.
+// Once the handshake is answered, the request span ends instead of staying
+// open for hours, and a connection span, linked to it, covers the rest of
+// the connection. Handlers record messages through StreamFromContext.
+//
+// Connections are measured in http.server.stream.duration and
+// http.server.stream.active, and messages in http.server.stream.messages.
+// HTTPMetrics records the handshake alone as the request, and
+// RequestTimeout leaves streams alone. It must run after the tracing
+// middleware and before the others.
+func (t *Telemetry) Streams() func(http.Handler) http.Handler {
+	m := &streamMetrics{}
+	m.duration, _ = selfMeter().Float64Histogram(
+		"http.server.stream.duration",
+		metric.WithDescription("Duration of WebSocket connections and Server-Sent Events streams."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(1, 10, 60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400),
+	)
+	m.active, _ = selfMeter().Int64UpDownCounter(
+		"http.server.stream.active",
+		metric.WithDescription("Open WebSocket connections and Server-Sent Events streams."),
+	)
+	m.messages, _ = selfMeter().Int64Counter(
+		"http.server.stream.messages",
+		metric.WithDescription("Messages of WebSocket connections and Server-Sent Events streams, by direction."),
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			kind := streamKind(r)
+			if kind == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s := &Stream{kind: kind, start: time.Now(), metrics: m}
+			r = r.WithContext(context.WithValue(r.Context(), streamKey{}, s))
+
+			next.ServeHTTP(&streamWriter{ResponseWriter: w, stream: s, r: r}, r)
+
+			s.close(r.Context())
+		})
+	}
+}
+
+// handshake returns the status and duration of the handshake of the stream
+// r serves, if it is one and was established.
+func handshake(r *http.Request) (int, time.Duration, bool) {
+	s := StreamFromContext(r.Context())
+	if s == nil || !s.established() {
+		return 0, 0, false
+	}
+	return s.status, s.handshake, true
+}
+
+// streamWriter opens its stream when the handshake response is sent: when
+// the header is written, or when the connection is hijacked by a WebSocket
+// library that writes the response itself.
+type streamWriter struct {
+	http.ResponseWriter
+	stream *Stream
+	r      *http.Request
+	wrote  bool
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	if !w.wrote && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+		w.wrote = true
+		w.stream.open(w.r, code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *streamWriter) Flush() {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		w.stream.open(w.r, http.StatusSwitchingProtocols)
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
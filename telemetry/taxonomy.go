@@ -0,0 +1,207 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TaxonomyRule tags the requests it matches with a business tag, e.g.
+// customer_tier=enterprise, which is added to their span, their HTTP
+// metrics and the logger of their context. Every matcher set must match.
+// For each tag, the first matching rule wins.
+type TaxonomyRule struct {
+	// Tag names the tag, in snake_case, e.g. "customer_tier".
+	Tag string `json:"tag"`
+	// Value is the value the rule tags requests with. The values of a tag
+	// must be few, as each is a metric series.
+	Value string `json:"value"`
+	// Path is a path.Match glob matched against the request path and its
+	// route pattern, e.g. "/checkout/*". Empty matches every request.
+	Path string `json:"path,omitempty"`
+	// Headers maps request header names to path.Match globs their value
+	// must match.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Claims maps claims of the bearer token, a JWT, to path.Match globs
+	// their value must match. The token is not verified: tags describe
+	// requests and must never authorize them.
+	Claims map[string]string `json:"claims,omitempty"`
+}
+
+// tagNamePattern keeps tags valid as attribute, label and log field names.
+var tagNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func (tr TaxonomyRule) validate() error {
+	var errs []error
+	if !tagNamePattern.MatchString(tr.Tag) {
+		errs = append(errs, fmt.Errorf("tag %q must be snake_case", tr.Tag))
+	}
+	if tr.Value == "" {
+		errs = append(errs, errors.New("value is required"))
+	}
+	globs := []string{tr.Path}
+	for _, g := range tr.Headers {
+		globs = append(globs, g)
+	}
+	for _, g := range tr.Claims {
+		globs = append(globs, g)
+	}
+	for _, g := range globs {
+		if _, err := path.Match(g, ""); err != nil {
+			errs = append(errs, fmt.Errorf("glob %q: %w", g, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("taxonomy rule %s=%s: %w", tr.Tag, tr.Value, err)
+	}
+	return nil
+}
+
+func validateTaxonomy(rules []TaxonomyRule) error {
+	var errs []error
+	for _, tr := range rules {
+		errs = append(errs, tr.validate())
+	}
+	return errors.Join(errs...)
+}
+
+// readTaxonomyFile reads a JSON array of rules from the file at path.
+func readTaxonomyFile(path string) ([]TaxonomyRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []TaxonomyRule
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// matches reports whether r, whose route pattern is route and bearer token
+// claims are claims, matches the rule. Globs are validated up front.
+func (tr TaxonomyRule) matches(r *http.Request, route string, claims map[string]string) bool {
+	if tr.Path != "" && !matchRoute([]string{tr.Path}, r.URL.Path, route) {
+		return false
+	}
+	for name, g := range tr.Headers {
+		if ok, _ := path.Match(g, r.Header.Get(name)); !ok {
+			return false
+		}
+	}
+	for name, g := range tr.Claims {
+		v, found := claims[name]
+		if ok, _ := path.Match(g, v); !ok || !found {
+			return false
+		}
+	}
+	return true
+}
+
+// requestTags returns the tags of the rules matching r, sorted by tag.
+func requestTags(rules []TaxonomyRule, r *http.Request) []attribute.KeyValue {
+	if len(rules) == 0 {
+		return nil
+	}
+	route := routeOf(r)
+	var claims map[string]string
+	for _, tr := range rules {
+		if len(tr.Claims) > 0 {
+			claims = bearerClaims(r)
+			break
+		}
+	}
+	set := map[string]bool{}
+	var tags []attribute.KeyValue
+	for _, tr := range rules {
+		if set[tr.Tag] || !tr.matches(r, route, claims) {
+			continue
+		}
+		set[tr.Tag] = true
+		tags = append(tags, attribute.String(tr.Tag, tr.Value))
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	return tags
+}
+
+// bearerClaims returns the scalar claims of the JWT in the Authorization
+// header of r, unverified, or nil if there is none.
+func bearerClaims(r *http.Request) map[string]string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	var raw map[string]any
+	if json.Unmarshal(payload, &raw) != nil {
+		return nil
+	}
+	claims := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v.(type) {
+		case string, float64, bool:
+			claims[k] = fmt.Sprint(v)
+		}
+	}
+	return claims
+}
+
+type requestTagsKey struct{}
+
+// RequestTags returns the business tags TagRequests gave the request of
+// ctx, by tag.
+func RequestTags(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(requestTagsKey{}).([]attribute.KeyValue)
+	m := make(map[string]string, len(tags))
+	for _, kv := range tags {
+		m[string(kv.Key)] = kv.Value.AsString()
+	}
+	return m
+}
+
+// TagRequests returns middleware tagging requests by the rules of
+// Options.Taxonomy. The tags are added to the request span, to the metrics
+// of HTTPMetrics when it runs inside, and to a logger in the request
+// context, which handlers log with through log.Ctx.
+func (t *Telemetry) TagRequests() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tags := requestTags(*t.taxonomy.Load(), r)
+			if len(tags) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			trace.SpanFromContext(ctx).SetAttributes(tags...)
+			lc := log.Logger.With()
+			for _, kv := range tags {
+				lc = lc.Str(string(kv.Key), kv.Value.AsString())
+			}
+			l := lc.Logger()
+			ctx = l.WithContext(context.WithValue(ctx, requestTagsKey{}, tags))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
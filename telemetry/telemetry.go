@@ -0,0 +1,232 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// instrumentationName scopes the instruments the telemetry stack creates
+// about itself.
+const instrumentationName = "go-otel/telemetry"
+
+// Telemetry holds the providers created by Setup.
+type Telemetry struct {
+	TracerProvider *sdktrace.TracerProvider
+	MeterProvider  *sdkmetric.MeterProvider
+
+	opts      Options
+	resource  *resource.Resource
+	sampler   *dynamicSampler
+	drift     *driftDetector
+	memory    *memoryWatermark
+	reload    *configWatcher
+	hot       *hotRoutes
+	pod       *podMetadata
+	relay     *relay
+	redactor  *Redactor
+	exemplars *exemplarStore
+	apdex     *apdexTracker
+	journal   *journal
+	verbose   verboseWindow
+	restore   func()
+	routes    atomic.Pointer[RouteFilterOptions]
+	taxonomy  atomic.Pointer[[]TaxonomyRule]
+	readOnly  atomic.Bool
+	start     time.Time
+}
+
+// Setup configures the global logger, tracer provider and meter provider.
+// Invalid options are rejected before anything is changed.
+func Setup(ctx context.Context, opts Options) (*Telemetry, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	// The reload file, if any, applies from the start.
+	base := opts
+	var reloadFile []byte
+	if opts.Reload.Path != "" {
+		s, b, err := readReloadFile(opts.Reload.Path, opts)
+		switch {
+		case err == nil:
+			opts, reloadFile = s.overlay(opts), b
+		case errors.Is(err, fs.ErrNotExist):
+			// It may be created later, e.g. with its ConfigMap.
+		default:
+			return nil, err
+		}
+	}
+
+	// One redactor serves the logs, the spans and the middleware, so the
+	// reload file can change its rules everywhere at once. Validated above.
+	redactor, _ := NewRedactor(opts.RedactionRules)
+	var scrub *Redactor
+	if len(opts.RedactionRules) > 0 || opts.Reload.Path != "" {
+		scrub = redactor
+	}
+
+	logger, err := newLogger(opts, scrub)
+	if err != nil {
+		return nil, err
+	}
+	log.Logger = logger
+	setErrorCodes(opts.ErrorCodes)
+	// Handlers logging through log.Ctx get the service logger, with the
+	// tags of TagRequests when there are some.
+	zerolog.DefaultContextLogger = &log.Logger
+
+	if err := setExporterCredentials(opts.Credentials); err != nil {
+		return nil, err
+	}
+	if err := opts.RateLimit.loadAPIKeys(); err != nil {
+		return nil, err
+	}
+	res, err := newResource(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Disabled {
+		log.Warn().Msg("telemetry SDK disabled, recording no traces or metrics")
+		t := newNoop(opts, redactor)
+		t.resource = res
+		return t, nil
+	}
+	mp, err := newMeterProvider(ctx, opts, res)
+	if err != nil {
+		return nil, err
+	}
+	// Registered first, so the instruments of the tracer provider report
+	// to it.
+	otel.SetMeterProvider(mp)
+	setErrorHandler()
+	if err := registerBuildInfo(ReadBuildInfo()); err != nil {
+		return nil, errors.Join(err, mp.Shutdown(ctx))
+	}
+
+	hot, err := startHotRoutes(opts.HotRoutes, buildID(ReadBuildInfo()))
+	if err != nil {
+		return nil, errors.Join(err, mp.Shutdown(ctx))
+	}
+	sampler := newDynamicSampler(opts.SampleRatio, hot)
+	pod, err := startPodMetadata(opts.PodMetadata)
+	if err != nil {
+		return nil, errors.Join(err, hot.shutdown(ctx), mp.Shutdown(ctx))
+	}
+	tp, err := newTracerProvider(ctx, opts, res, sampler, scrub, pod)
+	if err != nil {
+		return nil, errors.Join(err, pod.shutdown(ctx), hot.shutdown(ctx), mp.Shutdown(ctx))
+	}
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(Propagator())
+
+	t := &Telemetry{TracerProvider: tp, MeterProvider: mp, opts: opts, resource: res, sampler: sampler, hot: hot, pod: pod, redactor: redactor, start: start}
+	routes := opts.Routes
+	t.routes.Store(&routes)
+	taxonomy := opts.Taxonomy
+	t.taxonomy.Store(&taxonomy)
+	t.readOnly.Store(opts.ReadOnly)
+	t.exemplars = newExemplarStore(t.prometheusExporter().Exemplars)
+	t.apdex = newApdexTracker(opts.Apdex)
+	if t.drift, err = startDriftDetector(t, opts.Drift); err != nil {
+		return nil, errors.Join(err, t.Shutdown(ctx))
+	}
+	if t.memory, err = startMemoryWatermark(opts.Memory); err != nil {
+		return nil, errors.Join(err, t.Shutdown(ctx))
+	}
+	if t.relay, err = startRelay(ctx, opts, res); err != nil {
+		return nil, errors.Join(err, t.Shutdown(ctx))
+	}
+	if opts.Journal != "" {
+		var entries []JournalEntry
+		if t.journal, entries, err = openJournal(opts.Journal); err != nil {
+			return nil, errors.Join(err, t.Shutdown(ctx))
+		}
+		t.replayJournal(entries)
+	}
+	t.reload = startConfigWatcher(t, base, reloadFile)
+	return t, nil
+}
+
+// Resource returns the resource describing the service in its telemetry.
+func (t *Telemetry) Resource() *resource.Resource {
+	return t.resource
+}
+
+// Gatherer returns the prometheus gatherer metrics are scraped from, with
+// units converted unless the prometheus exporter asked for legacy units,
+// created timestamps and exemplars if it asked for them, and the series of
+// its scrape targets, which are passed through as scraped.
+func (t *Telemetry) Gatherer() prometheus.Gatherer {
+	eo := t.prometheusExporter()
+	g := newExemplarGatherer(prometheus.DefaultGatherer, t.exemplars)
+	if !eo.LegacyUnits {
+		g = NewUnitGatherer(g)
+	}
+	if eo.CreatedTimestamps {
+		g = newCreatedGatherer(g, t.start)
+	}
+	return newProxyGatherer(g, eo.ScrapeTargets)
+}
+
+// MetricsHandler serves the prometheus scrape endpoint, negotiating
+// OpenMetrics if the prometheus exporter asked for it.
+func (t *Telemetry) MetricsHandler() http.Handler {
+	eo := t.prometheusExporter()
+	opts := promhttp.HandlerOpts{EnableOpenMetrics: eo.OpenMetrics}
+	if eo.CreatedTimestamps {
+		opts.ProcessStartTime = t.start
+	}
+	return promhttp.HandlerFor(t.Gatherer(), opts)
+}
+
+// prometheusExporter returns the options of the prometheus exporter, of
+// which there is at most one, or zero options if there is none.
+func (t *Telemetry) prometheusExporter() MetricExporterOptions {
+	for _, eo := range t.opts.MetricExporters {
+		if eo.Kind == MetricExporterPrometheus {
+			return eo
+		}
+	}
+	return MetricExporterOptions{}
+}
+
+// ForceFlush exports the spans and metrics recorded so far.
+func (t *Telemetry) ForceFlush(ctx context.Context) error {
+	return errors.Join(
+		t.TracerProvider.ForceFlush(ctx),
+		t.MeterProvider.ForceFlush(ctx),
+	)
+}
+
+// Shutdown flushes and stops the providers.
+func (t *Telemetry) Shutdown(ctx context.Context) error {
+	return errors.Join(
+		t.shutdownVerbose(ctx),
+		t.relay.shutdown(ctx),
+		t.drift.shutdown(ctx),
+		t.memory.shutdown(ctx),
+		t.reload.shutdown(ctx),
+		t.hot.shutdown(ctx),
+		t.pod.shutdown(ctx),
+		t.apdex.shutdown(ctx),
+		t.TracerProvider.Shutdown(ctx),
+		t.MeterProvider.Shutdown(ctx),
+		t.journal.close(),
+		t.shutdownNoop(ctx),
+	)
+}
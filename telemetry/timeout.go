@@ -0,0 +1,124 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TimeoutOptions configures RequestTimeout.
+type TimeoutOptions struct {
+	// Default bounds every request. Zero means no timeout.
+	Default time.Duration
+	// Routes overrides Default for the paths matching a path.Match glob,
+	// e.g. "/reports/*". The longest matching glob wins.
+	Routes map[string]time.Duration
+}
+
+func (o TimeoutOptions) validate() error {
+	for g := range o.Routes {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("route timeout %q: %w", g, err)
+		}
+	}
+	return nil
+}
+
+// timeout returns the timeout of requests to p.
+func (o TimeoutOptions) timeout(p string) time.Duration {
+	d, best := o.Default, ""
+	for g, gd := range o.Routes {
+		if ok, _ := path.Match(g, p); ok && len(g) > len(best) {
+			d, best = gd, g
+		}
+	}
+	return d
+}
+
+// timeoutError is the body of 504 responses.
+type timeoutError struct {
+	ErrorResponse
+	Timeout string `json:"timeout"`
+}
+
+// RequestTimeout returns middleware cancelling the context of requests
+// running longer than their timeout. Handlers must return once their
+// context is done: those that have not responded yet get a 504 with a JSON
+// body. Each timeout adds a "request timeout" event to the request span and
+// is counted in http.server.request.timeouts. WebSocket and Server-Sent
+// Events requests, which last as long as their connection, have none. It
+// must run after ClassifyErrors, so timeouts are classified as such.
+//
+// With Options.BudgetHeaders, responses carry the timeout and the part of
+// it left in X-Budget-Limit and X-Budget-Remaining, and
+// http.server.budget.header.error measures how stale the latter was when
+// the response ended.
+func (t *Telemetry) RequestTimeout() func(http.Handler) http.Handler {
+	opts := t.opts.Timeout
+	timeouts, _ := selfMeter().Int64Counter(
+		"http.server.request.timeouts",
+		metric.WithDescription("Requests whose timeout fired, by route."),
+	)
+
+	budgetHeaders := t.opts.BudgetHeaders
+	budgetErrors, _ := selfMeter().Float64Histogram(
+		"http.server.budget.header.error",
+		metric.WithDescription("Time by which X-Budget-Remaining overstated the budget left when the response ended, by route."),
+		metric.WithUnit("s"),
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := opts.timeout(r.URL.Path)
+			if d <= 0 || streamKind(r) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeoutCause(r.Context(), d, ErrRequestTimeout)
+			defer cancel()
+			var bw *budgetWriter
+			if budgetHeaders {
+				deadline, _ := ctx.Deadline()
+				bw = &budgetWriter{ResponseWriter: w, budget: d, deadline: deadline}
+				w = bw
+				defer func() {
+					if stale, ok := bw.staleness(); ok {
+						budgetErrors.Record(r.Context(), stale.Seconds(), metric.WithAttributes(attribute.String("http.route", routePattern(r))))
+					}
+				}()
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return
+			}
+			route := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			timeouts.Add(r.Context(), 1, metric.WithAttributes(attribute.String("http.route", route)))
+			trace.SpanFromContext(r.Context()).AddEvent("request timeout", trace.WithAttributes(
+				attribute.String("timeout", d.String()),
+				attribute.Bool("responded", ww.Status() != 0),
+			))
+			SetRequestError(r.Context(), ctx.Err())
+			if ww.Status() != 0 {
+				return
+			}
+			render.Status(r, http.StatusGatewayTimeout)
+			render.JSON(ww, r, timeoutError{ErrorResponse: newErrorResponse(r, "request timed out"), Timeout: d.String()})
+		})
+	}
+}
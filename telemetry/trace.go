@@ -0,0 +1,182 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// NewTracerProvider creates a tracer provider that fans spans out to every
+// configured exporter. The caller owns the provider and must shut it down.
+func NewTracerProvider(ctx context.Context, opts Options) (*trace.TracerProvider, error) {
+	res, err := newResource(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	var redactor *Redactor
+	if len(opts.RedactionRules) > 0 {
+		if redactor, err = NewRedactor(opts.RedactionRules); err != nil {
+			return nil, err
+		}
+	}
+	return newTracerProvider(ctx, opts, res, newSampler(opts.SampleRatio, nil), redactor, nil)
+}
+
+// newTracerProvider builds the tracer provider, redacting spans with
+// redactor before export unless it is nil, and stamping them with the
+// current pod metadata unless pod is nil.
+func newTracerProvider(ctx context.Context, opts Options, res *resource.Resource, sampler trace.Sampler, redactor *Redactor, pod *podMetadata) (*trace.TracerProvider, error) {
+	if opts.SampleAll {
+		sampler = trace.AlwaysSample()
+	}
+	tpOpts := []trace.TracerProviderOption{
+		trace.WithResource(res),
+		trace.WithSampler(sampler),
+	}
+
+	tracker := newTraceTracker()
+	tpOpts = append(tpOpts,
+		trace.WithSpanProcessor(newSpanCountProcessor()),
+		trace.WithSpanProcessor(newSpanDurationCounter(opts.MaxSpanDuration)),
+	)
+	if sp := newSpanDefaultsProcessor(res, opts.SpanResourceAttributes); sp != nil {
+		tpOpts = append(tpOpts, trace.WithSpanProcessor(sp))
+	}
+	if pod != nil {
+		tpOpts = append(tpOpts, trace.WithSpanProcessor(podMetadataProcessor{pod: pod}))
+	}
+	if opts.SpanSchema != nil {
+		tpOpts = append(tpOpts, trace.WithSpanProcessor(newSpanSchemaProcessor(opts.SpanSchema)))
+	}
+	if len(opts.SpanHooks) > 0 {
+		// Hooks see the status the exporters see.
+		tpOpts = append(tpOpts, trace.WithSpanProcessor(serverStatusProcessor{spanHookProcessor{hooks: opts.SpanHooks}}))
+	}
+	for _, sp := range opts.SpanProcessors {
+		if redactor != nil {
+			sp = NewRedactProcessor(sp, redactor)
+		}
+		sp = completenessProcessor{SpanProcessor: sp, tracker: tracker}
+		sp = spanDurationProcessor{SpanProcessor: sp, max: opts.MaxSpanDuration}
+		tpOpts = append(tpOpts, trace.WithSpanProcessor(serverStatusProcessor{sp}))
+	}
+
+	// The processors built so far hold connections and files, released
+	// if a later exporter fails.
+	var built []trace.SpanProcessor
+	fail := func(err error) (*trace.TracerProvider, error) {
+		for _, sp := range built {
+			err = errors.Join(err, sp.Shutdown(ctx))
+		}
+		return nil, err
+	}
+	for i, eo := range opts.TraceExporters {
+		attrs := []attribute.KeyValue{
+			attribute.Int("exporter.index", i),
+			attribute.String("exporter.kind", string(eo.Kind)),
+		}
+		exporter, err := newTraceExporter(ctx, eo, attrs...)
+		if err != nil {
+			return fail(fmt.Errorf("trace exporter %d (%s): %w", i, eo.Kind, err))
+		}
+		exporter = newInstrumentedExporter(exporter, attrs...)
+
+		var sp trace.SpanProcessor
+		switch eo.Processor {
+		case ProcessorSimple:
+			sp = trace.NewSimpleSpanProcessor(exporter)
+		case ProcessorBatch, "":
+			sp = newBatchProcessor(exporter, eo.Batch, opts.ExportPriority, tracker, attrs...)
+		default:
+			return fail(errors.Join(fmt.Errorf("trace exporter %d: unknown processor %q", i, eo.Processor), exporter.Shutdown(ctx)))
+		}
+		if redactor != nil {
+			sp = NewRedactProcessor(sp, redactor)
+		}
+		sp = completenessProcessor{SpanProcessor: sp, tracker: tracker}
+		sp = spanDurationProcessor{SpanProcessor: sp, max: opts.MaxSpanDuration}
+		sp = serverStatusProcessor{sp}
+		built = append(built, sp)
+		tpOpts = append(tpOpts, trace.WithSpanProcessor(sp))
+	}
+	// Last, so every exporter has annotated a local root before its trace
+	// is forgotten.
+	tpOpts = append(tpOpts, trace.WithSpanProcessor(tracker))
+
+	return trace.NewTracerProvider(tpOpts...), nil
+}
+
+// newSampler samples ratio of new traces, more on the routes hot marks as
+// regressed, unless an upstream priority says otherwise, and follows the
+// parent otherwise. hot may be nil.
+func newSampler(ratio float64, hot *hotRoutes) trace.Sampler {
+	return trace.ParentBased(prioritySampler{newHotRouteSampler(trace.TraceIDRatioBased(ratio), hot)})
+}
+
+func newTraceExporter(ctx context.Context, eo TraceExporterOptions, attrs ...attribute.KeyValue) (trace.SpanExporter, error) {
+	switch eo.Kind {
+	case ExporterOTLP:
+		client, err := newOTLPClient(ctx, eo, attrs...)
+		if err != nil {
+			return nil, err
+		}
+		return otlptrace.New(ctx, client)
+
+	case ExporterStdout:
+		w, f, err := openOutput(eo.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		stdoutOpts := []stdouttrace.Option{stdouttrace.WithWriter(w)}
+		if eo.PrettyPrint {
+			stdoutOpts = append(stdoutOpts, stdouttrace.WithPrettyPrint())
+		}
+		exporter, err := stdouttrace.New(stdoutOpts...)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return exporter, nil
+		}
+		return &fileSpanExporter{SpanExporter: exporter, f: f}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown exporter kind %q", eo.Kind)
+	}
+}
+
+// openOutput opens path for appending, or returns os.Stdout if path is empty.
+// The returned file is nil when writing to stdout.
+func openOutput(path string) (io.Writer, *os.File, error) {
+	if path == "" {
+		return os.Stdout, nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f, nil
+}
+
+// fileSpanExporter closes the underlying file once the exporter is shut down.
+type fileSpanExporter struct {
+	trace.SpanExporter
+	f *os.File
+}
+
+func (e *fileSpanExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,285 @@
+package telemetry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// budgetDeposits is the number of requests whose deposits the retry budget
+// keeps at most, so a long quiet spell cannot save up a retry storm.
+const budgetDeposits = 1000
+
+// TransientRetryOptions configures RetryTransient.
+type TransientRetryOptions struct {
+	// MaxAttempts is the number of times an operation is tried, the first
+	// one included. Zero means 3.
+	MaxAttempts int
+	// Backoff is the longest wait before the first retry, doubled for each
+	// next one; waits are drawn at random below it. Zero means 25ms.
+	Backoff time.Duration
+	// BudgetRatio is the share of requests that may be retried, from 0 to
+	// 1, on top of BudgetFloor. Zero means 0.1.
+	BudgetRatio float64
+	// BudgetFloor is the number of retries per second allowed whatever the
+	// traffic, so quiet services still retry. Zero means 10.
+	BudgetFloor float64
+	// Errors are transient on top of those IsTransient knows: failures
+	// matching one of them with errors.Is are retried.
+	Errors []error
+	// MaxBodyBytes is the largest request body kept to replay. Requests
+	// with larger ones are served once. Zero means 64KiB.
+	MaxBodyBytes int
+}
+
+func (o TransientRetryOptions) validate() error {
+	if o.MaxAttempts < 0 || o.Backoff < 0 || o.BudgetFloor < 0 || o.MaxBodyBytes < 0 {
+		return fmt.Errorf("transient retry attempts, backoff, budget floor and body size must not be negative, got %d, %s, %g and %d",
+			o.MaxAttempts, o.Backoff, o.BudgetFloor, o.MaxBodyBytes)
+	}
+	if o.BudgetRatio < 0 || o.BudgetRatio > 1 {
+		return fmt.Errorf("transient retry budget ratio must be within [0, 1], got %g", o.BudgetRatio)
+	}
+	return nil
+}
+
+// IsTransient reports whether err is a failure that trying again may get
+// past: a connection reset, refused or aborted, or a serialization failure
+// or deadlock reported by a database driver whose errors have a SQLSTATE,
+// as those of pgx do.
+func IsTransient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var sqlErr interface{ SQLState() string }
+	if errors.As(err, &sqlErr) {
+		switch sqlErr.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+	return false
+}
+
+// retryBudget lets a share of requests be retried, plus a floor of retries
+// per second, so retries cannot multiply the load on a dependency which
+// is already failing. Each request deposits the ratio, each retry
+// withdraws one.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	floor  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRetryBudget(ratio, floor float64, now time.Time) *retryBudget {
+	return &retryBudget{ratio: ratio, floor: floor, tokens: floor, last: now}
+}
+
+// refill tops the balance up to the floor at the floor rate.
+func (b *retryBudget) refill(now time.Time) {
+	if b.tokens < b.floor {
+		b.tokens = math.Min(b.floor, b.tokens+now.Sub(b.last).Seconds()*b.floor)
+	}
+	b.last = now
+}
+
+func (b *retryBudget) deposit(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens = math.Min(b.tokens+b.ratio, math.Max(b.tokens, b.floor+b.ratio*budgetDeposits))
+}
+
+func (b *retryBudget) withdraw(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// responseBuffer holds the response of an attempt, which is only sent once
+// the attempt succeeded.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header { return b.header }
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// send writes the buffered response to w.
+func (b *responseBuffer) send(w http.ResponseWriter) {
+	h := w.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	for k, v := range b.header {
+		h[k] = v
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
+
+// RetryTransient returns a handler serving r with fn, which reports
+// failures by returning an error rather than answering them. Idempotent
+// requests failing with a transient error, as told by IsTransient or
+// Options.TransientRetry.Errors, are served again with backoff, for as
+// long as the retry budget allows. Transient errors fn panics with are
+// retried as well. Each attempt is a span of its own, named after the
+// route, and each retry an event on the request span, so traces show what
+// was tried again and why. Responses are buffered until an attempt
+// succeeds, which makes RetryTransient unfit for streaming handlers. The
+// failure of the last attempt is answered with RenderError.
+func (t *Telemetry) RetryTransient(fn func(http.ResponseWriter, *http.Request) error) http.Handler {
+	opts := t.opts.TransientRetry
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 25 * time.Millisecond
+	}
+	if opts.BudgetRatio <= 0 {
+		opts.BudgetRatio = 0.1
+	}
+	if opts.BudgetFloor <= 0 {
+		opts.BudgetFloor = 10
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 64 << 10
+	}
+	transient := func(err error) bool {
+		for _, target := range opts.Errors {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+		return IsTransient(err)
+	}
+	budget := newRetryBudget(opts.BudgetRatio, opts.BudgetFloor, time.Now())
+	tracer := otel.Tracer(instrumentationName)
+	retries, _ := selfMeter().Int64Counter(
+		"http.server.retries",
+		metric.WithDescription("Requests failing with a transient error, by route and whether they were retried."),
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bufferBody(r, opts.MaxBodyBytes)
+		if !ok || !idempotent(r) {
+			if err := fn(w, r); err != nil {
+				RenderError(w, r, err)
+			}
+			return
+		}
+		budget.deposit(time.Now())
+
+		route := routePattern(r)
+		var err error
+		for attempt := 1; ; attempt++ {
+			if body != nil {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			buf := &responseBuffer{header: w.Header().Clone()}
+			ctx, span := tracer.Start(r.Context(), r.Method+" "+route+" attempt",
+				trace.WithAttributes(attribute.Int("retry.attempt", attempt)))
+			err = serveAttempt(fn, buf, r.WithContext(ctx), transient, span)
+			SetSpanError(span, err)
+			span.End()
+			if err == nil {
+				buf.send(w)
+				return
+			}
+			if !transient(err) || attempt == opts.MaxAttempts || r.Context().Err() != nil {
+				break
+			}
+			if !budget.withdraw(time.Now()) {
+				retries.Add(r.Context(), 1, metric.WithAttributes(
+					attribute.String("http.route", route), attribute.String("result", "budget_exhausted")))
+				break
+			}
+			retries.Add(r.Context(), 1, metric.WithAttributes(
+				attribute.String("http.route", route), attribute.String("result", "retried")))
+			trace.SpanFromContext(r.Context()).AddEvent("retry", trace.WithAttributes(
+				attribute.Int("retry.attempt", attempt+1),
+				attribute.String("error.message", err.Error()),
+			))
+
+			wait := time.Duration(rand.Int63n(int64(opts.Backoff<<(attempt-1)) + 1))
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				continue
+			case <-r.Context().Done():
+				timer.Stop()
+			}
+			break
+		}
+		RenderError(w, r, err)
+	})
+}
+
+// serveAttempt serves r with fn, turning a panic with a transient error
+// into that error. Other panics go on, for Recoverer.
+func serveAttempt(fn func(http.ResponseWriter, *http.Request) error, w http.ResponseWriter, r *http.Request,
+	transient func(error) bool, span trace.Span) (err error) {
+	defer func() {
+		rvr := recover()
+		if rvr == nil {
+			return
+		}
+		if perr, ok := rvr.(error); ok && rvr != http.ErrAbortHandler && transient(perr) {
+			err = perr
+			return
+		}
+		span.SetStatus(codes.Error, fmt.Sprintf("panic: %v", rvr))
+		span.End()
+		panic(rvr)
+	}()
+	return fn(w, r)
+}
+
+// idempotent reports whether serving r again does no more than serving it
+// once: its method is idempotent, or the client sent an Idempotency-Key.
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
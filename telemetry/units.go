@@ -0,0 +1,133 @@
+package telemetry
+
+import (
+	"math"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// unitConversion rescales metrics whose name ends in a non-base unit to the
+// base unit prometheus conventions ask for.
+type unitConversion struct {
+	from, to string
+	factor   float64
+}
+
+var unitConversions = []unitConversion{
+	{"milliseconds", "seconds", 1e-3},
+	{"microseconds", "seconds", 1e-6},
+	{"nanoseconds", "seconds", 1e-9},
+	{"minutes", "seconds", 60},
+	{"hours", "seconds", 3600},
+	{"days", "seconds", 86400},
+	{"kilobytes", "bytes", 1e3},
+	{"megabytes", "bytes", 1e6},
+	{"gigabytes", "bytes", 1e9},
+	{"terabytes", "bytes", 1e12},
+	{"kibibytes", "bytes", 1 << 10},
+	{"mebibytes", "bytes", 1 << 20},
+	{"gibibytes", "bytes", 1 << 30},
+	{"tibibytes", "bytes", 1 << 40},
+}
+
+// unitGatherer converts gathered metric families to base units, renaming
+// them accordingly, e.g. "rpc_latency_milliseconds" becomes
+// "rpc_latency_seconds" with every value divided by 1000.
+type unitGatherer struct {
+	prometheus.Gatherer
+}
+
+// NewUnitGatherer wraps g so that every family is reported in base units.
+func NewUnitGatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	return unitGatherer{Gatherer: g}
+}
+
+func (g unitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+
+	names := make(map[string]bool, len(families))
+	for _, mf := range families {
+		names[mf.GetName()] = true
+	}
+
+	for _, mf := range families {
+		name, conv, ok := convertName(mf.GetName())
+		// Never merge into a family that already uses the target name.
+		if !ok || names[name] || !convertible(mf) {
+			continue
+		}
+		mf.Name = &name
+		if mf.Unit != nil {
+			mf.Unit = &conv.to
+		}
+		for _, m := range mf.Metric {
+			scaleMetric(m, conv.factor)
+		}
+	}
+	return families, err
+}
+
+// convertName returns the base unit name for name, if it has a unit suffix
+// that needs converting.
+func convertName(name string) (string, unitConversion, bool) {
+	base, total := strings.CutSuffix(name, "_total")
+	for _, c := range unitConversions {
+		if prefix, ok := strings.CutSuffix(base, "_"+c.from); ok {
+			converted := prefix + "_" + c.to
+			if total {
+				converted += "_total"
+			}
+			return converted, c, true
+		}
+	}
+	return "", unitConversion{}, false
+}
+
+// convertible reports whether every metric in mf can be rescaled. Native
+// histogram buckets are exponential and cannot.
+func convertible(mf *dto.MetricFamily) bool {
+	for _, m := range mf.Metric {
+		if h := m.GetHistogram(); h != nil && h.Schema != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func scaleMetric(m *dto.Metric, factor float64) {
+	scale := func(p *float64) {
+		if p != nil && !math.IsInf(*p, 0) {
+			*p *= factor
+		}
+	}
+
+	if c := m.GetCounter(); c != nil {
+		scale(c.Value)
+		if e := c.GetExemplar(); e != nil {
+			scale(e.Value)
+		}
+	}
+	if g := m.GetGauge(); g != nil {
+		scale(g.Value)
+	}
+	if u := m.GetUntyped(); u != nil {
+		scale(u.Value)
+	}
+	if s := m.GetSummary(); s != nil {
+		scale(s.SampleSum)
+		for _, q := range s.Quantile {
+			scale(q.Value)
+		}
+	}
+	if h := m.GetHistogram(); h != nil {
+		scale(h.SampleSum)
+		for _, b := range h.Bucket {
+			scale(b.UpperBound)
+			if e := b.GetExemplar(); e != nil {
+				scale(e.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// Validate checks opts for mistakes that would otherwise surface while the
+// providers are built, or not at all. Every problem found is reported.
+func (o Options) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if o.ServiceName == "" {
+		add("service name is required")
+	}
+	switch o.Preset {
+	case PresetProd, PresetDev, PresetDebug, "":
+	default:
+		add("unknown preset %q", o.Preset)
+	}
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		add("sample ratio must be between 0 and 1, got %g", o.SampleRatio)
+	}
+	if o.MaxSpanDuration < 0 {
+		add("max span duration must not be negative, got %s", o.MaxSpanDuration)
+	}
+
+	for i, eo := range o.TraceExporters {
+		for _, err := range eo.validate() {
+			add("trace exporter %d (%s): %w", i, eo.Kind, err)
+		}
+	}
+	prometheus := 0
+	for i, eo := range o.MetricExporters {
+		if eo.Kind == MetricExporterPrometheus {
+			prometheus++
+		}
+		for _, err := range eo.validate() {
+			add("metric exporter %d (%s): %w", i, eo.Kind, err)
+		}
+	}
+	if prometheus > 1 {
+		add("only one prometheus exporter can register on the default registry, got %d", prometheus)
+	}
+
+	switch o.Log.Format {
+	case LogJSON, LogConsole, "":
+	default:
+		add("unknown log format %q", o.Log.Format)
+	}
+	if _, err := NewRedactor(o.RedactionRules); err != nil {
+		errs = append(errs, err)
+	}
+	for i, h := range o.SpanHooks {
+		if h.Name == "" {
+			add("span hook %d: name is required", i)
+		}
+		if len(h.Actions) == 0 {
+			add("span hook %d (%s): at least one action is required", i, h.Name)
+		}
+	}
+	if err := o.Relay.validate(o.TraceExporters); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.HotRoutes.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateTaxonomy(o.Taxonomy); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateErrorCodes(o.ErrorCodes); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.Routes.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.Credentials.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.Reload.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseTrustedProxies(o.TrustedProxies); err != nil {
+		errs = append(errs, err)
+	}
+	if o.Archive.Ratio < 0 || o.Archive.Ratio > 1 {
+		add("archive ratio must be between 0 and 1, got %g", o.Archive.Ratio)
+	}
+	if o.CaptureDir != "" && o.Preset != PresetDev {
+		add("request capture is only available with the dev preset, got %q", o.Preset)
+	}
+	if err := o.Timeout.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.Shadow.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.ExportPriority.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.Audit.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.RateLimit.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.TransientRetry.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.PodMetadata.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := o.Faults.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (eo TraceExporterOptions) validate() []error {
+	var errs []error
+	switch eo.Processor {
+	case ProcessorBatch, ProcessorSimple, "":
+	default:
+		errs = append(errs, fmt.Errorf("unknown processor %q", eo.Processor))
+	}
+
+	switch eo.Kind {
+	case ExporterOTLP:
+		if err := validateEndpoint(eo.Endpoint); err != nil {
+			errs = append(errs, err)
+		}
+		if eo.Path != "" || eo.PrettyPrint {
+			errs = append(errs, errors.New("path and pretty print only apply to stdout exporters"))
+		}
+		if eo.Spool != nil && eo.Spool.Dir == "" {
+			errs = append(errs, errors.New("spool directory is required"))
+		}
+		if err := eo.Breaker.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	case ExporterStdout:
+		if eo.Endpoint != "" || eo.Retry != nil || eo.Spool != nil || eo.Breaker != nil {
+			errs = append(errs, errors.New("endpoint, retry, spool and breaker only apply to OTLP exporters"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown exporter kind %q", eo.Kind))
+	}
+	return errs
+}
+
+func (eo MetricExporterOptions) validate() []error {
+	var errs []error
+	if eo.Kind != MetricExporterOTLP && (eo.Endpoint != "" || eo.Insecure || eo.Timeout != 0 || eo.Temporality != "") {
+		errs = append(errs, errors.New("endpoint, insecure, timeout and temporality only apply to otlp exporters"))
+	}
+	if eo.Kind != MetricExporterPrometheus && len(eo.ScrapeTargets) > 0 {
+		errs = append(errs, errors.New("scrape targets only apply to prometheus exporters"))
+	}
+	if eo.Kind != MetricExporterPrometheus && eo.Exemplars != nil {
+		errs = append(errs, errors.New("exemplars only apply to prometheus exporters"))
+	}
+	switch eo.Kind {
+	case MetricExporterPrometheus:
+		if eo.Path != "" || eo.PrettyPrint || eo.Interval != 0 {
+			errs = append(errs, errors.New("path, pretty print and interval only apply to stdout exporters"))
+		}
+		if eo.URL != "" || eo.Job != "" || eo.Breaker != nil {
+			errs = append(errs, errors.New("url, job and breaker only apply to pushgateway and remote_write exporters"))
+		}
+		if eo.ExponentialHistograms != nil {
+			errs = append(errs, errors.New("exponential histograms are not supported by the prometheus exporter"))
+		}
+		names := make(map[string]bool, len(eo.ScrapeTargets))
+		for _, st := range eo.ScrapeTargets {
+			if err := st.validate(); err != nil {
+				errs = append(errs, err)
+			}
+			if names[st.Name] {
+				errs = append(errs, fmt.Errorf("duplicate scrape target %q", st.Name))
+			}
+			names[st.Name] = true
+		}
+		if err := eo.Exemplars.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	case MetricExporterStdout:
+		if eo.LegacyUnits {
+			errs = append(errs, errors.New("legacy units only apply to prometheus exporters"))
+		}
+		if len(eo.NativeHistograms) > 0 || eo.OpenMetrics || eo.CreatedTimestamps {
+			errs = append(errs, errors.New("native histograms, openmetrics and created timestamps only apply to prometheus exporters"))
+		}
+		if eo.URL != "" || eo.Job != "" || eo.Breaker != nil {
+			errs = append(errs, errors.New("url, job and breaker only apply to pushgateway and remote_write exporters"))
+		}
+	case MetricExporterPushgateway, MetricExporterRemoteWrite:
+		if u, err := url.Parse(eo.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("url %q must be an absolute http(s) URL", eo.URL))
+		}
+		if eo.Path != "" || eo.PrettyPrint {
+			errs = append(errs, errors.New("path and pretty print only apply to stdout exporters"))
+		}
+		if err := eo.Breaker.validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if eo.ExponentialHistograms != nil {
+			errs = append(errs, errors.New("exponential histograms are not supported by prometheus formats"))
+		}
+		if len(eo.NativeHistograms) > 0 || eo.OpenMetrics || eo.CreatedTimestamps {
+			errs = append(errs, errors.New("native histograms, openmetrics and created timestamps only apply to prometheus exporters"))
+		}
+	case MetricExporterOTLP:
+		if err := validateEndpoint(eo.Endpoint); err != nil {
+			errs = append(errs, err)
+		}
+		switch eo.Temporality {
+		case "", TemporalityCumulative, TemporalityDelta, TemporalityLowMemory:
+		default:
+			errs = append(errs, fmt.Errorf("unknown temporality %q", eo.Temporality))
+		}
+		if eo.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("timeout must not be negative, got %s", eo.Timeout))
+		}
+		if eo.Path != "" || eo.PrettyPrint {
+			errs = append(errs, errors.New("path and pretty print only apply to stdout exporters"))
+		}
+		if eo.URL != "" || eo.Job != "" || eo.Breaker != nil {
+			errs = append(errs, errors.New("url, job and breaker only apply to pushgateway and remote_write exporters"))
+		}
+		if eo.LegacyUnits || len(eo.NativeHistograms) > 0 || eo.OpenMetrics || eo.CreatedTimestamps {
+			errs = append(errs, errors.New("legacy units, native histograms, openmetrics and created timestamps only apply to prometheus exporters"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown exporter kind %q", eo.Kind))
+	}
+	return errs
+}
+
+// validateEndpoint checks endpoint is a host:port.
+func validateEndpoint(endpoint string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return fmt.Errorf("endpoint %q must be host:port: %w", endpoint, err)
+	}
+	if host == "" {
+		return fmt.Errorf("endpoint %q has no host", endpoint)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("endpoint %q has an invalid port", endpoint)
+	}
+	return nil
+}
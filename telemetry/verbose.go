@@ -0,0 +1,217 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-otel/internal/principal"
+)
+
+const (
+	// MaxVerboseWindow bounds the verbose tracing window, so a forgotten
+	// one cannot flood the backend for long.
+	MaxVerboseWindow = time.Hour
+	// verboseBodyBytes caps the bytes of each body recorded on spans.
+	verboseBodyBytes = 8 << 10
+)
+
+// verboseWindow is the time-boxed window during which every trace is
+// sampled and CaptureVerbose records headers and bodies. The window is a
+// span of its own, so tracing UIs show it as an annotation.
+type verboseWindow struct {
+	mu    sync.Mutex
+	until time.Time
+	timer *time.Timer
+	span  trace.Span
+}
+
+// VerboseWindow is the state of the verbose tracing window, as served by
+// VerboseHandler.
+type VerboseWindow struct {
+	Active bool       `json:"active"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// StartVerbose samples every trace and records request and response
+// headers and bodies on request spans for d, up to MaxVerboseWindow, then
+// reverts on its own. Starting an open window again moves its end.
+func (t *Telemetry) StartVerbose(ctx context.Context, d time.Duration) error {
+	if d <= 0 || d > MaxVerboseWindow {
+		return fmt.Errorf("verbose window must be within (0, %s], got %s", MaxVerboseWindow, d)
+	}
+	p, _ := principal.FromContext(ctx)
+	v := &t.verbose
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.until = time.Now().Add(d)
+	if v.timer != nil {
+		v.timer.Reset(d)
+		v.span.AddEvent("verbose tracing extended", trace.WithAttributes(
+			attribute.String("admin.principal", p.Name),
+			attribute.String("verbose.until", v.until.Format(time.RFC3339)),
+		))
+		log.Warn().Str("principal", p.Name).Time("until", v.until).Msg("verbose tracing extended")
+		return nil
+	}
+	// Set first, so the span of the window is sampled too.
+	t.sampler.verbose.Store(true)
+	_, v.span = otel.Tracer(instrumentationName).Start(context.Background(), "verbose tracing window",
+		trace.WithNewRoot())
+	v.span.AddEvent("verbose tracing started", trace.WithAttributes(
+		attribute.String("admin.principal", p.Name),
+		attribute.String("verbose.until", v.until.Format(time.RFC3339)),
+	))
+	v.timer = time.AfterFunc(d, func() { t.stopVerbose("expired") })
+	// Warn so the window is recorded whatever the log level.
+	log.Warn().Str("principal", p.Name).Time("until", v.until).Msg("verbose tracing started")
+	return nil
+}
+
+// StopVerbose closes the verbose tracing window before its end, if open.
+func (t *Telemetry) StopVerbose() {
+	t.stopVerbose("stopped")
+}
+
+func (t *Telemetry) stopVerbose(reason string) {
+	v := &t.verbose
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// An expiry racing with StartVerbose extending the window is late.
+	if v.timer == nil || reason == "expired" && time.Now().Before(v.until) {
+		return
+	}
+	v.timer.Stop()
+	t.sampler.verbose.Store(false)
+	v.span.AddEvent("verbose tracing ended", trace.WithAttributes(attribute.String("verbose.reason", reason)))
+	v.span.End()
+	v.timer, v.span, v.until = nil, nil, time.Time{}
+	log.Warn().Str("reason", reason).Msg("verbose tracing ended")
+}
+
+// Verbose returns the state of the verbose tracing window.
+func (t *Telemetry) Verbose() VerboseWindow {
+	v := &t.verbose
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.timer == nil {
+		return VerboseWindow{}
+	}
+	until := v.until
+	return VerboseWindow{Active: true, Until: &until}
+}
+
+// VerboseHandler serves the verbose tracing window as JSON on GET, opens
+// it for the minutes of a {"minutes": N} body on POST, and closes it on
+// DELETE.
+func (t *Telemetry) VerboseHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			var body struct {
+				Minutes float64 `json:"minutes"`
+			}
+			if err := render.DecodeJSON(r.Body, &body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			d := time.Duration(body.Minutes * float64(time.Minute))
+			if d <= 0 || d > MaxVerboseWindow {
+				http.Error(w, fmt.Sprintf("minutes must be within (0, %g]", MaxVerboseWindow.Minutes()), http.StatusBadRequest)
+				return
+			}
+			if err := t.journal.record(r, JournalEntry{Action: journalVerbose, Window: d.String()}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// Validated above.
+			_ = t.StartVerbose(r.Context(), d)
+		case http.MethodDelete:
+			if err := t.journal.record(r, JournalEntry{Action: journalVerbose, Window: time.Duration(0).String()}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			t.StopVerbose()
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		render.JSON(w, r, t.Verbose())
+	})
+}
+
+// CaptureVerbose returns middleware recording, while the verbose tracing
+// window is open, the headers of requests and responses as
+// http.request.header.* and http.response.header.* attributes of the
+// request span, and the first 8KiB of their bodies as http.request.body
+// and http.response.body, all redacted. Credentials are left out. It must
+// run after the tracing middleware.
+func (t *Telemetry) CaptureVerbose() func(http.Handler) http.Handler {
+	redactor := t.redactor
+	keyHeader := t.opts.RateLimit.keyHeader()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span := trace.SpanFromContext(r.Context())
+			if !t.sampler.verbose.Load() || !span.IsRecording() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reqBody := &limitedBuffer{max: verboseBodyBytes}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.TeeReader(r.Body, reqBody), r.Body}
+			}
+			respBody := &limitedBuffer{max: verboseBodyBytes}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(respBody)
+
+			next.ServeHTTP(ww, r)
+
+			attrs := append(headerAttrs("http.request.header.", r.Header, keyHeader, redactor),
+				headerAttrs("http.response.header.", w.Header(), keyHeader, redactor)...)
+			attrs = append(attrs,
+				attribute.String("http.request.body", redactor.Redact(reqBody.String())),
+				attribute.String("http.response.body", redactor.Redact(respBody.String())),
+			)
+			span.SetAttributes(attrs...)
+		})
+	}
+}
+
+// headerAttrs returns the redacted values of h, but for its sensitive
+// headers, as attributes named prefix plus the lower case header name.
+func headerAttrs(prefix string, h http.Header, keyHeader string, redactor *Redactor) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(h))
+	for name, values := range h {
+		if isSensitiveHeader(name, keyHeader) {
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i, v := range values {
+			redacted[i] = redactor.Redact(v)
+		}
+		attrs = append(attrs, attribute.StringSlice(prefix+strings.ToLower(name), redacted))
+	}
+	return attrs
+}
+
+func (t *Telemetry) shutdownVerbose(context.Context) error {
+	t.stopVerbose("shutdown")
+	return nil
+}